@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// A job currently held by a worker. Cancelling its context kills the
+// osmx subprocess.
+type runningJob struct {
+	task   Task
+	cancel context.CancelCauseFunc
+
+	// when the progress counters last advanced, and whether the job
+	// has been flagged for going without since.
+	lastProgressAt time.Time
+	counters       [3]int64
+	stalled        bool
+
+	// the highest PercentComplete reported.
+	percent float64
+}
+
+// the cause given when an operator or the client stops a running job,
+// or the server shuts down.
+type jobStopped struct {
+	requeue  bool
+	client   bool
+	shutdown bool
+	reason   string
+}
+
+func (s *jobStopped) Error() string {
+	if s.requeue {
+		return "requeued by operator"
+	}
+	if s.shutdown {
+		return "interrupted by shutdown"
+	}
+	if s.client {
+		return s.reason
+	}
+	return "failed by operator: " + s.reason
+}
+
+// requireAdmin writes a 401 or 403 unless the request carries an admin API key.
+func (h *Server) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	_, ok := h.requireScope(w, r, scopeAdmin, true)
+	return ok
+}
+
+func (h *Server) serveAdmin(w http.ResponseWriter, r *http.Request) {
+	if !h.requireAdmin(w, r) {
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/admin/"), "/")
+	if len(parts) == 1 && parts[0] == "stats" && r.Method == "GET" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h.stats())
+		return
+	}
+	if len(parts) == 1 && parts[0] == "reindex" && r.Method == "POST" {
+		n, err := h.reindex()
+		if err != nil {
+			w.WriteHeader(500)
+			fmt.Fprintf(w, "Error: %s", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct{ Reconstructed int }{n})
+		return
+	}
+	if len(parts) == 1 && parts[0] == "reload" && r.Method == "POST" {
+		changed, err := h.reload()
+		if err != nil {
+			w.WriteHeader(409)
+			fmt.Fprintf(w, "Error: %s", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct{ Changed []string }{changed})
+		return
+	}
+	if len(parts) == 1 && parts[0] == "jobs" && r.Method == "GET" {
+		h.serveJobs(w, r)
+		return
+	}
+	if len(parts) == 2 && parts[0] == "intake" && r.Method == "POST" && (parts[1] == "pause" || parts[1] == "resume") {
+		paused := parts[1] == "pause"
+		if h.intakePaused.Swap(paused) != paused {
+			fmt.Println("intake", parts[1]+"d", "by operator")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct{ Paused bool }{paused})
+		return
+	}
+	if len(parts) == 1 && parts[0] == "drain" && r.Method == "POST" {
+		h.serveDrain(w, r)
+		return
+	}
+	if len(parts) == 1 && parts[0] == "nodesLimit" && r.Method == "POST" {
+		h.serveSetNodesLimit(w, r)
+		return
+	}
+	if parts[0] == "failed" && len(parts) <= 2 && r.Method == "GET" {
+		id := ""
+		if len(parts) == 2 {
+			id = parts[1]
+		}
+		h.serveDeadLetters(w, r, id)
+		return
+	}
+	if len(parts) == 1 && parts[0] == "workers" && r.Method == "POST" {
+		h.serveSetWorkers(w, r)
+		return
+	}
+	if len(parts) == 1 && parts[0] == "limitOverride" && r.Method == "POST" {
+		h.serveLimitOverride(w, r)
+		return
+	}
+	if len(parts) == 3 && parts[0] == "jobs" && r.Method == "POST" {
+		switch parts[2] {
+		case "requeue":
+			h.stopJob(w, parts[1], &jobStopped{requeue: true})
+			return
+		case "fail":
+			var body struct{ Reason string }
+			json.NewDecoder(r.Body).Decode(&body)
+			if body.Reason == "" {
+				body.Reason = "failed by operator"
+			}
+			h.stopJob(w, parts[1], &jobStopped{reason: body.Reason})
+			return
+		case "priority":
+			h.serveSetPriority(w, r, parts[1])
+			return
+		}
+	}
+	w.WriteHeader(404)
+}
+
+// Operational counters for spotting load and abuse. Admin-only since
+// uuids are the only credential for a job's results.
+type Stats struct {
+	QueueSize    int
+	Running      int
+	IntakePaused bool
+	// the workers wanted, and those still running, which is more while
+	// workers retired by a resize finish their jobs.
+	Workers        int
+	WorkersRunning int
+	// requests currently polling each job's progress.
+	Pollers map[string]int64
+	// warnings of jobs completed since startup, by code.
+	Warnings map[string]int64
+	// bytes freed in filesDir by expiring and evicting results since
+	// startup.
+	ReclaimedBytes int64
+}
+
+func (h *Server) stats() Stats {
+	h.runningMutex.Lock()
+	running := len(h.running)
+	h.runningMutex.Unlock()
+	stats := Stats{QueueSize: h.queue.Len(), Running: running, IntakePaused: h.intakePaused.Load(), Pollers: h.activePollers(), Warnings: h.warnings.Counts(), ReclaimedBytes: h.reclaimedBytes.Load()}
+	if h.pool != nil {
+		stats.Workers, stats.WorkersRunning = h.pool.Size()
+	}
+	return stats
+}
+
+// serveCancel handles DELETE /api/{uuid}. Like a job's results, the
+// uuid is the only credential needed to cancel it, unless API keys are
+// required.
+func (h *Server) serveCancel(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) != 3 || parts[0] != "" || parts[1] != "api" || uuid.Validate(parts[2]) != nil {
+		w.WriteHeader(404)
+		return
+	}
+	if _, ok := h.requireScope(w, r, scopeCancel, h.settings().RequireAPIKeys); !ok {
+		return
+	}
+	h.stopJob(w, parts[2], &jobStopped{client: true, reason: "cancelled by client"})
+}
+
+// stopJob requeues or fails a job in any state: queued jobs are
+// pulled from the queue, running ones are killed and cleaned up by
+// their worker, and failed ones are requeued from their region.json.
+func (h *Server) stopJob(w http.ResponseWriter, uuid string, stop *jobStopped) {
+	for _, name := range []string{uuid + ".osm.pbf", uuid + ".osm.pbf.enc"} {
+		if _, err := os.Stat(filepath.Join(h.filesDir, name)); err == nil {
+			w.WriteHeader(409)
+			fmt.Fprintf(w, "Error: the job is complete")
+			return
+		}
+	}
+
+	h.runningMutex.Lock()
+	job, running := h.running[uuid]
+	if running {
+		// the worker sees the cause once osmx exits.
+		job.cancel(stop)
+	}
+	h.runningMutex.Unlock()
+	if running {
+		w.WriteHeader(202)
+		return
+	}
+
+	if task, ok := h.queue.Remove(uuid); ok {
+		if stop.requeue {
+			h.queue.PushFront(task, task.EstimatedNodes)
+		} else if err := h.cancelQueued(task, stop.reason); err != nil {
+			w.WriteHeader(500)
+			return
+		}
+		w.WriteHeader(200)
+		return
+	}
+
+	var record Progress
+	recordBytes, err := os.ReadFile(filepath.Join(h.filesDir, uuid))
+	if err == nil && json.Unmarshal(recordBytes, &record) == nil {
+		if record.Complete || !stop.requeue {
+			w.WriteHeader(409)
+			fmt.Fprintf(w, "Error: the job has already finished")
+			return
+		}
+		taskBytes, err := os.ReadFile(filepath.Join(h.filesDir, uuid+"_region.json"))
+		var task Task
+		if err != nil || json.Unmarshal(taskBytes, &task) != nil {
+			w.WriteHeader(409)
+			fmt.Fprintf(w, "Error: the job's region is no longer available")
+			return
+		}
+		os.Remove(filepath.Join(h.filesDir, uuid))
+		h.results.Forget(uuid)
+		if err := h.deadLetters.Remove(uuid); err != nil {
+			fmt.Println("requeueing", uuid, err)
+		}
+		task.SubmittedAt = time.Now()
+		h.setProgress(uuid, Progress{})
+		h.persistJob(task, jobQueued)
+		h.queue.PushFront(task, 0)
+		w.WriteHeader(200)
+		return
+	}
+
+	w.WriteHeader(404)
+}
+
+// cancelQueued fails a task taken out of the queue for the reason.
+func (h *Server) cancelQueued(task Task, reason string) error {
+	h.forgetJob(task.Uuid)
+	if task.KeyName != "" {
+		h.quotas.Release(task.KeyName, task.EstimatedNodes)
+	}
+	h.failures.Fail(failureCancelled, time.Now())
+	if err := h.writeFailure(task.Uuid, failureCancelled, reason); err != nil {
+		return err
+	}
+	h.notifyCallback(task)
+	return nil
+}
+
+// serveDrain handles POST /api/admin/drain, failing every queued job
+// for the Reason of the body. Running jobs are left to finish.
+func (h *Server) serveDrain(w http.ResponseWriter, r *http.Request) {
+	var body struct{ Reason string }
+	json.NewDecoder(r.Body).Decode(&body)
+	if body.Reason == "" {
+		body.Reason = "drained by operator"
+	}
+	tasks := h.queue.RemoveAll()
+	for _, task := range tasks {
+		if err := h.cancelQueued(task, body.Reason); err != nil {
+			fmt.Println("draining", task.Uuid, err)
+		}
+	}
+	fmt.Println("drained", len(tasks), "queued jobs")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct{ Drained int }{len(tasks)})
+}
+
+// serveSetPriority handles POST /api/admin/jobs/{uuid}/priority,
+// moving a queued job to the tier of the Priority of the body.
+func (h *Server) serveSetPriority(w http.ResponseWriter, r *http.Request, uuid string) {
+	var body struct{ Priority string }
+	json.NewDecoder(r.Body).Decode(&body)
+	if body.Priority != priorityHigh && body.Priority != priorityNormal && body.Priority != priorityLow {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, "Error: Priority must be high, normal or low")
+		return
+	}
+	task, ok := h.queue.SetPriority(uuid, body.Priority)
+	if !ok {
+		w.WriteHeader(409)
+		fmt.Fprintf(w, "Error: the job is not queued")
+		return
+	}
+	h.persistJob(task, jobQueued)
+	progress := h.currentProgress(uuid)
+	progress.Priority = body.Priority
+	h.setProgress(uuid, progress)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct{ Position int }{h.queue.Position(uuid)})
+}
+
+// serveSetNodesLimit handles POST /api/admin/nodesLimit, changing the
+// nodes limit until the next reload or restart.
+func (h *Server) serveSetNodesLimit(w http.ResponseWriter, r *http.Request) {
+	var body struct{ NodesLimit int }
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.NodesLimit <= 0 {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, "Error: NodesLimit must be a positive number of nodes")
+		return
+	}
+	settings := h.settings()
+	if settings.NodesLimit != body.NodesLimit {
+		fmt.Printf("-hardNodesLimit: %q -> %q by operator\n", strconv.Itoa(settings.NodesLimit), strconv.Itoa(body.NodesLimit))
+	}
+	settings.NodesLimit = body.NodesLimit
+	h.setSettings(settings)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct{ NodesLimit int }{body.NodesLimit})
+}
+
+// serveSetWorkers handles POST /api/admin/workers, resizing the worker
+// pool to the Workers of the body until the next restart.
+func (h *Server) serveSetWorkers(w http.ResponseWriter, r *http.Request) {
+	var body struct{ Workers int }
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Workers <= 0 {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, "Error: Workers must be a positive number of workers")
+		return
+	}
+	if h.pool == nil {
+		w.WriteHeader(409)
+		fmt.Fprintf(w, "Error: the workers are not started")
+		return
+	}
+	previous, _ := h.pool.Size()
+	if err := h.SetWorkers(body.Workers); err != nil {
+		fmt.Println("resizing workers:", err)
+		w.WriteHeader(500)
+		return
+	}
+	if previous != body.Workers {
+		fmt.Printf("-workers: %q -> %q by operator\n", strconv.Itoa(previous), strconv.Itoa(body.Workers))
+	}
+	wanted, running := h.pool.Size()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct{ Workers, WorkersRunning int }{wanted, running})
+}
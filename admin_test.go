@@ -0,0 +1,194 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func adminRequest(h *Server, path string, body string) int {
+	r := httptest.NewRequest("POST", path, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer admin")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code
+}
+
+func withAdmin(h *Server) *Server {
+	h.apiKeys[hashAPIKey("admin")] = &APIKey{Name: "ops", Admin: true}
+	h.apiKeys[hashAPIKey("user")] = &APIKey{Name: "user"}
+	return h
+}
+
+func TestAdminRequiresAdminKey(t *testing.T) {
+	h := withAdmin(newTestServer(t, "osmx"))
+	r := httptest.NewRequest("POST", "/api/admin/jobs/x/fail", nil)
+	r.Header.Set("Authorization", "Bearer user")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, 403, w.Code)
+}
+
+func TestAdminFailQueued(t *testing.T) {
+	h := withAdmin(newTestServer(t, "osmx"))
+	h.progress = make(map[string]Progress)
+	h.progressJSON = make(map[string][]byte)
+	h.queue = NewScheduler("fifo", 10)
+	code, uuid := submit(h, richmond)
+	assert.Equal(t, 201, code)
+
+	assert.Equal(t, 200, adminRequest(h, "/api/admin/jobs/"+uuid+"/fail", `{"Reason":"bad region"}`))
+	assert.Equal(t, 0, h.queue.Len())
+	_, progress := getProgress(h, uuid)
+	assert.True(t, progress.Failed)
+	assert.Equal(t, "bad region", progress.Error)
+
+	assert.Equal(t, 409, adminRequest(h, "/api/admin/jobs/"+uuid+"/fail", ""))
+	assert.Equal(t, 404, adminRequest(h, "/api/admin/jobs/unknown/requeue", ""))
+}
+
+func TestAdminRequeueRunning(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "started")
+	// the first run hangs, the requeued one completes.
+	h := withAdmin(newTestServer(t, fakeOsmx(t, `if [ ! -e `+marker+` ]; then touch `+marker+`; sleep 30 > /dev/null; fi`)))
+	h.StartWorkers()
+	_, uuid := submit(h, richmond)
+
+	waitFor(t, func() bool {
+		_, err := os.Stat(marker)
+		return err == nil
+	})
+	assert.Equal(t, 202, adminRequest(h, "/api/admin/jobs/"+uuid+"/requeue", ""))
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, uuid)
+		return progress.Complete
+	})
+	assert.Equal(t, 409, adminRequest(h, "/api/admin/jobs/"+uuid+"/requeue", ""))
+}
+
+func TestAdminFailRunning(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "started")
+	h := withAdmin(newTestServer(t, fakeOsmx(t, `touch `+marker+`; sleep 30 > /dev/null`)))
+	h.StartWorkers()
+	_, uuid := submit(h, richmond)
+
+	waitFor(t, func() bool {
+		_, err := os.Stat(marker)
+		return err == nil
+	})
+	assert.Equal(t, 202, adminRequest(h, "/api/admin/jobs/"+uuid+"/fail", `{"Reason":"stuck"}`))
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, uuid)
+		return progress.Failed
+	})
+	_, progress := getProgress(h, uuid)
+	assert.Equal(t, "stuck", progress.Error)
+	assert.Empty(t, scratchFiles(h))
+}
+
+func TestAdminStats(t *testing.T) {
+	h := withAdmin(newTestServer(t, "osmx"))
+	h.StartWorkers()
+	r := httptest.NewRequest("GET", "/api/admin/stats", nil)
+	r.Header.Set("Authorization", "Bearer admin")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"Pollers":{}`)
+}
+
+func TestAdminQueueControl(t *testing.T) {
+	h := withAdmin(newTestServer(t, "osmx"))
+	h.progress = make(map[string]Progress)
+	h.progressJSON = make(map[string][]byte)
+	h.queue = NewScheduler("fifo", 10)
+	_, first := submit(h, richmond)
+	_, second := submit(h, richmond)
+
+	assert.Equal(t, 200, adminRequest(h, "/api/admin/jobs/"+second+"/priority", `{"Priority":"high"}`))
+	assert.Equal(t, 1, h.queue.Position(second))
+	assert.Equal(t, "high", h.currentProgress(second).Priority)
+	assert.Equal(t, 400, adminRequest(h, "/api/admin/jobs/"+second+"/priority", `{"Priority":"urgent"}`))
+	assert.Equal(t, 409, adminRequest(h, "/api/admin/jobs/unknown/priority", `{"Priority":"low"}`))
+
+	assert.Equal(t, 200, adminRequest(h, "/api/admin/intake/pause", ""))
+	assert.True(t, h.stats().IntakePaused)
+	code, _ := submit(h, richmond)
+	assert.Equal(t, 503, code)
+	assert.Equal(t, 200, adminRequest(h, "/api/admin/drain", `{"Reason":"maintenance"}`))
+	assert.Equal(t, 0, h.queue.Len())
+	for _, uuid := range []string{first, second} {
+		_, progress := getProgress(h, uuid)
+		assert.True(t, progress.Failed)
+		assert.Equal(t, "maintenance", progress.Error)
+	}
+	assert.Equal(t, 200, adminRequest(h, "/api/admin/intake/resume", ""))
+	code, _ = submit(h, richmond)
+	assert.Equal(t, 201, code)
+
+	assert.Equal(t, 200, adminRequest(h, "/api/admin/nodesLimit", `{"NodesLimit":1}`))
+	code, _ = submit(h, richmond)
+	assert.Equal(t, 400, code)
+	assert.Equal(t, 400, adminRequest(h, "/api/admin/nodesLimit", `{"NodesLimit":0}`))
+}
+
+func cancelRequest(h *Server, uuid string) int {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("DELETE", "/api/"+uuid, nil))
+	return w.Code
+}
+
+func TestCancelQueued(t *testing.T) {
+	h := newTestServer(t, "osmx")
+	h.progress = make(map[string]Progress)
+	h.progressJSON = make(map[string][]byte)
+	h.queue = NewScheduler("fifo", 10)
+	_, uuid := submit(h, richmond)
+
+	assert.Equal(t, 200, cancelRequest(h, uuid))
+	assert.Equal(t, 0, h.queue.Len())
+	_, progress := getProgress(h, uuid)
+	assert.True(t, progress.Failed)
+	assert.Equal(t, failureCancelled, progress.FailureCategory)
+	assert.Equal(t, "cancelled by client", progress.Error)
+
+	assert.Equal(t, 409, cancelRequest(h, uuid))
+	assert.Equal(t, 404, cancelRequest(h, "00000000-0000-0000-0000-000000000000"))
+	assert.Equal(t, 404, cancelRequest(h, "admin"))
+}
+
+func TestCancelRunning(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "started")
+	h := newTestServer(t, fakeOsmx(t, `touch `+marker+`; sleep 30 > /dev/null`))
+	h.StartWorkers()
+	_, uuid := submit(h, richmond)
+
+	waitFor(t, func() bool {
+		_, err := os.Stat(marker)
+		return err == nil
+	})
+	assert.Equal(t, 202, cancelRequest(h, uuid))
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, uuid)
+		return progress.Failed
+	})
+	_, progress := getProgress(h, uuid)
+	assert.Equal(t, failureCancelled, progress.FailureCategory)
+	assert.Equal(t, "cancelled by client", progress.Error)
+	assert.Empty(t, scratchFiles(h))
+}
+
+func TestCancelComplete(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.StartWorkers()
+	_, uuid := submit(h, richmond)
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, uuid)
+		return progress.Complete
+	})
+	assert.Equal(t, 409, cancelRequest(h, uuid))
+}
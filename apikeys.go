@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// An API key issued to a partner. Keys are optional; requests without
+// one are anonymous.
+type APIKey struct {
+	Name string
+	// extracted nodes and result bytes allowed per calendar month (UTC),
+	// 0 for no quota.
+	MonthlyNodesQuota int64
+	MonthlyBytesQuota int64
+	// extracted nodes allowed per day (UTC), 0 for no quota.
+	DailyNodesQuota int64
+	// bytes of results its jobs may keep in filesDir, 0 for no quota.
+	// Over it, new jobs are refused, or with ExpireOverQuota its oldest
+	// results are deleted to make room.
+	StorageBytesQuota int64
+	ExpireOverQuota   bool
+	// allowed to use the /api/admin endpoints.
+	Admin bool
+	// its jobs are queued as high priority.
+	Premium bool
+	// what the key may do: submit, cancel and admin. Without Scopes a
+	// key may submit and cancel, and administer if it is Admin.
+	Scopes []string `json:",omitempty"`
+}
+
+// the scopes an API key can be granted.
+const (
+	scopeSubmit = "submit"
+	scopeCancel = "cancel"
+	scopeAdmin  = "admin"
+)
+
+var errInvalidAPIKey = errors.New("invalid API key")
+
+func (key *APIKey) can(scope string) bool {
+	if scope == scopeAdmin && key.Admin {
+		return true
+	}
+	if key.Scopes == nil {
+		return scope != scopeAdmin
+	}
+	return slices.Contains(key.Scopes, scope)
+}
+
+// loadAPIKeys reads a JSON object mapping each key to its settings.
+// Keys are held by their SHA-256 so lookups don't compare secrets.
+func loadAPIKeys(path string) (map[string]*APIKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]*APIKey
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]*APIKey, len(raw))
+	for secret, key := range raw {
+		if key.Name == "" {
+			return nil, errors.New("API key is missing a Name")
+		}
+		for _, scope := range key.Scopes {
+			if scope != scopeSubmit && scope != scopeCancel && scope != scopeAdmin {
+				return nil, fmt.Errorf("API key %s: unknown scope %q", key.Name, scope)
+			}
+		}
+		keys[hashAPIKey(secret)] = key
+	}
+	return keys, nil
+}
+
+// keyNamed is the API key with the name, nil if there is none.
+func (h *Server) keyNamed(name string) *APIKey {
+	for _, key := range h.settings().APIKeys {
+		if key.Name == name {
+			return key
+		}
+	}
+	return nil
+}
+
+func hashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// authenticate returns the API key presented as a bearer token, nil
+// for anonymous requests, or errInvalidAPIKey for an unknown key.
+func (h *Server) authenticate(r *http.Request) (*APIKey, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return nil, nil
+	}
+	secret, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		return nil, errInvalidAPIKey
+	}
+	key, ok := h.settings().APIKeys[hashAPIKey(strings.TrimSpace(secret))]
+	if !ok {
+		return nil, errInvalidAPIKey
+	}
+	return key, nil
+}
+
+// requireScope authenticates a request for something that needs the
+// scope, writing a 401 or 403 if it can't. Anonymous requests are
+// allowed unless required is set; they return a nil key.
+func (h *Server) requireScope(w http.ResponseWriter, r *http.Request, scope string, required bool) (*APIKey, bool) {
+	key, err := h.authenticate(r)
+	if err != nil || (key == nil && required) {
+		if err == nil {
+			err = errors.New("an API key is required")
+		}
+		w.WriteHeader(401)
+		fmt.Fprintf(w, "Error: %s", err)
+		return nil, false
+	}
+	if key != nil && !key.can(scope) {
+		w.WriteHeader(403)
+		fmt.Fprintf(w, "Error: the API key lacks the %s scope", scope)
+		return nil, false
+	}
+	return key, true
+}
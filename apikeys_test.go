@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadAPIKeyScopes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	os.WriteFile(path, []byte(`{"a": {"Name": "reader", "Scopes": ["cancel"]}, "b": {"Name": "legacy", "Admin": true}}`), 0644)
+	keys, err := loadAPIKeys(path)
+	assert.Nil(t, err)
+	reader := keys[hashAPIKey("a")]
+	assert.False(t, reader.can(scopeSubmit))
+	assert.True(t, reader.can(scopeCancel))
+	assert.False(t, reader.can(scopeAdmin))
+	legacy := keys[hashAPIKey("b")]
+	assert.True(t, legacy.can(scopeSubmit))
+	assert.True(t, legacy.can(scopeAdmin))
+	assert.False(t, (&APIKey{Name: "user"}).can(scopeAdmin))
+	assert.True(t, (&APIKey{Name: "ops", Scopes: []string{scopeAdmin}}).can(scopeAdmin))
+
+	os.WriteFile(path, []byte(`{"a": {"Name": "typo", "Scopes": ["submitt"]}}`), 0644)
+	_, err = loadAPIKeys(path)
+	assert.ErrorContains(t, err, "submitt")
+}
+
+func TestRequireAPIKeys(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	// no workers: submissions stay queued.
+	h.queue = NewScheduler("fifo", 10)
+	h.progress = make(map[string]Progress)
+	h.apiKeys[hashAPIKey("submitter")] = &APIKey{Name: "submitter", Scopes: []string{scopeSubmit}}
+	h.apiKeys[hashAPIKey("canceller")] = &APIKey{Name: "canceller", Scopes: []string{scopeCancel}}
+	request := func(method string, target string, body string, key string) int {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		if key != "" {
+			r.Header.Set("Authorization", "Bearer "+key)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// keys are optional by default, but their scopes hold.
+	assert.Equal(t, 201, request("POST", "/api/", richmond, ""))
+	assert.Equal(t, 403, request("POST", "/api/", richmond, "canceller"))
+	assert.Equal(t, 401, request("POST", "/api/", richmond, "unknown"))
+
+	h.requireAPIKeys = true
+	assert.Equal(t, 401, request("POST", "/api/", richmond, ""))
+	assert.Equal(t, 401, request("POST", "/api/batch", `[`+richmond+`]`, ""))
+	assert.Equal(t, 401, request("POST", "/api/reservations", "", ""))
+	assert.Equal(t, 201, request("POST", "/api/", richmond, "submitter"))
+
+	id := "00000000-0000-4000-8000-000000000000"
+	assert.Equal(t, 401, request("DELETE", "/api/"+id, "", ""))
+	assert.Equal(t, 403, request("DELETE", "/api/"+id, "", "submitter"))
+	assert.Equal(t, 404, request("DELETE", "/api/"+id, "", "canceller"))
+
+	// reading status stays open.
+	assert.Equal(t, 404, request("GET", "/api/"+id, "", ""))
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// backfillRecords writes a minimal completion record for each result
+// pbf in filesDir that lacks one, such as results from early versions
+// or copied in by hand. Existing records are never touched, so running
+// it again changes nothing. Returns the uuids of the new records.
+func backfillRecords(filesDir string) ([]string, error) {
+	entries, err := os.ReadDir(filesDir)
+	if err != nil {
+		return nil, err
+	}
+	var reconstructed []string
+	for _, d := range entries {
+		id, ok := strings.CutSuffix(d.Name(), ".osm.pbf")
+		if !ok || d.IsDir() || uuid.Validate(id) != nil {
+			continue
+		}
+		recordPath := filepath.Join(filesDir, id)
+		if _, err := os.Stat(recordPath); !os.IsNotExist(err) {
+			continue
+		}
+		// the pbf may be a link to a blob.
+		info, err := os.Stat(filepath.Join(filesDir, d.Name()))
+		if err != nil {
+			return reconstructed, err
+		}
+		// a result cut short by a crash is dropped rather than served.
+		if _, err := verifyPBF(filepath.Join(filesDir, d.Name())); err != nil {
+			fmt.Println("removing incomplete result", d.Name()+":", err)
+			if err := os.Remove(filepath.Join(filesDir, d.Name())); err != nil {
+				return reconstructed, err
+			}
+			continue
+		}
+		modified := info.ModTime().UTC().Format(time.RFC3339)
+		record, err := json.Marshal(Progress{
+			Complete:        true,
+			PercentComplete: 100,
+			SizeBytes:       info.Size(),
+			StartedAt:       modified,
+			FinishedAt:      modified,
+			Reconstructed:   true,
+			Warnings:        []Warning{{warningReconstructed, "the completion record was lost and rebuilt from the result file"}},
+		})
+		if err != nil {
+			return reconstructed, err
+		}
+		if err := writeFileAtomic(recordPath, record); err != nil {
+			return reconstructed, err
+		}
+		reconstructed = append(reconstructed, id)
+	}
+	return reconstructed, nil
+}
+
+// reindex backfills missing completion records and adds them to the
+// results index.
+func (h *Server) reindex() (int, error) {
+	reconstructed, err := backfillRecords(h.filesDir)
+	for _, id := range reconstructed {
+		h.noteFinished(id)
+		if entry, ok := readResultEntry(h.filesDir, id); ok {
+			h.results.Add(entry)
+		}
+	}
+	return len(reconstructed), err
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// a valid osm.pbf of 42 bytes, as far as verifyPBF can tell.
+func smallPBF() []byte {
+	b := appendBlob(nil, "OSMHeader", []byte("header"))
+	return appendBlob(b, "OSMData", make([]byte, 4))
+}
+
+func TestBackfillRecords(t *testing.T) {
+	dir := t.TempDir()
+	lost := "0f4f6ee9-9ae4-4d2b-8b2c-1b5a4b8b2a10"
+	kept := "1a7e1d1e-2c2a-4f0b-9a55-3b0b8f0c5d11"
+	os.WriteFile(filepath.Join(dir, lost+".osm.pbf"), smallPBF(), 0644)
+	mtime := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	os.Chtimes(filepath.Join(dir, lost+".osm.pbf"), mtime, mtime)
+	os.WriteFile(filepath.Join(dir, kept+".osm.pbf"), smallPBF(), 0644)
+	os.WriteFile(filepath.Join(dir, kept), []byte(`{"Complete":true}`), 0644)
+	os.WriteFile(filepath.Join(dir, "other.osm.pbf"), nil, 0644)
+	truncated := "2b8c3d4e-5f60-4a71-8b92-a3b4c5d6e7f8"
+	os.WriteFile(filepath.Join(dir, truncated+".osm.pbf"), smallPBF()[:30], 0644)
+
+	reconstructed, err := backfillRecords(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{lost}, reconstructed)
+	record, _ := os.ReadFile(filepath.Join(dir, lost))
+	assert.JSONEq(t, `{"Timestamp":"","CellsTotal":0,"CellsProg":0,"NodesTotal":0,"NodesProg":0,"ElemsTotal":0,"ElemsProg":0,"SizeBytes":42,"Elapsed":0,"Complete":true,"PercentComplete":100,"StartedAt":"2020-05-01T12:00:00Z","FinishedAt":"2020-05-01T12:00:00Z","Reconstructed":true,"Warnings":[{"Code":"reconstructed","Message":"the completion record was lost and rebuilt from the result file"}]}`, string(record))
+	record, _ = os.ReadFile(filepath.Join(dir, kept))
+	assert.Equal(t, `{"Complete":true}`, string(record))
+	// a truncated result is removed instead of served as complete.
+	_, err = os.Stat(filepath.Join(dir, truncated+".osm.pbf"))
+	assert.True(t, os.IsNotExist(err))
+
+	reconstructed, _ = backfillRecords(dir)
+	assert.Empty(t, reconstructed)
+}
+
+func TestAdminReindex(t *testing.T) {
+	h := withAdmin(newTestServer(t, "osmx"))
+	h.StartWorkers()
+	id := "0f4f6ee9-9ae4-4d2b-8b2c-1b5a4b8b2a10"
+	os.WriteFile(filepath.Join(h.filesDir, id+".osm.pbf"), smallPBF(), 0644)
+
+	assert.Equal(t, 200, adminRequest(h, "/api/admin/reindex", ""))
+	code, progress := getProgress(h, id)
+	assert.Equal(t, 200, code)
+	assert.True(t, progress.Complete)
+	assert.True(t, progress.Reconstructed)
+	page, _, _ := h.results.Page("", "", 10)
+	assert.Equal(t, id, page[0].Uuid)
+}
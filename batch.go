@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// the most tasks accepted in one batch.
+const maxBatchSize = 500
+
+// The response to POST /api/batch. Uuids and DeleteTokens are in the
+// order of the submitted array, with "" for members that were rejected.
+type BatchCreated struct {
+	BatchId      string `json:",omitempty"`
+	Uuids        []string
+	DeleteTokens []string
+	Rejected     []BatchRejection `json:",omitempty"`
+}
+
+// A member of a batch that wasn't queued, with the status and error it
+// would have been rejected with on its own.
+type BatchRejection struct {
+	Index  int
+	Status int
+	Error  string
+}
+
+// The members of a batch, persisted as {id}_batch.json in filesDir.
+type Batch struct {
+	Uuids     []string
+	CreatedAt string
+}
+
+// The response to GET /api/batch/{id}.
+type BatchStatus struct {
+	BatchId   string
+	CreatedAt string
+	Total     int
+	// members by state: queued, running, complete, failed or evicted.
+	States map[string]int
+	// the mean of the members' progress, counting finished ones as 100.
+	PercentComplete float64
+	Jobs            []JobEntry
+}
+
+// memberWriter captures the response submitTask writes for one member
+// of a batch.
+type memberWriter struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (w *memberWriter) Header() http.Header         { return w.header }
+func (w *memberWriter) Write(b []byte) (int, error) { return w.body.Write(b) }
+func (w *memberWriter) WriteHeader(code int)        { w.code = code }
+
+// rejection reads the error out of a rejected member's response, which
+// is plain text or JSON with an Error field.
+func (w *memberWriter) rejection(index int) BatchRejection {
+	rejection := BatchRejection{Index: index, Status: w.code}
+	if strings.HasPrefix(w.header.Get("Content-Type"), "application/json") {
+		var body struct{ Error string }
+		json.Unmarshal(w.body.Bytes(), &body)
+		rejection.Error = body.Error
+	} else {
+		rejection.Error = strings.TrimPrefix(strings.TrimSpace(w.body.String()), "Error: ")
+	}
+	if rejection.Error == "" {
+		rejection.Error = http.StatusText(w.code)
+	}
+	return rejection
+}
+
+// serveBatch handles POST /api/batch, submitting each Input of the
+// array as if it was POSTed on its own with the same query.
+func (h *Server) serveBatch(w http.ResponseWriter, r *http.Request, key *APIKey) {
+	var inputs []json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&inputs); err != nil {
+		writeInputError(w, bodyError(err, "the body must be a JSON array of tasks"))
+		return
+	}
+	if len(inputs) == 0 || len(inputs) > maxBatchSize {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, "Error: a batch must have between 1 and %d tasks", maxBatchSize)
+		return
+	}
+	if !h.limitSubmissions(w, r, len(inputs)) {
+		return
+	}
+
+	created := BatchCreated{Uuids: make([]string, len(inputs)), DeleteTokens: make([]string, len(inputs))}
+	batch := Batch{CreatedAt: time.Now().UTC().Format(time.RFC3339)}
+	for i, input := range inputs {
+		member := r.Clone(r.Context())
+		member.Header.Set("Content-Type", "application/json")
+		member.Body = io.NopCloser(bytes.NewReader(input))
+		mw := &memberWriter{header: make(http.Header), code: 200}
+		if c := h.submitTask(mw, member, key, h.newUuid(uuid.NewString), true); c != nil {
+			h.recordUserJob(r, c)
+			created.Uuids[i] = c.Uuid
+			created.DeleteTokens[i] = c.DeleteToken
+			batch.Uuids = append(batch.Uuids, c.Uuid)
+		} else {
+			created.Rejected = append(created.Rejected, mw.rejection(i))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(batch.Uuids) == 0 {
+		w.WriteHeader(created.Rejected[0].Status)
+		json.NewEncoder(w).Encode(created)
+		return
+	}
+	created.BatchId = h.newUuid(uuid.NewString)
+	b, err := json.Marshal(batch)
+	if err == nil {
+		err = writeFileAtomic(filepath.Join(h.filesDir, created.BatchId+"_batch.json"), b)
+	}
+	if err != nil {
+		// the members are queued regardless; only the summary is lost.
+		fmt.Println("writing batch", created.BatchId, err)
+		created.BatchId = ""
+	}
+	w.WriteHeader(201)
+	json.NewEncoder(w).Encode(created)
+}
+
+// serveBatchStatus handles GET /api/batch/{id}.
+func (h *Server) serveBatchStatus(w http.ResponseWriter, id string) {
+	var batch Batch
+	if uuid.Validate(id) != nil {
+		w.WriteHeader(404)
+		return
+	}
+	b, err := os.ReadFile(filepath.Join(h.filesDir, id+"_batch.json"))
+	if err != nil || json.Unmarshal(b, &batch) != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	status := BatchStatus{BatchId: id, CreatedAt: batch.CreatedAt, Total: len(batch.Uuids), States: make(map[string]int)}
+	var percent float64
+	for _, member := range batch.Uuids {
+		entry := h.jobEntry(member)
+		status.Jobs = append(status.Jobs, entry)
+		status.States[entry.State]++
+		switch entry.State {
+		case "queued":
+		case "running":
+			percent += h.currentProgress(member).PercentComplete
+		default:
+			percent += 100
+		}
+	}
+	status.PercentComplete = percent / float64(len(batch.Uuids))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func postBatch(h *Server, body string) (int, BatchCreated) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/api/batch", strings.NewReader(body)))
+	var created BatchCreated
+	json.NewDecoder(w.Body).Decode(&created)
+	return w.Code, created
+}
+
+func getBatch(h *Server, id string) (int, BatchStatus) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/batch/"+id, nil))
+	var status BatchStatus
+	json.NewDecoder(w.Body).Decode(&status)
+	return w.Code, status
+}
+
+func TestBatch(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.StartWorkers()
+	code, created := postBatch(h, `[`+richmond+`,{"Name":"bad","RegionType":"bbox","RegionData":[1,2]},`+richmond+`]`)
+	assert.Equal(t, 201, code)
+	assert.NotEmpty(t, created.BatchId)
+	assert.Len(t, created.Uuids, 3)
+	assert.NotEmpty(t, created.Uuids[0])
+	assert.Empty(t, created.Uuids[1])
+	assert.NotEmpty(t, created.Uuids[2])
+	assert.Len(t, created.DeleteTokens[2], 32)
+	assert.Empty(t, created.DeleteTokens[1])
+	assert.Len(t, created.Rejected, 1)
+	assert.Equal(t, 1, created.Rejected[0].Index)
+	assert.Equal(t, 400, created.Rejected[0].Status)
+	assert.NotEmpty(t, created.Rejected[0].Error)
+
+	waitFor(t, func() bool {
+		_, status := getBatch(h, created.BatchId)
+		return status.States["complete"] == 2
+	})
+	code, status := getBatch(h, created.BatchId)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, 2, status.Total)
+	assert.Equal(t, 100.0, status.PercentComplete)
+	assert.Equal(t, created.Uuids[0], status.Jobs[0].Uuid)
+	assert.Equal(t, created.Uuids[2], status.Jobs[1].Uuid)
+}
+
+func TestBatchRejected(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.StartWorkers()
+	code, created := postBatch(h, `[{"Name":"bad","RegionType":"bbox","RegionData":[1,2]}]`)
+	assert.Equal(t, 400, code)
+	assert.Empty(t, created.BatchId)
+	assert.Equal(t, []string{""}, created.Uuids)
+
+	for _, body := range []string{`[]`, `{}`, `[` + strings.Repeat(richmond+`,`, maxBatchSize) + richmond + `]`} {
+		code, _ := postBatch(h, body)
+		assert.Equal(t, 400, code)
+	}
+	assert.Equal(t, 0, h.queue.Len())
+
+	code, _ = getBatch(h, "00000000-0000-4000-8000-000000000000")
+	assert.Equal(t, 404, code)
+	code, _ = getBatch(h, "../quota")
+	assert.Equal(t, 404, code)
+}
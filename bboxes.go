@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+// upper bound on the boxes of a single bbox region.
+const maxBboxes = 25
+
+// parseBboxesRegion parses a bbox RegionData that is a list of
+// min_lat,min_lon,max_lat,max_lon boxes. It is stored as a bboxes
+// region, and its geometry is the union of the boxes so that overlaps
+// aren't counted twice in the node estimate.
+func parseBboxesRegion(data json.RawMessage) (orb.Geometry, string, json.RawMessage, error) {
+	var boxes [][]float64
+	if err := json.Unmarshal(data, &boxes); err != nil {
+		return nil, "", nil, errors.New("input bboxes are invalid")
+	}
+	if len(boxes) == 0 {
+		return nil, "", nil, errors.New("input does not have any bboxes")
+	}
+	if len(boxes) > maxBboxes {
+		return nil, "", nil, fmt.Errorf("input has more than %d bboxes", maxBboxes)
+	}
+	for i, box := range boxes {
+		if err := checkBbox(box); err != nil {
+			return nil, "", nil, fmt.Errorf("bbox %d: %w", i, err)
+		}
+	}
+	sanitizedData, _ := json.Marshal(boxes)
+	return bboxesGeometry(boxes), "bboxes", sanitizedData, nil
+}
+
+func checkBbox(box []float64) error {
+	if len(box) != 4 {
+		return errors.New("a bbox has 4 coordinates")
+	}
+	for i, v := range box {
+		limit := 90.0
+		if i%2 == 1 {
+			limit = 180
+		}
+		if v < -limit || v > limit {
+			return errors.New("coordinates are out of range")
+		}
+	}
+	if box[0] >= box[2] || box[1] >= box[3] {
+		return errors.New("the minimum must be less than the maximum")
+	}
+	return nil
+}
+
+// bboxesGeometry is the union of the boxes, a Polygon if they overlap
+// into one.
+func bboxesGeometry(boxes [][]float64) orb.Geometry {
+	polygons := make([]orb.Polygon, len(boxes))
+	for i, box := range boxes {
+		polygons[i] = orb.Polygon{orb.Bound{Min: orb.Point{box[1], box[0]}, Max: orb.Point{box[3], box[2]}}.ToRing()}
+	}
+	union := unionPolygons(polygons)
+	if len(union) == 1 {
+		return union[0]
+	}
+	return union
+}
+
+// encodeBboxes converts a bboxes region to GeoJSON for osmx, which only
+// reads a single box from a bbox file.
+func encodeBboxes(data json.RawMessage) ([]byte, error) {
+	var boxes [][]float64
+	if err := json.Unmarshal(data, &boxes); err != nil || len(boxes) == 0 {
+		return nil, fmt.Errorf("invalid bboxes region %s", data)
+	}
+	for _, box := range boxes {
+		if len(box) != 4 {
+			return nil, fmt.Errorf("invalid bboxes region %s", data)
+		}
+	}
+	return geojson.NewGeometry(bboxesGeometry(boxes)).MarshalJSON()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/planar"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBboxes(t *testing.T) {
+	geom, _, regionType, data, err := parseInput(strings.NewReader(`{"Name":"tiles", "RegionType":"bbox", "RegionData":[[0,0,1,1],[0,1,1,2.0000001]]}`))
+	assert.Nil(t, err)
+	assert.Equal(t, "bboxes", regionType)
+	assert.JSONEq(t, `[[0,0,1,1],[0,1,1,2]]`, string(data))
+	// adjacent boxes dissolve into one rectangle.
+	polygon, ok := geom.(orb.Polygon)
+	assert.True(t, ok)
+	assert.InDelta(t, 2.0, planar.Area(polygon), 1e-9)
+}
+
+func TestBboxesOverlapCountedOnce(t *testing.T) {
+	geom, _, _, _, err := parseInput(strings.NewReader(`{"Name":"tiles", "RegionType":"bbox", "RegionData":[[0,0,2,2],[1,1,3,3],[10,10,11,11]]}`))
+	assert.Nil(t, err)
+	mp, ok := geom.(orb.MultiPolygon)
+	assert.True(t, ok)
+	assert.Equal(t, 2, len(mp))
+	assert.InDelta(t, 8.0, planar.Area(mp), 1e-9)
+}
+
+func TestBboxesInvalid(t *testing.T) {
+	for _, data := range []string{
+		`[[0,0,1,1],[0,0,1]]`,
+		`[[0,0,1,1],[1,0,0,1]]`,
+		`[[0,0,1,1],[0,0,0,1]]`,
+		`[[0,0,91,1]]`,
+		`[[0,-181,1,1]]`,
+		`[[0,0,1,1],"a"]`,
+		`[[0,0,1,1],[0,0,0.00000001,1]]`,
+	} {
+		_, _, _, _, err := parseInput(strings.NewReader(`{"Name":"tiles", "RegionType":"bbox", "RegionData":` + data + `}`))
+		assert.NotNil(t, err, data)
+	}
+
+	boxes := make([]string, maxBboxes+1)
+	for i := range boxes {
+		boxes[i] = "[0,0,1,1]"
+	}
+	_, _, _, _, err := parseInput(strings.NewReader(`{"Name":"tiles", "RegionType":"bbox", "RegionData":[` + strings.Join(boxes, ",") + `]}`))
+	assert.NotNil(t, err)
+}
+
+func TestBboxesRegionFile(t *testing.T) {
+	dir := t.TempDir()
+	task := Task{Uuid: "tiles", SanitizedRegionType: "bboxes", SanitizedRegionData: json.RawMessage(`[[0,0,1,1],[5,5,6,6]]`)}
+	path, err := writeRegionFile(dir, task)
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(dir, "tiles.geojson"), path)
+	b, _ := os.ReadFile(path)
+	assert.Contains(t, string(b), `"MultiPolygon"`)
+
+	geom, ok := regionGeometry(task)
+	assert.True(t, ok)
+	assert.Equal(t, orb.Bound{Min: orb.Point{0, 0}, Max: orb.Point{6, 6}}, geom.Bound())
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Unencrypted results are stored once per resultKey, as
+// blobs/{key}.osm.pbf in filesDir. {uuid}.osm.pbf is a relative
+// symlink to the blob, so duplicate extracts share storage and the
+// static file server keeps working. blobs.json records which uuids
+// reference each blob; a blob is deleted with its last reference.
+type BlobStore struct {
+	mutex    sync.Mutex
+	filesDir string
+	refs     map[string][]string
+}
+
+func blobPath(hash string) string {
+	return filepath.Join("blobs", hash+".osm.pbf")
+}
+
+// resultKey names the blob of a result: the hash of what the task
+// extracts and of the timestamp of the data it was extracted from, so
+// extracts of the same region from the same data share one file. A
+// result of data without a timestamp is keyed by its content hash.
+func resultKey(task Task, dataTimestamp string, contentHash string) string {
+	if dataTimestamp == "" {
+		return contentHash
+	}
+	sum := sha256.Sum256([]byte(regionHash(task) + "\x00" + dataTimestamp))
+	return hex.EncodeToString(sum[:])
+}
+
+// LoadBlobStore reads blobs.json and reconciles it with filesDir: the
+// index is rewritten after each change, but a crash or an external
+// cleanup can leave links, references or blobs behind.
+func LoadBlobStore(filesDir string) (*BlobStore, error) {
+	b := &BlobStore{filesDir: filesDir, refs: make(map[string][]string)}
+	if err := os.MkdirAll(filepath.Join(filesDir, "blobs"), 0755); err != nil {
+		return nil, err
+	}
+	if data, err := os.ReadFile(filepath.Join(filesDir, "blobs.json")); err == nil {
+		if err := json.Unmarshal(data, &b.refs); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// the links in filesDir are the source of truth.
+	linked := make(map[string][]string)
+	entries, err := os.ReadDir(filesDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range entries {
+		id, ok := strings.CutSuffix(d.Name(), ".osm.pbf")
+		if !ok || d.Type()&os.ModeSymlink == 0 || uuid.Validate(id) != nil {
+			continue
+		}
+		target, err := os.Readlink(filepath.Join(filesDir, d.Name()))
+		if err != nil {
+			return nil, err
+		}
+		hash, ok := strings.CutSuffix(filepath.Base(target), ".osm.pbf")
+		if !ok || target != blobPath(hash) {
+			continue
+		}
+		linked[hash] = append(linked[hash], id)
+	}
+	b.refs = linked
+
+	blobs, err := os.ReadDir(filepath.Join(filesDir, "blobs"))
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range blobs {
+		hash, _ := strings.CutSuffix(d.Name(), ".osm.pbf")
+		if len(b.refs[hash]) == 0 {
+			if err := os.Remove(filepath.Join(filesDir, "blobs", d.Name())); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return b, b.save()
+}
+
+// Publish moves the pbf at src into the blob for its key, or drops it
+// if an earlier job already produced the same bytes, and links
+// {id}.osm.pbf to the blob. A result that differs from the blob of its
+// key is stored by its contentHash instead, so that the checksums in
+// its record are those of the file served. It returns the key used.
+func (b *BlobStore) Publish(key string, contentHash string, src string, id string) (string, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for _, hash := range []string{key, contentHash} {
+		path := filepath.Join(b.filesDir, blobPath(hash))
+		if _, err := os.Stat(path); err != nil {
+			if err := publishFile(src, path); err != nil {
+				return "", err
+			}
+			return hash, b.link(hash, id)
+		}
+		same, err := sameContent(path, src)
+		if err != nil {
+			return "", err
+		}
+		if !same {
+			continue
+		}
+		if err := os.Remove(src); err != nil {
+			return "", err
+		}
+		// age-based cleanup goes by the newest reference.
+		now := time.Now()
+		if err := os.Chtimes(path, now, now); err != nil {
+			return "", err
+		}
+		return hash, b.link(hash, id)
+	}
+	return "", fmt.Errorf("blob %s differs from its content hash", contentHash)
+}
+
+// link points {id}.osm.pbf at the blob. The mutex must be held.
+func (b *BlobStore) link(hash string, id string) error {
+	if err := os.Symlink(blobPath(hash), filepath.Join(b.filesDir, id+".osm.pbf")); err != nil {
+		return err
+	}
+	b.refs[hash] = append(b.refs[hash], id)
+	return b.save()
+}
+
+// sameContent is whether two files have the same bytes.
+func sameContent(a string, b string) (bool, error) {
+	fa, err := os.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+	fb, err := os.Open(b)
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+	sa, err := fa.Stat()
+	if err != nil {
+		return false, err
+	}
+	sb, err := fb.Stat()
+	if err != nil {
+		return false, err
+	}
+	if sa.Size() != sb.Size() {
+		return false, nil
+	}
+	bufA, bufB := make([]byte, 64*1024), make([]byte, 64*1024)
+	for {
+		n, errA := io.ReadFull(fa, bufA)
+		_, errB := io.ReadFull(fb, bufB[:n])
+		if errB != nil && errB != io.EOF {
+			return false, errB
+		}
+		if !bytes.Equal(bufA[:n], bufB[:n]) {
+			return false, nil
+		}
+		if errA == io.EOF || errA == io.ErrUnexpectedEOF {
+			return true, nil
+		}
+		if errA != nil {
+			return false, errA
+		}
+	}
+}
+
+// Release drops the reference of a uuid, deleting its blob if it was
+// the last one. The {id}.osm.pbf link is removed by the caller.
+func (b *BlobStore) Release(id string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for hash, ids := range b.refs {
+		i := slices.Index(ids, id)
+		if i < 0 {
+			continue
+		}
+		ids = slices.Delete(ids, i, i+1)
+		if len(ids) > 0 {
+			b.refs[hash] = ids
+			return b.save()
+		}
+		delete(b.refs, hash)
+		if err := os.Remove(filepath.Join(b.filesDir, blobPath(hash))); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return b.save()
+	}
+	return nil
+}
+
+// Shared reports whether the blob of a uuid is referenced by others,
+// so deleting it would free nothing.
+func (b *BlobStore) Shared(id string) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for _, ids := range b.refs {
+		if slices.Contains(ids, id) {
+			return len(ids) > 1
+		}
+	}
+	return false
+}
+
+func (b *BlobStore) save() error {
+	data, err := json.Marshal(b.refs)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(b.filesDir, "blobs.json"), data)
+}
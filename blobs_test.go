@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSharedBlob(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.StartWorkers()
+	var ids []string
+	for i := 0; i < 2; i++ {
+		code, id := submit(h, richmond)
+		assert.Equal(t, 201, code)
+		waitFor(t, func() bool {
+			_, progress := getProgress(h, id)
+			return progress.Complete
+		})
+		ids = append(ids, id)
+	}
+
+	_, first := getProgress(h, ids[0])
+	_, second := getProgress(h, ids[1])
+	task := Task{SanitizedRegionType: "bbox", SanitizedRegionData: []byte("[37.5272,-77.4571,37.553,-77.4133]")}
+	assert.Equal(t, blobPath(resultKey(task, first.DataTimestamp, first.SHA256)), first.Blob)
+	assert.Equal(t, first.Blob, second.Blob)
+	blobs, _ := os.ReadDir(filepath.Join(h.filesDir, "blobs"))
+	assert.Len(t, blobs, 1)
+
+	// another region is stored apart, even with the same content.
+	code, other := submit(h, `{"Name":"a_name","RegionType":"bbox","RegionData":[37.5,-77.5,37.6,-77.4]}`)
+	assert.Equal(t, 201, code)
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, other)
+		return progress.Complete
+	})
+	_, third := getProgress(h, other)
+	assert.Equal(t, first.SHA256, third.SHA256)
+	assert.NotEqual(t, first.Blob, third.Blob)
+	for _, id := range ids {
+		target, err := os.Readlink(filepath.Join(h.filesDir, id+".osm.pbf"))
+		assert.Nil(t, err)
+		assert.Equal(t, first.Blob, target)
+	}
+
+	// the blob outlives the first reference only.
+	assert.Nil(t, h.evict(ids[0], "deleted"))
+	_, err := os.Stat(filepath.Join(h.filesDir, first.Blob))
+	assert.Nil(t, err)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/"+ids[1]+"/download", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, first.SizeBytes, int64(w.Body.Len()))
+
+	assert.Nil(t, h.evict(ids[1], "deleted"))
+	_, err = os.Stat(filepath.Join(h.filesDir, first.Blob))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestResultKey(t *testing.T) {
+	task := Task{SanitizedRegionType: "bbox", SanitizedRegionData: []byte("[1,2,3,4]")}
+	key := resultKey(task, "2024-01-01T00:00:00Z", "content")
+	assert.Len(t, key, 64)
+	assert.NotEqual(t, key, resultKey(task, "2024-01-02T00:00:00Z", "content"))
+	// the name is only a label.
+	task.SanitizedName = "other"
+	assert.Equal(t, key, resultKey(task, "2024-01-01T00:00:00Z", "other content"))
+	task.SanitizedRegionData = []byte("[1,2,3,5]")
+	assert.NotEqual(t, key, resultKey(task, "2024-01-01T00:00:00Z", "content"))
+	assert.Equal(t, "content", resultKey(task, "", "content"))
+}
+
+func TestPublishDifferentContent(t *testing.T) {
+	filesDir := t.TempDir()
+	b, _ := LoadBlobStore(filesDir)
+	first, second, third := "2637da98-20a1-428f-b6db-18ac2861b763", "94ff36f6-6e0b-4d0b-8a5c-2e6d1b0b4a7c", "0f4f6ee9-9ae4-4d2b-8b2c-1b5a4b8b2a10"
+	src := filepath.Join(t.TempDir(), "result.osm.pbf")
+
+	os.WriteFile(src, []byte("pbf"), 0644)
+	key, err := b.Publish("region", "aa", src, first)
+	assert.Nil(t, err)
+	assert.Equal(t, "region", key)
+
+	// the same bytes share the blob of the region.
+	os.WriteFile(src, []byte("pbf"), 0644)
+	key, err = b.Publish("region", "aa", src, second)
+	assert.Nil(t, err)
+	assert.Equal(t, "region", key)
+
+	// other bytes are kept apart, by their content hash.
+	os.WriteFile(src, []byte("other pbf"), 0644)
+	key, err = b.Publish("region", "bb", src, third)
+	assert.Nil(t, err)
+	assert.Equal(t, "bb", key)
+	content, _ := os.ReadFile(filepath.Join(filesDir, third+".osm.pbf"))
+	assert.Equal(t, "other pbf", string(content))
+	assert.Equal(t, map[string][]string{"region": {first, second}, "bb": {third}}, b.refs)
+}
+
+func TestLoadBlobStoreReconciles(t *testing.T) {
+	filesDir := t.TempDir()
+	os.MkdirAll(filepath.Join(filesDir, "blobs"), 0755)
+	id := "2637da98-20a1-428f-b6db-18ac2861b763"
+	os.WriteFile(filepath.Join(filesDir, blobPath("aa")), []byte("pbf"), 0644)
+	os.Symlink(blobPath("aa"), filepath.Join(filesDir, id+".osm.pbf"))
+	// left behind by a crash before it was linked.
+	os.WriteFile(filepath.Join(filesDir, blobPath("bb")), []byte("pbf"), 0644)
+	// an index that is out of date.
+	os.WriteFile(filepath.Join(filesDir, "blobs.json"), []byte(`{"bb":["94ff36f6-6e0b-4d0b-8a5c-2e6d1b0b4a7c"]}`), 0644)
+
+	b, err := LoadBlobStore(filesDir)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]string{"aa": {id}}, b.refs)
+	_, err = os.Stat(filepath.Join(filesDir, blobPath("bb")))
+	assert.True(t, os.IsNotExist(err))
+
+	assert.Nil(t, b.Release(id))
+	_, err = os.Stat(filepath.Join(filesDir, blobPath("aa")))
+	assert.True(t, os.IsNotExist(err))
+}
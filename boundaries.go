@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+// the properties of a boundary feature that hold its codes, as tagged
+// on OSM boundary relations.
+var isoCodeProperties = []string{"ISO3166-1", "ISO3166-2"}
+
+type boundary struct {
+	name string
+	geom orb.Geometry
+}
+
+// Boundaries are the country and subdivision polygons of
+// -boundariesFile, by ISO 3166-1 or ISO 3166-2 code.
+type Boundaries struct {
+	byCode map[string]boundary
+}
+
+// LoadBoundaries reads a GeoJSON FeatureCollection of Polygons and
+// MultiPolygons. Features without a code, or that aren't polygonal,
+// are skipped.
+func LoadBoundaries(path string) (*Boundaries, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fc, err := geojson.UnmarshalFeatureCollection(b)
+	if err != nil {
+		return nil, err
+	}
+	bs := &Boundaries{byCode: make(map[string]boundary)}
+	for _, f := range fc.Features {
+		switch f.Geometry.(type) {
+		case orb.Polygon, orb.MultiPolygon:
+		default:
+			continue
+		}
+		name, _ := f.Properties["name"].(string)
+		for _, property := range isoCodeProperties {
+			code, _ := f.Properties[property].(string)
+			if code == "" {
+				continue
+			}
+			code = strings.ToUpper(code)
+			if _, ok := bs.byCode[code]; ok {
+				return nil, fmt.Errorf("%s is the code of more than one feature", code)
+			}
+			bs.byCode[code] = boundary{name: name, geom: f.Geometry}
+		}
+	}
+	if len(bs.byCode) == 0 {
+		return nil, errors.New("no features have an ISO3166-1 or ISO3166-2 property")
+	}
+	return bs, nil
+}
+
+// resolveISO turns an iso region into the geojson region of the
+// boundary of its code.
+func (h *Server) resolveISO(ctx context.Context, input Input) (Input, error) {
+	if h.boundaries == nil {
+		return input, errors.New("iso regions are not configured on this server")
+	}
+	var code string
+	if json.Unmarshal(input.RegionData, &code) != nil || code == "" {
+		return input, errors.New("input iso must be an ISO 3166-1 or ISO 3166-2 code")
+	}
+	b, ok := h.boundaries.byCode[strings.ToUpper(strings.TrimSpace(code))]
+	if !ok {
+		return input, fmt.Errorf("no boundary has the code %q", code)
+	}
+	name := b.name
+	if name == "" {
+		name = strings.ToUpper(code)
+	}
+	return resolvedInput(input, b.geom, name), nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/stretchr/testify/assert"
+)
+
+const boundariesFile = `{"type":"FeatureCollection","features":[
+{"type":"Feature","properties":{"name":"Virginia","ISO3166-2":"US-VA"},"geometry":{"type":"Polygon","coordinates":[[[-77.4571,37.5530],[-77.4571,37.5272],[-77.4133,37.5272],[-77.4133,37.5530],[-77.4571,37.5530]]]}},
+{"type":"Feature","properties":{"ISO3166-1":"us"},"geometry":{"type":"MultiPolygon","coordinates":[[[[-77.4571,37.5530],[-77.4571,37.5272],[-77.4133,37.5272],[-77.4133,37.5530],[-77.4571,37.5530]]]]}},
+{"type":"Feature","properties":{"name":"Richmond"},"geometry":{"type":"Polygon","coordinates":[[[-77.4571,37.5530],[-77.4571,37.5272],[-77.4133,37.5272],[-77.4133,37.5530],[-77.4571,37.5530]]]}},
+{"type":"Feature","properties":{"ISO3166-2":"US-DC"},"geometry":{"type":"Point","coordinates":[-77.03,38.9]}}]}`
+
+func writeBoundaries(t *testing.T, data string) string {
+	path := filepath.Join(t.TempDir(), "boundaries.geojson")
+	os.WriteFile(path, []byte(data), 0644)
+	return path
+}
+
+func TestLoadBoundaries(t *testing.T) {
+	bs, err := LoadBoundaries(writeBoundaries(t, boundariesFile))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(bs.byCode))
+	assert.Equal(t, "Virginia", bs.byCode["US-VA"].name)
+	_, ok := bs.byCode["US"]
+	assert.True(t, ok)
+
+	_, err = LoadBoundaries(writeBoundaries(t, `{"type":"FeatureCollection","features":[]}`))
+	assert.NotNil(t, err)
+	duplicate := `{"type":"FeatureCollection","features":[
+{"type":"Feature","properties":{"ISO3166-1":"US"},"geometry":{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,0]]]}},
+{"type":"Feature","properties":{"ISO3166-1":"us"},"geometry":{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,0]]]}}]}`
+	_, err = LoadBoundaries(writeBoundaries(t, duplicate))
+	assert.Equal(t, "US is the code of more than one feature", err.Error())
+}
+
+func TestISORegion(t *testing.T) {
+	h := newTestServer(t, "osmx")
+	_, err := h.resolveRegion(context.Background(), Input{RegionType: "iso", RegionData: json.RawMessage(`"US-VA"`)})
+	assert.Equal(t, "iso regions are not configured on this server", err.Error())
+
+	h.boundaries, _ = LoadBoundaries(writeBoundaries(t, boundariesFile))
+	input, err := h.resolveRegion(context.Background(), Input{RegionType: "iso", RegionData: json.RawMessage(`"us-va"`)})
+	assert.Nil(t, err)
+	assert.Equal(t, "Virginia", input.Name)
+	geom, _, _, _, err := parseRegion(input, defaultRegionLimits)
+	assert.Nil(t, err)
+	_, isPolygon := geom.(orb.Polygon)
+	assert.True(t, isPolygon)
+
+	input, _ = h.resolveRegion(context.Background(), Input{RegionType: "iso", RegionData: json.RawMessage(`"US"`)})
+	assert.Equal(t, "US", input.Name)
+	_, err = h.resolveRegion(context.Background(), Input{RegionType: "iso", RegionData: json.RawMessage(`"US-DC"`)})
+	assert.Equal(t, `no boundary has the code "US-DC"`, err.Error())
+
+	capabilities := (&Server{queue: NewScheduler("fifo", 10), boundaries: h.boundaries}).capabilities()
+	assert.Contains(t, capabilities.RegionTypes, "iso")
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"sort"
+)
+
+// What this deployment accepts, so clients can render only the
+// controls the server supports. Built from the running configuration
+// on every request rather than maintained by hand.
+type Capabilities struct {
+	RegionTypes     []string
+	OutputFormats   []string
+	NodesLimit      int
+	SoftNodesLimit  int
+	MaxBufferMeters int
+	// limits that are 0 are not enforced.
+	MaxBodyBytes   int64
+	MaxVertices    int
+	MaxPolygons    int
+	MaxRegionBytes int
+	QueueCapacity  int
+	Scheduler      string
+	// decimal places kept in sanitized region coordinates.
+	RegionPrecision int
+	Webhooks        bool
+	ObjectStorage   bool
+	// users can log in with an OpenStreetMap account, and are then
+	// allowed UserNodesLimit nodes if it is above NodesLimit.
+	OSMLogin       bool
+	UserNodesLimit int `json:",omitempty"`
+	// results can be encrypted at rest; EncryptResults if always.
+	Encryption     bool
+	EncryptResults bool
+	// hours results are kept after they finish, 0 for until evicted.
+	RetentionHours float64
+	// osmx flags accepted in ExtraArgs.
+	ExtraArgs []string
+	// what a recurring extract can be refreshed on.
+	Schedules []string
+}
+
+func (h *Server) capabilities() Capabilities {
+	regionTypes := make([]string, 0, len(regionParsers))
+	for regionType := range regionParsers {
+		regionTypes = append(regionTypes, regionType)
+	}
+	if h.relations != nil {
+		regionTypes = append(regionTypes, "relation")
+	}
+	if h.places != nil {
+		regionTypes = append(regionTypes, "place")
+	}
+	if h.boundaries != nil {
+		regionTypes = append(regionTypes, "iso")
+	}
+	if h.regionUrls != nil {
+		regionTypes = append(regionTypes, "url")
+	}
+	sort.Strings(regionTypes)
+
+	settings := h.settings()
+	userNodesLimit := 0
+	if h.osmAuth != nil && h.userNodesLimit > settings.NodesLimit {
+		userNodesLimit = h.userNodesLimit
+	}
+	return Capabilities{
+		RegionTypes:     regionTypes,
+		OutputFormats:   []string{"osm.pbf"},
+		NodesLimit:      settings.NodesLimit,
+		SoftNodesLimit:  settings.softLimit(),
+		MaxBufferMeters: maxBufferMeters,
+		QueueCapacity:   h.queue.capacity,
+		Scheduler:       h.scheduler,
+		MaxBodyBytes:    settings.RegionLimits.MaxBodyBytes,
+		MaxVertices:     settings.RegionLimits.MaxVertices,
+		MaxPolygons:     settings.RegionLimits.MaxPolygons,
+		MaxRegionBytes:  settings.RegionLimits.MaxRegionBytes,
+		RegionPrecision: settings.RegionLimits.Precision,
+		Encryption:      h.encryptionKeys != nil,
+		EncryptResults:  h.encryptResults,
+		Webhooks:        h.webhooks != nil,
+		ObjectStorage:   h.objectStore != nil,
+		OSMLogin:        h.osmAuth != nil,
+		UserNodesLimit:  userNodesLimit,
+		RetentionHours:  settings.ResultTTL.Hours(),
+		ExtraArgs:       h.extraArgs.Names(),
+		Schedules:       scheduleNames,
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCapabilities(t *testing.T) {
+	h := Server{nodesLimit: 1000, softNodesLimit: 800, scheduler: "sjf", queue: NewScheduler("sjf", 512)}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/capabilities", nil))
+	assert.Equal(t, 200, w.Code)
+
+	var capabilities Capabilities
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&capabilities))
+	expected := []string{"bbox", "circle", "geojson", "geopackage", "gpx", "h3", "kml", "kmz", "poly", "shapefile", "tiles"}
+	if _, ok := regionParsers["h3"]; !ok {
+		// h3 regions are only parsed by cgo builds.
+		expected = []string{"bbox", "circle", "geojson", "geopackage", "gpx", "kml", "kmz", "poly", "shapefile", "tiles"}
+	}
+	assert.Equal(t, expected, capabilities.RegionTypes)
+	assert.Equal(t, 1000, capabilities.NodesLimit)
+	assert.Equal(t, 800, capabilities.SoftNodesLimit)
+	assert.Equal(t, "sjf", capabilities.Scheduler)
+	assert.Equal(t, 512, capabilities.QueueCapacity)
+	assert.False(t, capabilities.Webhooks)
+	assert.False(t, capabilities.ObjectStorage)
+	assert.Equal(t, 0.0, capabilities.RetentionHours)
+	assert.Equal(t, []string{"daily", "weekly", "monthly"}, capabilities.Schedules)
+}
+
+func TestCapabilitiesStorage(t *testing.T) {
+	h := Server{queue: NewScheduler("fifo", 10), objectStore: &ObjectStore{}, resultTTL: 36 * time.Hour}
+	h.regionLimits.MaxVertices = 5000
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/capabilities", nil))
+
+	var capabilities map[string]any
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&capabilities))
+	assert.Equal(t, true, capabilities["ObjectStorage"])
+	assert.Equal(t, 36.0, capabilities["RetentionHours"])
+	assert.Equal(t, 5000.0, capabilities["MaxVertices"])
+	assert.NotContains(t, capabilities, "Sync")
+	assert.NotContains(t, capabilities, "AreaLimit")
+}
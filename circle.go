@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+// upper bound on the radius of a circle region.
+const maxCircleRadiusMeters = 100000
+
+// number of vertices used to approximate a circle region.
+const circleSegments = 64
+
+// a [lon, lat, radius_meters] circle, approximated by a polygon that is
+// stored as a GeoJSON region.
+func parseCircleRegion(input Input, _ RegionLimits) (orb.Geometry, string, json.RawMessage, error) {
+	var coords []float64
+	if err := json.Unmarshal(input.RegionData, &coords); err != nil || len(coords) != 3 {
+		return nil, "", nil, errors.New("input circle must be [lon, lat, radius_meters]")
+	}
+	center := orb.Point{coords[0], coords[1]}
+	if center[0] < -180 || center[0] > 180 || center[1] < -90 || center[1] > 90 {
+		return nil, "", nil, errors.New("circle center is out of range")
+	}
+	meters := coords[2]
+	if !(meters > 0 && meters <= maxCircleRadiusMeters) {
+		return nil, "", nil, errors.New("circle radius must be between 0 and 100000 meters")
+	}
+	pieces := splitAntimeridian(orb.MultiPolygon{circle(center, meters)})
+	var geom orb.Geometry = pieces
+	if len(pieces) == 1 {
+		geom = pieces[0]
+	}
+	sanitizedData, _ := geojson.NewGeometry(geom).MarshalJSON()
+	return geom, "geojson", sanitizedData, nil
+}
+
+// circle approximates the area within meters of center, using a local
+// equirectangular projection like capsule.
+func circle(center orb.Point, meters float64) orb.Polygon {
+	kx := metersPerDegree * math.Max(math.Cos(center[1]*math.Pi/180), 0.01)
+	ky := metersPerDegree
+	ring := make(orb.Ring, 0, circleSegments+1)
+	for i := 0; i < circleSegments; i++ {
+		angle := 2 * math.Pi * float64(i) / circleSegments
+		ring = append(ring, orb.Point{
+			center[0] + math.Cos(angle)*meters/kx,
+			clampLat(center[1] + math.Sin(angle)*meters/ky),
+		})
+	}
+	ring = append(ring, ring[0])
+	return orb.Polygon{ring}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircle(t *testing.T) {
+	geom, name, regiontype, data, err := parseInput(strings.NewReader(`{"Name":"a_name","RegionType":"circle","RegionData":[-77.4352,37.5401,5000]}`))
+	assert.Nil(t, err)
+	assert.Equal(t, "a_name", name)
+	assert.Equal(t, "geojson", regiontype)
+	assert.Contains(t, string(data), "Polygon")
+	poly, isPolygon := geom.(orb.Polygon)
+	assert.True(t, isPolygon)
+	for _, p := range poly[0] {
+		assert.InDelta(t, 5000, geo.Distance(orb.Point{-77.4352, 37.5401}, p), 50)
+	}
+}
+
+func TestCircleAntimeridian(t *testing.T) {
+	geom, _, _, _, err := parseInput(strings.NewReader(`{"Name":"a_name","RegionType":"circle","RegionData":[179.99,-16.5,10000]}`))
+	assert.Nil(t, err)
+	mp, isMultiPolygon := geom.(orb.MultiPolygon)
+	assert.True(t, isMultiPolygon)
+	assert.Equal(t, 2, len(mp))
+	bound := mp.Bound()
+	assert.True(t, bound.Min[0] >= -180 && bound.Max[0] <= 180)
+}
+
+func TestCircleInvalid(t *testing.T) {
+	for _, data := range []string{`[-77.4,37.5]`, `{"lon":-77.4}`, `[-77.4,37.5,0]`, `[-77.4,37.5,200000]`, `[-190,37.5,1000]`, `[-77.4,95,1000]`} {
+		_, _, _, _, err := parseInput(strings.NewReader(`{"Name":"a_name","RegionType":"circle","RegionData":` + data + `}`))
+		assert.NotNil(t, err, data)
+	}
+}
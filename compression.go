@@ -0,0 +1,54 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// upper bound on a decompressed request body, so a small compressed
+// request can't expand without end.
+const maxDecompressedBytes = 1 << 30
+
+// decompressBody replaces the body of a request sent with
+// Content-Encoding gzip or zstd, such as a large boundary GeoJSON, by
+// its decompressed stream. It writes an error and returns false if the
+// encoding is not supported or the body doesn't start like it.
+func decompressBody(w http.ResponseWriter, r *http.Request) bool {
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	var body io.ReadCloser
+	switch encoding {
+	case "", "identity":
+		return true
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(400)
+			fmt.Fprintf(w, "Error: the gzip request body is invalid")
+			return false
+		}
+		body = gz
+	case "zstd":
+		zr, err := zstd.NewReader(r.Body, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(maxDecompressedBytes))
+		if err != nil {
+			w.WriteHeader(400)
+			fmt.Fprintf(w, "Error: the zstd request body is invalid")
+			return false
+		}
+		body = zr.IOReadCloser()
+	default:
+		w.Header().Set("Accept-Encoding", "gzip, zstd")
+		w.WriteHeader(415)
+		fmt.Fprintf(w, "Error: Content-Encoding %s is not supported, use gzip or zstd", encoding)
+		return false
+	}
+	r.Body = http.MaxBytesReader(w, body, maxDecompressedBytes)
+	r.ContentLength = -1
+	r.Header.Del("Content-Encoding")
+	r.Header.Del("Content-Length")
+	return true
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+func gzipped(s string) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(s))
+	w.Close()
+	return buf.Bytes()
+}
+
+func zstdCompressed(s string) []byte {
+	w, _ := zstd.NewWriter(nil)
+	defer w.Close()
+	return w.EncodeAll([]byte(s), nil)
+}
+
+func TestCompressedBody(t *testing.T) {
+	h := newTestServer(t, "osmx")
+	region := string(detailedRegion(2000))
+	for encoding, body := range map[string][]byte{"gzip": gzipped(region), "zstd": zstdCompressed(region)} {
+		assert.True(t, len(body) < len(region)/2, encoding)
+		r := httptest.NewRequest("POST", "/api/estimate", bytes.NewReader(body))
+		r.Header.Set("Content-Encoding", encoding)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		assert.Equal(t, 200, w.Code, encoding)
+	}
+}
+
+func TestCompressedBodyInvalid(t *testing.T) {
+	h := newTestServer(t, "osmx")
+	r := httptest.NewRequest("POST", "/api/estimate", strings.NewReader(richmond))
+	r.Header.Set("Content-Encoding", "br")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, 415, w.Code)
+	assert.Equal(t, "gzip, zstd", w.Header().Get("Accept-Encoding"))
+	assert.Equal(t, "Error: Content-Encoding br is not supported, use gzip or zstd", w.Body.String())
+
+	r = httptest.NewRequest("POST", "/api/estimate", strings.NewReader(richmond))
+	r.Header.Set("Content-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, 400, w.Code)
+	assert.Equal(t, "Error: the gzip request body is invalid", w.Body.String())
+
+	// a truncated stream fails like any other invalid body.
+	body := gzipped(richmond)
+	r = httptest.NewRequest("POST", "/api/estimate", bytes.NewReader(body[:len(body)/2]))
+	r.Header.Set("Content-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, 400, w.Code)
+}
+
+func TestCompressedUpload(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.StartWorkers()
+	res := reserve(h)
+	r := httptest.NewRequest("PUT", res.UploadURL, bytes.NewReader(gzipped(richmond)))
+	r.Header.Set("Content-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, 201, w.Code)
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, res.Uuid)
+		return progress.Complete
+	})
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the command line, with the settings of any -config file
+// for the flags it doesn't give.
+type Config struct {
+	ConfigFile string
+
+	BindAddress        string
+	SocketMode         string
+	LogRequests        bool
+	FilesDir           string
+	MaxFilesBytes      int64
+	ResultTTLHours     float64
+	TmpDir             string
+	BytesPerNode       float64
+	StorageMargin      int64
+	Exec               string
+	ExtractNice        int
+	ExtractIOClass     string
+	ExtractCgroup      string
+	ExtractMemory      int64
+	SentryDsn          string
+	NodesLimit         int
+	SoftNodesLimit     int
+	RegionLimits       RegionLimits
+	APIKeysFile        string
+	RequireAPIKeys     bool
+	EncryptionKeyFile  string
+	EncryptResults     bool
+	MD5Checksums       bool
+	WebhookSecretFile  string
+	ObjectStoreUrl     string
+	ObjectStoreKeyFile string
+	ObjectStoreRegion  string
+	ObjectPublicUrl    string
+	DownloadSecretFile string
+	DownloadMinutes    float64
+	OSMClientId        string
+	OSMSecretFile      string
+	OSMRedirectUrl     string
+	OSMUrl             string
+	OSMApiUrl          string
+	NominatimUrl       string
+	BoundariesFile     string
+	RegionUrlAllowlist string
+	UserNodesLimit     int
+	StatsFile          string
+	QueueWaitBuckets   string
+	ExtractBuckets     string
+	SizeBuckets        string
+	StallMinutes       float64
+	KillStalledMinutes float64
+	MaxFailureRate     float64
+	Scheduler          string
+	Workers            int
+	LargeJobNodes      int
+	LargeWorkers       int
+	SmallJobNodes      int
+	DedupeMinutes      float64
+	CacheStaleMinutes  float64
+	SubmitsPerMinute   float64
+	SubmitBurst        int
+	TrustedProxies     string
+	ExtraArgsAllowlist string
+	OverrideSecretFile string
+	CORSOrigins        string
+	ShutdownGrace      float64
+
+	// the OSMX_FILE argument.
+	Data string
+}
+
+// the flags a reload can change. Changing any other is refused, since
+// it needs a restart.
+var reloadableFlags = map[string]bool{
+	"nodesLimit":         true,
+	"hardNodesLimit":     true,
+	"softNodesLimit":     true,
+	"regionPrecision":    true,
+	"maxRegionBytes":     true,
+	"maxBodyBytes":       true,
+	"maxRegionVertices":  true,
+	"maxRegionPolygons":  true,
+	"apiKeysFile":        true,
+	"requireAPIKeys":     true,
+	"maxFilesBytes":      true,
+	"resultTTLHours":     true,
+	"storageMarginBytes": true,
+	"bytesPerNode":       true,
+	"stallMinutes":       true,
+	"killStalledMinutes": true,
+	"maxFailureRate":     true,
+	"corsOrigins":        true,
+	"submitsPerMinute":   true,
+	"submitBurst":        true,
+}
+
+func defineFlags(fs *flag.FlagSet, c *Config) {
+	tmpDir := os.Getenv("TMPDIR")
+	if tmpDir == "" {
+		tmpDir = "/tmp"
+	}
+	fs.StringVar(&c.ConfigFile, "config", "", "YAML file, or file of flag=value lines, of the flags not given on the command line or in SLICEOSM_ environment variables, re-read on SIGHUP")
+	fs.StringVar(&c.BindAddress, "bind", ":8080", "IP address and port to listen on, or unix:/path/to.sock")
+	fs.StringVar(&c.SocketMode, "socketMode", "0660", "Permissions of a unix domain socket")
+	fs.BoolVar(&c.LogRequests, "accessLog", false, "Log every request")
+	fs.StringVar(&c.FilesDir, "filesDir", "", "Result directory")
+	fs.Int64Var(&c.MaxFilesBytes, "maxFilesBytes", 0, "Evict results when filesDir is larger than this many bytes, 0 for no limit")
+	fs.Float64Var(&c.ResultTTLHours, "resultTTLHours", 0, "Delete jobs, with their records and regions, this many hours after they finish; 0 to keep them")
+	fs.StringVar(&c.TmpDir, "tmpDir", tmpDir, "Scratch directory for running extracts, with one subdirectory per worker")
+	fs.Float64Var(&c.BytesPerNode, "bytesPerNode", defaultBytesPerNode, "Estimated output bytes per node until enough results completed to measure it, to refuse jobs larger than the scratch or result storage; 0 to disable")
+	fs.Int64Var(&c.StorageMargin, "storageMarginBytes", defaultStorageMarginBytes, "Free space in filesDir kept when accepting jobs by their estimated output")
+	fs.StringVar(&c.Exec, "exec", "osmx", "Path to OSMX executable")
+	fs.IntVar(&c.ExtractNice, "extractNice", 0, "Niceness added to extracts, from 1 to 19 so they give way to the API; 0 to inherit")
+	fs.StringVar(&c.ExtractIOClass, "extractIOClass", "", "I/O scheduling class of extracts: idle, or best-effort at the lowest priority; empty to inherit")
+	fs.StringVar(&c.ExtractCgroup, "extractCgroup", "", "cgroup v2 directory, delegated to this user, in which each extract runs in a child cgroup of its own")
+	fs.Int64Var(&c.ExtractMemory, "extractMemoryBytes", 0, "Memory each extract may use before it is killed, as the memory.max of its cgroup in -extractCgroup; 0 for no limit")
+	fs.StringVar(&c.SentryDsn, "sentryDsn", "", "Sentry DSN")
+	fs.IntVar(&c.NodesLimit, "hardNodesLimit", 100000000, "Nodes limit over which submissions are refused")
+	fs.IntVar(&c.NodesLimit, "nodesLimit", 100000000, "Deprecated name of -hardNodesLimit")
+	fs.IntVar(&c.SoftNodesLimit, "softNodesLimit", 0, "Nodes limit clients warn at before submitting, reported in the system state; 0 for -hardNodesLimit")
+	fs.IntVar(&c.RegionLimits.Precision, "regionPrecision", defaultRegionLimits.Precision, "Decimal places kept in region coordinates")
+	fs.IntVar(&c.RegionLimits.MaxRegionBytes, "maxRegionBytes", defaultRegionLimits.MaxRegionBytes, "Largest sanitized region in bytes, 0 for no limit")
+	fs.Int64Var(&c.RegionLimits.MaxBodyBytes, "maxBodyBytes", defaultRegionLimits.MaxBodyBytes, "Largest POST or PUT body in bytes, once decompressed, 0 for no limit")
+	fs.IntVar(&c.RegionLimits.MaxVertices, "maxRegionVertices", defaultRegionLimits.MaxVertices, "Most vertices of a submitted region, 0 for no limit")
+	fs.IntVar(&c.RegionLimits.MaxPolygons, "maxRegionPolygons", defaultRegionLimits.MaxPolygons, "Most polygons of a submitted region, 0 for no limit")
+	fs.StringVar(&c.APIKeysFile, "apiKeysFile", "", "JSON file of API keys and their quotas")
+	fs.BoolVar(&c.RequireAPIKeys, "requireAPIKeys", false, "Refuse submissions and cancellations without an API key with the submit or cancel scope")
+	fs.StringVar(&c.EncryptionKeyFile, "encryptionKeyFile", "", "JSON file of AES-256 keys for encrypting results at rest")
+	fs.BoolVar(&c.EncryptResults, "encryptResults", false, "Encrypt every result, not only those that request it")
+	fs.BoolVar(&c.MD5Checksums, "md5Checksums", false, "Add the MD5 of each result to its completion record, besides its SHA-256")
+	fs.StringVar(&c.WebhookSecretFile, "webhookSecretFile", "", "File of the shared secret completion callbacks are signed with; CallbackUrl is refused without it")
+	fs.StringVar(&c.DownloadSecretFile, "downloadSecretFile", "", "File of the secret download links are signed with; downloads without a valid link are refused")
+	fs.Float64Var(&c.DownloadMinutes, "downloadLinkMinutes", defaultDownloadLinkMinutes, "How long the signed download links of -downloadSecretFile are valid, in minutes")
+	fs.StringVar(&c.ObjectStoreUrl, "objectStoreUrl", "", "URL of an S3 bucket, or GCS bucket through its XML API, with an optional key prefix, that completed results and their records are uploaded to")
+	fs.StringVar(&c.ObjectStoreKeyFile, "objectStoreKeyFile", "", "JSON file of the AccessKeyId and SecretAccessKey, or GCS HMAC key, of -objectStoreUrl")
+	fs.StringVar(&c.ObjectStoreRegion, "objectStoreRegion", "us-east-1", "Region of -objectStoreUrl, auto for GCS")
+	fs.StringVar(&c.ObjectPublicUrl, "objectPublicUrl", "", "URL the objects of -objectStoreUrl are downloaded from, such as a CDN (default -objectStoreUrl)")
+	fs.StringVar(&c.OSMClientId, "osmClientId", "", "Client ID of an openstreetmap.org OAuth 2.0 application, to let users log in with their OSM account")
+	fs.StringVar(&c.OSMSecretFile, "osmClientSecretFile", "", "File of the client secret of the -osmClientId application")
+	fs.StringVar(&c.OSMRedirectUrl, "osmRedirectUrl", "", "Public URL of /api/auth/callback, as registered with the -osmClientId application")
+	fs.StringVar(&c.OSMUrl, "osmUrl", "https://www.openstreetmap.org", "OpenStreetMap website users log in with")
+	fs.StringVar(&c.BoundariesFile, "boundariesFile", "", "GeoJSON FeatureCollection of country and subdivision boundaries with ISO3166-1 or ISO3166-2 properties; iso regions are refused without it")
+	fs.StringVar(&c.NominatimUrl, "nominatimUrl", "", "Nominatim server place regions are geocoded with, such as https://nominatim.openstreetmap.org; place regions are refused without it")
+	fs.StringVar(&c.RegionUrlAllowlist, "regionUrlAllowlist", "", "Comma separated hosts url regions are fetched from, or * for any host with a public address; url regions are refused without it")
+	fs.StringVar(&c.OSMApiUrl, "osmApiUrl", "https://api.openstreetmap.org", "OpenStreetMap API the boundaries of relation regions are fetched from; empty to refuse relation regions")
+	fs.IntVar(&c.UserNodesLimit, "userNodesLimit", 0, "Nodes limit for users logged in with an OSM account, used if above -hardNodesLimit")
+	fs.StringVar(&c.StatsFile, "statsFile", "", "Prometheus text file of stats and job histograms, rewritten periodically (default stats.prom in -filesDir)")
+	fs.StringVar(&c.QueueWaitBuckets, "queueWaitBuckets", defaultQueueWaitBuckets, "Comma separated bucket bounds of the queue wait histogram, in seconds")
+	fs.StringVar(&c.ExtractBuckets, "extractBuckets", defaultExtractBuckets, "Comma separated bucket bounds of the extract duration histogram, in seconds")
+	fs.StringVar(&c.SizeBuckets, "sizeBuckets", defaultSizeBuckets, "Comma separated bucket bounds of the output size histogram, in bytes")
+	fs.Float64Var(&c.StallMinutes, "stallMinutes", defaultStallMinutes, "Flag running extracts whose progress hasn't advanced in this many minutes, 0 to disable")
+	fs.Float64Var(&c.KillStalledMinutes, "killStalledMinutes", 0, "Fail running extracts whose progress hasn't advanced in this many minutes, 0 to never")
+	fs.Float64Var(&c.MaxFailureRate, "maxFailureRate", defaultMaxFailureRate, "Fraction of extracts failed in the last 15 minutes above which the status is warn")
+	fs.StringVar(&c.Scheduler, "scheduler", "fifo", "Queue order: fifo or sjf (smallest node estimate first)")
+	fs.IntVar(&c.Workers, "workers", 0, "Extracts run at once, 0 for one per CPU")
+	fs.IntVar(&c.LargeJobNodes, "largeJobNodes", 0, "Run jobs estimated at more than this many nodes only on the first -largeWorkers workers, keeping the others for smaller jobs; 0 to run them on any")
+	fs.IntVar(&c.LargeWorkers, "largeWorkers", 1, "Workers that may run jobs over -largeJobNodes")
+	fs.Float64Var(&c.DedupeMinutes, "dedupeMinutes", defaultDedupeMinutes, "Return the job of an identical region queued, running or completed within this many minutes instead of extracting it again, 0 to disable")
+	fs.Float64Var(&c.CacheStaleMinutes, "cacheStalenessMinutes", 0, "Return the result of an identical region whose data is at most this many minutes behind the data file instead of extracting it again, 0 to disable")
+	fs.IntVar(&c.SmallJobNodes, "smallJobNodes", defaultSmallJobNodes, "Queue jobs estimated at up to this many nodes ahead of larger ones, 0 to disable")
+	fs.Float64Var(&c.SubmitsPerMinute, "submitsPerMinute", 0, "Submissions each client IP may make per minute past -submitBurst, 0 for no limit")
+	fs.IntVar(&c.SubmitBurst, "submitBurst", defaultSubmitBurst, "Submissions a client IP may make at once before -submitsPerMinute applies")
+	fs.StringVar(&c.OverrideSecretFile, "limitOverrideSecretFile", "", "File of the secret X-Limit-Override tokens are signed with; tokens are ignored without it")
+	fs.StringVar(&c.TrustedProxies, "trustedProxies", "", "Comma separated CIDRs of reverse proxies whose Forwarded, X-Forwarded-For and X-Real-IP headers name the client, and unix for a proxy on the unix socket")
+	fs.StringVar(&c.ExtraArgsAllowlist, "extraArgsAllowlist", "", "Comma separated osmx flags clients may set in ExtraArgs, each bare or as --flag=regexp its value must match")
+	fs.Float64Var(&c.ShutdownGrace, "shutdownGraceMinutes", defaultShutdownGraceMinutes, "On SIGTERM, wait this many minutes for running extracts to finish before stopping them")
+	fs.StringVar(&c.CORSOrigins, "corsOrigins", "*", "Comma separated origins allowed to read responses in a browser, or * for any")
+}
+
+// parseConfig parses the command line into fs, then applies the
+// SLICEOSM_ environment variables and then the -config file to the
+// flags still unset.
+func parseConfig(fs *flag.FlagSet, args []string) (*Config, error) {
+	c := &Config{}
+	defineFlags(fs, c)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	c.Data = fs.Arg(0)
+
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		markGiven(given, f.Name)
+	})
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok || given[f.Name] || err != nil {
+			return
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("%s: %w", envName(f.Name), setErr)
+		}
+		markGiven(given, f.Name)
+	})
+	if err != nil || c.ConfigFile == "" {
+		return c, err
+	}
+
+	settings, err := readConfigFile(c.ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range settings {
+		if s[0] == "config" {
+			return nil, errors.New("-config can't be set in the config file")
+		}
+		if given[s[0]] {
+			continue
+		}
+		if err := fs.Set(s[0], s[1]); err != nil {
+			return nil, fmt.Errorf("%s: -%s: %w", c.ConfigFile, s[0], err)
+		}
+	}
+	return c, nil
+}
+
+// old names of flags, set together with the flag they stand for.
+var flagAliases = map[string]string{
+	"nodesLimit": "hardNodesLimit",
+}
+
+// markGiven records that a flag was set, and so its alias or the flag
+// it is an alias of, which the environment and -config file then don't
+// override.
+func markGiven(given map[string]bool, name string) {
+	given[name] = true
+	for alias, flag := range flagAliases {
+		if name == alias {
+			given[flag] = true
+		} else if name == flag {
+			given[alias] = true
+		}
+	}
+}
+
+// envName is the environment variable of a flag: SLICEOSM_ and the flag
+// in upper snake case, such as SLICEOSM_API_KEYS_FILE for -apiKeysFile.
+func envName(flag string) string {
+	var b strings.Builder
+	b.WriteString("SLICEOSM_")
+	runes := []rune(flag)
+	for i, r := range runes {
+		upper := unicode.IsUpper(r)
+		// a word starts at an upper case letter after a lower case one,
+		// or at the last letter of an acronym followed by lower case.
+		if i > 0 && upper && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// readConfigFile reads a .yaml or .yml file as a mapping of flags to
+// values, with lists joined by commas, or any other file as flag=value
+// lines, ignoring blank lines and those starting with #. Values may be
+// in double quotes, so a flat TOML file can be read too. The flag may
+// have its leading dash.
+func readConfigFile(path string) ([][2]string, error) {
+	if ext := filepath.Ext(path); ext == ".yaml" || ext == ".yml" {
+		return readYAMLConfig(path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var settings [][2]string
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected flag=value", path, n)
+		}
+		value = strings.TrimSpace(value)
+		if unquoted, err := strconv.Unquote(value); err == nil && strings.HasPrefix(value, `"`) {
+			value = unquoted
+		}
+		settings = append(settings, [2]string{strings.TrimLeft(strings.TrimSpace(name), "-"), value})
+	}
+	return settings, scanner.Err()
+}
+
+func readYAMLConfig(path string) ([][2]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var settings [][2]string
+	for name, node := range doc {
+		switch node.Kind {
+		case yaml.ScalarNode:
+			settings = append(settings, [2]string{strings.TrimLeft(name, "-"), node.Value})
+		case yaml.SequenceNode:
+			values := make([]string, 0, len(node.Content))
+			for _, item := range node.Content {
+				if item.Kind != yaml.ScalarNode {
+					return nil, fmt.Errorf("%s:%d: %s: expected a list of values", path, item.Line, name)
+				}
+				values = append(values, item.Value)
+			}
+			settings = append(settings, [2]string{strings.TrimLeft(name, "-"), strings.Join(values, ",")})
+		default:
+			return nil, fmt.Errorf("%s:%d: %s: expected a value or a list", path, node.Line, name)
+		}
+	}
+	// in a stable order, so the same error is reported each time.
+	slices.SortFunc(settings, func(a, b [2]string) int { return strings.Compare(a[0], b[0]) })
+	return settings, nil
+}
+
+// loadSettings builds the reloadable settings of a configuration,
+// reading its API keys.
+func loadSettings(c *Config) (Settings, error) {
+	apiKeys := make(map[string]*APIKey)
+	if c.RequireAPIKeys && c.APIKeysFile == "" {
+		return Settings{}, errors.New("-requireAPIKeys needs -apiKeysFile")
+	}
+	if c.SubmitsPerMinute > 0 && c.SubmitBurst < 1 {
+		return Settings{}, errors.New("-submitBurst must be at least 1")
+	}
+	if c.APIKeysFile != "" {
+		var err error
+		apiKeys, err = loadAPIKeys(c.APIKeysFile)
+		if err != nil {
+			return Settings{}, fmt.Errorf("loading API keys: %w", err)
+		}
+	}
+	return Settings{
+		NodesLimit:       c.NodesLimit,
+		SoftNodesLimit:   c.SoftNodesLimit,
+		RegionLimits:     c.RegionLimits,
+		APIKeys:          apiKeys,
+		RequireAPIKeys:   c.RequireAPIKeys,
+		MaxFilesBytes:    c.MaxFilesBytes,
+		ResultTTL:        time.Duration(c.ResultTTLHours * float64(time.Hour)),
+		StorageMargin:    c.StorageMargin,
+		BytesPerNode:     c.BytesPerNode,
+		StallAfter:       time.Duration(c.StallMinutes * float64(time.Minute)),
+		KillStalledAfter: time.Duration(c.KillStalledMinutes * float64(time.Minute)),
+		MaxFailureRate:   c.MaxFailureRate,
+		CORSOrigins:      parseCORSOrigins(c.CORSOrigins),
+		SubmitsPerSecond: c.SubmitsPerMinute / 60,
+		SubmitBurst:      c.SubmitBurst,
+	}, nil
+}
+
+// Reloader re-reads the configuration the server was started with.
+type Reloader struct {
+	mutex sync.Mutex
+	args  []string
+	flags *flag.FlagSet // as last applied
+}
+
+func NewReloader(args []string, flags *flag.FlagSet) *Reloader {
+	return &Reloader{args: args, flags: flags}
+}
+
+// Reload parses the command line and -config file again and swaps the
+// changed settings into the server, returning what changed. A reload
+// that changes a flag that needs a restart changes nothing.
+func (rl *Reloader) Reload(h *Server) ([]string, error) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	fs := flag.NewFlagSet("reload", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	c, err := parseConfig(fs, rl.args)
+	if err != nil {
+		return nil, err
+	}
+
+	var changed, refused []string
+	fs.VisitAll(func(f *flag.Flag) {
+		previous := rl.flags.Lookup(f.Name).Value.String()
+		if previous == f.Value.String() || flagAliases[f.Name] != "" {
+			return
+		}
+		if !reloadableFlags[f.Name] {
+			refused = append(refused, "-"+f.Name)
+			return
+		}
+		changed = append(changed, fmt.Sprintf("-%s: %q -> %q", f.Name, previous, f.Value.String()))
+	})
+	if len(refused) > 0 {
+		return nil, fmt.Errorf("%s can't be changed without a restart", strings.Join(refused, ", "))
+	}
+
+	settings, err := loadSettings(c)
+	if err != nil {
+		return nil, err
+	}
+	changed = append(changed, diffAPIKeys(h.settings().APIKeys, settings.APIKeys)...)
+	h.setSettings(settings)
+	rl.flags = fs
+	return changed, nil
+}
+
+// reload applies a reload, logging what changed or why it was refused.
+func (h *Server) reload() ([]string, error) {
+	if h.reloader == nil {
+		return nil, errors.New("reloading is not configured")
+	}
+	changed, err := h.reloader.Reload(h)
+	if err != nil {
+		fmt.Println("reload refused:", err)
+		return nil, err
+	}
+	if len(changed) == 0 {
+		fmt.Println("reloaded, nothing changed")
+	}
+	for _, c := range changed {
+		fmt.Println("reloaded", c)
+	}
+	return changed, nil
+}
+
+// diffAPIKeys describes the keys added, removed or changed by a reload,
+// by name.
+func diffAPIKeys(previous map[string]*APIKey, next map[string]*APIKey) []string {
+	var added, removed, changed []string
+	for hash, key := range next {
+		if old, ok := previous[hash]; !ok {
+			added = append(added, key.Name)
+		} else if !reflect.DeepEqual(old, key) {
+			changed = append(changed, key.Name)
+		}
+	}
+	for hash, key := range previous {
+		if _, ok := next[hash]; !ok {
+			removed = append(removed, key.Name)
+		}
+	}
+	var diff []string
+	for _, d := range []struct {
+		verb  string
+		names []string
+	}{{"added", added}, {"removed", removed}, {"changed", changed}} {
+		if len(d.names) > 0 {
+			slices.Sort(d.names)
+			diff = append(diff, fmt.Sprintf("API keys %s: %s", d.verb, strings.Join(d.names, ", ")))
+		}
+	}
+	return diff
+}
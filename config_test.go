@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sliceosm.conf")
+	os.WriteFile(path, []byte("# limits\nnodesLimit=5\n\n-maxFailureRate = 0.2\nstallMinutes=3\n"), 0644)
+	c, err := parseConfig(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-config", path, "-nodesLimit", "7", "-filesDir", "/srv", "planet.osmx"})
+	assert.Nil(t, err)
+	// the command line wins over the file.
+	assert.Equal(t, 7, c.NodesLimit)
+	assert.Equal(t, 0.2, c.MaxFailureRate)
+	assert.Equal(t, 3.0, c.StallMinutes)
+	assert.Equal(t, "/srv", c.FilesDir)
+	assert.Equal(t, "planet.osmx", c.Data)
+	assert.Equal(t, "*", c.CORSOrigins)
+
+	for _, bad := range []string{"nodesLimit", "unknownFlag=1", "nodesLimit=many", "config=other.conf"} {
+		os.WriteFile(path, []byte(bad+"\n"), 0644)
+		_, err = parseConfig(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-config", path, "planet.osmx"})
+		assert.NotNil(t, err, bad)
+	}
+}
+
+func TestParseConfigEnv(t *testing.T) {
+	assert.Equal(t, "SLICEOSM_NODES_LIMIT", envName("nodesLimit"))
+	assert.Equal(t, "SLICEOSM_API_KEYS_FILE", envName("apiKeysFile"))
+	assert.Equal(t, "SLICEOSM_REQUIRE_API_KEYS", envName("requireAPIKeys"))
+	assert.Equal(t, "SLICEOSM_BIND", envName("bind"))
+
+	path := filepath.Join(t.TempDir(), "sliceosm.conf")
+	os.WriteFile(path, []byte("nodesLimit=5\nstallMinutes=3\nworkers=2\n"), 0644)
+	t.Setenv("SLICEOSM_CONFIG", path)
+	t.Setenv("SLICEOSM_NODES_LIMIT", "6")
+	t.Setenv("SLICEOSM_STALL_MINUTES", "4")
+	t.Setenv("SLICEOSM_SENTRY_DSN", "https://key@sentry.example/1")
+	c, err := parseConfig(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-stallMinutes", "5", "planet.osmx"})
+	assert.Nil(t, err)
+	// the command line wins over the environment, which wins over the file.
+	assert.Equal(t, 5.0, c.StallMinutes)
+	assert.Equal(t, 6, c.NodesLimit)
+	assert.Equal(t, 2, c.Workers)
+	assert.Equal(t, "https://key@sentry.example/1", c.SentryDsn)
+
+	t.Setenv("SLICEOSM_NODES_LIMIT", "many")
+	_, err = parseConfig(flag.NewFlagSet("test", flag.ContinueOnError), []string{"planet.osmx"})
+	assert.ErrorContains(t, err, "SLICEOSM_NODES_LIMIT")
+}
+
+func TestNodesLimitAlias(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sliceosm.conf")
+	os.WriteFile(path, []byte("nodesLimit=5\nsoftNodesLimit=3\n"), 0644)
+	c, err := parseConfig(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-config", path, "planet.osmx"})
+	assert.Nil(t, err)
+	assert.Equal(t, 5, c.NodesLimit)
+	assert.Equal(t, 3, c.SoftNodesLimit)
+
+	// the old name in the environment or file doesn't override the new
+	// one on the command line.
+	t.Setenv("SLICEOSM_NODES_LIMIT", "6")
+	c, err = parseConfig(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-config", path, "-hardNodesLimit", "7", "planet.osmx"})
+	assert.Nil(t, err)
+	assert.Equal(t, 7, c.NodesLimit)
+
+	settings := Settings{NodesLimit: 7, SoftNodesLimit: 3}
+	assert.Equal(t, 3, settings.softLimit())
+	settings.SoftNodesLimit = 0
+	assert.Equal(t, 7, settings.softLimit())
+	settings.SoftNodesLimit = 9
+	assert.Equal(t, 7, settings.softLimit())
+}
+
+func TestParseYAMLConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sliceosm.yaml")
+	os.WriteFile(path, []byte(`# limits
+bind: 127.0.0.1:9000
+filesDir: /srv/files
+nodesLimit: 1000000
+maxFilesBytes: 50000000000
+workers: 4
+requireAPIKeys: true
+corsOrigins:
+  - https://a.example
+  - https://b.example
+`), 0644)
+	c, err := parseConfig(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-config", path, "-workers", "8", "planet.osmx"})
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1:9000", c.BindAddress)
+	assert.Equal(t, "/srv/files", c.FilesDir)
+	assert.Equal(t, 1000000, c.NodesLimit)
+	assert.Equal(t, int64(50000000000), c.MaxFilesBytes)
+	assert.Equal(t, 8, c.Workers)
+	assert.True(t, c.RequireAPIKeys)
+	assert.Equal(t, "https://a.example,https://b.example", c.CORSOrigins)
+
+	for _, bad := range []string{"nodesLimit: [1, [2]]", "limits:\n  nodesLimit: 1", "unknownFlag: 1", "nodesLimit: many", "- nodesLimit"} {
+		os.WriteFile(path, []byte(bad+"\n"), 0644)
+		_, err = parseConfig(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-config", path, "planet.osmx"})
+		assert.NotNil(t, err, bad)
+	}
+}
+
+func TestParseTOMLConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sliceosm.toml")
+	os.WriteFile(path, []byte("bind = \"unix:/run/sliceosm.sock\"\nnodesLimit = 5\ncorsOrigins = \"https://a.example\"\n"), 0644)
+	c, err := parseConfig(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-config", path, "planet.osmx"})
+	assert.Nil(t, err)
+	assert.Equal(t, "unix:/run/sliceosm.sock", c.BindAddress)
+	assert.Equal(t, 5, c.NodesLimit)
+	assert.Equal(t, "https://a.example", c.CORSOrigins)
+
+	os.WriteFile(path, []byte("[limits]\nnodesLimit = 5\n"), 0644)
+	_, err = parseConfig(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-config", path, "planet.osmx"})
+	assert.NotNil(t, err)
+}
+
+// reloadableServer is a test server started from a config file.
+func reloadableServer(t *testing.T, config string, keys string) (*Server, string, string) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "sliceosm.conf")
+	keysPath := filepath.Join(dir, "keys.json")
+	os.WriteFile(configPath, []byte(config), 0644)
+	os.WriteFile(keysPath, []byte(keys), 0644)
+	h := newTestServer(t, "osmx")
+	args := []string{"-config", configPath, "-apiKeysFile", keysPath, "-filesDir", h.filesDir, "planet.osmx"}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	c, err := parseConfig(fs, args)
+	assert.Nil(t, err)
+	settings, err := loadSettings(c)
+	assert.Nil(t, err)
+	h.setSettings(settings)
+	h.reloader = NewReloader(args, fs)
+	return h, configPath, keysPath
+}
+
+func reloadRequest(h *Server) (int, []string) {
+	r := httptest.NewRequest("POST", "/api/admin/reload", nil)
+	r.Header.Set("Authorization", "Bearer admin")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	var body struct{ Changed []string }
+	json.NewDecoder(w.Body).Decode(&body)
+	return w.Code, body.Changed
+}
+
+const adminKeys = `{"admin": {"Name": "ops", "Admin": true}}`
+
+func TestReload(t *testing.T) {
+	h, configPath, keysPath := reloadableServer(t, "nodesLimit=100000000\nstallMinutes=10\n", adminKeys)
+	code, changed := reloadRequest(h)
+	assert.Equal(t, 200, code)
+	assert.Empty(t, changed)
+
+	os.WriteFile(configPath, []byte("nodesLimit=1\nstallMinutes=2\ncorsOrigins=https://a.example, https://b.example\n"), 0644)
+	os.WriteFile(keysPath, []byte(`{"admin": {"Name": "ops", "Admin": true}, "new": {"Name": "partner"}}`), 0644)
+	code, changed = reloadRequest(h)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, []string{
+		`-corsOrigins: "*" -> "https://a.example, https://b.example"`,
+		`-hardNodesLimit: "100000000" -> "1"`,
+		`-stallMinutes: "10" -> "2"`,
+		"API keys added: partner",
+	}, changed)
+	settings := h.settings()
+	assert.Equal(t, 1, settings.NodesLimit)
+	assert.Equal(t, 2*time.Minute, settings.StallAfter)
+	assert.Equal(t, []string{"https://a.example", "https://b.example"}, settings.CORSOrigins)
+
+	// new submissions see the new limit.
+	code, _ = submit(h, richmond)
+	assert.Equal(t, 400, code)
+	r := httptest.NewRequest("GET", "/api/quota", nil)
+	r.Header.Set("Authorization", "Bearer new")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, 200, w.Code)
+}
+
+func TestReloadRefusesRestartFlags(t *testing.T) {
+	h, configPath, keysPath := reloadableServer(t, "nodesLimit=100\n", adminKeys)
+	os.WriteFile(configPath, []byte("nodesLimit=200\nbind=:9090\ntmpDir=/elsewhere\n"), 0644)
+	code, _ := reloadRequest(h)
+	assert.Equal(t, 409, code)
+	// nothing is applied.
+	assert.Equal(t, 100, h.settings().NodesLimit)
+
+	os.WriteFile(configPath, []byte("nodesLimit=oops\n"), 0644)
+	code, _ = reloadRequest(h)
+	assert.Equal(t, 409, code)
+	assert.Equal(t, 100, h.settings().NodesLimit)
+
+	// neither does a key file that doesn't load.
+	os.WriteFile(configPath, []byte("nodesLimit=300\n"), 0644)
+	os.WriteFile(keysPath, []byte("{"), 0644)
+	code, _ = reloadRequest(h)
+	assert.Equal(t, 409, code)
+	assert.Equal(t, 100, h.settings().NodesLimit)
+	assert.Equal(t, 1, len(h.settings().APIKeys))
+}
+
+func TestReloadRateLimit(t *testing.T) {
+	h, configPath, _ := reloadableServer(t, "submitsPerMinute=0\n", adminKeys)
+	now := time.Now()
+	for i := 0; i < 20; i++ {
+		_, ok := h.submitLimiter.take("198.51.100.1", 1, now)
+		assert.True(t, ok)
+	}
+
+	os.WriteFile(configPath, []byte("submitsPerMinute=6\nsubmitBurst=2\n"), 0644)
+	code, changed := reloadRequest(h)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, []string{`-submitBurst: "10" -> "2"`, `-submitsPerMinute: "0" -> "6"`}, changed)
+	assert.Equal(t, 0.1, h.settings().SubmitsPerSecond)
+	for i := 0; i < 2; i++ {
+		_, ok := h.submitLimiter.take("198.51.100.1", 1, now)
+		assert.True(t, ok)
+	}
+	wait, ok := h.submitLimiter.take("198.51.100.1", 1, now)
+	assert.False(t, ok)
+	assert.Equal(t, 10*time.Second, wait)
+
+	os.WriteFile(configPath, []byte("submitsPerMinute=6\nsubmitBurst=0\n"), 0644)
+	code, _ = reloadRequest(h)
+	assert.Equal(t, 409, code)
+	assert.Equal(t, 2, h.settings().SubmitBurst)
+}
+
+func TestAllowOrigin(t *testing.T) {
+	for _, c := range []struct {
+		origins []string
+		origin  string
+		allowed string
+	}{
+		{nil, "https://a.example", "*"},
+		{[]string{"*"}, "", "*"},
+		{[]string{"https://a.example"}, "https://a.example", "https://a.example"},
+		{[]string{"https://a.example"}, "https://evil.example", ""},
+		{[]string{"https://a.example"}, "", ""},
+	} {
+		r := httptest.NewRequest("GET", "/api/", nil)
+		if c.origin != "" {
+			r.Header.Set("Origin", c.origin)
+		}
+		w := httptest.NewRecorder()
+		allowOrigin(w, r, c.origins)
+		assert.Equal(t, c.allowed, w.Header().Get("Access-Control-Allow-Origin"))
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// the most failed jobs kept; older ones are dropped.
+const maxDeadLetters = 1000
+
+// the end of osmx's stderr kept with a failed extract.
+const maxStderrBytes = 16 * 1024
+
+// A job that failed, with what an operator needs to find out why: the
+// full error, unlike the Error of its status, and the end of osmx's
+// stderr. Its region.json holds the original input.
+type DeadLetter struct {
+	Uuid     string
+	FailedAt string
+	Category string
+	Error    string
+	Stderr   string `json:",omitempty"`
+
+	// the task as submitted, only when a single job is requested.
+	Input json.RawMessage `json:",omitempty"`
+}
+
+// The failed jobs, oldest first, persisted as dead_letters.json in
+// filesDir. Jobs cancelled by a client or an operator aren't kept.
+type DeadLetterStore struct {
+	mutex   sync.Mutex
+	path    string
+	letters []DeadLetter
+}
+
+func LoadDeadLetters(filesDir string) (*DeadLetterStore, error) {
+	s := &DeadLetterStore{path: filepath.Join(filesDir, "dead_letters.json")}
+	b, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.letters); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *DeadLetterStore) save() error {
+	b, err := json.Marshal(s.letters)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, b)
+}
+
+// Add records a failed job, replacing an earlier failure of it.
+func (s *DeadLetterStore) Add(letter DeadLetter) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.remove(letter.Uuid)
+	s.letters = append(s.letters, letter)
+	if len(s.letters) > maxDeadLetters {
+		s.letters = s.letters[len(s.letters)-maxDeadLetters:]
+	}
+	return s.save()
+}
+
+func (s *DeadLetterStore) remove(id string) bool {
+	for i, letter := range s.letters {
+		if letter.Uuid == id {
+			s.letters = append(s.letters[:i], s.letters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Remove forgets a job that was queued again.
+func (s *DeadLetterStore) Remove(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.remove(id) {
+		return nil
+	}
+	return s.save()
+}
+
+// List is the failed jobs, most recent first.
+func (s *DeadLetterStore) List() []DeadLetter {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	letters := make([]DeadLetter, 0, len(s.letters))
+	for i := len(s.letters) - 1; i >= 0; i-- {
+		letters = append(letters, s.letters[i])
+	}
+	return letters
+}
+
+func (s *DeadLetterStore) Get(id string) (DeadLetter, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, letter := range s.letters {
+		if letter.Uuid == id {
+			return letter, true
+		}
+	}
+	return DeadLetter{}, false
+}
+
+// tailWriter keeps the last max bytes written to it.
+type tailWriter struct {
+	max int
+	buf []byte
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	if len(w.buf) > w.max {
+		w.buf = append([]byte(nil), w.buf[len(w.buf)-w.max:]...)
+	}
+	return len(p), nil
+}
+
+// osmxError is an extract that failed, with the end of what osmx wrote
+// to stderr.
+type osmxError struct {
+	err    error
+	stderr string
+}
+
+func (e *osmxError) Error() string {
+	return e.err.Error()
+}
+
+func (e *osmxError) Unwrap() error {
+	return e.err
+}
+
+// recordDeadLetter keeps a job that failed for the category.
+func (h *Server) recordDeadLetter(task Task, category string, err error) {
+	letter := DeadLetter{
+		Uuid:     task.Uuid,
+		FailedAt: time.Now().UTC().Format(time.RFC3339),
+		Category: category,
+		Error:    err.Error(),
+	}
+	var osmx *osmxError
+	if errors.As(err, &osmx) {
+		letter.Stderr = osmx.stderr
+	}
+	if err := h.deadLetters.Add(letter); err != nil {
+		fmt.Println("recording failed job", task.Uuid, err)
+	}
+}
+
+// serveDeadLetters handles GET /api/admin/failed and
+// GET /api/admin/failed/{uuid}, which includes the original input.
+func (h *Server) serveDeadLetters(w http.ResponseWriter, r *http.Request, id string) {
+	if id == "" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct{ Jobs []DeadLetter }{h.deadLetters.List()})
+		return
+	}
+	letter, ok := h.deadLetters.Get(id)
+	if uuid.Validate(id) != nil || !ok {
+		w.WriteHeader(404)
+		return
+	}
+	if b, err := os.ReadFile(filepath.Join(h.filesDir, id+"_region.json")); err == nil && json.Valid(b) {
+		letter.Input = b
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(letter)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getDeadLetters(h *Server, path string, v any) int {
+	r := httptest.NewRequest("GET", path, nil)
+	r.Header.Set("Authorization", "Bearer admin")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	json.NewDecoder(w.Body).Decode(v)
+	return w.Code
+}
+
+func TestDeadLetters(t *testing.T) {
+	broken := filepath.Join(t.TempDir(), "broken")
+	os.WriteFile(broken, nil, 0644)
+	h := withAdmin(newTestServer(t, fakeOsmx(t, `if [ -f `+broken+` ]; then echo "planet.osmx: no such table" >&2; exit 3; fi`)))
+	h.StartWorkers()
+	_, uuid := submit(h, richmond)
+	var progress Progress
+	waitFor(t, func() bool {
+		_, progress = getProgress(h, uuid)
+		return progress.Failed
+	})
+	// the status doesn't show osmx's output.
+	assert.Equal(t, "osmx exit status 3", progress.Error)
+
+	var list struct{ Jobs []DeadLetter }
+	assert.Equal(t, 200, getDeadLetters(h, "/api/admin/failed", &list))
+	assert.Len(t, list.Jobs, 1)
+	assert.Equal(t, uuid, list.Jobs[0].Uuid)
+	assert.Equal(t, failureExtract, list.Jobs[0].Category)
+	assert.Equal(t, "exit status 3", list.Jobs[0].Error)
+	assert.Equal(t, "planet.osmx: no such table\n", list.Jobs[0].Stderr)
+	assert.Empty(t, list.Jobs[0].Input)
+
+	var letter DeadLetter
+	assert.Equal(t, 200, getDeadLetters(h, "/api/admin/failed/"+uuid, &letter))
+	assert.Contains(t, string(letter.Input), `"SanitizedName":"richmond"`)
+	assert.Equal(t, 404, getDeadLetters(h, "/api/admin/failed/00000000-0000-0000-0000-000000000000", &letter))
+
+	// requeueing takes the job out of the dead letters.
+	os.Remove(broken)
+	assert.Equal(t, 200, adminRequest(h, "/api/admin/jobs/"+uuid+"/requeue", ""))
+	waitFor(t, func() bool {
+		_, progress = getProgress(h, uuid)
+		return progress.Complete
+	})
+	reloaded, err := LoadDeadLetters(h.filesDir)
+	assert.Nil(t, err)
+	assert.Empty(t, reloaded.List())
+}
+
+func TestTailWriter(t *testing.T) {
+	w := &tailWriter{max: 5}
+	w.Write([]byte("abc"))
+	w.Write([]byte("defg"))
+	assert.Equal(t, "cdefg", string(w.buf))
+	w.Write([]byte(strings.Repeat("x", 10)))
+	assert.Equal(t, "xxxxx", string(w.buf))
+}
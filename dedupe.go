@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// how long a completed result is handed out for identical submissions.
+const defaultDedupeMinutes = 60
+
+// dedupeIndex maps the hash of what a task extracts to the uuid of the
+// last job submitted with it. Entries are checked against the job's
+// progress or record when they are looked up, so a job that failed or
+// was evicted is never handed out. It is only held in memory: after a
+// restart, only jobs submitted since are matched.
+type dedupeIndex struct {
+	mutex    sync.Mutex
+	jobs     map[string]string // uuids by regionHash
+	prunedAt time.Time
+}
+
+// regionHash identifies the output of a task: its sanitized region and
+// the options that change the extract. The name is only a label.
+func regionHash(task Task) string {
+	sum := sha256.New()
+	sum.Write([]byte(task.SanitizedRegionType))
+	sum.Write([]byte{0})
+	sum.Write(task.SanitizedRegionData)
+	sum.Write([]byte{0})
+	// maps are encoded in key order.
+	options, _ := json.Marshal(struct {
+		ExtraArgs  map[string]string
+		SubRegions []SubRegion
+	}{task.ExtraArgs, task.SubRegions})
+	sum.Write(options)
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+// dedupable is whether another submission may be given this task's
+// result: encrypted results are bound to their uuid, dry runs and
+// pinned snapshots aren't a result of the current data, and a callback
+// would never be sent.
+func dedupable(task Task) bool {
+	return !task.Encrypt && !task.DryRun && task.SnapshotTimestamp == "" && task.CallbackUrl == ""
+}
+
+// current is whether a job can stand in for a new submission: it is
+// queued or running, or completed within the window and not evicted.
+func (h *Server) current(id string, now time.Time) bool {
+	if h.hasProgress(id) {
+		return true
+	}
+	b, err := os.ReadFile(filepath.Join(h.filesDir, id))
+	var record Progress
+	if err != nil || json.Unmarshal(b, &record) != nil || !record.Complete || record.Evicted {
+		return false
+	}
+	finished, err := time.Parse(time.RFC3339, record.FinishedAt)
+	return err == nil && now.Sub(finished) <= h.dedupeWindow
+}
+
+// lookup finds the uuid of a current job identical to task.
+func (d *dedupeIndex) lookup(h *Server, task Task, now time.Time) (string, bool) {
+	if h.dedupeWindow <= 0 || !dedupable(task) {
+		return "", false
+	}
+	hash := regionHash(task)
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	id, ok := d.jobs[hash]
+	if !ok {
+		return "", false
+	}
+	if !h.current(id, now) {
+		delete(d.jobs, hash)
+		return "", false
+	}
+	return id, true
+}
+
+// cachedResult finds the newest completed result identical to task
+// whose data isn't more than cacheStaleness behind the data file, even
+// if it completed before the dedupe window or the server started.
+func (h *Server) cachedResult(task Task) (string, bool) {
+	if h.cacheStaleness <= 0 || !dedupable(task) {
+		return "", false
+	}
+	entry, ok := h.results.Cached(regionHash(task))
+	if !ok {
+		return "", false
+	}
+	extracted, err := time.Parse(time.RFC3339, entry.DataTimestamp)
+	current := h.dataTimestamp()
+	if err != nil || current.IsZero() || current.Sub(extracted) > h.cacheStaleness {
+		return "", false
+	}
+	return entry.Uuid, true
+}
+
+// add records a queued task, dropping the entries that are no longer
+// current at most once per window.
+func (d *dedupeIndex) add(h *Server, task Task, now time.Time) {
+	if h.dedupeWindow <= 0 || !dedupable(task) {
+		return
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.jobs == nil {
+		d.jobs = make(map[string]string)
+	}
+	if now.Sub(d.prunedAt) > h.dedupeWindow {
+		for hash, id := range d.jobs {
+			if !h.current(id, now) {
+				delete(d.jobs, hash)
+			}
+		}
+		d.prunedAt = now
+	}
+	d.jobs[regionHash(task)] = task.Uuid
+}
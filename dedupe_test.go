@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func submitCreated(h *Server, target string, body string) Created {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", target, strings.NewReader(body)))
+	var created Created
+	json.NewDecoder(w.Body).Decode(&created)
+	return created
+}
+
+func TestDedupeQueued(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.dedupeWindow = time.Hour
+	h.progress = make(map[string]Progress)
+	h.progressJSON = make(map[string][]byte)
+	h.queue = NewScheduler("fifo", 10)
+
+	first := submitCreated(h, "/api/", richmond)
+	assert.False(t, first.Deduplicated)
+	// the name doesn't change the result.
+	second := submitCreated(h, "/api/", strings.Replace(richmond, `"richmond"`, `"rva"`, 1))
+	assert.True(t, second.Deduplicated)
+	assert.Equal(t, first.Uuid, second.Uuid)
+	assert.Equal(t, first.SanitizedRegionData, second.SanitizedRegionData)
+	assert.Equal(t, 1, h.queue.Len())
+
+	// nor are dry runs or other extract options the same job.
+	assert.NotEqual(t, first.Uuid, submitCreated(h, "/api/?dryRun=1", richmond).Uuid)
+	h.extraArgs, _ = parseExtraArgsAllowlist("--noUserData")
+	other := submitCreated(h, "/api/", strings.Replace(richmond, `}`, `,"ExtraArgs":{"--noUserData":""}}`, 1))
+	assert.NotEqual(t, first.Uuid, other.Uuid)
+	assert.False(t, other.Deduplicated)
+
+	// a job cancelled while queued isn't handed out.
+	h.queue.Remove(first.Uuid)
+	h.writeFailure(first.Uuid, failureCancelled, "cancelled by client")
+	assert.False(t, submitCreated(h, "/api/", richmond).Deduplicated)
+}
+
+func TestDedupeCompleted(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.dedupeWindow = time.Hour
+	h.StartWorkers()
+	first := submitCreated(h, "/api/", richmond)
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, first.Uuid)
+		return progress.Complete
+	})
+	second := submitCreated(h, "/api/", richmond)
+	assert.True(t, second.Deduplicated)
+	assert.Equal(t, first.Uuid, second.Uuid)
+
+	// past the window the region is extracted again.
+	path := filepath.Join(h.filesDir, first.Uuid)
+	b, _ := os.ReadFile(path)
+	var record Progress
+	json.Unmarshal(b, &record)
+	record.FinishedAt = time.Now().Add(-2 * time.Hour).UTC().Format(time.RFC3339)
+	b, _ = json.Marshal(record)
+	os.WriteFile(path, b, 0644)
+	third := submitCreated(h, "/api/", richmond)
+	assert.False(t, third.Deduplicated)
+	assert.NotEqual(t, first.Uuid, third.Uuid)
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, third.Uuid)
+		return progress.Complete
+	})
+}
+
+func TestDedupeDisabled(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.progress = make(map[string]Progress)
+	h.progressJSON = make(map[string][]byte)
+	h.queue = NewScheduler("fifo", 10)
+	assert.NotEqual(t, submitCreated(h, "/api/", richmond).Uuid, submitCreated(h, "/api/", richmond).Uuid)
+}
+
+func TestCachedResult(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.cacheStaleness = time.Hour
+	h.StartWorkers()
+	first := submitCreated(h, "/api/", richmond)
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, first.Uuid)
+		return progress.Complete
+	})
+
+	// the cache is rebuilt from the records at startup.
+	h.results, _ = LoadResultIndex(h.filesDir)
+	second := submitCreated(h, "/api/", richmond)
+	assert.True(t, second.Deduplicated)
+	assert.Equal(t, first.Uuid, second.Uuid)
+
+	forced := submitCreated(h, "/api/?force=true", richmond)
+	assert.False(t, forced.Deduplicated)
+	assert.NotEqual(t, first.Uuid, forced.Uuid)
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, forced.Uuid)
+		return progress.Complete
+	})
+	assert.Equal(t, forced.Uuid, submitCreated(h, "/api/", richmond).Uuid)
+
+	// once the data file has moved on, the region is extracted again.
+	h.lastUpdated.timestamp = time.Date(2024, 1, 1, 2, 0, 0, 0, time.UTC)
+	h.lastUpdated.checkedAt = time.Now()
+	stale := submitCreated(h, "/api/", richmond)
+	assert.False(t, stale.Deduplicated)
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, stale.Uuid)
+		return progress.Complete
+	})
+	h.lastUpdated.timestamp = time.Date(2024, 1, 1, 0, 30, 0, 0, time.UTC)
+
+	// nor is a deleted result handed out.
+	h.results.Remove(forced.Uuid)
+	h.results.Remove(stale.Uuid)
+	last := submitCreated(h, "/api/", richmond)
+	assert.False(t, last.Deduplicated)
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, last.Uuid)
+		return progress.Complete
+	})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// the header a DeleteToken is presented in.
+const deleteTokenHeader = "X-SliceOSM-Delete-Token"
+
+// deleteResultId parses the uuid of /api/{uuid}/result.
+func deleteResultId(path string) (string, bool) {
+	parts := strings.Split(path, "/")
+	if len(parts) != 4 || parts[0] != "" || parts[1] != "api" || parts[3] != "result" || uuid.Validate(parts[2]) != nil {
+		return "", false
+	}
+	return parts[2], true
+}
+
+// recordOwner persists who may delete the result of a new job,
+// returning its DeleteToken, or "" if it couldn't be recorded.
+func (h *Server) recordOwner(task Task) string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	token := hex.EncodeToString(b)
+	if err := h.jobs.PutOwner(task.Uuid, jobOwner{KeyName: task.KeyName, TokenHash: hashAPIKey(token)}); err != nil {
+		fmt.Println("recording owner", task.Uuid, err)
+		return ""
+	}
+	return token
+}
+
+// serveDeleteResult handles DELETE /api/{uuid}/result, deleting the
+// result, region and record of a finished job before they expire. It
+// takes the job's DeleteToken, the API key that submitted it or an
+// admin key.
+func (h *Server) serveDeleteResult(w http.ResponseWriter, r *http.Request, id string) {
+	key, err := h.authenticate(r)
+	if err != nil {
+		w.WriteHeader(401)
+		fmt.Fprintf(w, "Error: %s", err)
+		return
+	}
+	token := r.Header.Get(deleteTokenHeader)
+	if key == nil && token == "" {
+		w.WriteHeader(401)
+		fmt.Fprintf(w, "Error: a %s header or API key is required", deleteTokenHeader)
+		return
+	}
+	owner, owned, err := h.jobs.Owner(id)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	allowed := key != nil && key.can(scopeAdmin)
+	if owned && key != nil && owner.KeyName != "" && owner.KeyName == key.Name {
+		allowed = true
+	}
+	if owned && token != "" && subtle.ConstantTimeCompare([]byte(hashAPIKey(token)), []byte(owner.TokenHash)) == 1 {
+		allowed = true
+	}
+	if !allowed {
+		w.WriteHeader(403)
+		fmt.Fprintf(w, "Error: not allowed to delete the result of this job")
+		return
+	}
+
+	if h.hasProgress(id) {
+		w.WriteHeader(409)
+		fmt.Fprintf(w, "Error: the job hasn't finished; cancel it with DELETE /api/%s", id)
+		return
+	}
+	var progress Progress
+	b, err := os.ReadFile(filepath.Join(h.filesDir, id))
+	if err != nil || json.Unmarshal(b, &progress) != nil {
+		w.WriteHeader(404)
+		return
+	}
+	freed, err := h.expire(id, progress)
+	if err != nil {
+		fmt.Println("deleting result", id, err)
+		w.WriteHeader(500)
+		return
+	}
+	fmt.Println("deleted result", id, "on request, freeing", freed, "bytes")
+	w.WriteHeader(204)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// submitAs submits a task with an optional API key, returning what was
+// created.
+func submitAs(h *Server, body string, key string) Created {
+	r := httptest.NewRequest("POST", "/api/", strings.NewReader(body))
+	if key != "" {
+		r.Header.Set("Authorization", "Bearer "+key)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	var created Created
+	json.NewDecoder(w.Body).Decode(&created)
+	return created
+}
+
+func deleteResult(h *Server, id string, header string, value string) int {
+	r := httptest.NewRequest("DELETE", "/api/"+id+"/result", nil)
+	if header != "" {
+		r.Header.Set(header, value)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestDeleteResult(t *testing.T) {
+	h := withAdmin(newTestServer(t, fakeOsmx(t, "")))
+	h.StartWorkers()
+	created := submitAs(h, richmond, "")
+	assert.Len(t, created.DeleteToken, 32)
+	byKey := submitAs(h, `{"Name":"other","RegionType":"bbox","RegionData":[37.5272,-77.4571,37.5400,-77.4133]}`, "user")
+	for _, id := range []string{created.Uuid, byKey.Uuid} {
+		waitFor(t, func() bool {
+			_, progress := getProgress(h, id)
+			return progress.Complete
+		})
+	}
+
+	assert.Equal(t, 401, deleteResult(h, created.Uuid, "", ""))
+	assert.Equal(t, 403, deleteResult(h, created.Uuid, deleteTokenHeader, byKey.DeleteToken))
+	assert.Equal(t, 403, deleteResult(h, created.Uuid, "Authorization", "Bearer user"))
+	assert.Equal(t, 204, deleteResult(h, created.Uuid, deleteTokenHeader, created.DeleteToken))
+	for _, name := range []string{created.Uuid, created.Uuid + ".osm.pbf", created.Uuid + "_region.json"} {
+		_, err := os.Lstat(filepath.Join(h.filesDir, name))
+		assert.True(t, os.IsNotExist(err), name)
+	}
+	code, _ := getProgress(h, created.Uuid)
+	assert.Equal(t, 404, code)
+	assert.Equal(t, 403, deleteResult(h, created.Uuid, deleteTokenHeader, created.DeleteToken))
+
+	// the key that submitted a job may delete it.
+	assert.Equal(t, 204, deleteResult(h, byKey.Uuid, "Authorization", "Bearer user"))
+	assert.Equal(t, 404, deleteResult(h, byKey.Uuid, "Authorization", "Bearer admin"))
+}
+
+func TestDeleteResultQueued(t *testing.T) {
+	h := newTestServer(t, "osmx")
+	h.progress = make(map[string]Progress)
+	h.progressJSON = make(map[string][]byte)
+	h.queue = NewScheduler("fifo", 10)
+	created := submitAs(h, richmond, "")
+	assert.Equal(t, 409, deleteResult(h, created.Uuid, deleteTokenHeader, created.DeleteToken))
+}
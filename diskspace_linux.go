@@ -0,0 +1,15 @@
+package main
+
+import (
+	"syscall"
+)
+
+// diskSpace returns the size and the space available to us of the
+// filesystem containing path.
+func diskSpace(path string) (total uint64, free uint64, err error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, err
+	}
+	return stat.Blocks * uint64(stat.Bsize), stat.Bavail * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+)
+
+func diskSpace(path string) (total uint64, free uint64, err error) {
+	return 0, 0, errors.New("disk space is not available on this platform")
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/google/uuid"
+)
+
+// serveDownload handles GET /api/{uuid}/download, serving the result
+// and decrypting it on the fly if it is stored encrypted.
+func (h *Server) serveDownload(w http.ResponseWriter, r *http.Request, id string) {
+	if uuid.Validate(id) != nil {
+		w.WriteHeader(404)
+		return
+	}
+	if h.downloadLinks != nil && !h.downloadLinks.Valid(id, r.URL.Query(), time.Now()) {
+		w.WriteHeader(403)
+		fmt.Fprintf(w, "Error: the download link is invalid or has expired")
+		return
+	}
+	var progress Progress
+	b, err := os.ReadFile(filepath.Join(h.filesDir, id))
+	if err != nil || json.Unmarshal(b, &progress) != nil || !progress.Complete {
+		w.WriteHeader(404)
+		return
+	}
+	if progress.DryRun {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, "Error: a dry run has no result")
+		return
+	}
+
+	w.Header().Set("X-SliceOSM-Warnings", strconv.Itoa(len(progress.Warnings)))
+
+	if r.URL.Query().Get("split") == "1" {
+		if progress.SubRegions == nil {
+			w.WriteHeader(404)
+			fmt.Fprintf(w, "Error: the region has no named features to split")
+			return
+		}
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", h.contentDisposition(id, progress, ".zip"))
+		rec := &statusRecorder{ResponseWriter: w, status: 200}
+		http.ServeFile(rec, r, filepath.Join(h.filesDir, id+"_split.zip"))
+		if rec.status == 200 || rec.status == 206 {
+			h.countDownload(id, clientIP(r))
+		}
+		return
+	}
+
+	if progress.ObjectUrl != "" {
+		http.Redirect(w, r, progress.ObjectUrl, http.StatusFound)
+		h.countDownload(id, clientIP(r))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", h.contentDisposition(id, progress, ".osm.pbf"))
+	if progress.Encryption == nil {
+		path := filepath.Join(h.filesDir, id+".osm.pbf")
+		if progress.Blob != "" {
+			path = filepath.Join(h.filesDir, progress.Blob)
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: 200}
+		http.ServeFile(rec, r, path)
+		if rec.status == 200 || rec.status == 206 {
+			h.countDownload(id, clientIP(r))
+		}
+		return
+	}
+
+	if h.encryptionKeys == nil || h.encryptionKeys.Keys[progress.Encryption.KeyId] == nil {
+		w.WriteHeader(500)
+		fmt.Fprintf(w, "Error: encryption key %s is not loaded", progress.Encryption.KeyId)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(progress.SizeBytes, 10))
+	if err := h.encryptionKeys.decryptTo(w, filepath.Join(h.filesDir, id+".osm.pbf.enc"), id, progress.Encryption); err != nil {
+		// the headers are already sent, the short body fails the download.
+		fmt.Println(err)
+		requestHub(r).CaptureException(err)
+		return
+	}
+	h.countDownload(id, clientIP(r))
+}
+
+// contentDisposition names the download of a job after its region and
+// the replication timestamp of its data, as in
+// richmond_20240101T000000Z.osm.pbf.
+func (h *Server) contentDisposition(id string, progress Progress, ext string) string {
+	var task Task
+	if b, err := os.ReadFile(filepath.Join(h.filesDir, id+"_region.json")); err == nil {
+		json.Unmarshal(b, &task)
+	}
+	name := strings.Trim(unsafeFilename.ReplaceAllString(task.SanitizedName, "_"), "_.")
+	if name == "" {
+		name = "extract"
+	}
+	if t, err := time.Parse(time.RFC3339, cmp.Or(progress.DataTimestamp, progress.FinishedAt)); err == nil {
+		name += "_" + t.UTC().Format("20060102T150405Z")
+	}
+	return mime.FormatMediaType("attachment", map[string]string{"filename": name + ext})
+}
+
+// requests for the same result from the same client within this long
+// of each other are counted as one download, so resuming a transfer
+// with range requests doesn't inflate the counter.
+const downloadWindow = 5 * time.Minute
+
+type downloadTracker struct {
+	mutex    sync.Mutex
+	seen     map[string]time.Time // last request by result and client
+	prunedAt time.Time
+}
+
+// first reports whether this is a new transfer rather than a
+// continuation of one served within the window. Entries past the
+// window are dropped at most once per window.
+func (d *downloadTracker) first(id string, ip string, now time.Time) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.seen == nil {
+		d.seen = make(map[string]time.Time)
+	}
+	if now.Sub(d.prunedAt) > downloadWindow {
+		for key, t := range d.seen {
+			if now.Sub(t) > downloadWindow {
+				delete(d.seen, key)
+			}
+		}
+		d.prunedAt = now
+	}
+	key := id + " " + ip
+	last, seen := d.seen[key]
+	d.seen[key] = now
+	return !seen || now.Sub(last) > downloadWindow
+}
+
+// countDownload records a served download in the completion record.
+func (h *Server) countDownload(id string, ip string) {
+	now := time.Now()
+	err := h.updateRecord(id, func(p *Progress) bool {
+		p.LastDownloadedAt = now.UTC().Format(time.RFC3339)
+		if h.downloads.first(id, ip, now) {
+			p.Downloads++
+		}
+		return true
+	})
+	if err != nil {
+		fmt.Println(err)
+		sentry.CaptureException(err)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownload(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.StartWorkers()
+	_, uuid := submit(h, richmond)
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, uuid)
+		return progress.Complete
+	})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/"+uuid+"/download", nil))
+	assert.Equal(t, 200, w.Code)
+	pbf, _ := os.ReadFile(filepath.Join(h.filesDir, uuid+".osm.pbf"))
+	assert.Equal(t, pbf, w.Body.Bytes())
+	assert.Regexp(t, `^attachment; filename=richmond_\d{8}T\d{6}Z\.osm\.pbf$`, w.Header().Get("Content-Disposition"))
+	_, progress := getProgress(h, uuid)
+	assert.Equal(t, int64(1), progress.Downloads)
+	assert.NotEmpty(t, progress.LastDownloadedAt)
+
+	// resuming the transfer doesn't count again
+	r := httptest.NewRequest("GET", "/api/"+uuid+"/download", nil)
+	r.Header.Set("Range", "bytes=10-")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, 206, w.Code)
+	_, progress = getProgress(h, uuid)
+	assert.Equal(t, int64(1), progress.Downloads)
+
+	r = httptest.NewRequest("GET", "/api/"+uuid+"/download", nil)
+	r.RemoteAddr = "192.0.2.2:1234"
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	_, progress = getProgress(h, uuid)
+	assert.Equal(t, int64(2), progress.Downloads)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/not-a-uuid/download", nil))
+	assert.Equal(t, 404, w.Code)
+}
+
+func TestContentDisposition(t *testing.T) {
+	h := newTestServer(t, "osmx")
+	id := "5f0f8c3e-5a0e-4d7e-9d3b-0c7a8e2f6b1a"
+	os.WriteFile(filepath.Join(h.filesDir, id+"_region.json"), []byte(`{"SanitizedName":"Île de \"Paris\""}`), 0644)
+	progress := Progress{DataTimestamp: "2024-01-02T03:04:05Z", FinishedAt: "2024-01-03T00:00:00Z"}
+	assert.Equal(t, `attachment; filename=le_de_Paris_20240102T030405Z.osm.pbf`, h.contentDisposition(id, progress, ".osm.pbf"))
+	assert.Equal(t, `attachment; filename=extract_20240103T000000Z.zip`, h.contentDisposition("missing", Progress{FinishedAt: progress.FinishedAt}, ".zip"))
+	assert.Equal(t, `attachment; filename=extract.osm.pbf`, h.contentDisposition("missing", Progress{}, ".osm.pbf"))
+}
+
+func TestDownloadEncrypted(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	code, _ := submit(h, `{"Name":"x","RegionType":"bbox","RegionData":[37.5,-77.5,37.6,-77.4],"Encrypt":true}`)
+	assert.Equal(t, 400, code)
+
+	h.encryptionKeys = testEncryptionKeys()
+	h.StartWorkers()
+	_, uuid := submit(h, `{"Name":"x","RegionType":"bbox","RegionData":[37.5,-77.5,37.6,-77.4],"Encrypt":true}`)
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, uuid)
+		return progress.Complete
+	})
+
+	_, progress := getProgress(h, uuid)
+	assert.Equal(t, "k2", progress.Encryption.KeyId)
+	_, err := os.Stat(filepath.Join(h.filesDir, uuid+".osm.pbf"))
+	assert.True(t, os.IsNotExist(err))
+	assert.Empty(t, scratchFiles(h))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/"+uuid+"/download", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, progress.SizeBytes, int64(w.Body.Len()))
+	_, err = verifyPBF(writeTemp(t, w.Body.Bytes()))
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(w.Header().Get("Content-Type"), "application/octet-stream"))
+}
+
+func TestDownloadTracker(t *testing.T) {
+	var d downloadTracker
+	now := time.Now()
+	assert.True(t, d.first("a", "192.0.2.1", now))
+	assert.False(t, d.first("a", "192.0.2.1", now.Add(time.Minute)))
+	assert.True(t, d.first("b", "192.0.2.1", now.Add(time.Minute)))
+	assert.True(t, d.first("a", "192.0.2.1", now.Add(time.Minute+downloadWindow+time.Second)))
+
+	// entries past the window are dropped, but not on every download.
+	d = downloadTracker{}
+	for i := 0; i < 100; i++ {
+		d.first(fmt.Sprint(i), "192.0.2.1", now)
+	}
+	d.first("a", "192.0.2.1", now.Add(time.Minute))
+	assert.Len(t, d.seen, 101)
+	assert.True(t, d.first("0", "192.0.2.1", now.Add(2*time.Minute+downloadWindow)))
+	assert.Len(t, d.seen, 1)
+}
+
+func writeTemp(t *testing.T, b []byte) string {
+	path := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(path, b, 0644)
+	return path
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+const defaultDownloadLinkMinutes = 60
+
+// DownloadLinks signs expiring links to /api/{uuid}/download, so that
+// results are only downloaded through links the API handed out and
+// filesDir can stay private.
+type DownloadLinks struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+func loadDownloadLinks(secretFile string, ttl time.Duration) (*DownloadLinks, error) {
+	b, err := os.ReadFile(secretFile)
+	if err != nil {
+		return nil, err
+	}
+	secret := bytes.TrimSpace(b)
+	if len(secret) == 0 {
+		return nil, errors.New("the secret is empty")
+	}
+	if ttl <= 0 {
+		return nil, errors.New("-downloadLinkMinutes must be positive")
+	}
+	return &DownloadLinks{secret: secret, ttl: ttl}, nil
+}
+
+func (d *DownloadLinks) signature(id string, expires int64) string {
+	mac := hmac.New(sha256.New, d.secret)
+	fmt.Fprintf(mac, "%s.%d", id, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// URL is a link to download the result of a job that expires ttl after
+// now.
+func (d *DownloadLinks) URL(id string, now time.Time) string {
+	expires := now.Add(d.ttl).Unix()
+	return fmt.Sprintf("/api/%s/download?expires=%d&signature=%s", id, expires, d.signature(id, expires))
+}
+
+// Valid checks the expires and signature parameters of a download.
+func (d *DownloadLinks) Valid(id string, query url.Values, now time.Time) bool {
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil || now.Unix() > expires {
+		return false
+	}
+	return hmac.Equal([]byte(query.Get("signature")), []byte(d.signature(id, expires)))
+}
+
+// withDownloadUrl adds a fresh DownloadUrl to the encoded record of a
+// completed job, keeping its bytes as they are.
+func (h *Server) withDownloadUrl(record []byte, id string, progress Progress) []byte {
+	if h.downloadLinks == nil || !progress.Complete || progress.DryRun {
+		return record
+	}
+	var b bytes.Buffer
+	b.Write(bytes.TrimSuffix(bytes.TrimSpace(record), []byte("}")))
+	fmt.Fprintf(&b, `,"DownloadUrl":%q}`, h.downloadLinks.URL(id, time.Now()))
+	b.WriteByte('\n')
+	return b.Bytes()
+}
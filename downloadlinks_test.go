@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownloadLinks(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	os.WriteFile(secretFile, []byte("s3cret\n"), 0600)
+	d, err := loadDownloadLinks(secretFile, time.Hour)
+	assert.Nil(t, err)
+	now := time.Unix(1700000000, 0)
+	link, _ := url.Parse(d.URL("a", now))
+	assert.Equal(t, "/api/a/download", link.Path)
+	assert.Equal(t, "1700003600", link.Query().Get("expires"))
+	assert.True(t, d.Valid("a", link.Query(), now.Add(time.Hour)))
+	assert.False(t, d.Valid("a", link.Query(), now.Add(time.Hour+time.Second)))
+	assert.False(t, d.Valid("b", link.Query(), now))
+	query := link.Query()
+	query.Set("expires", "1800000000")
+	assert.False(t, d.Valid("a", query, now))
+	assert.False(t, d.Valid("a", url.Values{}, now))
+
+	os.WriteFile(secretFile, nil, 0600)
+	_, err = loadDownloadLinks(secretFile, time.Hour)
+	assert.NotNil(t, err)
+}
+
+func TestSignedDownload(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.downloadLinks = &DownloadLinks{secret: []byte("s3cret"), ttl: time.Hour}
+	h.StartWorkers()
+	_, id := submit(h, richmond)
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, id)
+		return progress.Complete
+	})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/"+id, nil))
+	var status struct{ DownloadUrl string }
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&status))
+	assert.True(t, strings.HasPrefix(status.DownloadUrl, "/api/"+id+"/download?expires="))
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", status.DownloadUrl, nil))
+	assert.Equal(t, 200, w.Code)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/"+id+"/download", nil))
+	assert.Equal(t, 403, w.Code)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/paulmach/orb"
+)
+
+// dryRunPlanned is whether osmx has reported the totals of an extract:
+// NodesTotal is only known once it has read the cells of the region.
+func dryRunPlanned(p Progress) bool {
+	return p.NodesTotal > 0
+}
+
+// finishDryRun persists the totals of a dry run as a completion
+// record without a pbf. Its region.json stays so that a submission
+// can reference it with FromDryRun.
+func (h *Server) finishDryRun(id int, task Task, start time.Time) error {
+	record := h.currentProgress(task.Uuid)
+	elapsed := time.Since(start).Seconds()
+	record.Elapsed = elapsed
+	record.FinishedAt = time.Now().UTC().Format(time.RFC3339)
+	record.Complete = true
+	record.PercentComplete = 100
+	record.DryRun = true
+	record.Stage = ""
+	for _, sub := range task.SubRegions {
+		record.SubRegions = append(record.SubRegions, SubRegion{Name: sub.Name, Bbox: sub.Bbox})
+	}
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(h.filesDir, task.Uuid), b); err != nil {
+		return err
+	}
+	h.takeProgress(task.Uuid)
+	h.noteFinished(task.Uuid)
+	// nothing was produced, so nothing is charged.
+	if task.KeyName != "" {
+		h.quotas.Release(task.KeyName, task.EstimatedNodes)
+	}
+	fmt.Println("worker", id, "finished dry run", task.Uuid, "in", elapsed)
+	return nil
+}
+
+// loadDryRun returns the sanitized task of a finished dry run and its
+// region, for a submission that references it.
+func (h *Server) loadDryRun(id string) (Task, orb.Geometry, error) {
+	notFound := errors.New("FromDryRun is not a finished dry run")
+	if uuid.Validate(id) != nil {
+		return Task{}, nil, notFound
+	}
+	var record Progress
+	b, err := os.ReadFile(filepath.Join(h.filesDir, id))
+	if err != nil || json.Unmarshal(b, &record) != nil || !record.Complete || !record.DryRun {
+		return Task{}, nil, notFound
+	}
+	var task Task
+	b, err = os.ReadFile(filepath.Join(h.filesDir, id+"_region.json"))
+	if err != nil || json.Unmarshal(b, &task) != nil {
+		return Task{}, nil, notFound
+	}
+	geom, ok := regionGeometry(task)
+	if !ok {
+		return Task{}, nil, notFound
+	}
+	return task, geom, nil
+}
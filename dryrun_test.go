@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDryRun(t *testing.T) {
+	// the extract would never finish, so the dry run only completes
+	// if osmx is stopped after the totals line.
+	h := newTestServer(t, fakeOsmx(t, `echo '{"Timestamp":"2024-01-01T00:00:00Z","CellsTotal":10,"CellsProg":10,"NodesTotal":100,"NodesProg":0,"ElemsTotal":120,"ElemsProg":0}'
+exec sleep 30`))
+	h.StartWorkers()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/api/?dryRun=1&echoRegion=false", strings.NewReader(richmond)))
+	assert.Equal(t, 201, w.Code)
+	var created Created
+	json.NewDecoder(w.Body).Decode(&created)
+	id := created.Uuid
+
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, id)
+		return progress.Complete
+	})
+	_, progress := getProgress(h, id)
+	assert.True(t, progress.DryRun)
+	assert.Equal(t, int64(100), progress.NodesTotal)
+	assert.Equal(t, int64(120), progress.ElemsTotal)
+	_, err := os.Stat(filepath.Join(h.filesDir, id+".osm.pbf"))
+	assert.True(t, os.IsNotExist(err))
+	assert.Empty(t, scratchFiles(h))
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/"+id+"/download", nil))
+	assert.Equal(t, 404, w.Code)
+	entries, _, _ := h.results.Page("", "", 10)
+	assert.Empty(t, entries)
+}
+
+func TestFromDryRun(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.StartWorkers()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/api/?dryRun=1", strings.NewReader(richmond)))
+	var planned Created
+	json.NewDecoder(w.Body).Decode(&planned)
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, planned.Uuid)
+		return progress.Complete
+	})
+
+	// the region comes from the dry run, with no RegionType given.
+	code, id := submit(h, `{"Name":"again","FromDryRun":"`+planned.Uuid+`"}`)
+	assert.Equal(t, 201, code)
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, id)
+		return progress.Complete
+	})
+	_, progress := getProgress(h, id)
+	assert.False(t, progress.DryRun)
+	_, err := os.Stat(filepath.Join(h.filesDir, id+".osm.pbf"))
+	assert.Nil(t, err)
+	b, _ := os.ReadFile(filepath.Join(h.filesDir, id+"_region.json"))
+	var task Task
+	json.Unmarshal(b, &task)
+	assert.Equal(t, "again", task.SanitizedName)
+	assert.Equal(t, planned.SanitizedRegionData, task.SanitizedRegionData)
+
+	// a real job can't stand in for a dry run.
+	code, _ = submit(h, `{"FromDryRun":"`+id+`"}`)
+	assert.Equal(t, 400, code)
+	code, _ = submit(h, `{"FromDryRun":"../etc"}`)
+	assert.Equal(t, 400, code)
+}
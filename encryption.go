@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// results are encrypted in chunks so they can be decrypted while
+// streaming a download, without holding the whole file in memory.
+const encryptionChunkSize = 64 << 10
+
+// length of the random nonce prefix; the rest of each chunk's 12 byte
+// nonce is a counter and a last-chunk flag.
+const noncePrefixSize = 7
+
+// The keys results are encrypted with. New results use Current; older
+// keys are kept so results encrypted before a rotation can be read.
+type EncryptionKeys struct {
+	Current string
+	Keys    map[string][]byte // base64 encoded 32 byte AES-256 keys
+}
+
+// How a result was encrypted, stored in its completion record.
+type Encryption struct {
+	KeyId string
+	Nonce string // base64 nonce prefix
+}
+
+func loadEncryptionKeys(path string) (*EncryptionKeys, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys EncryptionKeys
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return nil, err
+	}
+	for id, key := range keys.Keys {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("key %s is %d bytes, must be 32", id, len(key))
+		}
+	}
+	if _, ok := keys.Keys[keys.Current]; !ok {
+		return nil, errors.New("Current does not name a key")
+	}
+	return &keys, nil
+}
+
+func chunkNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptFile writes src encrypted with the current key to dst. The
+// uuid is authenticated with every chunk so results can't be swapped.
+func (keys *EncryptionKeys) encryptFile(src string, dst string, uuid string) (*Encryption, error) {
+	aead, err := newGCM(keys.Keys[keys.Current])
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, noncePrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+
+	err = transformChunks(bufio.NewReader(in), out, encryptionChunkSize, func(counter uint32, last bool, chunk []byte) ([]byte, error) {
+		return aead.Seal(nil, chunkNonce(prefix, counter, last), chunk, []byte(uuid)), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := out.Close(); err != nil {
+		return nil, err
+	}
+	return &Encryption{KeyId: keys.Current, Nonce: base64.StdEncoding.EncodeToString(prefix)}, nil
+}
+
+// decryptTo streams the plaintext of an encrypted result to w.
+func (keys *EncryptionKeys) decryptTo(w io.Writer, src string, uuid string, e *Encryption) error {
+	key, ok := keys.Keys[e.KeyId]
+	if !ok {
+		return fmt.Errorf("unknown encryption key %s", e.KeyId)
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	prefix, err := base64.StdEncoding.DecodeString(e.Nonce)
+	if err != nil || len(prefix) != noncePrefixSize {
+		return errors.New("invalid nonce")
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return transformChunks(bufio.NewReader(in), w, encryptionChunkSize+aead.Overhead(), func(counter uint32, last bool, chunk []byte) ([]byte, error) {
+		return aead.Open(nil, chunkNonce(prefix, counter, last), chunk, []byte(uuid))
+	})
+}
+
+// transformChunks applies f to each size byte chunk of r, flagging the
+// last one, which may be short or empty.
+func transformChunks(r *bufio.Reader, w io.Writer, size int, f func(uint32, bool, []byte) ([]byte, error)) error {
+	buf := make([]byte, size)
+	for counter := uint32(0); ; counter++ {
+		n, err := io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		last := err != nil
+		if !last {
+			if _, err := r.Peek(1); err == io.EOF {
+				last = true
+			}
+		}
+		out, err := f(counter, last, buf[:n])
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(out); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testEncryptionKeys() *EncryptionKeys {
+	return &EncryptionKeys{Current: "k2", Keys: map[string][]byte{
+		"k1": bytes.Repeat([]byte{1}, 32),
+		"k2": bytes.Repeat([]byte{2}, 32),
+	}}
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	keys := testEncryptionKeys()
+	dir := t.TempDir()
+	for _, size := range []int{0, 1, encryptionChunkSize, encryptionChunkSize + 1, 3 * encryptionChunkSize} {
+		plaintext := make([]byte, size)
+		rand.Read(plaintext)
+		src := filepath.Join(dir, "plain")
+		os.WriteFile(src, plaintext, 0644)
+
+		e, err := keys.encryptFile(src, src+".enc", "id")
+		assert.Nil(t, err)
+		assert.Equal(t, "k2", e.KeyId)
+		var out bytes.Buffer
+		assert.Nil(t, keys.decryptTo(&out, src+".enc", "id", e))
+		assert.True(t, bytes.Equal(plaintext, out.Bytes()))
+	}
+}
+
+func TestEncryptionTamper(t *testing.T) {
+	keys := testEncryptionKeys()
+	dir := t.TempDir()
+	src := filepath.Join(dir, "plain")
+	os.WriteFile(src, make([]byte, 2*encryptionChunkSize+10), 0644)
+	e, _ := keys.encryptFile(src, src+".enc", "id")
+	ciphertext, _ := os.ReadFile(src + ".enc")
+
+	var out bytes.Buffer
+	assert.NotNil(t, keys.decryptTo(&out, src+".enc", "other", e))
+
+	// truncated at a chunk boundary
+	os.WriteFile(src+".enc", ciphertext[:encryptionChunkSize+16], 0644)
+	assert.NotNil(t, keys.decryptTo(&out, src+".enc", "id", e))
+
+	ciphertext[100] ^= 1
+	os.WriteFile(src+".enc", ciphertext, 0644)
+	assert.NotNil(t, keys.decryptTo(&out, src+".enc", "id", e))
+}
+
+func TestEncryptionRotation(t *testing.T) {
+	keys := testEncryptionKeys()
+	keys.Current = "k1"
+	dir := t.TempDir()
+	src := filepath.Join(dir, "plain")
+	os.WriteFile(src, []byte("data"), 0644)
+	e, _ := keys.encryptFile(src, src+".enc", "id")
+
+	keys.Current = "k2"
+	var out bytes.Buffer
+	assert.Nil(t, keys.decryptTo(&out, src+".enc", "id", e))
+	assert.Equal(t, "data", out.String())
+
+	delete(keys.Keys, "k1")
+	assert.NotNil(t, keys.decryptTo(&out, src+".enc", "id", e))
+}
+
+func TestLoadEncryptionKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	os.WriteFile(path, []byte(`{"Current":"a","Keys":{"a":"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="}}`), 0644)
+	keys, err := loadEncryptionKeys(path)
+	assert.Nil(t, err)
+	assert.Len(t, keys.Keys["a"], 32)
+
+	os.WriteFile(path, []byte(`{"Current":"b","Keys":{"a":"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="}}`), 0644)
+	_, err = loadEncryptionKeys(path)
+	assert.NotNil(t, err)
+
+	os.WriteFile(path, []byte(`{"Current":"a","Keys":{"a":"AAAA"}}`), 0644)
+	_, err = loadEncryptionKeys(path)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/maptile"
+	"github.com/paulmach/orb/planar"
+	"github.com/paulmach/orb/project"
+)
+
+// number of tiles listed in an estimate breakdown.
+const estimateTopTiles = 10
+
+// the most grid cells estimated when suggesting how to split a region
+// over the nodes limit, so the rejection stays fast.
+const maxSplitCells = 64
+
+// The node estimate of a region, with an optional breakdown of which
+// tiles drove it.
+type Estimate struct {
+	Nodes      int
+	NodesLimit int
+	Detail     *EstimateDetail `json:",omitempty"`
+}
+
+type EstimateDetail struct {
+	Zoom     int
+	Tiles    int
+	TopTiles []TileEstimate
+}
+
+type TileEstimate struct {
+	Tile string // z/x/y
+	// the raw raster value; Nodes is Value * 32.
+	Value  float64
+	Nodes  int
+	Bounds [4]float64 // min lon, min lat, max lon, max lat
+}
+
+// the body of a request rejected for the nodes limit.
+type LimitError struct {
+	Error string
+	Estimate
+	// how many times over the limit the region is.
+	OverLimit float64
+	// bboxes, in the RegionData order of a bbox task, that each fall
+	// under the limit and can be submitted separately.
+	SuggestedSplit [][4]float64 `json:",omitempty"`
+}
+
+// GetSumDetail is GetSum, also returning the top tiles by contribution.
+// It allocates per covering tile, so the submission path only calls it
+// once a region has been rejected.
+func GetSumDetail(image image.Image, geom orb.Geometry, top int) (int, EstimateDetail) {
+	covering, zoom := coverRegion(geom)
+
+	tiles := make([]TileEstimate, 0, len(covering))
+	sum := 0.0
+	for t := range covering {
+		pixel := GetPixel(image, int(t.Z), int(t.X), int(t.Y))
+		sum += pixel
+		b := t.Bound()
+		tiles = append(tiles, TileEstimate{
+			Tile:   fmt.Sprintf("%d/%d/%d", t.Z, t.X, t.Y),
+			Value:  pixel,
+			Nodes:  int(pixel * 32),
+			Bounds: [4]float64{b.Min[0], b.Min[1], b.Max[0], b.Max[1]},
+		})
+	}
+	sort.Slice(tiles, func(i, j int) bool {
+		if tiles[i].Nodes != tiles[j].Nodes {
+			return tiles[i].Nodes > tiles[j].Nodes
+		}
+		return tiles[i].Tile < tiles[j].Tile
+	})
+	if len(tiles) > top {
+		tiles = tiles[:top]
+	}
+	return int(sum * 32), EstimateDetail{Zoom: int(zoom), Tiles: len(covering), TopTiles: tiles}
+}
+
+func (h *Server) estimate(geom orb.Geometry, nodes int, limit int, detail bool) Estimate {
+	e := Estimate{Nodes: nodes, NodesLimit: limit}
+	if detail {
+		_, d := GetSumDetail(h.image, geom, estimateTopTiles)
+		e.Detail = &d
+	}
+	return e
+}
+
+// writeLimitError rejects a region over the nodes limit, explaining
+// which tiles made it expensive.
+func (h *Server) writeLimitError(w http.ResponseWriter, geom orb.Geometry, nodes int, limit int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(400)
+	json.NewEncoder(w).Encode(LimitError{
+		Error:          "the limit of nodes was exceeded.",
+		Estimate:       h.estimate(geom, nodes, limit, true),
+		OverLimit:      float64(nodes) / float64(limit),
+		SuggestedSplit: h.suggestSplit(geom.Bound(), nodes, limit),
+	})
+}
+
+// suggestSplit divides a bbox into the regular grid with the fewest
+// cells that are each under the nodes limit, or returns nil if none is
+// found within maxSplitCells estimated cells.
+func (h *Server) suggestSplit(bound orb.Bound, nodes int, limit int) [][4]float64 {
+	settings := h.settings()
+	type grid struct{ cols, rows int }
+	var grids []grid
+	for cols := 1; cols <= maxSplitCells; cols++ {
+		for rows := 1; cols*rows <= maxSplitCells; rows++ {
+			// the cells together cover every tile of the region, so
+			// fewer than nodes/limit of them can't fit.
+			if cols*rows > 1 && cols*rows*limit >= nodes {
+				grids = append(grids, grid{cols, rows})
+			}
+		}
+	}
+	width := bound.Max[0] - bound.Min[0]
+	height := bound.Max[1] - bound.Min[1]
+	aspect := width * math.Cos(bound.Center()[1]*math.Pi/180) / height
+	skew := func(g grid) float64 {
+		return math.Abs(math.Log(aspect * float64(g.rows) / float64(g.cols)))
+	}
+	// fewest cells first, then the squarest cells.
+	sort.SliceStable(grids, func(i, j int) bool {
+		if grids[i].cols*grids[i].rows != grids[j].cols*grids[j].rows {
+			return grids[i].cols*grids[i].rows < grids[j].cols*grids[j].rows
+		}
+		return skew(grids[i]) < skew(grids[j])
+	})
+
+	factor := math.Pow10(settings.RegionLimits.Precision)
+	edge := func(min, max float64, i, n int) float64 {
+		if i == n {
+			return max
+		}
+		return math.Round((min+(max-min)*float64(i)/float64(n))*factor) / factor
+	}
+	evaluated := 0
+	for _, g := range grids {
+		var cells [][4]float64
+		fits := true
+		for j := 0; j < g.rows && fits; j++ {
+			for i := 0; i < g.cols; i++ {
+				if evaluated == maxSplitCells {
+					return nil
+				}
+				evaluated++
+				cell := orb.Bound{
+					Min: orb.Point{edge(bound.Min[0], bound.Max[0], i, g.cols), edge(bound.Min[1], bound.Max[1], j, g.rows)},
+					Max: orb.Point{edge(bound.Min[0], bound.Max[0], i+1, g.cols), edge(bound.Min[1], bound.Max[1], j+1, g.rows)},
+				}
+				if GetSum(h.image, cell) > limit {
+					fits = false
+					break
+				}
+				cells = append(cells, [4]float64{cell.Min[1], cell.Min[0], cell.Max[1], cell.Max[0]})
+			}
+		}
+		if fits {
+			return cells
+		}
+	}
+	return nil
+}
+
+// serveEstimate handles POST /api/estimate, which takes the same body
+// as a submission and returns its node estimate without creating a job.
+func (h *Server) serveEstimate(w http.ResponseWriter, r *http.Request) {
+	limits := h.settings().RegionLimits
+	var input Input
+	var err error
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		input, err = decodeMultipartInput(r, limits.Precision)
+	} else {
+		input, err = decodeInput(r.Body, limits.Precision)
+	}
+	var geom orb.Geometry
+	if err == nil {
+		var regionType string
+		var data json.RawMessage
+		input, err = h.resolveRegion(r.Context(), input)
+		if err == nil {
+			geom, _, regionType, data, err = parseRegion(input, limits)
+		}
+		if err == nil && input.Exclude != nil {
+			geom, _, _, _, err = excludeRegion(geom, regionType, data, input.Exclude, limits)
+		}
+	}
+	if err != nil {
+		writeInputError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.estimate(geom, GetSum(h.image, geom), h.nodesLimitFor(r, h.settings()), r.URL.Query().Get("detail") == "1"))
+}
+
+// the cover of a region is refined until it has more than this many
+// tiles, or reaches maxCoverZoom.
+const (
+	coverBudget  = 256
+	maxCoverZoom = 14
+)
+
+// a tile of a cover, with the part of the region inside it in world
+// coordinates.
+type coverTile struct {
+	tile  maptile.Tile
+	piece orb.MultiPolygon
+}
+
+// coverRegion covers the region at the first zoom with more than 256
+// tiles, or at zoom 14 for small regions. It descends from zoom 0,
+// clipping the region to the children of each covering tile, so each
+// zoom only looks at the tiles under the last cover and at the part of
+// the region inside them, and memory is bounded by the budget and the
+// region's vertices.
+func coverRegion(geom orb.Geometry) (map[maptile.Tile]bool, maptile.Zoom) {
+	var cover []coverTile
+	if root := worldPolygons(geom); coversArea(root, 0) {
+		cover = []coverTile{{maptile.New(0, 0, 0), root}}
+	}
+	var scratch [2]orb.Ring
+	z := maptile.Zoom(0)
+	for ; len(cover) <= coverBudget && z < maxCoverZoom; z++ {
+		var next []coverTile
+		for _, parent := range cover {
+			bounds := make([]orb.Bound, len(parent.piece))
+			for i, p := range parent.piece {
+				bounds[i] = p.Bound()
+			}
+			for _, child := range parent.tile.Children() {
+				if piece := clipPiece(parent.piece, bounds, tileWorldBound(child), &scratch); coversArea(piece, child.Z) {
+					next = append(next, coverTile{child, piece})
+				}
+			}
+		}
+		cover = next
+	}
+
+	covering := make(map[maptile.Tile]bool, len(cover))
+	for _, c := range cover {
+		covering[c.tile] = true
+	}
+	return covering, z
+}
+
+// worldPolygons projects the polygons of a region to world coordinates,
+// web mercator scaled to the unit square with y down like tile rows.
+func worldPolygons(geom orb.Geometry) orb.MultiPolygon {
+	var mp orb.MultiPolygon
+	switch g := geom.(type) {
+	case orb.Bound:
+		mp = orb.MultiPolygon{g.ToPolygon()}
+	case orb.Polygon:
+		mp = orb.MultiPolygon{g}
+	case orb.MultiPolygon:
+		mp = g
+	}
+	return project.MultiPolygon(orb.Clone(mp).(orb.MultiPolygon), func(p orb.Point) orb.Point {
+		lat := math.Max(-85.0511, math.Min(85.0511, p[1]))
+		sin := math.Sin(lat * math.Pi / 180)
+		return orb.Point{p[0]/360 + 0.5, 0.5 - math.Log((1+sin)/(1-sin))/(4*math.Pi)}
+	})
+}
+
+// clipPiece clips a piece, whose polygons have the given bounds, to
+// bound. Polygons within bound are shared with the parent piece.
+func clipPiece(piece orb.MultiPolygon, bounds []orb.Bound, bound orb.Bound, scratch *[2]orb.Ring) orb.MultiPolygon {
+	var clipped orb.MultiPolygon
+	for i, p := range piece {
+		b := bounds[i]
+		if !bound.Intersects(b) {
+			continue
+		}
+		if bound.Contains(b.Min) && bound.Contains(b.Max) {
+			clipped = append(clipped, p)
+			continue
+		}
+		outer := clipRing(p[0], bound, scratch)
+		if outer == nil {
+			continue
+		}
+		polygon := orb.Polygon{outer}
+		for _, hole := range p[1:] {
+			if hole = clipRing(hole, bound, scratch); hole != nil {
+				polygon = append(polygon, hole)
+			}
+		}
+		clipped = append(clipped, polygon)
+	}
+	return clipped
+}
+
+// clipRing clips a ring to bound with Sutherland-Hodgman, one side of
+// the bound at a time. The passes go through scratch, so only the
+// result is allocated and the ring is left as it is.
+func clipRing(ring orb.Ring, bound orb.Bound, scratch *[2]orb.Ring) orb.Ring {
+	in := ring
+	for side := 0; side < 4; side++ {
+		if len(in) == 0 {
+			return nil
+		}
+		out := scratch[side%2][:0]
+		prev := in[len(in)-1]
+		prevInside := insideSide(prev, bound, side)
+		for _, p := range in {
+			inside := insideSide(p, bound, side)
+			if inside != prevInside {
+				out = append(out, crossSide(prev, p, bound, side))
+			}
+			if inside {
+				out = append(out, p)
+			}
+			prev, prevInside = p, inside
+		}
+		scratch[side%2] = out
+		in = out
+	}
+	if len(in) == 0 {
+		return nil
+	}
+	clipped := make(orb.Ring, len(in), len(in)+1)
+	copy(clipped, in)
+	if clipped[0] != clipped[len(clipped)-1] {
+		clipped = append(clipped, clipped[0])
+	}
+	return clipped
+}
+
+// sides 0 to 3 are the min x, max x, min y and max y of the bound.
+func insideSide(p orb.Point, bound orb.Bound, side int) bool {
+	switch side {
+	case 0:
+		return p[0] >= bound.Min[0]
+	case 1:
+		return p[0] <= bound.Max[0]
+	case 2:
+		return p[1] >= bound.Min[1]
+	}
+	return p[1] <= bound.Max[1]
+}
+
+// crossSide is where the segment from a to b crosses a side of the bound.
+func crossSide(a orb.Point, b orb.Point, bound orb.Bound, side int) orb.Point {
+	axis := side / 2
+	edge := bound.Min[axis]
+	if side%2 == 1 {
+		edge = bound.Max[axis]
+	}
+	t := (edge - a[axis]) / (b[axis] - a[axis])
+	p := orb.Point{a[0] + t*(b[0]-a[0]), a[1] + t*(b[1]-a[1])}
+	p[axis] = edge
+	return p
+}
+
+func tileWorldBound(t maptile.Tile) orb.Bound {
+	size := 1 / float64(uint32(1)<<t.Z)
+	return orb.Bound{
+		Min: orb.Point{float64(t.X) * size, float64(t.Y) * size},
+		Max: orb.Point{float64(t.X+1) * size, float64(t.Y+1) * size},
+	}
+}
+
+// coversArea reports whether a clipped piece of a region has area in a
+// tile at zoom z. Clipping leaves degenerate rings along the tile edges
+// where the region only passes a corner, and a tile inside a hole is
+// clipped to the same square for the outer ring and the hole, so both
+// have no area.
+func coversArea(piece orb.MultiPolygon, z maptile.Zoom) bool {
+	size := 1 / float64(uint32(1)<<z)
+	return planar.Area(piece) > size*size*1e-9
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/maptile"
+	"github.com/paulmach/orb/maptile/tilecover"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetSumDetailMatchesGetSum(t *testing.T) {
+	h := newTestServer(t, "osmx")
+	geom := orb.Bound{Min: orb.Point{-77.4571, 37.5272}, Max: orb.Point{-77.4133, 37.5530}}
+	nodes, detail := GetSumDetail(h.image, geom, 3)
+	assert.Equal(t, GetSum(h.image, geom), nodes)
+	assert.True(t, detail.Tiles > 0)
+	assert.Equal(t, 3, len(detail.TopTiles))
+	assert.True(t, detail.TopTiles[0].Nodes >= detail.TopTiles[1].Nodes)
+}
+
+func TestEstimateEndpoint(t *testing.T) {
+	h := newTestServer(t, "osmx")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/api/estimate", strings.NewReader(richmond)))
+	var estimate Estimate
+	json.NewDecoder(w.Body).Decode(&estimate)
+	assert.Equal(t, 200, w.Code)
+	assert.True(t, estimate.Nodes > 0)
+	assert.Nil(t, estimate.Detail)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/api/estimate?detail=1", strings.NewReader(richmond)))
+	json.NewDecoder(w.Body).Decode(&estimate)
+	assert.NotNil(t, estimate.Detail)
+	assert.True(t, len(estimate.Detail.TopTiles) > 0)
+}
+
+func TestLimitErrorDetail(t *testing.T) {
+	h := newTestServer(t, "osmx")
+	h.nodesLimit = 10
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/api/", strings.NewReader(richmond)))
+	assert.Equal(t, 400, w.Code)
+	var limitErr LimitError
+	json.NewDecoder(w.Body).Decode(&limitErr)
+	assert.Equal(t, "the limit of nodes was exceeded.", limitErr.Error)
+	assert.Equal(t, 10, limitErr.NodesLimit)
+	assert.NotNil(t, limitErr.Detail)
+}
+
+func TestSuggestSplit(t *testing.T) {
+	h := newTestServer(t, "osmx")
+	virginia := `{"RegionType":"bbox","RegionData":[36.5,-83.7,39.5,-75.2]}`
+	var input Input
+	json.Unmarshal([]byte(virginia), &input)
+	geom, _, _, _, _ := parseRegion(input, h.regionLimits)
+	nodes := GetSum(h.image, geom)
+	h.nodesLimit = nodes/3 + 1
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/api/", strings.NewReader(virginia)))
+	assert.Equal(t, 400, w.Code)
+	var limitErr LimitError
+	json.NewDecoder(w.Body).Decode(&limitErr)
+	assert.InDelta(t, float64(nodes)/float64(h.nodesLimit), limitErr.OverLimit, 0.001)
+	assert.True(t, len(limitErr.SuggestedSplit) >= 3)
+	for _, cell := range limitErr.SuggestedSplit {
+		bound := orb.Bound{Min: orb.Point{cell[1], cell[0]}, Max: orb.Point{cell[3], cell[2]}}
+		assert.True(t, GetSum(h.image, bound) <= h.nodesLimit)
+	}
+
+	// more than maxSplitCells cells would be needed.
+	assert.Nil(t, h.suggestSplit(geom.Bound(), nodes*1000, h.nodesLimit))
+	// no cell gets under the limit.
+	h.nodesLimit = 1
+	assert.Nil(t, h.suggestSplit(geom.Bound(), nodes, h.nodesLimit))
+}
+
+// corridor is a polygon of n vertices along a winding river, 0.002
+// degrees wide.
+func corridor(n int) orb.Polygon {
+	half := n / 2
+	left := make(orb.Ring, 0, n+1)
+	right := make(orb.Ring, 0, half)
+	for i := 0; i < half; i++ {
+		lat := 30 + 17*float64(i)/float64(half)
+		lon := -90 + 0.3*math.Sin(float64(i)/50)
+		left = append(left, orb.Point{lon - 0.001, lat})
+		right = append(right, orb.Point{lon + 0.001, lat})
+	}
+	for i := len(right) - 1; i >= 0; i-- {
+		left = append(left, right[i])
+	}
+	return orb.Polygon{append(left, left[0])}
+}
+
+// the cover as it was computed before it was bounded: the full
+// tilecover of every zoom until one has more than 256 tiles.
+func tilecoverRegion(geom orb.Geometry) (map[maptile.Tile]bool, maptile.Zoom) {
+	var covering map[maptile.Tile]bool
+	var z maptile.Zoom
+	for z = 0; z <= 14; z++ {
+		covering, _ = tilecover.Geometry(geom, z)
+		if len(covering) > 256 {
+			break
+		}
+	}
+	return covering, min(z, 14)
+}
+
+func TestCoverRegionMatchesTilecover(t *testing.T) {
+	h := newTestServer(t, "osmx")
+	input, _ := decodeInput(strings.NewReader(districts), defaultRegionLimits.Precision)
+	named, _, _, _, err := parseRegion(input, defaultRegionLimits)
+	assert.Nil(t, err)
+	holed := orb.Polygon{
+		orb.Bound{Min: orb.Point{-78, 37}, Max: orb.Point{-77, 38}}.ToRing(),
+		orb.Bound{Min: orb.Point{-77.8, 37.2}, Max: orb.Point{-77.2, 37.8}}.ToRing(),
+	}
+	for name, geom := range map[string]orb.Geometry{
+		"richmond": orb.Bound{Min: orb.Point{-77.4571, 37.5272}, Max: orb.Point{-77.4133, 37.5530}},
+		"europe":   orb.Bound{Min: orb.Point{-10, 35}, Max: orb.Point{30, 60}},
+		"named":    named,
+		"holed":    holed,
+		"corridor": corridor(2000),
+	} {
+		covering, zoom := coverRegion(geom)
+		expected, expectedZoom := tilecoverRegion(geom)
+		assert.Equal(t, expectedZoom, zoom, name)
+		// tilecover also counts tiles the region only touches.
+		assert.InDelta(t, len(expected), len(covering), 0.05*float64(len(expected)), name)
+		for tile := range covering {
+			assert.True(t, expected[tile], name)
+		}
+		sum := 0.0
+		for tile := range expected {
+			sum += GetPixel(h.image, int(tile.Z), int(tile.X), int(tile.Y))
+		}
+		assert.InDelta(t, int(sum*32), GetSum(h.image, geom), 0.05*sum*32, name)
+	}
+}
+
+func BenchmarkCoverCorridor(b *testing.B) {
+	geom := corridor(50000)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		covering, _ := coverRegion(geom)
+		if len(covering) <= coverBudget {
+			b.Fatal(len(covering))
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/planar"
+)
+
+// excludeRegion subtracts the Exclude polygons of a submission from its
+// sanitized region, which becomes a geojson region with holes or several
+// parts. An exclusion that misses the region leaves it as it is, with a
+// warning.
+func excludeRegion(geom orb.Geometry, regionType string, data json.RawMessage, exclude json.RawMessage, limits RegionLimits) (orb.Geometry, string, json.RawMessage, []Warning, error) {
+	excluded, err := parseExclude(exclude)
+	if err != nil {
+		return nil, "", nil, nil, err
+	}
+	if err := checkRegionComplexity(excluded, limits); err != nil {
+		return nil, "", nil, nil, err
+	}
+
+	var region orb.MultiPolygon
+	switch v := geom.(type) {
+	case orb.Bound:
+		region = orb.MultiPolygon{{v.ToRing()}}
+	case orb.Polygon:
+		region = orb.MultiPolygon{v}
+	case orb.MultiPolygon:
+		region = v
+	default:
+		return nil, "", nil, nil, errors.New("Exclude is not supported for this region")
+	}
+
+	missed := []Warning{{warningExcludeDisjoint, "Exclude does not intersect the region"}}
+	if !excluded.Bound().Intersects(region.Bound()) {
+		return geom, regionType, data, missed, nil
+	}
+	area := planar.Area(region)
+	subtracted := differencePolygons(region, excluded)
+	remaining := planar.Area(subtracted)
+	if math.Abs(area-remaining) <= area*1e-9 {
+		return geom, regionType, data, missed, nil
+	}
+	if len(subtracted) == 0 || remaining == 0 {
+		return nil, "", nil, nil, errors.New("Exclude covers the whole region")
+	}
+
+	var result orb.Geometry = subtracted
+	if len(subtracted) == 1 {
+		result = subtracted[0]
+	}
+	data, _ = geojson.NewGeometry(result).MarshalJSON()
+	result, data, err = roundRegion(result, "geojson", data, limits.Precision)
+	if err != nil {
+		return nil, "", nil, nil, err
+	}
+	if planar.Area(result) == 0.0 {
+		return nil, "", nil, nil, errors.New("Input has 0 area")
+	}
+	if err := checkNestedRings(result); err != nil {
+		return nil, "", nil, nil, err
+	}
+	if err := checkRegionSize(data, limits); err != nil {
+		return nil, "", nil, nil, err
+	}
+	return result, "geojson", data, nil, nil
+}
+
+// parseExclude reads the Exclude of a submission, a GeoJSON Polygon or
+// MultiPolygon.
+func parseExclude(exclude json.RawMessage) (orb.MultiPolygon, error) {
+	g, err := geojson.UnmarshalGeometry(exclude)
+	if err != nil {
+		return nil, errors.New("Exclude GeoJSON is invalid")
+	}
+	var mp orb.MultiPolygon
+	switch v := g.Geometry().(type) {
+	case orb.Polygon:
+		mp = orb.MultiPolygon{v}
+	case orb.MultiPolygon:
+		mp = v
+	default:
+		return nil, errors.New("Exclude must be a Polygon or MultiPolygon")
+	}
+	if len(mp) == 0 {
+		return nil, errors.New("Exclude does not have enough rings")
+	}
+	for _, p := range mp {
+		if len(p) == 0 {
+			return nil, errors.New("Exclude does not have enough rings")
+		}
+		for _, ring := range p {
+			if len(ring) < 4 {
+				return nil, errors.New("Exclude ring does not have enough coordinates")
+			}
+		}
+	}
+	return mp, nil
+}
+
+// checkNestedRings checks that, after rounding, every ring still has
+// enough coordinates and every hole lies within its shell.
+func checkNestedRings(geom orb.Geometry) error {
+	var mp orb.MultiPolygon
+	switch v := geom.(type) {
+	case orb.Polygon:
+		mp = orb.MultiPolygon{v}
+	case orb.MultiPolygon:
+		mp = v
+	}
+	for _, p := range mp {
+		for i, ring := range p {
+			if len(ring) < 4 {
+				return errors.New("ring does not have enough coordinates")
+			}
+			if i == 0 {
+				continue
+			}
+			for _, pt := range ring {
+				if !planar.RingContains(p[0], pt) {
+					return errors.New("a hole of the region is outside its shell")
+				}
+			}
+		}
+	}
+	return nil
+}
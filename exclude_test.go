@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/planar"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExcludeHole(t *testing.T) {
+	geom, regionType, data, warnings, err := excludeRegion(orb.Bound{Min: orb.Point{0, 0}, Max: orb.Point{4, 4}}, "bbox", json.RawMessage(`[0,0,4,4]`),
+		json.RawMessage(`{"type":"Polygon","coordinates":[[[1,1],[2,1],[2,2],[1,2],[1,1]]]}`), defaultRegionLimits)
+	assert.Nil(t, err)
+	assert.Empty(t, warnings)
+	assert.Equal(t, "geojson", regionType)
+	polygon, ok := geom.(orb.Polygon)
+	assert.True(t, ok)
+	assert.Equal(t, 2, len(polygon))
+	assert.InDelta(t, 15.0, planar.Area(geom), 1e-9)
+	assert.Contains(t, string(data), `"Polygon"`)
+}
+
+func TestExcludeSplits(t *testing.T) {
+	geom, _, _, _, err := excludeRegion(square(0, 0, 3), "geojson", nil,
+		json.RawMessage(`{"type":"MultiPolygon","coordinates":[[[[1,-1],[2,-1],[2,4],[1,4],[1,-1]]]]}`), defaultRegionLimits)
+	assert.Nil(t, err)
+	mp, ok := geom.(orb.MultiPolygon)
+	assert.True(t, ok)
+	assert.Equal(t, 2, len(mp))
+	assert.InDelta(t, 6.0, planar.Area(geom), 1e-9)
+}
+
+func TestExcludeMisses(t *testing.T) {
+	region := square(0, 0, 3)
+	// disjoint bounds, and overlapping bounds without overlapping area.
+	for _, exclude := range []string{
+		`{"type":"Polygon","coordinates":[[[10,10],[11,10],[11,11],[10,11],[10,10]]]}`,
+		`{"type":"Polygon","coordinates":[[[3,3],[5,3],[5,5],[3,5],[3,3]]]}`,
+	} {
+		geom, regionType, data, warnings, err := excludeRegion(region, "geojson", json.RawMessage(`{}`), json.RawMessage(exclude), defaultRegionLimits)
+		assert.Nil(t, err)
+		assert.Equal(t, []Warning{{warningExcludeDisjoint, "Exclude does not intersect the region"}}, warnings)
+		assert.Equal(t, region, geom)
+		assert.Equal(t, "geojson", regionType)
+		assert.Equal(t, `{}`, string(data))
+	}
+}
+
+func TestExcludeInvalid(t *testing.T) {
+	for _, exclude := range []string{
+		`{"type":"Point","coordinates":[1,1]}`,
+		`{"type":"Polygon","coordinates":[[[1,1],[2,1],[1,1]]]}`,
+		`not json`,
+		// the whole region.
+		`{"type":"Polygon","coordinates":[[[-1,-1],[5,-1],[5,5],[-1,5],[-1,-1]]]}`,
+	} {
+		_, _, _, _, err := excludeRegion(square(0, 0, 3), "geojson", nil, json.RawMessage(exclude), defaultRegionLimits)
+		assert.NotNil(t, err, exclude)
+	}
+}
+
+func TestSubmitExclude(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.StartWorkers()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/api/", strings.NewReader(`{"Name":"richmond","RegionType":"bbox","RegionData":[37.5272,-77.4571,37.5530,-77.4133],"Exclude":{"type":"Polygon","coordinates":[[[-77.44,37.53],[-77.43,37.53],[-77.43,37.54],[-77.44,37.54],[-77.44,37.53]]]}}`)))
+	assert.Equal(t, 201, w.Code)
+	var created Created
+	json.NewDecoder(w.Body).Decode(&created)
+	assert.Equal(t, "geojson", created.SanitizedRegionType)
+	assert.Empty(t, created.Warnings)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/api/", strings.NewReader(`{"Name":"richmond","RegionType":"bbox","RegionData":[37.5272,-77.4571,37.5530,-77.4133],"Exclude":{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1],[0,0]]]}}`)))
+	assert.Equal(t, 201, w.Code)
+	created = Created{}
+	json.NewDecoder(w.Body).Decode(&created)
+	assert.Equal(t, "bbox", created.SanitizedRegionType)
+	assert.Equal(t, []Warning{{warningExcludeDisjoint, "Exclude does not intersect the region"}}, created.Warnings)
+}
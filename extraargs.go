@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ExtraArgsAllowlist holds the osmx flags clients may set through
+// ExtraArgs, each with the pattern its value must match. A flag with no
+// pattern takes no value.
+type ExtraArgsAllowlist map[string]*regexp.Regexp
+
+// parseExtraArgsAllowlist parses a comma separated list of flags, each
+// either bare, such as --noUserData, or followed by = and a regular
+// expression the whole value must match, such as --wayNodeFilter=[a-z]+.
+func parseExtraArgsAllowlist(s string) (ExtraArgsAllowlist, error) {
+	allowlist := make(ExtraArgsAllowlist)
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		name, pattern, hasPattern := strings.Cut(field, "=")
+		if !strings.HasPrefix(name, "-") {
+			return nil, fmt.Errorf("extra argument %q must be an osmx flag", name)
+		}
+		if !hasPattern {
+			allowlist[name] = nil
+			continue
+		}
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("extra argument %s: %w", name, err)
+		}
+		allowlist[name] = re
+	}
+	return allowlist, nil
+}
+
+// Validate checks the ExtraArgs of a submission, naming the first
+// offending flag in sorted order.
+func (a ExtraArgsAllowlist) Validate(extraArgs map[string]string) error {
+	for _, name := range sortedKeys(extraArgs) {
+		value := extraArgs[name]
+		re, ok := a[name]
+		if !ok {
+			return fmt.Errorf("ExtraArgs %s is not allowed", name)
+		}
+		if re == nil && value != "" {
+			return fmt.Errorf("ExtraArgs %s takes no value", name)
+		}
+		if re != nil && !re.MatchString(value) {
+			return fmt.Errorf("ExtraArgs %s has an invalid value", name)
+		}
+	}
+	return nil
+}
+
+// Names lists the allowed flags, sorted.
+func (a ExtraArgsAllowlist) Names() []string {
+	names := make([]string, 0, len(a))
+	for name := range a {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Args renders validated ExtraArgs as osmx arguments, sorted by flag so
+// the command line doesn't depend on map order.
+func (a ExtraArgsAllowlist) Args(extraArgs map[string]string) []string {
+	var args []string
+	for _, name := range sortedKeys(extraArgs) {
+		args = append(args, name)
+		if a[name] != nil {
+			args = append(args, extraArgs[name])
+		}
+	}
+	return args
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseExtraArgsAllowlist(t *testing.T) {
+	allowlist, err := parseExtraArgsAllowlist("--noUserData, --wayNodeFilter=[a-z]+")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"--noUserData", "--wayNodeFilter"}, allowlist.Names())
+
+	allowlist, err = parseExtraArgsAllowlist("")
+	assert.Nil(t, err)
+	assert.Empty(t, allowlist)
+
+	_, err = parseExtraArgsAllowlist("noUserData")
+	assert.NotNil(t, err)
+	_, err = parseExtraArgsAllowlist("--wayNodeFilter=[a-z")
+	assert.NotNil(t, err)
+}
+
+func TestValidateExtraArgs(t *testing.T) {
+	allowlist, _ := parseExtraArgsAllowlist("--noUserData,--wayNodeFilter=[a-z]+")
+	assert.Nil(t, allowlist.Validate(nil))
+	assert.Nil(t, allowlist.Validate(map[string]string{"--noUserData": "", "--wayNodeFilter": "highway"}))
+	assert.EqualError(t, allowlist.Validate(map[string]string{"--noUserData": "", "--output": "/etc/passwd"}), "ExtraArgs --output is not allowed")
+	assert.EqualError(t, allowlist.Validate(map[string]string{"--noUserData": "yes"}), "ExtraArgs --noUserData takes no value")
+	// the pattern must match the whole value.
+	assert.EqualError(t, allowlist.Validate(map[string]string{"--wayNodeFilter": "highway; rm"}), "ExtraArgs --wayNodeFilter has an invalid value")
+
+	// the default allowlist is empty.
+	var empty ExtraArgsAllowlist
+	assert.NotNil(t, empty.Validate(map[string]string{"--noUserData": ""}))
+}
+
+func TestExtraArgsInProvenance(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.extraArgs, _ = parseExtraArgsAllowlist("--wayNodeFilter=[a-z]+,--noUserData")
+	h.StartWorkers()
+
+	code, body := submit(h, `{"Name":"richmond","RegionType":"bbox","RegionData":[37.5272,-77.4571,37.5530,-77.4133],"ExtraArgs":{"--bogus":""}}`)
+	assert.Equal(t, 400, code)
+	assert.Contains(t, body, "--bogus")
+
+	_, uuid := submit(h, `{"Name":"richmond","RegionType":"bbox","RegionData":[37.5272,-77.4571,37.5530,-77.4133],"ExtraArgs":{"--wayNodeFilter":"highway","--noUserData":""}}`)
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, uuid)
+		return progress.Complete
+	})
+	_, progress := getProgress(h, uuid)
+	args := progress.Provenance.Args
+	assert.Equal(t, []string{"--noUserData", "--wayNodeFilter", "highway"}, args[len(args)-3:])
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Extractor runs extracts from the data file, so the queue, workers and
+// completion can be tested without osmx.
+type Extractor interface {
+	// Extract writes the part of dataFile within the region to outPath.
+	// When progress is not nil it is called with every progress report,
+	// and returning false stops the extract early without an error.
+	Extract(ctx context.Context, dataFile string, regionPath string, outPath string, extraArgs []string, progress func(Progress) bool) error
+
+	// Timestamp is the replication timestamp of dataFile.
+	Timestamp(ctx context.Context, dataFile string) (time.Time, error)
+}
+
+// osmxExtractor runs the osmx executable.
+type osmxExtractor struct {
+	exec   string
+	limits extractLimits
+}
+
+// extractArgs is the osmx command line of an extract, without the
+// executable.
+func extractArgs(dataFile string, regionPath string, outPath string, extraArgs []string, jsonOutput bool) []string {
+	args := []string{"extract", dataFile, outPath}
+	if jsonOutput {
+		args = append(args, "--jsonOutput")
+	}
+	args = append(args, "--region", regionPath)
+	return append(args, extraArgs...)
+}
+
+func (x *osmxExtractor) Extract(ctx context.Context, dataFile string, regionPath string, outPath string, extraArgs []string, progress func(Progress) bool) error {
+	name, args := x.limits.command(x.exec, extractArgs(dataFile, regionPath, outPath, extraArgs, progress != nil))
+	cmd := exec.CommandContext(ctx, name, args...)
+	ownProcessGroup(cmd)
+	stderr := &tailWriter{max: maxStderrBytes}
+	cmd.Stderr = stderr
+	leaveCgroup, err := x.limits.enterCgroup(cmd)
+	if err != nil {
+		return err
+	}
+	err = x.run(ctx, cmd, progress)
+	if cgroupErr := leaveCgroup(); cgroupErr != nil && err != nil {
+		err = cgroupErr
+	}
+	if err != nil && len(stderr.buf) > 0 {
+		return &osmxError{err: err, stderr: string(stderr.buf)}
+	}
+	return err
+}
+
+func (x *osmxExtractor) run(ctx context.Context, cmd *exec.Cmd, progress func(Progress) bool) error {
+	if progress == nil {
+		return cmd.Run()
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(stdout)
+	stopped := false
+	line, err := reader.ReadString('\n')
+	for err == nil {
+		var p Progress
+		if err := json.NewDecoder(strings.NewReader(line)).Decode(&p); err != nil {
+			cmd.Cancel()
+			cmd.Wait()
+			return err
+		}
+		if !progress(p) {
+			stopped = true
+			break
+		}
+		line, err = reader.ReadString('\n')
+	}
+	if stopped {
+		cmd.Cancel()
+	}
+	err = cmd.Wait()
+	if stopped && ctx.Err() == nil {
+		return nil
+	}
+	return err
+}
+
+func (x *osmxExtractor) Timestamp(ctx context.Context, dataFile string) (time.Time, error) {
+	cmd := exec.CommandContext(ctx, x.exec, "query", dataFile, "timestamp")
+	ownProcessGroup(cmd)
+	timestampRaw, err := cmd.Output()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, strings.TrimSpace(string(timestampRaw)))
+}
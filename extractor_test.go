@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeExtractor stands in for osmx: it reports the given progress and
+// writes output to the result path. With step set, each report waits
+// for a value on it.
+type fakeExtractor struct {
+	timestamp time.Time
+	reports   []Progress
+	step      chan struct{}
+	output    []byte
+	err       error
+
+	mutex   sync.Mutex
+	regions []string // the region files of the extracts, as read when they ran
+}
+
+func (x *fakeExtractor) Extract(ctx context.Context, dataFile string, regionPath string, outPath string, extraArgs []string, progress func(Progress) bool) error {
+	region, err := os.ReadFile(regionPath)
+	if err != nil {
+		return err
+	}
+	x.mutex.Lock()
+	x.regions = append(x.regions, string(region))
+	x.mutex.Unlock()
+
+	for _, report := range x.reports {
+		if x.step != nil {
+			select {
+			case <-x.step:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if progress != nil && !progress(report) {
+			return nil
+		}
+	}
+	if x.err != nil {
+		return x.err
+	}
+	return os.WriteFile(outPath, x.output, 0644)
+}
+
+func (x *fakeExtractor) Timestamp(ctx context.Context, dataFile string) (time.Time, error) {
+	if x.timestamp.IsZero() {
+		return time.Time{}, errors.New("no timestamp")
+	}
+	return x.timestamp, nil
+}
+
+func newFakeExtractor() *fakeExtractor {
+	pbf := appendBlob(nil, "OSMHeader", []byte("header"))
+	pbf = appendBlob(pbf, "OSMData", make([]byte, 100))
+	return &fakeExtractor{
+		timestamp: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		reports: []Progress{
+			{Timestamp: "2024-01-01T00:00:00Z", CellsTotal: 10, CellsProg: 5, NodesTotal: 100, NodesProg: 50},
+			{Timestamp: "2024-01-01T00:00:00Z", CellsTotal: 10, CellsProg: 10, NodesTotal: 100, NodesProg: 100, ElemsTotal: 120, ElemsProg: 120},
+		},
+		output: pbf,
+	}
+}
+
+func newFakeServer(t *testing.T, x *fakeExtractor) *Server {
+	h := newTestServer(t, "")
+	h.extractor = x
+	h.StartWorkers()
+	return h
+}
+
+func TestFakeExtract(t *testing.T) {
+	x := newFakeExtractor()
+	x.step = make(chan struct{})
+	h := newFakeServer(t, x)
+	code, uuid := submit(h, richmond)
+	assert.Equal(t, 201, code)
+
+	x.step <- struct{}{}
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, uuid)
+		return progress.CellsProg == 5
+	})
+	code, progress := getProgress(h, uuid)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, "extracting", progress.Stage)
+	assert.Equal(t, int64(50), progress.NodesProg)
+	assert.False(t, progress.Complete)
+	assert.Equal(t, "2024-01-01T00:00:00Z", progress.DataTimestamp)
+	assert.NotEmpty(t, progress.StartedAt)
+
+	x.step <- struct{}{}
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, uuid)
+		return progress.Complete
+	})
+	_, progress = getProgress(h, uuid)
+	assert.Equal(t, int64(120), progress.ElemsTotal)
+	assert.Equal(t, int64(len(x.output)), progress.SizeBytes)
+	sum := sha256.Sum256(x.output)
+	assert.Equal(t, hex.EncodeToString(sum[:]), progress.SHA256)
+	assert.Empty(t, progress.MD5)
+	assert.Equal(t, &[4]float64{-77.4571, 37.5272, -77.4133, 37.553}, progress.Bbox)
+	assert.Equal(t, &ElementCounts{}, progress.Elements)
+	assert.Equal(t, "", progress.Stage)
+	assert.Contains(t, progress.StageDurations, "extracting")
+	assert.Contains(t, progress.StageDurations, "finalizing")
+
+	// the completion record, the result and its region are in filesDir,
+	// and nothing is left in scratch.
+	var record Progress
+	b, err := os.ReadFile(filepath.Join(h.filesDir, uuid))
+	assert.Nil(t, err)
+	json.Unmarshal(b, &record)
+	assert.True(t, record.Complete)
+	result, err := os.ReadFile(filepath.Join(h.filesDir, uuid+".osm.pbf"))
+	assert.Nil(t, err)
+	assert.Equal(t, x.output, result)
+	_, err = os.Stat(filepath.Join(h.filesDir, progress.Blob))
+	assert.Nil(t, err)
+	var task Task
+	b, _ = os.ReadFile(filepath.Join(h.filesDir, uuid+"_region.json"))
+	json.Unmarshal(b, &task)
+	assert.Equal(t, "richmond", task.SanitizedName)
+	assert.Equal(t, "bbox", task.SanitizedRegionType)
+	assert.Empty(t, scratchFiles(h))
+	assert.Equal(t, 1, len(x.regions))
+
+	entries, _, _ := h.results.Page("", "", 10)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, uuid, entries[0].Uuid)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/"+uuid+"/download", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, x.output, w.Body.Bytes())
+}
+
+func TestFakeExtractFails(t *testing.T) {
+	x := newFakeExtractor()
+	x.err = errors.New("osmx crashed")
+	h := withAdmin(newFakeServer(t, x))
+	r := httptest.NewRequest("POST", "/api/", strings.NewReader(richmond))
+	r.Header.Set("Authorization", "Bearer user")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, 201, w.Code)
+
+	// the estimate held against the quota is released.
+	key := h.apiKeys[hashAPIKey("user")]
+	waitFor(t, func() bool {
+		failures, _ := h.failures.Window(time.Now())
+		return failures[failureExtract] == 1 && h.quotas.Status(key).NodesPending == 0
+	})
+	assert.Equal(t, int64(0), h.quotas.Status(key).NodesUsed)
+	assert.Empty(t, scratchFiles(h))
+	entries, _, _ := h.results.Page("", "", 10)
+	assert.Empty(t, entries)
+}
+
+func TestFakeExtractDryRun(t *testing.T) {
+	x := newFakeExtractor()
+	h := newFakeServer(t, x)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/api/?dryRun=1", strings.NewReader(richmond)))
+	assert.Equal(t, 201, w.Code)
+	var created Created
+	json.NewDecoder(w.Body).Decode(&created)
+
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, created.Uuid)
+		return progress.Complete
+	})
+	// stopped at the first report with the totals.
+	_, progress := getProgress(h, created.Uuid)
+	assert.True(t, progress.DryRun)
+	assert.Equal(t, int64(5), progress.CellsProg)
+	_, err := os.Stat(filepath.Join(h.filesDir, created.Uuid+".osm.pbf"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestFakeExtractCancelled(t *testing.T) {
+	x := newFakeExtractor()
+	x.step = make(chan struct{})
+	h := withAdmin(newFakeServer(t, x))
+	_, uuid := submit(h, richmond)
+	x.step <- struct{}{}
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, uuid)
+		return progress.CellsProg == 5
+	})
+
+	assert.Equal(t, 202, adminRequest(h, "/api/admin/jobs/"+uuid+"/fail", `{"Reason":"wrong region"}`))
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, uuid)
+		return progress.Failed
+	})
+	_, progress := getProgress(h, uuid)
+	assert.Equal(t, failureCancelled, progress.FailureCategory)
+	assert.Equal(t, "wrong region", progress.Error)
+	assert.Equal(t, int64(5), progress.CellsProg)
+	assert.Empty(t, scratchFiles(h))
+}
+
+func TestFakeSystemState(t *testing.T) {
+	x := newFakeExtractor()
+	x.timestamp = time.Now().Add(-time.Hour).Truncate(time.Second)
+	h := newFakeServer(t, x)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/", nil))
+	var state SystemState
+	json.NewDecoder(w.Body).Decode(&state)
+	assert.Equal(t, x.timestamp.Format(time.RFC3339), state.Timestamp)
+	assert.Equal(t, "warn", state.Status)
+	assert.Equal(t, 0, state.QueueSize)
+}
+
+func TestMD5Checksum(t *testing.T) {
+	x := newFakeExtractor()
+	h := newTestServer(t, "")
+	h.extractor = x
+	h.md5Checksums = true
+	h.StartWorkers()
+	_, uuid := submit(h, richmond)
+	var progress Progress
+	waitFor(t, func() bool {
+		_, progress = getProgress(h, uuid)
+		return progress.Complete
+	})
+	sum := md5.Sum(x.output)
+	assert.Equal(t, hex.EncodeToString(sum[:]), progress.MD5)
+	assert.Len(t, progress.SHA256, 64)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// categories of failure counted in SystemState.
+const (
+	failureValidation = "validation" // submissions with an invalid body or region
+	failureLimit      = "limit"      // submissions over the nodes limit, a quota or scratch space
+	failureExtract    = "extract"    // extracts that errored or produced a corrupt result
+	failureTimeout    = "timeout"    // extracts that ran out of time
+	failureCancelled  = "cancelled"  // jobs failed by an operator or cancelled by the client
+	failureSnapshot   = "snapshot"   // jobs whose pinned snapshot was replaced before they ran
+	failureConflict   = "conflict"   // jobs whose uuid already had a result
+)
+
+// failures are counted over this long, in one-minute buckets.
+const failureWindowMinutes = 15
+
+// with at least this many recent extracts all failing, the status is
+// error: the data file or osmx is likely broken.
+const minExtractsForError = 3
+
+const defaultMaxFailureRate = 0.5
+
+type failureBucket struct {
+	minute   int64
+	counts   map[string]int
+	extracts int // finished extracts, failed or not
+}
+
+// Rolling counts of failures by category and of finished extracts.
+type FailureCounts struct {
+	mutex   sync.Mutex
+	buckets [failureWindowMinutes]failureBucket
+}
+
+func (f *FailureCounts) bucket(now time.Time) *failureBucket {
+	minute := now.Unix() / 60
+	b := &f.buckets[minute%failureWindowMinutes]
+	if b.minute != minute {
+		*b = failureBucket{minute: minute, counts: make(map[string]int)}
+	}
+	return b
+}
+
+// Fail counts a failure. Failed extracts and timeouts also count as
+// finished extracts.
+func (f *FailureCounts) Fail(category string, now time.Time) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	b := f.bucket(now)
+	b.counts[category]++
+	if category == failureExtract || category == failureTimeout {
+		b.extracts++
+	}
+}
+
+// Succeed counts an extract that completed.
+func (f *FailureCounts) Succeed(now time.Time) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.bucket(now).extracts++
+}
+
+// Window returns the failures by category and the finished extracts
+// of the last 15 minutes.
+func (f *FailureCounts) Window(now time.Time) (map[string]int, int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	counts := make(map[string]int)
+	extracts := 0
+	minute := now.Unix() / 60
+	for _, b := range f.buckets {
+		if b.minute <= minute-failureWindowMinutes || b.minute > minute {
+			continue
+		}
+		for category, n := range b.counts {
+			counts[category] += n
+		}
+		extracts += b.extracts
+	}
+	return counts, extracts
+}
+
+// extractStatus derives the health of extracts from the recent counts:
+// error when every one of several extracts failed, warn when more than
+// maxRate of them did.
+func extractStatus(counts map[string]int, extracts int, maxRate float64) string {
+	failed := counts[failureExtract] + counts[failureTimeout]
+	if extracts >= minExtractsForError && failed == extracts {
+		return "error"
+	}
+	if extracts > 0 && float64(failed)/float64(extracts) > maxRate {
+		return "warn"
+	}
+	return "ok"
+}
+
+// worseStatus is the more severe of two statuses.
+func worseStatus(a string, b string) string {
+	rank := map[string]int{"ok": 0, "warn": 1, "error": 2}
+	if rank[b] > rank[a] {
+		return b
+	}
+	return a
+}
+
+// failureCategory classifies the error a task ended with.
+func failureCategory(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, errJobStalled) {
+		return failureTimeout
+	}
+	if errors.Is(err, errSnapshotExpired) {
+		return failureSnapshot
+	}
+	if errors.Is(err, errUuidConflict) {
+		return failureConflict
+	}
+	return failureExtract
+}
+
+// failureReason is the Error of a failed job's status: why it failed,
+// without the scratch paths and internals a wrapped error may carry.
+func failureReason(err error) string {
+	var exit *exec.ExitError
+	switch {
+	case errors.Is(err, errJobStalled):
+		return errJobStalled.Error()
+	case errors.Is(err, errMemoryLimit):
+		return errMemoryLimit.Error()
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timed out"
+	case errors.As(err, &exit):
+		return "osmx " + exit.Error()
+	}
+	return "extract failed"
+}
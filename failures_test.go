@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFailureWindow(t *testing.T) {
+	var f FailureCounts
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	f.Fail(failureValidation, start)
+	f.Fail(failureExtract, start.Add(time.Minute))
+	f.Succeed(start.Add(2 * time.Minute))
+
+	counts, extracts := f.Window(start.Add(2 * time.Minute))
+	assert.Equal(t, map[string]int{failureValidation: 1, failureExtract: 1}, counts)
+	assert.Equal(t, 2, extracts)
+
+	// the first minute has left the window.
+	counts, extracts = f.Window(start.Add(15 * time.Minute))
+	assert.Equal(t, map[string]int{failureExtract: 1}, counts)
+	assert.Equal(t, 2, extracts)
+
+	// a bucket is reused once its minute has passed.
+	f.Fail(failureLimit, start.Add(16*time.Minute))
+	counts, extracts = f.Window(start.Add(16 * time.Minute))
+	assert.Equal(t, map[string]int{failureLimit: 1}, counts)
+	assert.Equal(t, 1, extracts)
+
+	counts, extracts = f.Window(start.Add(time.Hour))
+	assert.Empty(t, counts)
+	assert.Equal(t, 0, extracts)
+}
+
+func TestExtractStatus(t *testing.T) {
+	for _, c := range []struct {
+		counts   map[string]int
+		extracts int
+		status   string
+	}{
+		{nil, 0, "ok"},
+		// rejected submissions say nothing about extracts.
+		{map[string]int{failureValidation: 50, failureLimit: 50}, 0, "ok"},
+		{map[string]int{failureExtract: 1}, 4, "ok"},
+		{map[string]int{failureExtract: 2}, 4, "ok"},
+		{map[string]int{failureExtract: 2, failureTimeout: 1}, 4, "warn"},
+		// too few extracts to call the server broken.
+		{map[string]int{failureExtract: 2}, 2, "warn"},
+		{map[string]int{failureExtract: 2, failureTimeout: 1}, 3, "error"},
+		{map[string]int{failureCancelled: 3}, 0, "ok"},
+	} {
+		assert.Equal(t, c.status, extractStatus(c.counts, c.extracts, 0.5), fmt.Sprint(c.counts, c.extracts))
+	}
+	assert.Equal(t, "warn", worseStatus("warn", "ok"))
+	assert.Equal(t, "error", worseStatus("warn", "error"))
+}
+
+func TestFailureCategory(t *testing.T) {
+	assert.Equal(t, failureTimeout, failureCategory(fmt.Errorf("osmx: %w", context.DeadlineExceeded)))
+	assert.Equal(t, failureExtract, failureCategory(errors.New("exit status 1")))
+}
+
+// a job whose extract errors ends with a failure record instead of
+// being polled forever.
+func TestFailedExtractStatus(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, "exit 1"))
+	h.StartWorkers()
+	_, uuid := submit(h, richmond)
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, uuid)
+		return progress.Failed
+	})
+	code, progress := getProgress(h, uuid)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, failureExtract, progress.FailureCategory)
+	assert.Equal(t, "osmx exit status 1", progress.Error)
+	assert.NotEmpty(t, progress.FinishedAt)
+	assert.False(t, h.hasProgress(uuid))
+	_, err := os.Stat(filepath.Join(h.filesDir, uuid))
+	assert.Nil(t, err)
+}
+
+func TestFailureReason(t *testing.T) {
+	assert.Equal(t, "stalled", failureReason(fmt.Errorf("job x: %w", errJobStalled)))
+	assert.Equal(t, "timed out", failureReason(context.DeadlineExceeded))
+	assert.Equal(t, "the extract used more memory than allowed", failureReason(fmt.Errorf("job x: %w", errMemoryLimit)))
+	assert.Equal(t, "extract failed", failureReason(errors.New("open /tmp/worker-0/x.osm.pbf: no such file")))
+}
+
+func TestSystemStateFailures(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, "exit 1"))
+	h.StartWorkers()
+	for i := 0; i < minExtractsForError; i++ {
+		submit(h, richmond)
+	}
+	code, _ := submit(h, `{"RegionType":"circle"}`)
+	assert.Equal(t, 400, code)
+
+	var state SystemState
+	waitFor(t, func() bool {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/api/", nil))
+		json.NewDecoder(w.Body).Decode(&state)
+		return state.Failures[failureExtract] == minExtractsForError
+	})
+	assert.Equal(t, 1, state.Failures[failureValidation])
+	assert.Equal(t, "error", state.Status)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/wkb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/project"
+)
+
+// a GeoPackage, base64 encoded in a JSON string. The polygons of its
+// Layer, or of the one feature with FeatureId, are reprojected to WGS84
+// and stored as a GeoJSON region.
+func parseGeoPackageRegion(input Input, limits RegionLimits) (orb.Geometry, string, json.RawMessage, error) {
+	var data []byte
+	if err := json.Unmarshal(input.RegionData, &data); err != nil {
+		return nil, "", nil, errors.New("input geopackage must be base64 encoded")
+	}
+	db, err := openSQLite(data)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("input geopackage is invalid: %w", err)
+	}
+
+	type layer struct {
+		column string
+		srsId  int64
+	}
+	layers := make(map[string]layer)
+	err = db.readTable("gpkg_geometry_columns", func(_ int64, row map[string]any) error {
+		name, _ := row["table_name"].(string)
+		column, _ := row["column_name"].(string)
+		srsId, _ := row["srs_id"].(int64)
+		layers[name] = layer{column: strings.ToLower(column), srsId: srsId}
+		return nil
+	})
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("input geopackage is invalid: %w", err)
+	}
+	names := make([]string, 0, len(layers))
+	for name := range layers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	name := input.Layer
+	if name == "" {
+		if len(names) != 1 {
+			return nil, "", nil, fmt.Errorf("the geopackage has %d layers, choose one as Layer: %s", len(names), strings.Join(names, ", "))
+		}
+		name = names[0]
+	}
+	l, ok := layers[name]
+	if !ok {
+		return nil, "", nil, fmt.Errorf("the geopackage has no layer %q, its layers are: %s", name, strings.Join(names, ", "))
+	}
+
+	unproject, err := geoPackageUnproject(db, l.srsId)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	var polygons []orb.Polygon
+	found := false
+	// the feature id of a GeoPackage is the rowid of its table.
+	err = db.readTable(name, func(fid int64, row map[string]any) error {
+		if input.FeatureId != nil {
+			if fid != *input.FeatureId {
+				return nil
+			}
+			found = true
+		}
+		blob, _ := row[l.column].([]byte)
+		if blob == nil {
+			return nil
+		}
+		geom, err := decodeGeoPackageGeometry(blob)
+		if err != nil || geom == nil {
+			return err
+		}
+		switch g := geom.(type) {
+		case orb.Polygon:
+			polygons = append(polygons, project.Polygon(g, unproject))
+		case orb.MultiPolygon:
+			polygons = append(polygons, project.MultiPolygon(g, unproject)...)
+		default:
+			if input.FeatureId != nil {
+				return fmt.Errorf("feature %d is a %s, not a polygon", *input.FeatureId, geom.GeoJSONType())
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if input.FeatureId != nil && !found {
+		return nil, "", nil, fmt.Errorf("layer %q has no feature %d", name, *input.FeatureId)
+	}
+	for _, polygon := range polygons {
+		bound := polygon.Bound()
+		if bound.Min[0] < -180 || bound.Max[0] > 180 || bound.Min[1] < -90 || bound.Max[1] > 90 {
+			return nil, "", nil, errors.New("geopackage coordinates are out of range")
+		}
+	}
+	if err := checkPolygonsComplexity(polygons, limits); err != nil {
+		return nil, "", nil, err
+	}
+	union := unionPolygons(polygons)
+	if len(union) == 0 {
+		return nil, "", nil, fmt.Errorf("layer %q has no polygons", name)
+	}
+	var geom orb.Geometry = union
+	if len(union) == 1 {
+		geom = union[0]
+	}
+	sanitizedData, _ := geojson.NewGeometry(geom).MarshalJSON()
+	return geom, "geojson", sanitizedData, nil
+}
+
+// geoPackageUnproject converts coordinates of the spatial reference
+// system srsId to WGS84. 0 and -1 are the undefined geographic and
+// cartesian systems, taken as longitude and latitude.
+func geoPackageUnproject(db *sqliteFile, srsId int64) (orb.Projection, error) {
+	identity := func(p orb.Point) orb.Point { return p }
+	if srsId == 4326 || srsId == 0 || srsId == -1 {
+		return identity, nil
+	}
+	var definition string
+	err := db.readTable("gpkg_spatial_ref_sys", func(_ int64, row map[string]any) error {
+		if id, _ := row["srs_id"].(int64); id == srsId {
+			definition, _ = row["definition"].(string)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("input geopackage is invalid: %w", err)
+	}
+	if definition == "" {
+		return nil, fmt.Errorf("the geopackage has no definition of srs %d", srsId)
+	}
+	unproject, err := prjUnproject(definition)
+	if err != nil {
+		return nil, fmt.Errorf("srs %d: %w", srsId, err)
+	}
+	return unproject, nil
+}
+
+// decodeGeoPackageGeometry reads the WKB after the GeoPackage binary
+// header of a geometry blob.
+func decodeGeoPackageGeometry(blob []byte) (orb.Geometry, error) {
+	if len(blob) < 8 || blob[0] != 'G' || blob[1] != 'P' {
+		return nil, errors.New("a geopackage geometry is invalid")
+	}
+	flags := blob[3]
+	envelope := map[byte]int{0: 0, 1: 32, 2: 48, 3: 48, 4: 64}
+	size, ok := envelope[(flags>>1)&0x07]
+	if !ok || len(blob) < 8+size {
+		return nil, errors.New("a geopackage geometry is invalid")
+	}
+	if flags&0x10 != 0 {
+		return nil, nil // empty
+	}
+	geom, err := wkb.Unmarshal(blob[8+size:])
+	if err != nil {
+		return nil, fmt.Errorf("a geopackage geometry is invalid: %w", err)
+	}
+	return geom, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/stretchr/testify/assert"
+)
+
+// testdata/regions.gpkg has small pages so its tables span interior and
+// overflow pages. Its layers are "areas" in WGS84, with Richmond as
+// feature 1, a 300 point circle as feature 2 and 40 squares after it,
+// "areas_mercator" in EPSG:3857, with Richmond as feature 7, and the
+// points of "stops".
+func geoPackageInput(t *testing.T, layer string, featureId int64) string {
+	b, err := os.ReadFile("testdata/regions.gpkg")
+	assert.Nil(t, err)
+	data, _ := json.Marshal(b)
+	input := `{"Name":"a_name","RegionType":"geopackage","RegionData":` + string(data)
+	if layer != "" {
+		input += `,"Layer":"` + layer + `"`
+	}
+	if featureId != 0 {
+		input += fmt.Sprintf(`,"FeatureId":%d`, featureId)
+	}
+	return input + `}`
+}
+
+func TestGeoPackageRegion(t *testing.T) {
+	geom, _, regiontype, _, err := parseInput(strings.NewReader(geoPackageInput(t, "areas", 1)))
+	assert.Nil(t, err)
+	assert.Equal(t, "geojson", regiontype)
+	_, isPolygon := geom.(orb.Polygon)
+	assert.True(t, isPolygon)
+	assert.Equal(t, richmondRing.Bound(), geom.Bound())
+
+	geom, _, _, _, err = parseInput(strings.NewReader(geoPackageInput(t, "areas", 2)))
+	assert.Nil(t, err)
+	assert.Equal(t, 301, len(geom.(orb.Polygon)[0]))
+
+	geom, _, _, _, err = parseInput(strings.NewReader(geoPackageInput(t, "areas", 0)))
+	assert.Nil(t, err)
+	multi, isMultiPolygon := geom.(orb.MultiPolygon)
+	assert.True(t, isMultiPolygon)
+	assert.Equal(t, 42, len(multi))
+}
+
+func TestGeoPackageProjected(t *testing.T) {
+	geom, _, _, _, err := parseInput(strings.NewReader(geoPackageInput(t, "areas_mercator", 7)))
+	assert.Nil(t, err)
+	bound := geom.Bound()
+	assert.InDelta(t, -77.4571, bound.Min[0], 1e-6)
+	assert.InDelta(t, 37.5272, bound.Min[1], 1e-6)
+	assert.InDelta(t, -77.4133, bound.Max[0], 1e-6)
+	assert.InDelta(t, 37.5530, bound.Max[1], 1e-6)
+}
+
+func TestGeoPackageInvalid(t *testing.T) {
+	_, _, _, _, err := parseInput(strings.NewReader(geoPackageInput(t, "", 0)))
+	assert.Equal(t, "the geopackage has 3 layers, choose one as Layer: areas, areas_mercator, stops", err.Error())
+	_, _, _, _, err = parseInput(strings.NewReader(geoPackageInput(t, "roads", 0)))
+	assert.Equal(t, `the geopackage has no layer "roads", its layers are: areas, areas_mercator, stops`, err.Error())
+	_, _, _, _, err = parseInput(strings.NewReader(geoPackageInput(t, "areas", 1000)))
+	assert.Equal(t, `layer "areas" has no feature 1000`, err.Error())
+	_, _, _, _, err = parseInput(strings.NewReader(geoPackageInput(t, "stops", 0)))
+	assert.Equal(t, `layer "stops" has no polygons`, err.Error())
+	_, _, _, _, err = parseInput(strings.NewReader(geoPackageInput(t, "stops", 1)))
+	assert.Equal(t, "feature 1 is a Point, not a polygon", err.Error())
+
+	data, _ := json.Marshal([]byte("not a database"))
+	_, _, _, _, err = parseInput(strings.NewReader(`{"RegionType":"geopackage","RegionData":` + string(data) + `}`))
+	assert.NotNil(t, err)
+}
+
+func TestGeoPackageUpload(t *testing.T) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("Name", "study area")
+	form.WriteField("RegionType", "geopackage")
+	form.WriteField("Layer", "areas")
+	form.WriteField("FeatureId", "1")
+	file, _ := form.CreateFormFile("RegionData", "regions.gpkg")
+	b, _ := os.ReadFile("testdata/regions.gpkg")
+	file.Write(b)
+	form.Close()
+
+	r := httptest.NewRequest("POST", "/api/", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	input, err := decodeMultipartInput(r, defaultRegionLimits.Precision)
+	assert.Nil(t, err)
+	geom, _, _, _, err := parseRegion(input, defaultRegionLimits)
+	assert.Nil(t, err)
+	assert.Equal(t, richmondRing.Bound(), geom.Bound())
+}
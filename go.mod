@@ -5,8 +5,12 @@ go 1.23.0
 require (
 	github.com/getsentry/sentry-go v0.29.1
 	github.com/google/uuid v1.6.0
+	github.com/klauspost/compress v1.18.0
 	github.com/paulmach/orb v0.11.1
 	github.com/stretchr/testify v1.8.2
+	github.com/uber/h3-go/v4 v4.4.0
+	go.etcd.io/bbolt v1.3.11
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -15,5 +19,4 @@ require (
 	go.mongodb.org/mongo-driver v1.11.4 // indirect
 	golang.org/x/sys v0.18.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"math"
+	"strings"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/simplify"
+)
+
+// upper bound on the buffer distance of a corridor region.
+const maxBufferMeters = 10000
+
+// tracks are simplified until they have at most this many vertices
+// before being buffered.
+const maxCorridorVertices = 2000
+
+// number of vertices used to approximate each half circle of a buffer.
+const bufferArcSegments = 8
+
+const metersPerDegree = 6378137 * math.Pi / 180
+
+type gpxPoint struct {
+	Lat float64 `xml:"lat,attr"`
+	Lon float64 `xml:"lon,attr"`
+}
+
+type gpxFile struct {
+	Waypoints []gpxPoint `xml:"wpt"`
+	Routes    []struct {
+		Points []gpxPoint `xml:"rtept"`
+	} `xml:"rte"`
+	Tracks []struct {
+		Segments []struct {
+			Points []gpxPoint `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+}
+
+// a GPX document in a JSON string, buffered by BufferMeters into a
+// corridor that is stored as a GeoJSON region.
+func parseGPXRegion(input Input, _ RegionLimits) (orb.Geometry, string, json.RawMessage, error) {
+	var data string
+	if err := json.Unmarshal(input.RegionData, &data); err != nil {
+		return nil, "", nil, errors.New("input GPX is invalid")
+	}
+	lines, err := parseGPX(data)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return corridorRegion(lines, input.BufferMeters)
+}
+
+// a GeoJSON LineString or MultiLineString, such as a planned route,
+// buffered by BufferMeters like a GPX track.
+func parseLineRegion(lines orb.MultiLineString, meters float64) (orb.Geometry, string, json.RawMessage, error) {
+	var nonEmpty orb.MultiLineString
+	for _, ls := range lines {
+		for _, p := range ls {
+			if p[0] < -180 || p[0] > 180 || p[1] < -90 || p[1] > 90 {
+				return nil, "", nil, errors.New("LineString coordinates are out of range")
+			}
+		}
+		if len(ls) > 0 {
+			nonEmpty = append(nonEmpty, ls)
+		}
+	}
+	if len(nonEmpty) == 0 {
+		return nil, "", nil, errors.New("LineString does not have any coordinates")
+	}
+	return corridorRegion(nonEmpty, meters)
+}
+
+// corridorRegion is the sanitized GeoJSON region within meters of the
+// lines.
+func corridorRegion(lines orb.MultiLineString, meters float64) (orb.Geometry, string, json.RawMessage, error) {
+	corridor, err := bufferLines(lines, meters)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	var geom orb.Geometry = corridor
+	if len(corridor) == 1 {
+		geom = corridor[0]
+	}
+	sanitizedData, _ := geojson.NewGeometry(geom).MarshalJSON()
+	return geom, "geojson", sanitizedData, nil
+}
+
+// parseGPX returns one LineString per track segment and route.
+func parseGPX(data string) (orb.MultiLineString, error) {
+	var gpx gpxFile
+	if err := xml.NewDecoder(strings.NewReader(data)).Decode(&gpx); err != nil {
+		return nil, errors.New("input GPX is invalid")
+	}
+
+	var lines orb.MultiLineString
+	add := func(points []gpxPoint) {
+		var ls orb.LineString
+		for _, p := range points {
+			ls = append(ls, orb.Point{p.Lon, p.Lat})
+		}
+		if len(ls) > 0 {
+			lines = append(lines, ls)
+		}
+	}
+	for _, rte := range gpx.Routes {
+		add(rte.Points)
+	}
+	for _, trk := range gpx.Tracks {
+		for _, seg := range trk.Segments {
+			add(seg.Points)
+		}
+	}
+
+	if len(lines) == 0 {
+		if len(gpx.Waypoints) > 0 {
+			return nil, errors.New("GPX contains only waypoints, a track or route is required")
+		}
+		return nil, errors.New("GPX does not contain a track or route")
+	}
+
+	for _, ls := range lines {
+		for _, p := range ls {
+			if p[0] < -180 || p[0] > 180 || p[1] < -90 || p[1] > 90 {
+				return nil, errors.New("GPX coordinates are out of range")
+			}
+		}
+	}
+	return lines, nil
+}
+
+// bufferLines builds the corridor within meters of the lines, split at
+// the antimeridian.
+func bufferLines(lines orb.MultiLineString, meters float64) (orb.MultiPolygon, error) {
+	if meters <= 0 || meters > maxBufferMeters {
+		return nil, errors.New("BufferMeters must be between 0 and 10000")
+	}
+
+	lines = simplifyLines(unwrapLines(lines), meters)
+
+	var capsules []orb.Polygon
+	for _, ls := range lines {
+		if len(ls) == 1 {
+			capsules = append(capsules, capsule(ls[0], ls[0], meters))
+		}
+		for i := 0; i+1 < len(ls); i++ {
+			capsules = append(capsules, capsule(ls[i], ls[i+1], meters))
+		}
+	}
+
+	return splitAntimeridian(unionPolygons(capsules)), nil
+}
+
+// unwrapLines makes longitudes continuous along each line, so a track
+// crossing the antimeridian runs past ±180 instead of jumping across the
+// world.
+func unwrapLines(lines orb.MultiLineString) orb.MultiLineString {
+	out := make(orb.MultiLineString, len(lines))
+	for i, ls := range lines {
+		out[i] = make(orb.LineString, len(ls))
+		offset := 0.0
+		for j, p := range ls {
+			if j > 0 {
+				delta := p[0] + offset - out[i][j-1][0]
+				if delta > 180 {
+					offset -= 360
+				} else if delta < -180 {
+					offset += 360
+				}
+			}
+			out[i][j] = orb.Point{p[0] + offset, p[1]}
+		}
+	}
+	return out
+}
+
+// simplifyLines drops vertices that deviate less than a tenth of the
+// buffer, then shares the vertex cap between lines by length if needed.
+func simplifyLines(lines orb.MultiLineString, meters float64) orb.MultiLineString {
+	simplified := simplify.DouglasPeucker(meters / 10 / metersPerDegree).MultiLineString(lines.Clone())
+	count := 0
+	for _, ls := range simplified {
+		count += len(ls)
+	}
+	if count <= maxCorridorVertices {
+		return simplified
+	}
+	for i, ls := range simplified {
+		keep := len(ls) * maxCorridorVertices / count
+		if keep < 2 {
+			keep = 2
+		}
+		simplified[i] = simplify.VisvalingamKeep(keep).LineString(ls)
+	}
+	return simplified
+}
+
+// capsule approximates the area within meters of the segment a-b, using
+// a local equirectangular projection centered on the segment.
+func capsule(a, b orb.Point, meters float64) orb.Polygon {
+	lat := (a[1] + b[1]) / 2
+	kx := metersPerDegree * math.Max(math.Cos(lat*math.Pi/180), 0.01)
+	ky := metersPerDegree
+
+	dx, dy := (b[0]-a[0])*kx, (b[1]-a[1])*ky
+	heading := math.Atan2(dy, dx)
+
+	var ring orb.Ring
+	arc := func(center orb.Point, from float64) {
+		for i := 0; i <= bufferArcSegments; i++ {
+			angle := from + math.Pi*float64(i)/bufferArcSegments
+			ring = append(ring, orb.Point{
+				center[0] + math.Cos(angle)*meters/kx,
+				clampLat(center[1] + math.Sin(angle)*meters/ky),
+			})
+		}
+	}
+	arc(b, heading-math.Pi/2)
+	arc(a, heading+math.Pi/2)
+	ring = append(ring, ring[0])
+	return orb.Polygon{ring}
+}
+
+func clampLat(lat float64) float64 {
+	return math.Max(-89.9, math.Min(89.9, lat))
+}
+
+// splitAntimeridian cuts polygons that extend past ±180 and shifts the
+// pieces back into range.
+func splitAntimeridian(mp orb.MultiPolygon) orb.MultiPolygon {
+	bound := mp.Bound()
+	if bound.Min[0] >= -180 && bound.Max[0] <= 180 {
+		return mp
+	}
+
+	var out orb.MultiPolygon
+	for shift := -720.0; shift <= 720; shift += 360 {
+		band := orb.Bound{Min: orb.Point{-180 + shift, -90}, Max: orb.Point{180 + shift, 90}}
+		if !band.Intersects(bound) {
+			continue
+		}
+		for _, p := range clipPolygons(mp, band) {
+			for _, r := range p {
+				for i := range r {
+					r[i][0] -= shift
+				}
+			}
+			out = append(out, p)
+		}
+	}
+	return out
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/planar"
+	"github.com/stretchr/testify/assert"
+)
+
+func gpxInput(gpx string, buffer float64) string {
+	data, _ := json.Marshal(gpx)
+	input, _ := json.Marshal(Input{Name: "a_name", RegionType: "gpx", RegionData: data, BufferMeters: buffer})
+	return string(input)
+}
+
+func TestGPXTrack(t *testing.T) {
+	gpx := `<gpx><trk><trkseg><trkpt lat="37.53" lon="-77.45"/><trkpt lat="37.54" lon="-77.44"/><trkpt lat="37.53" lon="-77.43"/></trkseg></trk></gpx>`
+	geom, name, regiontype, data, err := parseInput(strings.NewReader(gpxInput(gpx, 500)))
+	assert.Nil(t, err)
+	assert.Equal(t, "a_name", name)
+	assert.Equal(t, "geojson", regiontype)
+	assert.Contains(t, string(data), "Polygon")
+	assert.True(t, planar.Area(geom) > 0)
+	_, isPolygon := geom.(orb.Polygon)
+	assert.True(t, isPolygon)
+}
+
+func TestGPXOutAndBack(t *testing.T) {
+	gpx := `<gpx><trk><trkseg><trkpt lat="0" lon="0"/><trkpt lat="0" lon="0.1"/><trkpt lat="0" lon="0"/></trkseg></trk></gpx>`
+	geom, _, _, _, err := parseInput(strings.NewReader(gpxInput(gpx, 1000)))
+	assert.Nil(t, err)
+	poly, isPolygon := geom.(orb.Polygon)
+	assert.True(t, isPolygon)
+	assert.Equal(t, 1, len(poly))
+}
+
+func TestGPXOnlyWaypoints(t *testing.T) {
+	gpx := `<gpx><wpt lat="37.53" lon="-77.45"/></gpx>`
+	_, _, _, _, err := parseInput(strings.NewReader(gpxInput(gpx, 500)))
+	assert.EqualError(t, err, "GPX contains only waypoints, a track or route is required")
+}
+
+func TestGPXMissingBuffer(t *testing.T) {
+	gpx := `<gpx><rte><rtept lat="37.53" lon="-77.45"/><rtept lat="37.54" lon="-77.44"/></rte></gpx>`
+	_, _, _, _, err := parseInput(strings.NewReader(gpxInput(gpx, 0)))
+	assert.NotNil(t, err)
+}
+
+func TestGPXAntimeridian(t *testing.T) {
+	gpx := `<gpx><trk><trkseg><trkpt lat="-17" lon="179.9"/><trkpt lat="-17" lon="-179.9"/></trkseg></trk></gpx>`
+	geom, _, _, _, err := parseInput(strings.NewReader(gpxInput(gpx, 1000)))
+	assert.Nil(t, err)
+	mp, isMultiPolygon := geom.(orb.MultiPolygon)
+	assert.True(t, isMultiPolygon)
+	assert.Equal(t, 2, len(mp))
+	bound := mp.Bound()
+	assert.True(t, bound.Min[0] >= -180 && bound.Max[0] <= 180)
+}
+
+func TestGPXVertexCap(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("<gpx><trk><trkseg>")
+	for i := 0; i < 20000; i++ {
+		fmt.Fprintf(&b, `<trkpt lat="%f" lon="%f"/>`, 0.01*float64(i%7), float64(i)*0.0001)
+	}
+	b.WriteString("</trkseg></trk></gpx>")
+	lines, err := parseGPX(b.String())
+	assert.Nil(t, err)
+	simplified := simplifyLines(lines, 50)
+	assert.True(t, len(simplified[0]) <= maxCorridorVertices)
+	corridor, err := bufferLines(lines, 50)
+	assert.Nil(t, err)
+	assert.True(t, planar.Area(corridor) > 0)
+}
+
+func TestLineStringCorridor(t *testing.T) {
+	body := `{"Name":"a_name","RegionType":"geojson","BufferMeters":500,"RegionData":{"type":"LineString","coordinates":[[-77.45,37.53],[-77.44,37.54],[-77.43,37.53]]}}`
+	geom, _, regiontype, data, err := parseInput(strings.NewReader(body))
+	assert.Nil(t, err)
+	assert.Equal(t, "geojson", regiontype)
+	assert.Contains(t, string(data), "Polygon")
+	_, isPolygon := geom.(orb.Polygon)
+	assert.True(t, isPolygon)
+	assert.True(t, planar.Area(geom) > 0)
+
+	feature := `{"Name":"a_name","RegionType":"geojson","BufferMeters":500,"RegionData":{"type":"Feature","properties":{},"geometry":{"type":"MultiLineString","coordinates":[[[-77.45,37.53],[-77.44,37.54]],[[10,10],[10.01,10.01]]]}}}`
+	geom, _, _, _, err = parseInput(strings.NewReader(feature))
+	assert.Nil(t, err)
+	mp, isMultiPolygon := geom.(orb.MultiPolygon)
+	assert.True(t, isMultiPolygon)
+	assert.Equal(t, 2, len(mp))
+}
+
+func TestLineStringCorridorInvalid(t *testing.T) {
+	_, _, _, _, err := parseInput(strings.NewReader(`{"Name":"a_name","RegionType":"geojson","RegionData":{"type":"LineString","coordinates":[[-77.45,37.53],[-77.44,37.54]]}}`))
+	assert.Equal(t, "BufferMeters must be between 0 and 10000", err.Error())
+	_, _, _, _, err = parseInput(strings.NewReader(`{"Name":"a_name","RegionType":"geojson","BufferMeters":500,"RegionData":{"type":"LineString","coordinates":[[-77.45,37.53],[-200,37.54]]}}`))
+	assert.Equal(t, "LineString coordinates are out of range", err.Error())
+	_, _, _, _, err = parseInput(strings.NewReader(`{"Name":"a_name","RegionType":"geojson","BufferMeters":500,"RegionData":{"type":"MultiLineString","coordinates":[]}}`))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,92 @@
+//go:build cgo
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/uber/h3-go/v4"
+)
+
+// upper bound on the cells of a single h3 region.
+const maxH3Cells = 10000
+
+// the H3 library is C, so h3 regions are only accepted by builds with
+// cgo.
+func init() {
+	regionParsers["h3"] = parseH3Region
+}
+
+// a list of H3 cell indexes as hex strings, extracted as the union of
+// the cells and stored as a GeoJSON region.
+func parseH3Region(input Input, limits RegionLimits) (orb.Geometry, string, json.RawMessage, error) {
+	var indexes []string
+	if err := json.Unmarshal(input.RegionData, &indexes); err != nil {
+		return nil, "", nil, errors.New("input h3 must be a list of cell indexes")
+	}
+	if len(indexes) == 0 {
+		return nil, "", nil, errors.New("input does not have any cells")
+	}
+	if len(indexes) > maxH3Cells {
+		return nil, "", nil, fmt.Errorf("input has more than %d cells", maxH3Cells)
+	}
+	// cellsToMultiPolygon needs distinct cells of one resolution.
+	byResolution := make(map[int][]h3.Cell)
+	seen := make(map[h3.Cell]bool)
+	for _, s := range indexes {
+		cell := h3.Cell(h3.IndexFromString(s))
+		if !cell.IsValid() {
+			return nil, "", nil, fmt.Errorf("%q is not an H3 cell", s)
+		}
+		if !seen[cell] {
+			seen[cell] = true
+			byResolution[cell.Resolution()] = append(byResolution[cell.Resolution()], cell)
+		}
+	}
+	resolutions := make([]int, 0, len(byResolution))
+	for res := range byResolution {
+		resolutions = append(resolutions, res)
+	}
+	sort.Ints(resolutions)
+
+	var polygons []orb.Polygon
+	for _, res := range resolutions {
+		outlines, err := h3.CellsToMultiPolygon(byResolution[res])
+		if err != nil {
+			return nil, "", nil, err
+		}
+		for _, outline := range outlines {
+			polygon := orb.Polygon{h3Ring(outline.GeoLoop)}
+			for _, hole := range outline.Holes {
+				polygon = append(polygon, h3Ring(hole))
+			}
+			polygons = append(polygons, polygon)
+		}
+	}
+	if err := checkPolygonsComplexity(polygons, limits); err != nil {
+		return nil, "", nil, err
+	}
+	union := splitAntimeridian(unionPolygons(polygons))
+	var geom orb.Geometry = union
+	if len(union) == 1 {
+		geom = union[0]
+	}
+	sanitizedData, _ := geojson.NewGeometry(geom).MarshalJSON()
+	return geom, "geojson", sanitizedData, nil
+}
+
+// h3Ring closes a loop of H3 vertices, with longitudes made continuous
+// for cells that cross the antimeridian.
+func h3Ring(loop h3.GeoLoop) orb.Ring {
+	var ls orb.LineString
+	for _, v := range loop {
+		ls = append(ls, orb.Point{v.Lng, v.Lat})
+	}
+	ring := orb.Ring(unwrapLines(orb.MultiLineString{ls})[0])
+	return append(ring, ring[0])
+}
@@ -0,0 +1,58 @@
+//go:build cgo
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/planar"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber/h3-go/v4"
+)
+
+func h3Input(cells ...h3.Cell) string {
+	indexes := make([]string, len(cells))
+	for i, c := range cells {
+		indexes[i] = c.String()
+	}
+	data, _ := json.Marshal(indexes)
+	return `{"Name":"a_name","RegionType":"h3","RegionData":` + string(data) + `}`
+}
+
+func TestH3Region(t *testing.T) {
+	cell, _ := h3.LatLngToCell(h3.NewLatLng(37.54, -77.435), 7)
+	disk, _ := cell.GridDisk(1)
+	geom, _, regiontype, _, err := parseInput(strings.NewReader(h3Input(append(disk, cell)...)))
+	assert.Nil(t, err)
+	assert.Equal(t, "geojson", regiontype)
+	poly, isPolygon := geom.(orb.Polygon)
+	assert.True(t, isPolygon)
+	assert.Equal(t, 1, len(poly))
+	assert.True(t, planar.RingContains(poly[0], orb.Point{-77.435, 37.54}))
+
+	// cells of different resolutions are joined.
+	child, _ := cell.CenterChild(9)
+	geom, _, _, _, err = parseInput(strings.NewReader(h3Input(cell, child)))
+	assert.Nil(t, err)
+	_, isPolygon = geom.(orb.Polygon)
+	assert.True(t, isPolygon)
+}
+
+func TestH3Antimeridian(t *testing.T) {
+	cell, _ := h3.LatLngToCell(h3.NewLatLng(-16.5, 179.99), 5)
+	geom, _, _, _, err := parseInput(strings.NewReader(h3Input(cell)))
+	assert.Nil(t, err)
+	bound := geom.Bound()
+	assert.True(t, bound.Min[0] >= -180 && bound.Max[0] <= 180)
+	assert.True(t, bound.Max[0]-bound.Min[0] > 180)
+}
+
+func TestH3Invalid(t *testing.T) {
+	for _, data := range []string{`[]`, `["zzz"]`, `["0"]`, `"8a2a1072b59ffff"`} {
+		_, _, _, _, err := parseInput(strings.NewReader(`{"Name":"a_name","RegionType":"h3","RegionData":` + data + `}`))
+		assert.NotNil(t, err, data)
+	}
+}
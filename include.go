@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// documents of a job that GET /api/{uuid}?include= can embed in the
+// status, by include name, each read from filesDir only when asked for.
+// A document that doesn't exist yet, such as the region of a queued
+// job, is embedded as null.
+var statusIncludes = map[string]func(h *Server, uuid string) (json.RawMessage, error){
+	"region": func(h *Server, uuid string) (json.RawMessage, error) {
+		return os.ReadFile(filepath.Join(h.filesDir, uuid+"_region.json"))
+	},
+}
+
+// parseIncludes reads the comma separated include query parameter.
+func parseIncludes(r *http.Request) ([]string, error) {
+	s := r.URL.Query().Get("include")
+	if s == "" {
+		return nil, nil
+	}
+	var includes []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := statusIncludes[name]; !ok {
+			supported := make([]string, 0, len(statusIncludes))
+			for name := range statusIncludes {
+				supported = append(supported, name)
+			}
+			sort.Strings(supported)
+			return nil, fmt.Errorf("unknown include %q, supported: %s", name, strings.Join(supported, ", "))
+		}
+		includes = append(includes, name)
+	}
+	return includes, nil
+}
+
+// withIncludes adds the included documents to an encoded status under
+// "Included", keeping the bytes of both as they are.
+func (h *Server) withIncludes(status []byte, uuid string, includes []string) []byte {
+	if len(includes) == 0 {
+		return status
+	}
+	included := make(map[string]json.RawMessage)
+	for _, name := range includes {
+		doc, err := statusIncludes[name](h, uuid)
+		if err != nil || !json.Valid(doc) {
+			doc = json.RawMessage("null")
+		}
+		included[name] = doc
+	}
+	var b bytes.Buffer
+	b.Write(bytes.TrimSuffix(bytes.TrimSpace(status), []byte("}")))
+	b.WriteString(`,"Included":{`)
+	names := make([]string, 0, len(included))
+	for name := range included {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%q:", name)
+		b.Write(included[name])
+	}
+	b.WriteString("}}\n")
+	return b.Bytes()
+}
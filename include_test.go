@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getStatus(h *Server, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	return w
+}
+
+func TestIncludeRegion(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.StartWorkers()
+	code, uuid := submit(h, richmond)
+	assert.Equal(t, 201, code)
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, uuid)
+		return progress.Complete
+	})
+	region, err := os.ReadFile(filepath.Join(h.filesDir, uuid+"_region.json"))
+	assert.Nil(t, err)
+
+	w := getStatus(h, "/api/"+uuid+"?include=region")
+	assert.Equal(t, 200, w.Code)
+	var complete struct {
+		Complete bool
+		Included map[string]json.RawMessage
+	}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &complete))
+	assert.True(t, complete.Complete)
+	assert.Equal(t, string(region), string(complete.Included["region"]))
+
+	// without include the record is served as it is stored.
+	record, _ := os.ReadFile(filepath.Join(h.filesDir, uuid))
+	assert.Equal(t, string(record), getStatus(h, "/api/"+uuid).Body.String())
+
+	w = getStatus(h, "/api/"+uuid+"?include=region,stages")
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "supported: region")
+}
+
+func TestIncludeBeforeRegionIsWritten(t *testing.T) {
+	h := newProgressServer()
+	h.filesDir = t.TempDir()
+	h.queue.Push(Task{Uuid: "a"}, 0)
+	h.setProgress("a", Progress{})
+
+	w := httptest.NewRecorder()
+	assert.True(t, h.serveProgress(w, "a", []string{"region"}))
+	var queued struct {
+		QueuePosition int
+		Included      map[string]json.RawMessage
+	}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &queued))
+	assert.Equal(t, 1, queued.QueuePosition)
+	assert.Equal(t, "null", string(queued.Included["region"]))
+}
@@ -0,0 +1,598 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+// how long deleted results are remembered for mirrors to prune.
+const tombstoneRetention = 30 * 24 * time.Hour
+
+const defaultResultsLimit = 500
+const maxResultsLimit = 5000
+
+// NDJSON listings are streamed in chunks of this many entries, flushed
+// as they go, so they can be much longer than a JSON page.
+const streamChunk = 1000
+const maxStreamedResults = 1000000
+
+// Public metadata of a completed result, or a tombstone for a deleted
+// one. Entries are ordered by ChangedAt, when the result completed or
+// was deleted, then by Uuid.
+type ResultEntry struct {
+	Uuid          string
+	ChangedAt     string
+	Deleted       bool        `json:",omitempty"`
+	Name          string      `json:",omitempty"`
+	Bbox          *[4]float64 `json:",omitempty"` // min lon, min lat, max lon, max lat
+	SizeBytes     int64       `json:",omitempty"`
+	SHA256        string      `json:",omitempty"`
+	MD5           string      `json:",omitempty"`
+	StartedAt     string      `json:",omitempty"`
+	FinishedAt    string      `json:",omitempty"`
+	DataTimestamp string      `json:",omitempty"`
+	ExpiresAt     string      `json:",omitempty"`
+
+	// NodesTotal of the extract, for tuning the output size estimate,
+	// and the seconds of its stages, for weighting PercentComplete.
+	nodes          int64
+	stageDurations map[string]float64
+
+	// the regionHash of a result that can be handed out for identical
+	// submissions, "" for encrypted ones.
+	regionHash string
+
+	// whether the result is encrypted, so kept out of the public listing.
+	encrypted bool
+}
+
+type ResultsPage struct {
+	Results []ResultEntry
+	Cursor  string `json:",omitempty"`
+}
+
+// An incremental index of results, loaded from filesDir at startup and
+// kept up to date as jobs complete or results are deleted.
+type ResultIndex struct {
+	mutex          sync.RWMutex
+	entries        []ResultEntry
+	tombstonesPath string
+
+	// the newest result of each regionHash.
+	byRegion map[string]ResultEntry
+
+	// encrypted results by uuid. They count toward the totals but are
+	// not listed, and leave no tombstone when deleted.
+	encrypted map[string]ResultEntry
+
+	// summaries of the jobs with a record, by uuid, for the listing of
+	// recent jobs.
+	finished map[string]JobSummary
+
+	// totals over the results with both a node count and a size.
+	sizedNodes int64
+	sizedBytes int64
+
+	// seconds in each stage over the results with stage durations, and
+	// the seconds extracting of those that had the stage.
+	timedResults    int64
+	stageSeconds    map[string]float64
+	stageExtracting map[string]float64
+}
+
+func (e ResultEntry) before(changedAt string, uuid string) bool {
+	if e.ChangedAt != changedAt {
+		return e.ChangedAt < changedAt
+	}
+	return e.Uuid < uuid
+}
+
+// LoadResultIndex scans filesDir for completion records and reads the
+// tombstones of recently deleted results.
+func LoadResultIndex(filesDir string) (*ResultIndex, error) {
+	ix := &ResultIndex{
+		tombstonesPath: filepath.Join(filesDir, "tombstones.jsonl"),
+		finished:       make(map[string]JobSummary),
+		encrypted:      make(map[string]ResultEntry),
+	}
+
+	dirEntries, err := os.ReadDir(filesDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range dirEntries {
+		if d.IsDir() || uuid.Validate(d.Name()) != nil {
+			continue
+		}
+		progress, task, ok := readRecord(filesDir, d.Name())
+		if !ok {
+			continue
+		}
+		ix.finished[task.Uuid] = newJobSummary(task, progress)
+		if progress.Complete && !progress.DryRun {
+			entry := newResultEntry(task, progress)
+			if entry.encrypted {
+				ix.encrypted[entry.Uuid] = entry
+			} else {
+				ix.entries = append(ix.entries, entry)
+			}
+			ix.count(entry, 1)
+			ix.cache(entry)
+		}
+	}
+
+	if f, err := os.Open(ix.tombstonesPath); err == nil {
+		cutoff := time.Now().Add(-tombstoneRetention).UTC().Format(time.RFC3339Nano)
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var entry ResultEntry
+			if json.Unmarshal(scanner.Bytes(), &entry) == nil && entry.ChangedAt >= cutoff {
+				ix.entries = append(ix.entries, entry)
+			}
+		}
+		f.Close()
+	}
+
+	sort.Slice(ix.entries, func(i, j int) bool {
+		return ix.entries[i].before(ix.entries[j].ChangedAt, ix.entries[j].Uuid)
+	})
+	return ix, nil
+}
+
+// readResultEntry builds the entry of a completed job from its
+// completion record and region.json.
+func readResultEntry(filesDir string, id string) (ResultEntry, bool) {
+	progress, task, ok := readRecord(filesDir, id)
+	if !ok || !progress.Complete || progress.DryRun {
+		return ResultEntry{}, false
+	}
+	return newResultEntry(task, progress), true
+}
+
+// readRecord reads the record of a finished job and its region.json,
+// which records rebuilt without one lack.
+func readRecord(filesDir string, id string) (Progress, Task, bool) {
+	var progress Progress
+	b, err := os.ReadFile(filepath.Join(filesDir, id))
+	if err != nil || json.Unmarshal(b, &progress) != nil {
+		return Progress{}, Task{}, false
+	}
+	var task Task
+	if b, err := os.ReadFile(filepath.Join(filesDir, id+"_region.json")); err == nil {
+		json.Unmarshal(b, &task)
+	}
+	task.Uuid = id
+	return progress, task, true
+}
+
+func newResultEntry(task Task, progress Progress) ResultEntry {
+	entry := ResultEntry{
+		Uuid:           task.Uuid,
+		ChangedAt:      progress.FinishedAt,
+		Name:           task.SanitizedName,
+		SizeBytes:      progress.SizeBytes,
+		SHA256:         progress.SHA256,
+		MD5:            progress.MD5,
+		StartedAt:      progress.StartedAt,
+		FinishedAt:     progress.FinishedAt,
+		DataTimestamp:  progress.DataTimestamp,
+		nodes:          progress.NodesTotal,
+		stageDurations: progress.StageDurations,
+		encrypted:      task.Encrypt || progress.Encryption != nil,
+	}
+	if bound, ok := regionBound(task); ok {
+		entry.Bbox = &[4]float64{bound.Min[0], bound.Min[1], bound.Max[0], bound.Max[1]}
+	}
+	// records rebuilt without their region.json have no region.
+	if !entry.encrypted && task.SanitizedRegionType != "" {
+		entry.regionHash = regionHash(task)
+	}
+	return entry
+}
+
+// regionBound is the extent of a sanitized region.
+func regionBound(task Task) (orb.Bound, bool) {
+	geom, ok := regionGeometry(task)
+	if !ok {
+		return orb.Bound{}, false
+	}
+	return geom.Bound(), true
+}
+
+// regionGeometry decodes the sanitized region of a task.
+func regionGeometry(task Task) (orb.Geometry, bool) {
+	switch task.SanitizedRegionType {
+	case "bbox":
+		var coords []float64
+		if json.Unmarshal(task.SanitizedRegionData, &coords) != nil || len(coords) != 4 {
+			return nil, false
+		}
+		return orb.Bound{Min: orb.Point{coords[1], coords[0]}, Max: orb.Point{coords[3], coords[2]}}, true
+	case "bboxes":
+		var boxes [][]float64
+		if json.Unmarshal(task.SanitizedRegionData, &boxes) != nil || len(boxes) == 0 {
+			return nil, false
+		}
+		for _, box := range boxes {
+			if len(box) != 4 {
+				return nil, false
+			}
+		}
+		return bboxesGeometry(boxes), true
+	case "geojson":
+		g, err := geojson.UnmarshalGeometry(task.SanitizedRegionData)
+		if err != nil {
+			return nil, false
+		}
+		return g.Geometry(), true
+	}
+	return nil, false
+}
+
+func (ix *ResultIndex) insert(entry ResultEntry) {
+	i := sort.Search(len(ix.entries), func(i int) bool {
+		return !ix.entries[i].before(entry.ChangedAt, entry.Uuid)
+	})
+	ix.entries = append(ix.entries, ResultEntry{})
+	copy(ix.entries[i+1:], ix.entries[i:])
+	ix.entries[i] = entry
+}
+
+// count adds an entry to the size totals, or with sign -1 takes it
+// away. The mutex must be held.
+func (ix *ResultIndex) count(entry ResultEntry, sign int64) {
+	if entry.nodes > 0 && entry.SizeBytes > 0 {
+		ix.sizedNodes += sign * entry.nodes
+		ix.sizedBytes += sign * entry.SizeBytes
+	}
+	if extracting := entry.stageDurations["extracting"]; extracting > 0 {
+		if ix.stageSeconds == nil {
+			ix.stageSeconds = make(map[string]float64)
+			ix.stageExtracting = make(map[string]float64)
+		}
+		ix.timedResults += sign
+		for stage, seconds := range entry.stageDurations {
+			ix.stageSeconds[stage] += float64(sign) * seconds
+			ix.stageExtracting[stage] += float64(sign) * extracting
+		}
+	}
+}
+
+// cache makes an entry the result of its region if it is the newest.
+// Finish times are to the second, so of two results finished in the
+// same second the one added last wins. The mutex must be held.
+func (ix *ResultIndex) cache(entry ResultEntry) {
+	if entry.regionHash == "" {
+		return
+	}
+	if ix.byRegion == nil {
+		ix.byRegion = make(map[string]ResultEntry)
+	}
+	if cached, ok := ix.byRegion[entry.regionHash]; !ok || cached.ChangedAt <= entry.ChangedAt {
+		ix.byRegion[entry.regionHash] = entry
+	}
+}
+
+// Add records a newly completed result.
+func (ix *ResultIndex) Add(entry ResultEntry) {
+	ix.mutex.Lock()
+	defer ix.mutex.Unlock()
+	if entry.encrypted {
+		if ix.encrypted == nil {
+			ix.encrypted = make(map[string]ResultEntry)
+		}
+		ix.encrypted[entry.Uuid] = entry
+	} else {
+		ix.insert(entry)
+	}
+	ix.count(entry, 1)
+	ix.cache(entry)
+}
+
+// Finish records the summary of a job whose record was written.
+func (ix *ResultIndex) Finish(summary JobSummary) {
+	ix.mutex.Lock()
+	defer ix.mutex.Unlock()
+	ix.finished[summary.Uuid] = summary
+}
+
+// Forget drops the summary of a job whose record was removed.
+func (ix *ResultIndex) Forget(id string) {
+	ix.mutex.Lock()
+	defer ix.mutex.Unlock()
+	delete(ix.finished, id)
+}
+
+// Finished lists the summaries of the jobs with a record, in no order.
+func (ix *ResultIndex) Finished() []JobSummary {
+	ix.mutex.RLock()
+	defer ix.mutex.RUnlock()
+	summaries := make([]JobSummary, 0, len(ix.finished))
+	for _, summary := range ix.finished {
+		summaries = append(summaries, summary)
+	}
+	return summaries
+}
+
+// FinishedJob is the summary of a job with a record.
+func (ix *ResultIndex) FinishedJob(id string) (JobSummary, bool) {
+	ix.mutex.RLock()
+	defer ix.mutex.RUnlock()
+	summary, ok := ix.finished[id]
+	return summary, ok
+}
+
+// Cached is the newest result that hasn't been deleted of the region
+// with the given regionHash.
+func (ix *ResultIndex) Cached(hash string) (ResultEntry, bool) {
+	ix.mutex.RLock()
+	defer ix.mutex.RUnlock()
+	entry, ok := ix.byRegion[hash]
+	return entry, ok
+}
+
+// BytesPerNode is the output size per node over the indexed results,
+// once they add up to at least minNodes.
+func (ix *ResultIndex) BytesPerNode(minNodes int64) (float64, bool) {
+	ix.mutex.RLock()
+	defer ix.mutex.RUnlock()
+	if ix.sizedNodes < minNodes || ix.sizedNodes == 0 {
+		return 0, false
+	}
+	return float64(ix.sizedBytes) / float64(ix.sizedNodes), true
+}
+
+// StageWeights is the time spent in each stage relative to extracting,
+// over the jobs that had the stage, once at least minResults results
+// have stage durations.
+func (ix *ResultIndex) StageWeights(minResults int64) (map[string]float64, bool) {
+	ix.mutex.RLock()
+	defer ix.mutex.RUnlock()
+	if ix.timedResults < minResults || ix.timedResults == 0 {
+		return nil, false
+	}
+	weights := make(map[string]float64, len(ix.stageSeconds))
+	for stage, seconds := range ix.stageSeconds {
+		if extracting := ix.stageExtracting[stage]; extracting > 0 {
+			weights[stage] = seconds / extracting
+		}
+	}
+	for stage, weight := range defaultStageWeights {
+		if _, ok := weights[stage]; !ok {
+			weights[stage] = weight
+		}
+	}
+	return weights, true
+}
+
+// Remove replaces the entry of a deleted result with a tombstone,
+// which is persisted so mirrors learn of the deletion after a restart.
+// Encrypted results were never listed, so are just dropped.
+func (ix *ResultIndex) Remove(id string) error {
+	ix.mutex.Lock()
+	defer ix.mutex.Unlock()
+	if e, ok := ix.encrypted[id]; ok {
+		ix.count(e, -1)
+		delete(ix.encrypted, id)
+		return nil
+	}
+	for i, e := range ix.entries {
+		if e.Uuid == id && !e.Deleted {
+			ix.count(e, -1)
+			// an older result of the region isn't brought back: it was
+			// most likely evicted first.
+			if ix.byRegion[e.regionHash].Uuid == id {
+				delete(ix.byRegion, e.regionHash)
+			}
+			ix.entries = append(ix.entries[:i], ix.entries[i+1:]...)
+			break
+		}
+	}
+	tombstone := ResultEntry{Uuid: id, ChangedAt: time.Now().UTC().Format(time.RFC3339Nano), Deleted: true}
+	ix.insert(tombstone)
+
+	line, err := json.Marshal(tombstone)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(ix.tombstonesPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// Page returns up to limit entries changed at or after since, continuing
+// after the cursor of a previous page.
+func (ix *ResultIndex) Page(since string, cursor string, limit int) ([]ResultEntry, string, error) {
+	afterChangedAt, afterUuid := since, ""
+	inclusive := true
+	if cursor != "" {
+		b, err := base64.RawURLEncoding.DecodeString(cursor)
+		parts := strings.SplitN(string(b), "|", 2)
+		if err != nil || len(parts) != 2 {
+			return nil, "", errInvalidCursor
+		}
+		afterChangedAt, afterUuid = parts[0], parts[1]
+		inclusive = false
+	}
+
+	ix.mutex.RLock()
+	defer ix.mutex.RUnlock()
+	i := sort.Search(len(ix.entries), func(i int) bool {
+		e := ix.entries[i]
+		if inclusive {
+			return e.ChangedAt >= afterChangedAt
+		}
+		return afterChangedAt < e.ChangedAt || (e.ChangedAt == afterChangedAt && afterUuid < e.Uuid)
+	})
+	end := min(i+limit, len(ix.entries))
+	page := make([]ResultEntry, end-i)
+	copy(page, ix.entries[i:end])
+
+	next := ""
+	if end < len(ix.entries) && len(page) > 0 {
+		next = page[len(page)-1].cursor()
+	}
+	return page, next, nil
+}
+
+func (e ResultEntry) cursor() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(e.ChangedAt + "|" + e.Uuid))
+}
+
+// Stream is Page for long listings: the entries are copied out of the
+// index a chunk at a time and passed to f, so neither the lock nor the
+// whole page is held while a client reads. The next cursor is known
+// before any entry and is passed to begin.
+func (ix *ResultIndex) Stream(since string, cursor string, limit int, begin func(next string), f func([]ResultEntry) error) error {
+	first, next, err := ix.Page(since, cursor, 1)
+	if err != nil {
+		return err
+	}
+	if len(first) == 0 {
+		begin("")
+		return nil
+	}
+	// entries added while streaming sort after the end of the page, so
+	// it is fixed by its last entry.
+	ix.mutex.RLock()
+	i := sort.Search(len(ix.entries), func(i int) bool {
+		return !ix.entries[i].before(first[0].ChangedAt, first[0].Uuid)
+	})
+	end := min(i+limit, len(ix.entries))
+	last := ix.entries[end-1]
+	next = ""
+	if end < len(ix.entries) {
+		next = last.cursor()
+	}
+	ix.mutex.RUnlock()
+	begin(next)
+
+	for remaining := limit; remaining > 0; {
+		page, more, err := ix.Page(since, cursor, min(streamChunk, remaining))
+		if err != nil {
+			return err
+		}
+		for j, e := range page {
+			if last.before(e.ChangedAt, e.Uuid) {
+				page = page[:j]
+				more = ""
+				break
+			}
+		}
+		if len(page) > 0 {
+			if err := f(page); err != nil {
+				return err
+			}
+		}
+		if more == "" || len(page) == 0 {
+			return nil
+		}
+		remaining -= len(page)
+		cursor = page[len(page)-1].cursor()
+	}
+	return nil
+}
+
+// wantsNDJSON is whether a listing should be streamed one JSON object
+// per line, by ?format=ndjson or the Accept header.
+func wantsNDJSON(r *http.Request) bool {
+	return r.URL.Query().Get("format") == "ndjson" || strings.Contains(r.Header.Get("Accept"), "application/x-ndjson")
+}
+
+// streamNDJSON writes entries one per line and flushes them.
+func streamNDJSON[E any](w http.ResponseWriter, entries []E) error {
+	encoder := json.NewEncoder(w)
+	for _, e := range entries {
+		if err := encoder.Encode(e); err != nil {
+			return err
+		}
+	}
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// parseLimit parses the page size of a listing, which is larger when
+// it is streamed.
+func parseLimit(s string, ndjson bool) (int, error) {
+	if s == "" {
+		return defaultResultsLimit, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0, errors.New("limit must be a positive integer")
+	}
+	if ndjson {
+		return min(n, maxStreamedResults), nil
+	}
+	return min(n, maxResultsLimit), nil
+}
+
+// serveResults handles GET /api/results?since=&limit=&cursor=&format=.
+func (h *Server) serveResults(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	since := ""
+	if s := query.Get("since"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			w.WriteHeader(400)
+			w.Write([]byte("Error: since must be an RFC3339 timestamp"))
+			return
+		}
+		since = t.UTC().Format(time.RFC3339Nano)
+	}
+	ndjson := wantsNDJSON(r)
+	limit, err := parseLimit(query.Get("limit"), ndjson)
+	if err != nil {
+		w.WriteHeader(400)
+		w.Write([]byte("Error: " + err.Error()))
+		return
+	}
+
+	if ndjson {
+		begin := func(next string) {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			if next != "" {
+				w.Header().Set("X-Next-Cursor", next)
+			}
+		}
+		err := h.results.Stream(since, query.Get("cursor"), limit, begin, func(page []ResultEntry) error {
+			return streamNDJSON(w, page)
+		})
+		if errors.Is(err, errInvalidCursor) {
+			w.WriteHeader(400)
+			w.Write([]byte("Error: " + err.Error()))
+		}
+		return
+	}
+
+	page, cursor, err := h.results.Page(since, query.Get("cursor"), limit)
+	if err != nil {
+		w.WriteHeader(400)
+		w.Write([]byte("Error: " + err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ResultsPage{Results: page, Cursor: cursor})
+}
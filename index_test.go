@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResultIndex(t *testing.T) {
+	dir := t.TempDir()
+	ix, err := LoadResultIndex(dir)
+	assert.Nil(t, err)
+	ix.Add(ResultEntry{Uuid: "b", ChangedAt: "2024-01-02T00:00:00Z"})
+	ix.Add(ResultEntry{Uuid: "a", ChangedAt: "2024-01-03T00:00:00Z"})
+	ix.Add(ResultEntry{Uuid: "c", ChangedAt: "2024-01-01T00:00:00Z"})
+
+	page, cursor, err := ix.Page("", "", 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"c", "b"}, []string{page[0].Uuid, page[1].Uuid})
+	page, next, _ := ix.Page("", cursor, 2)
+	assert.Equal(t, "a", page[0].Uuid)
+	assert.Equal(t, "", next)
+
+	page, _, _ = ix.Page("2024-01-02T00:00:00Z", "", 10)
+	assert.Equal(t, 2, len(page))
+
+	assert.Nil(t, ix.Remove("c"))
+	page, _, _ = ix.Page("", "", 10)
+	assert.Equal(t, 3, len(page))
+	assert.Equal(t, "c", page[2].Uuid)
+	assert.True(t, page[2].Deleted)
+
+	_, _, err = ix.Page("", "garbage", 10)
+	assert.NotNil(t, err)
+
+	// tombstones survive a restart
+	reloaded, _ := LoadResultIndex(dir)
+	page, _, _ = reloaded.Page("", "", 10)
+	assert.Equal(t, 1, len(page))
+	assert.True(t, page[0].Deleted)
+}
+
+func TestLoadResultIndex(t *testing.T) {
+	dir := t.TempDir()
+	id := "0f4f6ee9-9ae4-4d2b-8b2c-1b5a4b8b2a10"
+	os.WriteFile(filepath.Join(dir, id), []byte(`{"Complete":true,"SizeBytes":42,"FinishedAt":"2024-01-01T00:00:00Z"}`), 0644)
+	os.WriteFile(filepath.Join(dir, id+"_region.json"), []byte(`{"SanitizedName":"richmond","SanitizedRegionType":"bbox","SanitizedRegionData":[37.5,-77.5,37.6,-77.4]}`), 0644)
+	os.WriteFile(filepath.Join(dir, "1a7e1d1e-2c2a-4f0b-9a55-3b0b8f0c5d11"), []byte(`{"Failed":true}`), 0644)
+	os.WriteFile(filepath.Join(dir, "quota.json"), []byte(`{}`), 0644)
+
+	ix, err := LoadResultIndex(dir)
+	assert.Nil(t, err)
+	page, _, _ := ix.Page("", "", 10)
+	assert.Equal(t, 1, len(page))
+	assert.Equal(t, "richmond", page[0].Name)
+	assert.Equal(t, int64(42), page[0].SizeBytes)
+	assert.Equal(t, &[4]float64{-77.5, 37.5, -77.4, 37.6}, page[0].Bbox)
+}
+
+func TestResultIndexEncrypted(t *testing.T) {
+	dir := t.TempDir()
+	id := "0f4f6ee9-9ae4-4d2b-8b2c-1b5a4b8b2a10"
+	os.WriteFile(filepath.Join(dir, id), []byte(`{"Complete":true,"SizeBytes":42,"FinishedAt":"2024-01-01T00:00:00Z","Encryption":{}}`), 0644)
+	os.WriteFile(filepath.Join(dir, id+"_region.json"), []byte(`{"SanitizedName":"richmond","Encrypt":true}`), 0644)
+
+	ix, err := LoadResultIndex(dir)
+	assert.Nil(t, err)
+	ix.Add(ResultEntry{Uuid: "a", ChangedAt: "2024-01-02T00:00:00Z", encrypted: true})
+	page, _, _ := ix.Page("", "", 10)
+	assert.Empty(t, page)
+
+	// deleting them leaves no tombstone either.
+	assert.Nil(t, ix.Remove(id))
+	assert.Nil(t, ix.Remove("a"))
+	page, _, _ = ix.Page("", "", 10)
+	assert.Empty(t, page)
+	assert.Empty(t, ix.encrypted)
+}
+
+func TestServeResults(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.StartWorkers()
+	_, uuid := submit(h, richmond)
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, uuid)
+		return progress.Complete
+	})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/results?since=2000-01-01T00:00:00Z", nil))
+	assert.Equal(t, 200, w.Code)
+	var page ResultsPage
+	json.NewDecoder(w.Body).Decode(&page)
+	assert.Equal(t, 1, len(page.Results))
+	assert.Equal(t, uuid, page.Results[0].Uuid)
+	assert.Len(t, page.Results[0].SHA256, 64)
+	assert.NotNil(t, page.Results[0].Bbox)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/results?format=ndjson", nil))
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	assert.Equal(t, 1, strings.Count(w.Body.String(), "\n"))
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/results?since=yesterday", nil))
+	assert.Equal(t, 400, w.Code)
+}
+
+// a response writer that discards the body, sampling the live heap as
+// it goes.
+type heapSampler struct {
+	header http.Header
+	lines  int
+	writes int
+	peak   uint64
+}
+
+func (s *heapSampler) Header() http.Header { return s.header }
+func (s *heapSampler) WriteHeader(int)     {}
+func (s *heapSampler) Flush()              {}
+func (s *heapSampler) Write(b []byte) (int, error) {
+	s.lines += bytes.Count(b, []byte("\n"))
+	if s.writes++; s.writes%20000 == 0 {
+		runtime.GC()
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		s.peak = max(s.peak, m.HeapAlloc)
+	}
+	return len(b), nil
+}
+
+func TestStreamResultsMemory(t *testing.T) {
+	h := newTestServer(t, "osmx")
+	const n = 100000
+	h.results.entries = make([]ResultEntry, n)
+	for i := range h.results.entries {
+		h.results.entries[i] = ResultEntry{
+			Uuid:      fmt.Sprintf("%08d-0000-4000-8000-000000000000", i),
+			ChangedAt: "2024-01-01T00:00:00Z",
+			Name:      strings.Repeat("x", 200),
+			SHA256:    strings.Repeat("0", 64),
+		}
+	}
+
+	runtime.GC()
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	s := &heapSampler{header: make(http.Header)}
+	h.ServeHTTP(s, httptest.NewRequest("GET", fmt.Sprintf("/api/results?format=ndjson&limit=%d", n), nil))
+	runtime.KeepAlive(h)
+	assert.Equal(t, n, s.lines)
+	assert.Empty(t, s.header.Get("X-Next-Cursor"))
+	// a copy of the whole page would take 15MB.
+	assert.Less(t, int64(s.peak)-int64(before.HeapAlloc), int64(8<<20))
+}
+
+func TestStreamResultsCursor(t *testing.T) {
+	h := newTestServer(t, "osmx")
+	for i := 0; i < 2500; i++ {
+		h.results.Add(ResultEntry{Uuid: fmt.Sprintf("%04d", i), ChangedAt: "2024-01-01T00:00:00Z"})
+	}
+
+	var seen []string
+	cursor := ""
+	for {
+		r := httptest.NewRequest("GET", "/api/results?limit=1100&cursor="+cursor, nil)
+		r.Header.Set("Accept", "application/x-ndjson")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+		decoder := json.NewDecoder(w.Body)
+		for decoder.More() {
+			var entry ResultEntry
+			decoder.Decode(&entry)
+			seen = append(seen, entry.Uuid)
+		}
+		cursor = w.Header().Get("X-Next-Cursor")
+		if cursor == "" {
+			break
+		}
+	}
+	assert.Len(t, seen, 2500)
+	assert.Equal(t, "0000", seen[0])
+	assert.Equal(t, "2499", seen[2499])
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/results?format=ndjson&cursor=garbage", nil))
+	assert.Equal(t, 400, w.Code)
+}
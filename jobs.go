@@ -0,0 +1,342 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// A job in the admin listing.
+type JobEntry struct {
+	Uuid        string
+	State       string // queued, running, complete, failed or evicted
+	DryRun      bool   `json:",omitempty"`
+	SizeBytes   int64  `json:",omitempty"`
+	SubmittedAt string `json:",omitempty"`
+	StartedAt   string `json:",omitempty"`
+	FinishedAt  string `json:",omitempty"`
+	Error       string `json:",omitempty"`
+}
+
+type JobsPage struct {
+	Jobs   []JobEntry
+	Cursor string `json:",omitempty"` // the last uuid of the page
+}
+
+// jobIds lists every job with progress in memory or a record in
+// filesDir, in uuid order. Only the names are held; entries are read
+// as they are written out.
+func (h *Server) jobIds() ([]string, error) {
+	dir, err := os.Open(h.filesDir)
+	if err != nil {
+		return nil, err
+	}
+	names, err := dir.Readdirnames(-1)
+	dir.Close()
+	if err != nil {
+		return nil, err
+	}
+	ids := names[:0]
+	for _, name := range names {
+		if uuid.Validate(name) == nil {
+			ids = append(ids, name)
+		}
+	}
+	h.progressMutex.RLock()
+	for id := range h.progress {
+		ids = append(ids, id)
+	}
+	h.progressMutex.RUnlock()
+	slices.Sort(ids)
+	return slices.Compact(ids), nil
+}
+
+func (h *Server) jobEntry(id string) JobEntry {
+	h.progressMutex.RLock()
+	progress, running := h.progress[id]
+	h.progressMutex.RUnlock()
+	if running {
+		if progress.StartedAt == "" {
+			return JobEntry{Uuid: id, State: "queued"}
+		}
+		return JobEntry{Uuid: id, State: "running", StartedAt: progress.StartedAt}
+	}
+
+	entry := JobEntry{Uuid: id}
+	b, err := os.ReadFile(filepath.Join(h.filesDir, id))
+	if err != nil || json.Unmarshal(b, &progress) != nil {
+		// finished between listing and reading, or an unreadable record.
+		entry.State = "failed"
+		entry.Error = "record is missing"
+		return entry
+	}
+	entry.State = recordState(progress)
+	entry.DryRun = progress.DryRun
+	entry.SizeBytes = progress.SizeBytes
+	entry.SubmittedAt = progress.SubmittedAt
+	entry.StartedAt = progress.StartedAt
+	entry.FinishedAt = progress.FinishedAt
+	entry.Error = progress.Error
+	return entry
+}
+
+// recordState is the state of a job with the record.
+func recordState(progress Progress) string {
+	switch {
+	case progress.Complete:
+		return "complete"
+	case progress.Evicted:
+		return "evicted"
+	}
+	return "failed"
+}
+
+// serveJobs handles GET /api/admin/jobs?limit=&cursor=&format=.
+func (h *Server) serveJobs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	ndjson := wantsNDJSON(r)
+	limit, err := parseLimit(query.Get("limit"), ndjson)
+	cursor := query.Get("cursor")
+	if err == nil && cursor != "" && uuid.Validate(cursor) != nil {
+		err = errInvalidCursor
+	}
+	if err != nil {
+		w.WriteHeader(400)
+		w.Write([]byte("Error: " + err.Error()))
+		return
+	}
+	ids, err := h.jobIds()
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte("Error: " + err.Error()))
+		return
+	}
+
+	i, found := slices.BinarySearch(ids, cursor)
+	if found {
+		i++
+	}
+	end := min(i+limit, len(ids))
+	next := ""
+	if end < len(ids) && end > i {
+		next = ids[end-1]
+	}
+	ids = ids[i:end]
+
+	if ndjson {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		if next != "" {
+			w.Header().Set("X-Next-Cursor", next)
+		}
+		chunk := make([]JobEntry, 0, streamChunk)
+		for len(ids) > 0 {
+			n := min(streamChunk, len(ids))
+			chunk = chunk[:0]
+			for _, id := range ids[:n] {
+				chunk = append(chunk, h.jobEntry(id))
+			}
+			if streamNDJSON(w, chunk) != nil {
+				return
+			}
+			ids = ids[n:]
+		}
+		return
+	}
+
+	page := JobsPage{Jobs: make([]JobEntry, 0, len(ids)), Cursor: next}
+	for _, id := range ids {
+		page.Jobs = append(page.Jobs, h.jobEntry(id))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// A job in the public listing of recent jobs.
+type JobSummary struct {
+	Uuid        string
+	Status      string // queued, running, complete, failed or evicted
+	Name        string `json:",omitempty"`
+	RegionType  string `json:",omitempty"`
+	DryRun      bool   `json:",omitempty"`
+	SizeBytes   int64  `json:",omitempty"`
+	SubmittedAt string `json:",omitempty"`
+	StartedAt   string `json:",omitempty"`
+	FinishedAt  string `json:",omitempty"`
+}
+
+type JobSummaries struct {
+	Jobs  []JobSummary
+	Total int // the jobs matching the filter, across all pages
+}
+
+var jobStatuses = []string{"queued", "running", "complete", "failed", "evicted"}
+
+// recentJobs lists the jobs with the given status, or all of them,
+// most recently submitted first. Records written before submission
+// times were kept are ordered by when they started. Unless owned is
+// nil, only the jobs in it are listed.
+func (h *Server) recentJobs(status string, owned map[string]bool) ([]JobSummary, error) {
+	stored, err := h.jobs.Jobs()
+	if err != nil {
+		return nil, err
+	}
+	var jobs []JobSummary
+	inFlight := make(map[string]bool, len(stored))
+	for _, job := range stored {
+		task := job.Task
+		inFlight[task.Uuid] = true
+		if (status != "" && status != job.State) || (owned != nil && !owned[task.Uuid]) {
+			continue
+		}
+		summary := JobSummary{
+			Uuid:        task.Uuid,
+			Status:      job.State,
+			Name:        task.SanitizedName,
+			RegionType:  cmp.Or(task.RegionType, task.SanitizedRegionType),
+			DryRun:      task.DryRun,
+			SubmittedAt: task.SubmittedAt.UTC().Format(time.RFC3339),
+		}
+		if job.State == jobRunning {
+			summary.StartedAt = h.currentProgress(task.Uuid).StartedAt
+		}
+		jobs = append(jobs, summary)
+	}
+
+	if status != jobQueued && status != jobRunning {
+		for _, summary := range h.results.Finished() {
+			if inFlight[summary.Uuid] || (status != "" && status != summary.Status) || (owned != nil && !owned[summary.Uuid]) {
+				continue
+			}
+			jobs = append(jobs, summary)
+		}
+	}
+
+	recency := func(j JobSummary) string {
+		return cmp.Or(j.SubmittedAt, j.StartedAt, j.FinishedAt)
+	}
+	slices.SortFunc(jobs, func(a, b JobSummary) int {
+		return cmp.Or(cmp.Compare(recency(b), recency(a)), cmp.Compare(a.Uuid, b.Uuid))
+	})
+	return jobs, nil
+}
+
+// newJobSummary summarizes a finished job from its record and
+// region.json.
+func newJobSummary(task Task, progress Progress) JobSummary {
+	return JobSummary{
+		Uuid:        task.Uuid,
+		Status:      recordState(progress),
+		Name:        task.SanitizedName,
+		RegionType:  cmp.Or(task.RegionType, task.SanitizedRegionType),
+		DryRun:      progress.DryRun,
+		SizeBytes:   progress.SizeBytes,
+		SubmittedAt: progress.SubmittedAt,
+		StartedAt:   progress.StartedAt,
+		FinishedAt:  progress.FinishedAt,
+	}
+}
+
+// noteFinished adds a job whose record was just written, or rewritten,
+// to the listing of recent jobs.
+func (h *Server) noteFinished(id string) {
+	if progress, task, ok := readRecord(h.filesDir, id); ok {
+		h.results.Finish(newJobSummary(task, progress))
+	}
+}
+
+// jobSummary summarizes a job from its progress or record.
+func (h *Server) jobSummary(id string) JobSummary {
+	h.progressMutex.RLock()
+	_, running := h.progress[id]
+	h.progressMutex.RUnlock()
+	if summary, ok := h.results.FinishedJob(id); ok && !running {
+		return summary
+	}
+	entry := h.jobEntry(id)
+	summary := JobSummary{
+		Uuid:        id,
+		Status:      entry.State,
+		DryRun:      entry.DryRun,
+		SizeBytes:   entry.SizeBytes,
+		SubmittedAt: entry.SubmittedAt,
+		StartedAt:   entry.StartedAt,
+		FinishedAt:  entry.FinishedAt,
+	}
+	// queued jobs have no region.json yet.
+	var task Task
+	if b, err := os.ReadFile(filepath.Join(h.filesDir, id+"_region.json")); err == nil && json.Unmarshal(b, &task) == nil {
+		summary.Name = task.SanitizedName
+		summary.RegionType = cmp.Or(task.RegionType, task.SanitizedRegionType)
+	}
+	return summary
+}
+
+// serveRecentJobs handles GET /api/jobs?status=&limit=&offset=. An admin
+// key lists every job; other API keys the jobs they submitted, and
+// logged in users the jobs in their history.
+func (h *Server) serveRecentJobs(w http.ResponseWriter, r *http.Request) {
+	key, err := h.authenticate(r)
+	if err != nil {
+		w.WriteHeader(401)
+		fmt.Fprintf(w, "Error: %s", err)
+		return
+	}
+	var owned map[string]bool
+	switch user := h.osmUser(r); {
+	case key != nil && key.can(scopeAdmin):
+	case key != nil:
+		owned, err = h.jobs.OwnedBy(key.Name)
+	case user != nil:
+		owned = h.userJobs.Uuids(user.Id)
+	default:
+		w.WriteHeader(401)
+		fmt.Fprintf(w, "Error: an API key or login is required")
+		return
+	}
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte("Error: " + err.Error()))
+		return
+	}
+
+	query := r.URL.Query()
+	status := query.Get("status")
+	limit, err := parseLimit(query.Get("limit"), false)
+	offset := 0
+	if s := query.Get("offset"); err == nil && s != "" {
+		offset, err = strconv.Atoi(s)
+		if err != nil || offset < 0 {
+			err = errors.New("offset must be a non-negative integer")
+		}
+	}
+	if err == nil && status != "" && !slices.Contains(jobStatuses, status) {
+		err = errors.New("status must be queued, running, complete, failed or evicted")
+	}
+	if err != nil {
+		w.WriteHeader(400)
+		w.Write([]byte("Error: " + err.Error()))
+		return
+	}
+	jobs, err := h.recentJobs(status, owned)
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte("Error: " + err.Error()))
+		return
+	}
+
+	page := JobSummaries{Jobs: []JobSummary{}, Total: len(jobs)}
+	if offset < len(jobs) {
+		page.Jobs = jobs[offset:min(offset+limit, len(jobs))]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func adminGet(h *Server, path string, accept string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", path, nil)
+	r.Header.Set("Authorization", "Bearer admin")
+	if accept != "" {
+		r.Header.Set("Accept", accept)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestAdminJobs(t *testing.T) {
+	h := withAdmin(newTestServer(t, "osmx"))
+	h.progress = map[string]Progress{"00000000-0000-4000-8000-000000000000": {}}
+	h.progressJSON = make(map[string][]byte)
+	for i := 1; i <= 5; i++ {
+		os.WriteFile(filepath.Join(h.filesDir, fmt.Sprintf("%08d-0000-4000-8000-000000000000", i)), []byte(`{"Complete":true,"SizeBytes":42}`), 0644)
+	}
+	os.WriteFile(filepath.Join(h.filesDir, "00000006-0000-4000-8000-000000000000"), []byte(`{"Failed":true,"Error":"corrupt output"}`), 0644)
+
+	w := adminGet(h, "/api/admin/jobs?limit=4", "")
+	assert.Equal(t, 200, w.Code)
+	var page JobsPage
+	json.NewDecoder(w.Body).Decode(&page)
+	assert.Len(t, page.Jobs, 4)
+	assert.Equal(t, "queued", page.Jobs[0].State)
+	assert.Equal(t, "complete", page.Jobs[1].State)
+	assert.Equal(t, int64(42), page.Jobs[1].SizeBytes)
+	assert.Equal(t, "00000003-0000-4000-8000-000000000000", page.Cursor)
+
+	w = adminGet(h, "/api/admin/jobs?cursor="+page.Cursor, "application/x-ndjson")
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	assert.Empty(t, w.Header().Get("X-Next-Cursor"))
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	assert.Len(t, lines, 3)
+	var last JobEntry
+	json.Unmarshal([]byte(lines[2]), &last)
+	assert.Equal(t, "failed", last.State)
+	assert.Equal(t, "corrupt output", last.Error)
+
+	w = adminGet(h, "/api/admin/jobs?cursor=garbage", "")
+	assert.Equal(t, 400, w.Code)
+}
+
+func TestRecentJobs(t *testing.T) {
+	h := withAdmin(newTestServer(t, "osmx"))
+	h.progress = make(map[string]Progress)
+	h.progressJSON = make(map[string][]byte)
+	h.queue = NewScheduler("fifo", 10)
+	_, queued := submit(h, richmond)
+	_, running := submit(h, richmond)
+	task, _ := h.queue.Remove(running)
+	h.persistJob(task, jobRunning)
+	h.setProgress(running, Progress{StartedAt: "2099-01-01T00:00:00Z"})
+
+	complete := "00000001-0000-4000-8000-000000000000"
+	os.WriteFile(filepath.Join(h.filesDir, complete), []byte(`{"Complete":true,"SizeBytes":42,"SubmittedAt":"2024-01-02T00:00:00Z","StartedAt":"2024-01-02T00:01:00Z"}`), 0644)
+	os.WriteFile(filepath.Join(h.filesDir, complete+"_region.json"), []byte(`{"SanitizedName":"downtown","SanitizedRegionType":"geojson","RegionType":"gpx"}`), 0644)
+	// a record from before submission times were kept.
+	failed := "00000002-0000-4000-8000-000000000000"
+	os.WriteFile(filepath.Join(h.filesDir, failed), []byte(`{"Failed":true,"StartedAt":"2024-01-01T00:00:00Z"}`), 0644)
+	h.results, _ = LoadResultIndex(h.filesDir)
+
+	list := func(query string) JobSummaries {
+		w := adminGet(h, "/api/jobs"+query, "")
+		assert.Equal(t, 200, w.Code)
+		var page JobSummaries
+		json.NewDecoder(w.Body).Decode(&page)
+		return page
+	}
+
+	page := list("")
+	assert.Equal(t, 4, page.Total)
+	assert.Len(t, page.Jobs, 4)
+	assert.ElementsMatch(t, []string{queued, running}, []string{page.Jobs[0].Uuid, page.Jobs[1].Uuid})
+	assert.Equal(t, complete, page.Jobs[2].Uuid)
+	assert.Equal(t, JobSummary{Uuid: complete, Status: "complete", Name: "downtown", RegionType: "gpx", SizeBytes: 42, SubmittedAt: "2024-01-02T00:00:00Z", StartedAt: "2024-01-02T00:01:00Z"}, page.Jobs[2])
+	assert.Equal(t, failed, page.Jobs[3].Uuid)
+	assert.Equal(t, "failed", page.Jobs[3].Status)
+
+	page = list("?status=running")
+	assert.Equal(t, 1, page.Total)
+	assert.Equal(t, running, page.Jobs[0].Uuid)
+	assert.Equal(t, "richmond", page.Jobs[0].Name)
+	assert.Equal(t, "bbox", page.Jobs[0].RegionType)
+	assert.Equal(t, "2099-01-01T00:00:00Z", page.Jobs[0].StartedAt)
+	assert.NotEmpty(t, page.Jobs[0].SubmittedAt)
+
+	page = list("?limit=1&offset=2")
+	assert.Equal(t, 4, page.Total)
+	assert.Len(t, page.Jobs, 1)
+	assert.Equal(t, complete, page.Jobs[0].Uuid)
+
+	page = list("?offset=10")
+	assert.Equal(t, 4, page.Total)
+	assert.Empty(t, page.Jobs)
+
+	for _, query := range []string{"?status=lost", "?offset=-1", "?limit=0"} {
+		w := adminGet(h, "/api/jobs"+query, "")
+		assert.Equal(t, 400, w.Code, query)
+	}
+}
+
+func TestRecentJobsOwned(t *testing.T) {
+	h := withOSMAuth(t, withAdmin(newTestServer(t, fakeOsmx(t, ""))))
+	h.progress = make(map[string]Progress)
+	h.progressJSON = make(map[string][]byte)
+	h.queue = NewScheduler("fifo", 10)
+	keyed := submitAs(h, richmond, "user").Uuid
+	_, anonymous := submit(h, richmond)
+	session := login(t, h)
+	r := httptest.NewRequest("POST", "/api/", strings.NewReader(richmond))
+	r.AddCookie(session)
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	list := func(r *http.Request) []string {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		assert.Equal(t, 200, w.Code)
+		var page JobSummaries
+		json.NewDecoder(w.Body).Decode(&page)
+		var uuids []string
+		for _, job := range page.Jobs {
+			uuids = append(uuids, job.Uuid)
+		}
+		return uuids
+	}
+
+	r = httptest.NewRequest("GET", "/api/jobs", nil)
+	r.Header.Set("Authorization", "Bearer user")
+	assert.Equal(t, []string{keyed}, list(r))
+
+	r = httptest.NewRequest("GET", "/api/jobs", nil)
+	r.AddCookie(session)
+	users := list(r)
+	assert.Len(t, users, 1)
+	assert.NotContains(t, users, keyed)
+	assert.NotContains(t, users, anonymous)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/jobs", nil))
+	assert.Equal(t, 401, w.Code)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	jobsBucket   = []byte("jobs")
+	ownersBucket = []byte("owners")
+)
+
+// states of a persisted job.
+const (
+	jobQueued  = "queued"
+	jobRunning = "running"
+)
+
+// Queued and running jobs, persisted in queue.db in filesDir so they
+// are queued again after a restart or crash. A job is removed once it
+// completes or fails. The owners of jobs are kept until their results
+// are deleted.
+type JobStore struct {
+	db *bolt.DB
+}
+
+// a persisted job, with the fields of its task that aren't part of the
+// public region.json.
+type storedJob struct {
+	Task           Task
+	KeyName        string
+	EstimatedNodes int64
+	SubmittedAt    time.Time
+	CallbackUrl    string
+	Priority       string
+	State          string
+}
+
+func OpenJobStore(filesDir string) (*JobStore, error) {
+	// another server on the same filesDir holds the lock.
+	db, err := bolt.Open(filepath.Join(filesDir, "queue.db"), 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(jobsBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(ownersBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &JobStore{db: db}, nil
+}
+
+func (s *JobStore) Close() error {
+	return s.db.Close()
+}
+
+// Put records a job in the given state, replacing any earlier state.
+func (s *JobStore) Put(task Task, state string) error {
+	b, err := json.Marshal(storedJob{task, task.KeyName, task.EstimatedNodes, task.SubmittedAt, task.CallbackUrl, task.Priority, state})
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).Put([]byte(task.Uuid), b)
+	})
+}
+
+// Delete forgets a job that finished.
+func (s *JobStore) Delete(uuid string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).Delete([]byte(uuid))
+	})
+}
+
+// who may delete the result of a job: the API key that submitted it,
+// and the hash of the DeleteToken it was given.
+type jobOwner struct {
+	KeyName   string `json:",omitempty"`
+	TokenHash string
+}
+
+// PutOwner records the owner of a new job.
+func (s *JobStore) PutOwner(uuid string, owner jobOwner) error {
+	b, err := json.Marshal(owner)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(ownersBucket).Put([]byte(uuid), b)
+	})
+}
+
+// Owner returns the owner of a job, false for jobs submitted before
+// owners were recorded.
+func (s *JobStore) Owner(uuid string) (jobOwner, bool, error) {
+	var owner jobOwner
+	var ok bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(ownersBucket).Get([]byte(uuid))
+		if b == nil {
+			return nil
+		}
+		ok = true
+		return json.Unmarshal(b, &owner)
+	})
+	return owner, ok, err
+}
+
+// OwnedBy lists the jobs submitted with the named API key.
+func (s *JobStore) OwnedBy(keyName string) (map[string]bool, error) {
+	owned := make(map[string]bool)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(ownersBucket).ForEach(func(k, v []byte) error {
+			var owner jobOwner
+			if err := json.Unmarshal(v, &owner); err != nil {
+				return fmt.Errorf("queue.db: owner of %s: %w", k, err)
+			}
+			if owner.KeyName == keyName {
+				owned[string(k)] = true
+			}
+			return nil
+		})
+	})
+	return owned, err
+}
+
+// DeleteOwner forgets the owner of a job whose result was deleted.
+func (s *JobStore) DeleteOwner(uuid string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(ownersBucket).Delete([]byte(uuid))
+	})
+}
+
+// Jobs lists the persisted jobs in the order they were submitted.
+func (s *JobStore) Jobs() ([]storedJob, error) {
+	var jobs []storedJob
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).ForEach(func(k, v []byte) error {
+			var job storedJob
+			if err := json.Unmarshal(v, &job); err != nil {
+				return fmt.Errorf("queue.db: job %s: %w", k, err)
+			}
+			job.Task.KeyName = job.KeyName
+			job.Task.EstimatedNodes = job.EstimatedNodes
+			job.Task.SubmittedAt = job.SubmittedAt
+			job.Task.CallbackUrl = job.CallbackUrl
+			job.Task.Priority = job.Priority
+			jobs = append(jobs, job)
+			return nil
+		})
+	})
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[i].SubmittedAt.Before(jobs[j].SubmittedAt)
+	})
+	return jobs, err
+}
+
+// persistJob records the state of a job, logging failures: the job
+// still runs, it just won't survive a restart.
+func (h *Server) persistJob(task Task, state string) {
+	if err := h.jobs.Put(task, state); err != nil {
+		fmt.Println("persisting job", task.Uuid, err)
+	}
+}
+
+func (h *Server) forgetJob(uuid string) {
+	if err := h.jobs.Delete(uuid); err != nil {
+		fmt.Println("forgetting job", uuid, err)
+	}
+}
+
+// restoreJobs queues the jobs left by the last run again. Those that
+// were running start over, ahead of those that were waiting.
+func (h *Server) restoreJobs() (int, error) {
+	jobs, err := h.jobs.Jobs()
+	if err != nil {
+		return 0, err
+	}
+	var queued []storedJob
+	for _, job := range jobs {
+		if job.State == jobRunning {
+			h.restoreJob(job.Task)
+			h.queue.PushFront(job.Task, job.EstimatedNodes)
+		} else {
+			queued = append(queued, job)
+		}
+	}
+	for _, job := range queued {
+		h.restoreJob(job.Task)
+		if !h.queue.Push(job.Task, int(job.EstimatedNodes)) {
+			h.takeProgress(job.Task.Uuid)
+			if job.KeyName != "" {
+				h.quotas.Release(job.KeyName, job.EstimatedNodes)
+			}
+			fmt.Println("queue full, not restoring job", job.Task.Uuid)
+		}
+	}
+	return len(jobs), nil
+}
+
+func (h *Server) restoreJob(task Task) {
+	h.setProgress(task.Uuid, Progress{Priority: task.Priority})
+	if task.KeyName != "" {
+		h.quotas.Hold(task.KeyName, task.EstimatedNodes)
+	}
+}
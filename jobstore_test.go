@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobStore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenJobStore(dir)
+	assert.Nil(t, err)
+	now := time.Now().UTC().Truncate(time.Second)
+	s.Put(Task{Uuid: "b", KeyName: "partner", EstimatedNodes: 100, SubmittedAt: now.Add(time.Minute)}, jobQueued)
+	s.Put(Task{Uuid: "a", SubmittedAt: now}, jobQueued)
+	s.Put(Task{Uuid: "c", SubmittedAt: now.Add(2 * time.Minute)}, jobQueued)
+	s.Put(Task{Uuid: "a", SubmittedAt: now}, jobRunning)
+	assert.Nil(t, s.Delete("c"))
+	assert.Nil(t, s.Close())
+
+	// the store survives reopening, with the fields region.json omits.
+	s, err = OpenJobStore(dir)
+	assert.Nil(t, err)
+	defer s.Close()
+	jobs, err := s.Jobs()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(jobs))
+	assert.Equal(t, "a", jobs[0].Task.Uuid)
+	assert.Equal(t, jobRunning, jobs[0].State)
+	assert.Equal(t, "b", jobs[1].Task.Uuid)
+	assert.Equal(t, "partner", jobs[1].Task.KeyName)
+	assert.Equal(t, int64(100), jobs[1].Task.EstimatedNodes)
+	assert.True(t, now.Add(time.Minute).Equal(jobs[1].Task.SubmittedAt))
+}
+
+func TestRestoreJobsAfterRestart(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.progress = make(map[string]Progress)
+	h.progressJSON = make(map[string][]byte)
+	h.queue = NewScheduler("fifo", 10)
+	_, waiting := submit(h, richmond)
+	_, running := submit(h, richmond)
+	task, _ := h.queue.Remove(running)
+	h.persistJob(task, jobRunning)
+
+	// restart without workers having run: the queue and progress are
+	// lost, only queue.db remains.
+	h.jobs.Close()
+	jobs, err := OpenJobStore(h.filesDir)
+	assert.Nil(t, err)
+	t.Cleanup(func() { jobs.Close() })
+	h.jobs = jobs
+	h.StartWorkers()
+	n, err := h.restoreJobs()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	for _, uuid := range []string{waiting, running} {
+		waitFor(t, func() bool {
+			_, progress := getProgress(h, uuid)
+			return progress.Complete
+		})
+	}
+	// jobs are forgotten just after their record is written.
+	waitFor(t, func() bool {
+		stored, _ := h.jobs.Jobs()
+		return len(stored) == 0
+	})
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+type kmlRing struct {
+	Coordinates string `xml:"LinearRing>coordinates"`
+}
+
+type kmlPolygon struct {
+	Outer  kmlRing   `xml:"outerBoundaryIs"`
+	Inners []kmlRing `xml:"innerBoundaryIs"`
+}
+
+// a KML document in a JSON string, as drawn in Google Earth, whose
+// polygons are stored as a GeoJSON region.
+func parseKMLRegion(input Input, limits RegionLimits) (orb.Geometry, string, json.RawMessage, error) {
+	var data string
+	if err := json.Unmarshal(input.RegionData, &data); err != nil {
+		return nil, "", nil, errors.New("input KML is invalid")
+	}
+	return kmlRegion([]byte(data), limits)
+}
+
+// a KMZ, the zipped KML that Google Earth saves, base64 encoded in a
+// JSON string.
+func parseKMZRegion(input Input, limits RegionLimits) (orb.Geometry, string, json.RawMessage, error) {
+	var zipped []byte
+	if err := json.Unmarshal(input.RegionData, &zipped); err != nil {
+		return nil, "", nil, errors.New("input KMZ must be a base64 encoded zip")
+	}
+	archive, err := zip.NewReader(bytes.NewReader(zipped), int64(len(zipped)))
+	if err != nil {
+		return nil, "", nil, errors.New("input KMZ is not a zip")
+	}
+	// the root document is doc.kml, or else the first .kml file.
+	var doc *zip.File
+	for _, f := range archive.File {
+		name := strings.ToLower(f.Name)
+		if path.Ext(name) != ".kml" || strings.HasPrefix(path.Base(name), ".") {
+			continue
+		}
+		if doc == nil || name == "doc.kml" {
+			doc = f
+		}
+	}
+	if doc == nil {
+		return nil, "", nil, errors.New("the KMZ has no .kml file")
+	}
+	data, err := readZipFile(doc)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return kmlRegion(data, limits)
+}
+
+func kmlRegion(data []byte, limits RegionLimits) (orb.Geometry, string, json.RawMessage, error) {
+	polygons, err := parseKML(data)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if err := checkPolygonsComplexity(polygons, limits); err != nil {
+		return nil, "", nil, err
+	}
+	union := unionPolygons(polygons)
+	if len(union) == 0 {
+		return nil, "", nil, errors.New("KML has no polygons")
+	}
+	var geom orb.Geometry = union
+	if len(union) == 1 {
+		geom = union[0]
+	}
+	sanitizedData, _ := geojson.NewGeometry(geom).MarshalJSON()
+	return geom, "geojson", sanitizedData, nil
+}
+
+// parseKML returns every Polygon of a KML document, wherever it is in
+// its Folders, Placemarks and MultiGeometries. Other geometries, such
+// as paths and points, are skipped.
+func parseKML(data []byte) ([]orb.Polygon, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	var polygons []orb.Polygon
+	found := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.New("input KML is invalid")
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if start.Name.Local == "kml" {
+			found = true
+		}
+		if start.Name.Local != "Polygon" {
+			continue
+		}
+		var p kmlPolygon
+		if err := decoder.DecodeElement(&p, &start); err != nil {
+			return nil, errors.New("input KML is invalid")
+		}
+		outer, err := parseKMLRing(p.Outer.Coordinates)
+		if err != nil {
+			return nil, err
+		}
+		polygon := orb.Polygon{outer}
+		for _, inner := range p.Inners {
+			ring, err := parseKMLRing(inner.Coordinates)
+			if err != nil {
+				return nil, err
+			}
+			polygon = append(polygon, ring)
+		}
+		polygons = append(polygons, polygon)
+	}
+	if !found {
+		return nil, errors.New("input is not a KML document")
+	}
+	return polygons, nil
+}
+
+// parseKMLRing reads the whitespace separated lon,lat[,alt] tuples of a
+// coordinates element, closing the ring if needed.
+func parseKMLRing(coordinates string) (orb.Ring, error) {
+	var ring orb.Ring
+	for _, tuple := range strings.Fields(coordinates) {
+		fields := strings.Split(tuple, ",")
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("KML coordinates %q are not lon,lat", tuple)
+		}
+		lon, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("KML coordinates %q are not lon,lat", tuple)
+		}
+		lat, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("KML coordinates %q are not lon,lat", tuple)
+		}
+		if lon < -180 || lon > 180 || lat < -90 || lat > 90 {
+			return nil, errors.New("KML coordinates are out of range")
+		}
+		ring = append(ring, orb.Point{lon, lat})
+	}
+	if len(ring) > 0 && !ring.Closed() {
+		ring = append(ring, ring[0])
+	}
+	if len(ring) < 4 {
+		return nil, errors.New("ring does not have enough coordinates")
+	}
+	return ring, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/stretchr/testify/assert"
+)
+
+// as Google Earth saves a drawn polygon, with altitudes.
+const richmondKML = `<?xml version="1.0" encoding="UTF-8"?>
+<kml xmlns="http://www.opengis.net/kml/2.2" xmlns:gx="http://www.google.com/kml/ext/2.2">
+<Document>
+	<name>Richmond.kml</name>
+	<Placemark>
+		<name>Richmond</name>
+		<Polygon>
+			<tessellate>1</tessellate>
+			<outerBoundaryIs>
+				<LinearRing>
+					<coordinates>
+						-77.4571,37.5272,0 -77.4133,37.5272,0 -77.4133,37.5530,0 -77.4571,37.5530,0 -77.4571,37.5272,0
+					</coordinates>
+				</LinearRing>
+			</outerBoundaryIs>
+		</Polygon>
+	</Placemark>
+</Document>
+</kml>`
+
+func kmlInput(kml string) string {
+	data, _ := json.Marshal(kml)
+	return `{"Name":"a_name","RegionType":"kml","RegionData":` + string(data) + `}`
+}
+
+func kmzInput(zipped []byte) string {
+	data, _ := json.Marshal(zipped)
+	return `{"Name":"a_name","RegionType":"kmz","RegionData":` + string(data) + `}`
+}
+
+func TestKMLRegion(t *testing.T) {
+	geom, _, regiontype, _, err := parseInput(strings.NewReader(kmlInput(richmondKML)))
+	assert.Nil(t, err)
+	assert.Equal(t, "geojson", regiontype)
+	_, isPolygon := geom.(orb.Polygon)
+	assert.True(t, isPolygon)
+	assert.Equal(t, richmondRing.Bound(), geom.Bound())
+}
+
+func TestKMLNested(t *testing.T) {
+	kml := `<kml xmlns="http://www.opengis.net/kml/2.2"><Document><Folder>
+		<Placemark><name>a route</name><LineString><coordinates>-77.45,37.53 -77.44,37.54</coordinates></LineString></Placemark>
+		<Placemark><MultiGeometry>
+			<Polygon>
+				<outerBoundaryIs><LinearRing><coordinates>-77.4571,37.5272 -77.4133,37.5272 -77.4133,37.5530 -77.4571,37.5530</coordinates></LinearRing></outerBoundaryIs>
+				<innerBoundaryIs><LinearRing><coordinates>-77.44,37.53 -77.44,37.54 -77.43,37.54 -77.43,37.53 -77.44,37.53</coordinates></LinearRing></innerBoundaryIs>
+			</Polygon>
+			<Polygon><outerBoundaryIs><LinearRing><coordinates>10,10 10.1,10 10.1,10.1 10,10.1 10,10</coordinates></LinearRing></outerBoundaryIs></Polygon>
+		</MultiGeometry></Placemark>
+	</Folder></Document></kml>`
+	geom, _, _, _, err := parseInput(strings.NewReader(kmlInput(kml)))
+	assert.Nil(t, err)
+	multi, isMultiPolygon := geom.(orb.MultiPolygon)
+	assert.True(t, isMultiPolygon)
+	assert.Equal(t, 2, len(multi))
+	holes := 0
+	for _, polygon := range multi {
+		holes += len(polygon) - 1
+	}
+	assert.Equal(t, 1, holes)
+}
+
+func TestKMZRegion(t *testing.T) {
+	zipped := zipFiles(map[string][]byte{"files/icon.kml": []byte(`<kml/>`), "doc.kml": []byte(richmondKML)})
+	geom, _, _, _, err := parseInput(strings.NewReader(kmzInput(zipped)))
+	assert.Nil(t, err)
+	assert.Equal(t, richmondRing.Bound(), geom.Bound())
+}
+
+func TestKMLInvalid(t *testing.T) {
+	for input, message := range map[string]string{
+		kmlInput(`<gpx><trk/></gpx>`): "input is not a KML document",
+		kmlInput(`<kml><Placemark><Point><coordinates>-77.45,37.53</coordinates></Point></Placemark></kml>`):                                           "KML has no polygons",
+		kmlInput(`<kml><Polygon><outerBoundaryIs><LinearRing><coordinates>-77.45 37.53</coordinates></LinearRing></outerBoundaryIs></Polygon></kml>`):  `KML coordinates "-77.45" are not lon,lat`,
+		kmlInput(`<kml><Polygon><outerBoundaryIs><LinearRing><coordinates>0,0 200,0 0,1</coordinates></LinearRing></outerBoundaryIs></Polygon></kml>`): "KML coordinates are out of range",
+		kmlInput(`<kml><Polygon>`):                                  "input KML is invalid",
+		kmzInput([]byte("not a zip")):                               "input KMZ is not a zip",
+		kmzInput(zipFiles(map[string][]byte{"a.txt": []byte("a")})): "the KMZ has no .kml file",
+	} {
+		_, _, _, _, err := parseInput(strings.NewReader(input))
+		assert.Equal(t, message, err.Error(), input)
+	}
+}
+
+func TestKMZUpload(t *testing.T) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("Name", "study area")
+	form.WriteField("RegionType", "kmz")
+	file, _ := form.CreateFormFile("RegionData", "area.kmz")
+	file.Write(zipFiles(map[string][]byte{"doc.kml": []byte(richmondKML)}))
+	form.Close()
+
+	r := httptest.NewRequest("POST", "/api/", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	input, err := decodeMultipartInput(r, defaultRegionLimits.Precision)
+	assert.Nil(t, err)
+	geom, _, _, _, err := parseRegion(input, defaultRegionLimits)
+	assert.Nil(t, err)
+	assert.Equal(t, richmondRing.Bound(), geom.Bound())
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+)
+
+var errMemoryLimit = errors.New("the extract used more memory than allowed")
+
+// extractLimits are the resources an osmx extract may use, so a large
+// one can't starve the API or other processes on the same machine.
+type extractLimits struct {
+	nice        int    // added to the niceness of osmx, 0 to inherit
+	ioClass     string // idle, best-effort or "" to inherit
+	cgroup      string // cgroup v2 directory each extract gets a child of
+	memoryBytes int64  // memory.max of each extract's cgroup, 0 for none
+}
+
+// prepare checks the limits, and the cgroup extracts are run in.
+func (l extractLimits) prepare() error {
+	if l.nice < 0 || l.nice > 19 {
+		return errors.New("-extractNice must be from 0 to 19")
+	}
+	if !slices.Contains([]string{"", "idle", "best-effort"}, l.ioClass) {
+		return errors.New("-extractIOClass must be idle or best-effort")
+	}
+	if l.memoryBytes < 0 || (l.memoryBytes > 0 && l.cgroup == "") {
+		return errors.New("-extractMemoryBytes needs -extractCgroup")
+	}
+	if l.cgroup != "" {
+		return prepareCgroup(l.cgroup, l.memoryBytes > 0)
+	}
+	return nil
+}
+
+// command is the command line that runs exe with args under nice and
+// ionice, as configured.
+func (l extractLimits) command(exe string, args []string) (string, []string) {
+	var prefix []string
+	if l.nice > 0 {
+		prefix = append(prefix, "nice", "-n", strconv.Itoa(l.nice))
+	}
+	switch l.ioClass {
+	case "idle":
+		prefix = append(prefix, "ionice", "-c", "3")
+	case "best-effort":
+		// the lowest priority within the class.
+		prefix = append(prefix, "ionice", "-c", "2", "-n", "7")
+	}
+	if len(prefix) == 0 {
+		return exe, args
+	}
+	return prefix[0], append(append(prefix[1:], exe), args...)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// prepareCgroup checks that extracts can be given child cgroups of dir,
+// and enables the memory controller for them if needed.
+func prepareCgroup(dir string, memory bool) error {
+	if _, err := os.Stat(filepath.Join(dir, "cgroup.procs")); err != nil {
+		return fmt.Errorf("-extractCgroup %s is not a cgroup v2 directory: %w", dir, err)
+	}
+	if !memory {
+		return nil
+	}
+	f, err := os.OpenFile(filepath.Join(dir, "cgroup.subtree_control"), os.O_WRONLY, 0)
+	if err == nil {
+		_, err = f.WriteString("+memory")
+		f.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("enabling the memory controller of -extractCgroup %s: %w", dir, err)
+	}
+	return nil
+}
+
+// enterCgroup makes cmd start in a new child cgroup of -extractCgroup.
+// The returned function removes the cgroup once cmd has exited, and
+// returns errMemoryLimit if osmx was killed for running out of memory.
+func (l extractLimits) enterCgroup(cmd *exec.Cmd) (func() error, error) {
+	if l.cgroup == "" {
+		return func() error { return nil }, nil
+	}
+	dir, err := os.MkdirTemp(l.cgroup, "extract-")
+	if err != nil {
+		return nil, err
+	}
+	if l.memoryBytes > 0 {
+		if err := writeCgroupFile(dir, "memory.max", strconv.FormatInt(l.memoryBytes, 10)); err != nil {
+			os.Remove(dir)
+			return nil, err
+		}
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		os.Remove(dir)
+		return nil, err
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(f.Fd())
+	return func() error {
+		f.Close()
+		oomKilled := cgroupEvent(dir, "memory.events", "oom_kill") > 0
+		if err := os.Remove(dir); err != nil {
+			fmt.Println("removing cgroup", dir, err)
+		}
+		if oomKilled {
+			return errMemoryLimit
+		}
+		return nil
+	}, nil
+}
+
+// ownProcessGroup starts cmd in a process group of its own, and makes
+// cancelling it kill the whole group, so the processes osmx or a wrapper
+// script started don't outlive it.
+func ownProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
+
+func writeCgroupFile(dir string, name string, value string) error {
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(value)
+	return err
+}
+
+// cgroupEvent is a counter of a cgroup's events file, or 0 if it can't
+// be read.
+func cgroupEvent(dir string, file string, key string) int64 {
+	f, err := os.Open(filepath.Join(dir, file))
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name, value, _ := strings.Cut(scanner.Text(), " ")
+		if name == key {
+			n, _ := strconv.ParseInt(value, 10, 64)
+			return n
+		}
+	}
+	return 0
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+)
+
+func prepareCgroup(dir string, memory bool) error {
+	return errors.New("-extractCgroup is only available on Linux")
+}
+
+// ownProcessGroup leaves cancelling cmd to kill only its process.
+func ownProcessGroup(cmd *exec.Cmd) {}
+
+func (l extractLimits) enterCgroup(cmd *exec.Cmd) (func() error, error) {
+	return func() error { return nil }, nil
+}
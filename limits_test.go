@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtractLimitsCommand(t *testing.T) {
+	name, args := extractLimits{}.command("osmx", []string{"extract"})
+	assert.Equal(t, "osmx", name)
+	assert.Equal(t, []string{"extract"}, args)
+
+	name, args = extractLimits{nice: 10, ioClass: "idle"}.command("osmx", []string{"extract"})
+	assert.Equal(t, "nice", name)
+	assert.Equal(t, []string{"-n", "10", "ionice", "-c", "3", "osmx", "extract"}, args)
+
+	for _, bad := range []extractLimits{{nice: 20}, {nice: -1}, {ioClass: "realtime"}, {memoryBytes: 1 << 30}, {cgroup: t.TempDir()}} {
+		assert.NotNil(t, bad.prepare(), bad)
+	}
+}
+
+func TestExtractNice(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "nice")
+	x := &osmxExtractor{exec: fakeOsmx(t, `nice > `+out), limits: extractLimits{nice: 5}}
+	err := x.Extract(context.Background(), "planet.osmx", "region.json", filepath.Join(t.TempDir(), "out.osm.pbf"), nil, nil)
+	assert.Nil(t, err)
+	niceness, _ := os.ReadFile(out)
+	assert.Equal(t, "5", strings.TrimSpace(string(niceness)))
+}
+
+func TestCancelKillsProcessGroup(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("process groups are only used on Linux")
+	}
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	// a wrapper script whose child would outlive it if only it was killed.
+	x := &osmxExtractor{exec: fakeOsmx(t, `sleep 30 & echo $! > `+pidFile+`; wait`)}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- x.Extract(ctx, "planet.osmx", "region.json", filepath.Join(t.TempDir(), "out.osm.pbf"), nil, func(Progress) bool { return true })
+	}()
+	waitFor(t, func() bool {
+		b, err := os.ReadFile(pidFile)
+		return err == nil && strings.HasSuffix(string(b), "\n")
+	})
+	cancel()
+	select {
+	case err := <-done:
+		assert.NotNil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the extract outlived its context")
+	}
+
+	pid, _ := os.ReadFile(pidFile)
+	waitFor(t, func() bool {
+		stat, err := os.ReadFile("/proc/" + strings.TrimSpace(string(pid)) + "/stat")
+		// gone, or a zombie left for init to reap.
+		return err != nil || strings.Contains(string(stat), ") Z ")
+	})
+}
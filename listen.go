@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the first file descriptor passed by systemd socket activation.
+const listenFdsStart = 3
+
+// listen opens the listener for -bind. A socket passed by systemd
+// (LISTEN_FDS) takes precedence; "unix:/path" binds a unix domain
+// socket, replacing a stale one, and returns its path so it can be
+// removed on shutdown; anything else is a TCP address.
+func listen(bind string, socketMode os.FileMode) (net.Listener, string, error) {
+	if fds := os.Getenv("LISTEN_FDS"); fds != "" {
+		if pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID")); pid != 0 && pid != os.Getpid() {
+			return nil, "", errors.New("LISTEN_FDS was passed to another process")
+		}
+		if n, err := strconv.Atoi(fds); err != nil || n != 1 {
+			return nil, "", fmt.Errorf("expected exactly one socket from systemd, got LISTEN_FDS=%s", fds)
+		}
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+		l, err := net.FileListener(os.NewFile(listenFdsStart, "LISTEN_FD_3"))
+		return l, "", err
+	}
+
+	path, ok := strings.CutPrefix(bind, "unix:")
+	if !ok {
+		l, err := net.Listen("tcp", bind)
+		return l, "", err
+	}
+
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, "", fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, "", fmt.Errorf("%s is in use by another process", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, "", err
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := os.Chmod(path, socketMode); err != nil {
+		l.Close()
+		return nil, "", err
+	}
+	return l, path, nil
+}
+
+type peerKey struct{}
+
+// connContext records the credentials of processes connecting over a
+// unix domain socket, which have no remote IP address.
+func connContext(ctx context.Context, c net.Conn) context.Context {
+	if uc, ok := c.(*net.UnixConn); ok {
+		return context.WithValue(ctx, peerKey{}, peerCredentials(uc))
+	}
+	return ctx
+}
+
+// remoteAddr identifies the client for logging: its address, or the
+// peer credentials for unix domain socket connections.
+func remoteAddr(r *http.Request) string {
+	if peer, ok := r.Context().Value(peerKey{}).(string); ok {
+		return peer
+	}
+	return r.RemoteAddr
+}
+
+// clientIP is the address of the client without its port, as resolved
+// past any trusted proxies.
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	addr := remoteAddr(r)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Flush passes on the flushes of streamed listings.
+func (s *statusRecorder) Flush() {
+	http.NewResponseController(s.ResponseWriter).Flush()
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// accessLog prints one line per request.
+func accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: 200}
+		next.ServeHTTP(rec, r)
+		fmt.Println(clientIP(r), r.Method, r.URL.Path, rec.status, time.Since(start).Round(time.Millisecond))
+	})
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api.sock")
+	l, socketPath, err := listen("unix:"+path, 0600)
+	assert.Nil(t, err)
+	assert.Equal(t, path, socketPath)
+	info, _ := os.Stat(path)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	_, _, err = listen("unix:"+path, 0600)
+	assert.NotNil(t, err)
+
+	server := &http.Server{ConnContext: connContext, Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, remoteAddr(r))
+	})}
+	go server.Serve(l)
+	defer server.Close()
+
+	client := http.Client{Transport: &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return net.Dial("unix", path)
+	}}}
+	resp, err := client.Get("http://unix/")
+	assert.Nil(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.True(t, strings.HasPrefix(string(body), "unix"))
+}
+
+func TestListenStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api.sock")
+	stale, _ := net.Listen("unix", path)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	l, _, err := listen("unix:"+path, 0660)
+	assert.Nil(t, err)
+	l.Close()
+}
+
+func TestListenNotSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api.sock")
+	os.WriteFile(path, []byte("data"), 0644)
+	_, _, err := listen("unix:"+path, 0660)
+	assert.NotNil(t, err)
+}
+
+func TestAccessLogFlushes(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.results.Add(ResultEntry{Uuid: "a", ChangedAt: "2024-01-01T00:00:00Z"})
+	w := httptest.NewRecorder()
+	accessLog(h).ServeHTTP(w, httptest.NewRequest("GET", "/api/results?format=ndjson", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.True(t, w.Flushed)
+	assert.Equal(t, 1, strings.Count(w.Body.String(), "\n"))
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
 	_ "embed"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -13,8 +16,6 @@ import (
 	"github.com/google/uuid"
 	"github.com/paulmach/orb"
 	"github.com/paulmach/orb/geojson"
-	"github.com/paulmach/orb/maptile"
-	"github.com/paulmach/orb/maptile/tilecover"
 	"github.com/paulmach/orb/planar"
 	"image"
 	"image/png"
@@ -24,11 +25,15 @@ import (
 	"math"
 	"net/http"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -38,19 +43,50 @@ import (
 //go:embed z12_red_green.png
 var imageBytes []byte
 
+// upper bound in seconds on ?waitForQueue, how long a POST may block
+// waiting for space in a full queue.
+const maxWaitForQueue = 60
+
 // global system state.
 type SystemState struct {
 	Status     string
 	QueueSize  int
 	NodesLimit int
 	Timestamp  string
+	Scheduler  string
+	// the nodes limit clients warn at, up to NodesLimit, over which
+	// submissions are refused.
+	SoftNodesLimit int
+	// failures by category in the last 15 minutes.
+	Failures map[string]int
 }
 
 // the content of a POST request
 type Input struct {
-	Name       string
-	RegionType string // geojson, bbox
-	RegionData json.RawMessage
+	Name         string
+	RegionType   string // geojson, bbox, gpx, poly
+	RegionData   json.RawMessage
+	BufferMeters float64 // corridor width for gpx and LineStrings
+	Encrypt      bool    // store the result encrypted at rest
+	Repair       bool    // repair an invalid polygon instead of rejecting it
+	Schedule     string  // refresh the extract daily, weekly or monthly
+	FromDryRun   string  // uuid of a finished dry run whose region is reused
+
+	// "now" or the replication timestamp the extract must reflect.
+	SnapshotTimestamp string
+
+	// osmx flags from -extraArgsAllowlist and their values.
+	ExtraArgs map[string]string
+
+	// a GeoJSON Polygon or MultiPolygon cut out of the region.
+	Exclude json.RawMessage
+
+	// the layer of a geopackage region, and optionally one feature of it.
+	Layer     string
+	FeatureId *int64
+
+	// receives a signed POST of the record once the task finishes.
+	CallbackUrl string
 }
 
 // A sanitized serialization of the submitted job
@@ -61,6 +97,79 @@ type Task struct {
 	SanitizedName       string
 	SanitizedRegionType string
 	SanitizedRegionData json.RawMessage
+
+	// the RegionType as submitted, such as gpx, for display; the region
+	// is handed to osmx by its SanitizedRegionType.
+	RegionType string `json:",omitempty"`
+
+	// the API key that submitted the task and the node estimate held
+	// against its quota. Not part of the public region.json.
+	KeyName        string `json:"-"`
+	EstimatedNodes int64  `json:"-"`
+
+	// where to POST the record once finished, which may hold a token.
+	CallbackUrl string `json:"-"`
+
+	// the tier the task is queued in.
+	Priority string `json:"-"`
+
+	// named features, extracted again one by one for the split download.
+	SubRegions []SubRegion `json:",omitempty"`
+
+	// store the result encrypted with the current key.
+	Encrypt bool `json:",omitempty"`
+
+	// how the extract was run, filled in when it starts.
+	Provenance *Provenance `json:",omitempty"`
+
+	// the token that let the task through the nodes limit.
+	LimitOverride *LimitOverride `json:",omitempty"`
+
+	// stop osmx once it has reported the totals, without a result.
+	DryRun bool `json:",omitempty"`
+
+	// fail rather than extract from data newer than this.
+	SnapshotTimestamp string `json:",omitempty"`
+
+	// allowlisted osmx flags appended to the extract.
+	ExtraArgs map[string]string `json:",omitempty"`
+
+	// warnings from validating the submission, carried into the
+	// completion record.
+	Warnings []Warning `json:",omitempty"`
+
+	// when the task was queued, for the queue wait histogram.
+	SubmittedAt time.Time `json:"-"`
+}
+
+// The response to an accepted task, echoing the region exactly as it
+// is stored in region.json.
+type Created struct {
+	Uuid                string
+	SanitizedRegionType string          `json:",omitempty"`
+	SanitizedRegionData json.RawMessage `json:",omitempty"`
+	Bbox                *[4]float64     `json:",omitempty"` // min lon, min lat, max lon, max lat
+
+	// the expected size of the result, to warn about huge downloads.
+	EstimatedSizeBytes int64 `json:",omitempty"`
+
+	// problems with the submission that didn't prevent it.
+	Warnings []Warning `json:",omitempty"`
+
+	// the pinned snapshot, to pass on to later tasks of a batch.
+	SnapshotTimestamp string `json:",omitempty"`
+
+	// the Uuid is of an identical job submitted earlier.
+	Deduplicated bool `json:",omitempty"`
+
+	// deletes the result with DELETE /api/{uuid}/result. Only handed
+	// out once, and not for deduplicated jobs.
+	DeleteToken string `json:",omitempty"`
+
+	// the schedule of a recurring extract, and the stable URL of the
+	// result of its latest run.
+	ScheduleId string `json:",omitempty"`
+	LatestUrl  string `json:",omitempty"`
 }
 
 // Used to display progress. When complete, is persisted
@@ -78,20 +187,224 @@ type Progress struct {
 	SizeBytes int64
 	Elapsed   float64
 	Complete  bool
+
+	// how far the job is, from 0 to 100, weighting its stages by their
+	// usual durations. It never goes down while the job runs.
+	PercentComplete float64
+
+	// 1-based place in the queue while waiting for a worker, and the
+	// tier it was queued in: high, normal or low.
+	QueuePosition int    `json:",omitempty"`
+	Priority      string `json:",omitempty"`
+
+	// the running stage, extracting, splitting or finalizing, and once complete
+	// the seconds spent in each.
+	Stage          string             `json:",omitempty"`
+	StageDurations map[string]float64 `json:",omitempty"`
+
+	// terminal failure, persisted in place of a completion record, with
+	// its category: extract, timeout or cancelled.
+	Failed          bool   `json:",omitempty"`
+	FailureCategory string `json:",omitempty"`
+	Error           string `json:",omitempty"`
+
+	// RFC3339 times the task was queued and the extract ran, and the
+	// replication timestamp of the data file when it started.
+	SubmittedAt   string `json:",omitempty"`
+	StartedAt     string `json:",omitempty"`
+	FinishedAt    string `json:",omitempty"`
+	DataTimestamp string `json:",omitempty"`
+
+	// the snapshot the task was pinned to.
+	SnapshotTimestamp string `json:",omitempty"`
+
+	// hex SHA-256 of the published pbf, and its hex MD5 with
+	// -md5Checksums.
+	SHA256 string `json:",omitempty"`
+	MD5    string `json:",omitempty"`
+
+	// the extent of the region, min lon, min lat, max lon, max lat,
+	// and the elements in the pbf.
+	Bbox     *[4]float64    `json:",omitempty"`
+	Elements *ElementCounts `json:",omitempty"`
+
+	// where the pbf uploaded to -objectStoreUrl is served from.
+	ObjectUrl string `json:",omitempty"`
+
+	// the path of the unencrypted result in filesDir, shared with
+	// other jobs that produced the same bytes.
+	Blob string `json:",omitempty"`
+
+	Provenance *Provenance `json:",omitempty"`
+
+	// set when the result is stored encrypted; SizeBytes and SHA256
+	// are of the plaintext.
+	Encryption *Encryption `json:",omitempty"`
+
+	// the named features of a FeatureCollection region.
+	SubRegions []SubRegion `json:",omitempty"`
+
+	// times the result was downloaded, and when it last was.
+	Downloads        int64  `json:",omitempty"`
+	LastDownloadedAt string `json:",omitempty"`
+
+	// the result was deleted before it expired, with the reason in Error.
+	Evicted bool `json:",omitempty"`
+
+	// the uuid of the job that retried this failed or evicted one.
+	RetriedAs string `json:",omitempty"`
+
+	// the record was synthesized from a result pbf whose original
+	// record was lost; only SizeBytes and the times, from the file's
+	// mtime, are known.
+	Reconstructed bool `json:",omitempty"`
+
+	// the job was a dry run: the totals are those osmx planned, and
+	// there is no pbf to download.
+	DryRun bool `json:",omitempty"`
+
+	// the job's progress hasn't advanced in -stallMinutes.
+	Stalled bool `json:",omitempty"`
+
+	// problems that didn't stop the job from completing.
+	Warnings []Warning `json:",omitempty"`
+
+	// the uuid was reserved and the region is yet to be uploaded
+	// before UploadExpiresAt.
+	AwaitingUpload  bool   `json:",omitempty"`
+	UploadExpiresAt string `json:",omitempty"`
 }
 
 type Server struct {
 	progress      map[string]Progress
+	progressJSON  map[string][]byte
 	progressMutex sync.RWMutex
-	queue         chan Task
+	pollers       sync.Map // uuid to *atomic.Int64
+	queue         *Scheduler
+	scheduler     string
+	workerCount   int // 0 for one per CPU
+	// jobs estimated above largeJobNodes only run on the first
+	// largeWorkers workers; 0 to run them on any.
+	largeJobNodes int
+	largeWorkers  int
+	pool          *workerPool
 	filesDir      string
 	tmpDir        string
 	exec          string
 	data          string
 	image         image.Image
 	nodesLimit    int
+	regionLimits  RegionLimits
+	extractor     Extractor
+	extraArgs     ExtraArgsAllowlist
+	osmxVersion   string
+	apiKeys       map[string]*APIKey
+	running       map[string]*runningJob
+	runningMutex  sync.Mutex
+	workers       sync.WaitGroup
+	quotas        *QuotaStore
+	results       *ResultIndex
+	blobs         *BlobStore
+	metrics       *Metrics
+	popularity    *PopularityStore
+	jobs          *JobStore
+
+	// reported to clients to warn at, 0 for nodesLimit.
+	softNodesLimit int
+
+	encryptionKeys *EncryptionKeys
+	encryptResults bool
+
+	// nil unless -limitOverrideSecretFile is set, when X-Limit-Override
+	// tokens let submissions over the nodes limit through.
+	limitOverrides *LimitOverrides
+
+	// completion records carry an MD5 as well as a SHA-256.
+	md5Checksums bool
+
+	// nil unless -webhookSecretFile is set.
+	webhooks *Webhooks
+
+	// nil when -osmApiUrl is empty, refusing relation regions.
+	relations *Relations
+
+	// nil unless -nominatimUrl is set, when place regions are accepted.
+	places *Places
+
+	// nil unless -regionUrlAllowlist is set, when url regions are
+	// accepted.
+	regionUrls *RegionUrls
+
+	// nil unless -boundariesFile is set, when iso regions are accepted.
+	boundaries *Boundaries
+
+	// nil unless -objectStoreUrl is set.
+	objectStore *ObjectStore
+
+	// nil unless -downloadSecretFile is set, when downloads need a
+	// signed link.
+	downloadLinks *DownloadLinks
+
+	// jobs that failed, for operators to inspect and queue again.
+	deadLetters *DeadLetterStore
+
+	// nil unless -osmClientId is set. Logged in users may submit up to
+	// userNodesLimit nodes if it is above the nodes limit.
+	osmAuth        *OSMAuth
+	userJobs       *UserJobStore
+	userNodesLimit int
+
+	// recurring extracts, submitted again as they fall due.
+	schedules *ScheduleStore
+
+	// tasks estimated at up to this many nodes are queued as high
+	// priority, 0 for none.
+	smallJobNodes int
+
+	// identical submissions get the job of one queued, running or
+	// completed within dedupeWindow, 0 to always run them, or a result
+	// whose data is at most cacheStaleness behind the data file.
+	dedupe         dedupeIndex
+	dedupeWindow   time.Duration
+	cacheStaleness time.Duration
+
+	// submissions by client IP, unlimited unless -submitsPerMinute is set.
+	submitLimiter rateLimiter
+	// set by an operator to refuse submissions.
+	intakePaused atomic.Bool
+
+	// budget for everything in filesDir, 0 for none.
+	maxFilesBytes int64
+	bytesPerNode  float64
+	// space in filesDir that results are never expected to fill.
+	storageMargin int64
+
+	// finished jobs are deleted resultTTL after they finish, 0 for never.
+	resultTTL time.Duration
+	// bytes freed by expiring and evicting results since startup.
+	reclaimedBytes atomic.Int64
+
+	// running jobs without progress for stallAfter are flagged, and
+	// killed after killStalledAfter if it is not 0.
+	stallAfter       time.Duration
+	killStalledAfter time.Duration
+	recordsMutex     sync.Mutex
+
+	failures       FailureCounts
+	maxFailureRate float64
+	downloads      downloadTracker
+	warnings       WarningCounts
+	reservations   reservationStore
 
 	lastUpdated LastUpdated
+
+	// guards the settings a reload can change: nodesLimit, regionLimits,
+	// apiKeys, requireAPIKeys, maxFilesBytes, resultTTL, storageMargin,
+	// bytesPerNode, the stall thresholds, maxFailureRate and corsOrigins.
+	settingsMutex  sync.RWMutex
+	corsOrigins    []string
+	requireAPIKeys bool
+	reloader       *Reloader
 }
 
 type LastUpdated struct {
@@ -100,25 +413,73 @@ type LastUpdated struct {
 	checkedAt time.Time
 }
 
-func (h *Server) runTask(id int, task Task) error {
+// ask osmx for the replication timestamp of the data file.
+func (h *Server) queryTimestamp(ctx context.Context) (time.Time, error) {
+	return h.extractor.Timestamp(ctx, h.data)
+}
+
+// dataTimestamp is the replication timestamp of the data file, asked of
+// osmx at most every 10 seconds. It is zero until osmx first answers.
+func (h *Server) dataTimestamp() time.Time {
+	h.lastUpdated.mutex.Lock()
+	defer h.lastUpdated.mutex.Unlock()
+	if time.Since(h.lastUpdated.checkedAt).Seconds() > 10 {
+		// shared by every request, so not tied to any of them.
+		timestamp, err := h.queryTimestamp(context.Background())
+		if err == nil {
+			h.lastUpdated.timestamp = timestamp
+			h.lastUpdated.checkedAt = time.Now()
+		}
+	}
+	return h.lastUpdated.timestamp
+}
+
+func (h *Server) runTask(ctx context.Context, id int, task Task) error {
 	uuid := task.Uuid
 	fmt.Println("worker", id, "started job", uuid)
 	start := time.Now()
 
-	pbfPath := filepath.Join(h.tmpDir, uuid+".osm.pbf")
-
-	regionPath := filepath.Join(h.tmpDir, uuid+"."+task.SanitizedRegionType)
+	// never overwrite a result, such as one restored from a backup
+	// alongside the queue.
+	if h.hasResult(uuid) {
+		h.takeProgress(uuid)
+		return fmt.Errorf("job %s: %w", uuid, errUuidConflict)
+	}
 
-	out, err := os.Create(regionPath)
+	// the extract reflects the data file as of the start of the task,
+	// not when it was submitted.
+	var dataTimestamp string
+	if timestamp, err := h.queryTimestamp(ctx); err == nil {
+		dataTimestamp = timestamp.Format(time.RFC3339)
+	}
+	if task.SnapshotTimestamp != "" && dataTimestamp != task.SnapshotTimestamp {
+		h.setProgress(uuid, Progress{DataTimestamp: dataTimestamp, SnapshotTimestamp: task.SnapshotTimestamp})
+		if err := h.writeFailure(uuid, failureSnapshot, errSnapshotExpired.Error()); err != nil {
+			return err
+		}
+		return fmt.Errorf("job %s: %w", uuid, errSnapshotExpired)
+	}
+	// nothing is left in scratch if the job fails or is killed.
+	scratch := h.jobScratchDir(id, uuid)
+	if err := os.MkdirAll(scratch, 0755); err != nil {
+		return err
+	}
+	defer os.RemoveAll(scratch)
+	pbfPath := filepath.Join(scratch, uuid+".osm.pbf")
+	regionPath, err := writeRegionFile(scratch, task)
 	if err != nil {
 		return err
 	}
-	if task.SanitizedRegionType == "bbox" {
-		out.Write(task.SanitizedRegionData[1 : len(task.SanitizedRegionData)-1])
-	} else {
-		out.Write(task.SanitizedRegionData)
+
+	extraArgs := h.extraArgs.Args(task.ExtraArgs)
+	task.Provenance = h.provenance(extractArgs(h.data, regionPath, pbfPath, extraArgs, true))
+	task.Provenance.LimitOverride = task.LimitOverride
+
+	submitted := ""
+	if !task.SubmittedAt.IsZero() {
+		submitted = task.SubmittedAt.UTC().Format(time.RFC3339)
 	}
-	out.Close()
+	h.setProgress(uuid, Progress{Priority: task.Priority, SubmittedAt: submitted, StartedAt: start.UTC().Format(time.RFC3339), DataTimestamp: dataTimestamp, SnapshotTimestamp: task.SnapshotTimestamp, Provenance: task.Provenance, Warnings: task.Warnings})
 
 	taskJson, err := json.Marshal(task)
 	if err != nil {
@@ -129,29 +490,55 @@ func (h *Server) runTask(id int, task Task) error {
 		return err
 	}
 
-	args := []string{"extract", h.data, pbfPath, "--jsonOutput", "--region", regionPath}
-	cmd := exec.Command(h.exec, args...)
-	stdout, err := cmd.StdoutPipe()
-
-	err = cmd.Start()
+	err = h.extractor.Extract(ctx, h.data, regionPath, pbfPath, extraArgs, func(progress Progress) bool {
+		progress.Priority = task.Priority
+		progress.SubmittedAt = submitted
+		progress.StartedAt = start.UTC().Format(time.RFC3339)
+		progress.DataTimestamp = dataTimestamp
+		progress.SnapshotTimestamp = task.SnapshotTimestamp
+		progress.Provenance = task.Provenance
+		progress.Warnings = task.Warnings
+		progress.Stage = "extracting"
+		progress.Stalled = h.heartbeat(uuid, progress)
+		progress.PercentComplete = h.percentComplete(uuid, progress)
+		h.setProgress(uuid, progress)
+		// osmx has no planning mode, so a dry run is stopped once the
+		// totals are known.
+		return !(task.DryRun && dryRunPlanned(progress))
+	})
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
 	if err != nil {
 		return err
 	}
-	reader := bufio.NewReader(stdout)
-	line, err := reader.ReadString('\n')
-	for err == nil {
-		var progress Progress
-		if err := json.NewDecoder(strings.NewReader(line)).Decode(&progress); err != nil {
+	if task.DryRun {
+		return h.finishDryRun(id, task, start)
+	}
+
+	extracted := time.Now()
+	var splitPath string
+	if len(task.SubRegions) > 0 {
+		h.setStage(uuid, "splitting")
+		splitPath = filepath.Join(scratch, uuid+"_split.zip")
+		defer os.Remove(splitPath)
+		if err := h.extractSubRegions(ctx, scratch, uuid, task.SubRegions, extraArgs, splitPath); err != nil {
 			return err
 		}
-		h.progressMutex.Lock()
-		h.progress[uuid] = progress
-		h.progressMutex.Unlock()
-		line, err = reader.ReadString('\n')
 	}
-	err = cmd.Wait()
+	split := time.Now()
+	h.setStage(uuid, "finalizing")
+
+	// osmx can crash after its last progress line, so check the
+	// result is a complete pbf before publishing it.
+	if _, err := verifyPBF(pbfPath); err != nil {
+		os.Remove(regionPath)
+		return h.quarantine(uuid, pbfPath, err)
+	}
+	elements, err := countElements(pbfPath)
 	if err != nil {
-		return err
+		fmt.Println("counting elements:", err)
+		sentry.CaptureException(err)
 	}
 
 	f, err := os.Open(pbfPath)
@@ -163,68 +550,272 @@ func (h *Server) runTask(id int, task Task) error {
 	if err != nil {
 		return err
 	}
+	hash, md5Hash := sha256.New(), md5.New()
+	hashes := io.Writer(hash)
+	if h.md5Checksums {
+		hashes = io.MultiWriter(hash, md5Hash)
+	}
+	if _, err := io.Copy(hashes, f); err != nil {
+		return err
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+
+	publishPath, publishSize := pbfPath, stat.Size()
+	resultPath := filepath.Join(h.filesDir, uuid+".osm.pbf")
+	var encryption *Encryption
+	if task.Encrypt {
+		// only the ciphertext is moved into filesDir.
+		encryptedPath := pbfPath + ".enc"
+		defer os.Remove(encryptedPath)
+		encryption, err = h.encryptionKeys.encryptFile(pbfPath, encryptedPath, uuid)
+		if err != nil {
+			return err
+		}
+		encrypted, err := os.Stat(encryptedPath)
+		if err != nil {
+			return err
+		}
+		publishPath, publishSize = encryptedPath, encrypted.Size()
+		resultPath += ".enc"
+	}
 
-	if err := os.Rename(pbfPath, filepath.Join(h.filesDir, uuid+".osm.pbf")); err != nil {
+	var blob string
+	if task.Encrypt {
+		err = publishFile(publishPath, resultPath)
+	} else {
+		// the same region extracted from the same data shares one blob.
+		var key string
+		key, err = h.blobs.Publish(resultKey(task, dataTimestamp, sum), sum, publishPath, uuid)
+		blob = blobPath(key)
+	}
+	if err != nil {
 		return err
 	}
+	if published, err := os.Stat(resultPath); err != nil || published.Size() != publishSize {
+		return h.quarantine(uuid, resultPath, fmt.Errorf("size changed from %d bytes when published", publishSize))
+	}
+
+	if splitPath != "" {
+		if err := publishFile(splitPath, filepath.Join(h.filesDir, uuid+"_split.zip")); err != nil {
+			return err
+		}
+	}
 
 	if err := os.Remove(regionPath); err != nil {
 		return err
 	}
 
-	var lastProgress Progress
-	h.progressMutex.Lock()
-	lastProgress = h.progress[uuid]
-	delete(h.progress, uuid)
-	h.progressMutex.Unlock()
+	lastProgress := h.currentProgress(uuid)
 
 	elapsed := time.Since(start).Seconds()
 	lastProgress.Elapsed = elapsed
+	lastProgress.StartedAt = start.UTC().Format(time.RFC3339)
+	lastProgress.FinishedAt = time.Now().UTC().Format(time.RFC3339)
+	lastProgress.DataTimestamp = dataTimestamp
+	lastProgress.SnapshotTimestamp = task.SnapshotTimestamp
 	lastProgress.Complete = true
+	lastProgress.PercentComplete = 100
 	lastProgress.SizeBytes = stat.Size()
+	lastProgress.SHA256 = sum
+	if h.md5Checksums {
+		lastProgress.MD5 = hex.EncodeToString(md5Hash.Sum(nil))
+	}
+	lastProgress.Blob = blob
+	lastProgress.Elements = elements
+	if bound, ok := regionBound(task); ok {
+		lastProgress.Bbox = &[4]float64{bound.Min[0], bound.Min[1], bound.Max[0], bound.Max[1]}
+	}
+	lastProgress.Encryption = encryption
+	lastProgress.Stage = ""
+	lastProgress.StageDurations = map[string]float64{
+		"extracting": extracted.Sub(start).Seconds(),
+		"finalizing": time.Since(split).Seconds(),
+	}
+	if splitPath != "" {
+		lastProgress.StageDurations["splitting"] = split.Sub(extracted).Seconds()
+	}
+	for _, sub := range task.SubRegions {
+		lastProgress.SubRegions = append(lastProgress.SubRegions, SubRegion{Name: sub.Name, Bbox: sub.Bbox})
+	}
+	lastProgress.Warnings = slices.Concat(task.Warnings, completionWarnings(task, lastProgress))
+	// encrypted results are only served by the API.
+	if h.objectStore != nil && encryption == nil {
+		objectUrl, warning := h.uploadResult(ctx, uuid, filepath.Join(h.filesDir, blob), sum)
+		if warning != nil {
+			lastProgress.Warnings = append(lastProgress.Warnings, *warning)
+		}
+		lastProgress.ObjectUrl = objectUrl
+	}
 	completion, err := json.Marshal(lastProgress)
 	if err != nil {
 		return err
 	}
-	if err := ioutil.WriteFile(filepath.Join(h.filesDir, uuid), completion, 0644); err != nil {
+	if lastProgress.ObjectUrl != "" {
+		h.uploadRecord(ctx, uuid, completion)
+	}
+	// the record is in place before the in-memory progress goes away,
+	// so a poll in between never finds neither.
+	if err := writeFileAtomic(filepath.Join(h.filesDir, uuid), completion); err != nil {
 		return err
 	}
+	h.takeProgress(uuid)
+	h.results.Add(newResultEntry(task, lastProgress))
+	h.noteFinished(uuid)
+	h.warnings.Add(lastProgress.Warnings)
+	h.metrics.ObserveJob(start.Sub(task.SubmittedAt), time.Since(start), stat.Size())
+	h.writeStats()
+	if task.KeyName != "" {
+		if err := h.quotas.Record(task.KeyName, task.EstimatedNodes, lastProgress.NodesTotal, stat.Size()); err != nil {
+			fmt.Println(err)
+			sentry.CaptureException(err)
+		}
+		if err := h.quotas.Store(task.KeyName, uuid, stat.Size()); err != nil {
+			fmt.Println(err)
+			sentry.CaptureException(err)
+		}
+		h.expireOverStorage(task.KeyName)
+	}
 	fmt.Println("worker", id, "finished job", uuid, "in", elapsed)
 	return nil
 }
 
-func (h *Server) worker(id int, queue chan Task) {
-	for task := range queue {
-		h.progressMutex.Lock()
-		h.progress[task.Uuid] = Progress{}
-		h.progressMutex.Unlock()
+// quarantine keeps a corrupt result for debugging and records the job as failed.
+func (h *Server) quarantine(uuid string, pbfPath string, cause error) error {
+	quarantineDir := filepath.Join(h.filesDir, "quarantine")
+	if err := os.MkdirAll(quarantineDir, 0755); err != nil {
+		return err
+	}
+	if err := publishFile(pbfPath, filepath.Join(quarantineDir, filepath.Base(pbfPath))); err != nil {
+		return err
+	}
+	if err := h.writeFailure(uuid, failureExtract, "corrupt output"); err != nil {
+		return err
+	}
+	return fmt.Errorf("job %s: corrupt output: %w", uuid, cause)
+}
 
-		err := h.runTask(id, task)
-		if err != nil {
+// writeFailure persists a terminal failure record for the job in place
+// of its completion record, with the category of failure and a reason.
+func (h *Server) writeFailure(uuid string, category string, reason string) error {
+	progress := h.currentProgress(uuid)
+	progress.Stage = ""
+	progress.Failed = true
+	progress.FailureCategory = category
+	progress.Error = reason
+	progress.FinishedAt = time.Now().UTC().Format(time.RFC3339)
+	record, err := json.Marshal(progress)
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(filepath.Join(h.filesDir, uuid), record)
+	h.takeProgress(uuid)
+	h.noteFinished(uuid)
+	return err
+}
+
+func (h *Server) worker(id int, queue *Scheduler, pool *workerPool) {
+	defer h.workers.Done()
+	for {
+		retired := false
+		task, ok := queue.PopUnless(func() bool {
+			retired = pool.leave(id)
+			return retired
+		}, h.maxWorkerNodes(id))
+		if !ok {
+			if !retired {
+				pool.release(id)
+			}
+			return
+		}
+		h.setProgress(task.Uuid, Progress{Priority: task.Priority})
+		h.persistJob(task, jobRunning)
+
+		ctx, cancel := context.WithCancelCause(context.Background())
+		h.runningMutex.Lock()
+		h.running[task.Uuid] = &runningJob{task: task, cancel: cancel, lastProgressAt: time.Now()}
+		h.runningMutex.Unlock()
+
+		err := h.runTask(ctx, id, task)
+
+		h.runningMutex.Lock()
+		delete(h.running, task.Uuid)
+		h.runningMutex.Unlock()
+		cancel(nil)
+
+		var stop *jobStopped
+		if errors.As(err, &stop) {
+			fmt.Println("worker", id, "stopped job", task.Uuid, "-", stop)
+			if stop.shutdown {
+				// still persisted as running, so it starts over first.
+				continue
+			}
+			if stop.requeue {
+				h.setProgress(task.Uuid, Progress{Priority: task.Priority})
+				h.persistJob(task, jobQueued)
+				h.queue.PushFront(task, task.EstimatedNodes)
+				continue
+			}
+			h.forgetJob(task.Uuid)
+			h.failures.Fail(failureCancelled, time.Now())
+			if err := h.writeFailure(task.Uuid, failureCancelled, stop.reason); err != nil {
+				fmt.Println(err)
+			}
+			if task.KeyName != "" {
+				h.quotas.Release(task.KeyName, task.EstimatedNodes)
+			}
+			h.notifyCallback(task)
+			continue
+		}
+
+		h.forgetJob(task.Uuid)
+		if err == nil {
+			h.failures.Succeed(time.Now())
+		} else {
+			category := failureCategory(err)
+			h.failures.Fail(category, time.Now())
+			// failures runTask records itself, such as a corrupt result,
+			// have already replaced the progress.
+			if h.hasProgress(task.Uuid) {
+				if err := h.writeFailure(task.Uuid, category, failureReason(err)); err != nil {
+					fmt.Println(err)
+				}
+			}
+			if task.KeyName != "" {
+				h.quotas.Release(task.KeyName, task.EstimatedNodes)
+			}
+			h.recordDeadLetter(task, category, err)
 			fmt.Println(err)
 			sentry.CaptureException(err)
 			sentry.Flush(time.Second * 5)
 		}
+		h.notifyCallback(task)
 	}
 }
 
 func (h *Server) StartWorkers() {
-	h.queue = make(chan Task, 512)
+	h.queue = NewScheduler(h.scheduler, 512)
 	h.progress = make(map[string]Progress)
+	h.progressJSON = make(map[string][]byte)
+	h.running = make(map[string]*runningJob)
+
+	h.pool = newWorkerPool()
 
-	for i := 0; i < runtime.NumCPU(); i++ {
-		go h.worker(i, h.queue)
+	workers := h.workerCount
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if err := h.prepareScratch(workers); err != nil {
+		fmt.Println(err)
+		sentry.CaptureException(err)
+	}
+	if err := h.SetWorkers(workers); err != nil {
+		fmt.Println(err)
+		sentry.CaptureException(err)
 	}
 }
 
 func GetSum(image image.Image, geom orb.Geometry) int {
-	var covering map[maptile.Tile]bool
-	for z := 0; z <= 14; z++ {
-		covering, _ = tilecover.Geometry(geom, maptile.Zoom(z))
-		if len(covering) > 256 {
-			break
-		}
-	}
+	covering, _ := coverRegion(geom)
 
 	sum := 0.0
 	for t := range covering {
@@ -259,117 +850,297 @@ func GetPixel(image image.Image, z int, x int, y int) float64 {
 }
 
 func parseInput(body io.Reader) (orb.Geometry, string, string, json.RawMessage, error) {
-	decoder := json.NewDecoder(body)
-
-	var input Input
-	err := decoder.Decode(&input)
+	input, err := decodeInput(body, defaultRegionLimits.Precision)
 	if err != nil {
-		return nil, "", "", nil, errors.New("input GeoJSON is invalid")
+		return nil, "", "", nil, err
 	}
+	return parseRegion(input, defaultRegionLimits)
+}
 
-	var geom orb.Geometry
-	var sanitizedData json.RawMessage
+// the multipart form variant of a POST request, used to upload
+// region files such as GPX tracks instead of inlining them.
+func decodeMultipartInput(r *http.Request, precision int) (Input, error) {
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		return Input{}, bodyError(err, "input form is invalid")
+	}
+	input := Input{Name: r.FormValue("Name"), RegionType: r.FormValue("RegionType"), Encrypt: r.FormValue("Encrypt") == "true", Repair: r.FormValue("Repair") == "true", Schedule: r.FormValue("Schedule")}
+	if s := r.FormValue("BufferMeters"); s != "" {
+		if _, err := fmt.Sscan(s, &input.BufferMeters); err != nil {
+			return input, errors.New("BufferMeters is invalid")
+		}
+	}
+	input.Layer = r.FormValue("Layer")
+	if s := r.FormValue("FeatureId"); s != "" {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return input, errors.New("FeatureId is invalid")
+		}
+		input.FeatureId = &id
+	}
 
-	if input.RegionType == "geojson" {
-		geojsonGeom, err := geojson.UnmarshalGeometry(input.RegionData)
+	var regionData io.Reader = strings.NewReader(r.FormValue("RegionData"))
+	if file, _, err := r.FormFile("RegionData"); err == nil {
+		defer file.Close()
+		regionData = file
+	}
+	// documents that aren't JSON are passed on as a JSON string, and
+	// binary ones such as zips as base64. JSON, such as a GeoJSON file
+	// of tens of megabytes, is streamed through compactRegionData.
+	switch input.RegionType {
+	case "gpx", "poly", "place", "iso", "kml":
+		b, err := io.ReadAll(regionData)
 		if err != nil {
-			return nil, "", "", nil, errors.New("input GeoJSON is invalid")
+			return input, err
 		}
-		geom = geojsonGeom.Geometry()
-		switch v := geom.(type) {
-		case orb.Polygon:
-			if len(v) == 0 {
-				return nil, "", "", nil, errors.New("geom does not have enough rings")
-			}
-			for _, ring := range v {
-				if len(ring) < 4 {
-					return nil, "", "", nil, errors.New("ring does not have enough coordinates")
-				}
-			}
-		case orb.MultiPolygon:
-			if len(v) == 0 {
-				return nil, "", "", nil, errors.New("geom does not have enough rings")
-			}
-			for _, polygon := range v {
-				if len(polygon) == 0 {
-					return nil, "", "", nil, errors.New("geom does not have enough rings")
-				}
-				for _, ring := range polygon {
-					if len(ring) < 4 {
-						return nil, "", "", nil, errors.New("ring does not have enough coordinates")
-					}
-				}
-			}
+		input.RegionData, _ = json.Marshal(string(b))
+	case "shapefile", "geopackage", "kmz":
+		b, err := io.ReadAll(regionData)
+		if err != nil {
+			return input, err
 		}
-		sanitizedData, _ = geojsonGeom.MarshalJSON()
-	} else if input.RegionType == "bbox" {
-		var coords []float64
-		json.Unmarshal(input.RegionData, &coords)
-		if len(coords) < 4 {
-			return nil, "", "", nil, errors.New("input does not have >3 coordinates")
+		input.RegionData, _ = json.Marshal(b)
+	default:
+		decoder := json.NewDecoder(regionData)
+		decoder.UseNumber()
+		var err error
+		if input.RegionData, err = compactRegionData(decoder, precision); err != nil {
+			return input, bodyError(err, "input GeoJSON is invalid")
 		}
-		geom = orb.MultiPoint{orb.Point{coords[1], coords[0]}, orb.Point{coords[3], coords[2]}}.Bound()
-		sanitizedData, _ = json.Marshal(coords[0:4])
-	} else {
+	}
+	return input, nil
+}
+
+// a region parser turns the RegionData of an Input into a geometry,
+// the region type it is stored as, and its sanitized serialization.
+// Parsers that union polygons check them against the vertex and
+// polygon limits first.
+type regionParser func(input Input, limits RegionLimits) (orb.Geometry, string, json.RawMessage, error)
+
+// the accepted RegionTypes.
+var regionParsers = map[string]regionParser{
+	"geojson":    parseGeoJSONRegion,
+	"bbox":       parseBboxRegion,
+	"gpx":        parseGPXRegion,
+	"poly":       parsePolyRegion,
+	"circle":     parseCircleRegion,
+	"tiles":      parseTilesRegion,
+	"shapefile":  parseShapefileRegion,
+	"kml":        parseKMLRegion,
+	"kmz":        parseKMZRegion,
+	"geopackage": parseGeoPackageRegion,
+}
+
+// resolveRegion turns the regions that name something, a relation, a
+// place or an ISO code, into the geojson region of its boundary, and a
+// url into the region of the file it points to. Other regions are
+// returned unchanged.
+func (h *Server) resolveRegion(ctx context.Context, input Input) (Input, error) {
+	switch input.RegionType {
+	case "relation":
+		return h.resolveRelation(ctx, input)
+	case "place":
+		return h.resolvePlace(ctx, input)
+	case "iso":
+		return h.resolveISO(ctx, input)
+	case "url":
+		return h.resolveUrl(ctx, input)
+	}
+	return input, nil
+}
+
+// resolvedInput replaces the region of input by the geojson of geom,
+// named name unless the input has a Name.
+func resolvedInput(input Input, geom orb.Geometry, name string) Input {
+	input.RegionType = "geojson"
+	input.RegionData, _ = geojson.NewGeometry(geom).MarshalJSON()
+	if input.Name == "" {
+		input.Name = name
+	}
+	return input
+}
+
+func parseRegion(input Input, limits RegionLimits) (orb.Geometry, string, string, json.RawMessage, error) {
+	parser, ok := regionParsers[input.RegionType]
+	if !ok {
 		return nil, "", "", nil, errors.New("invalid input RegionType")
 	}
+	geom, sanitizedType, sanitizedData, err := parser(input, limits)
+	if err != nil {
+		return nil, "", "", nil, err
+	}
+	if err := checkRegionComplexity(geom, limits); err != nil {
+		return nil, "", "", nil, err
+	}
+	if input.RegionType == "geojson" {
+		geom, sanitizedData, err = validRegion(geom, sanitizedData, input.Repair)
+		if err != nil {
+			return nil, "", "", nil, err
+		}
+	}
+
+	geom, sanitizedData, err = roundRegion(geom, sanitizedType, sanitizedData, limits.Precision)
+	if err != nil {
+		return nil, "", "", nil, err
+	}
 
 	if planar.Area(geom) == 0.0 {
 		return nil, "", "", nil, errors.New("Input has 0 area")
 	}
 
-	return geom, input.Name, input.RegionType, sanitizedData, nil
+	if err := checkRegionSize(sanitizedData, limits); err != nil {
+		return nil, "", "", nil, err
+	}
+
+	return geom, input.Name, sanitizedType, sanitizedData, nil
+}
+
+func parseGeoJSONRegion(input Input, limits RegionLimits) (orb.Geometry, string, json.RawMessage, error) {
+	var probe struct {
+		Type     string
+		Geometry json.RawMessage
+	}
+	if json.Unmarshal(input.RegionData, &probe) == nil && probe.Type == "FeatureCollection" {
+		return parseFeatureCollectionRegion(input.RegionData, limits)
+	}
+	// a Feature is extracted by its geometry.
+	if probe.Type == "Feature" {
+		input.RegionData = probe.Geometry
+	}
+	geojsonGeom, err := geojson.UnmarshalGeometry(input.RegionData)
+	if err != nil {
+		return nil, "", nil, errors.New("input GeoJSON is invalid")
+	}
+	geom := geojsonGeom.Geometry()
+	switch v := geom.(type) {
+	case orb.LineString:
+		return parseLineRegion(orb.MultiLineString{v}, input.BufferMeters)
+	case orb.MultiLineString:
+		return parseLineRegion(v, input.BufferMeters)
+	case orb.Polygon:
+		if len(v) == 0 {
+			return nil, "", nil, errors.New("geom does not have enough rings")
+		}
+		for _, ring := range v {
+			if len(ring) < 4 {
+				return nil, "", nil, errors.New("ring does not have enough coordinates")
+			}
+		}
+	case orb.MultiPolygon:
+		if len(v) == 0 {
+			return nil, "", nil, errors.New("geom does not have enough rings")
+		}
+		for _, polygon := range v {
+			if len(polygon) == 0 {
+				return nil, "", nil, errors.New("geom does not have enough rings")
+			}
+			for _, ring := range polygon {
+				if len(ring) < 4 {
+					return nil, "", nil, errors.New("ring does not have enough coordinates")
+				}
+			}
+		}
+	}
+	sanitizedData, _ := geojsonGeom.MarshalJSON()
+	return geom, "geojson", sanitizedData, nil
+}
+
+func parseBboxRegion(input Input, _ RegionLimits) (orb.Geometry, string, json.RawMessage, error) {
+	var probe []json.RawMessage
+	if json.Unmarshal(input.RegionData, &probe) == nil && len(probe) > 0 && bytes.HasPrefix(bytes.TrimSpace(probe[0]), []byte("[")) {
+		return parseBboxesRegion(input.RegionData)
+	}
+	var coords []float64
+	json.Unmarshal(input.RegionData, &coords)
+	if len(coords) < 4 {
+		return nil, "", nil, errors.New("input does not have >3 coordinates")
+	}
+	geom := orb.MultiPoint{orb.Point{coords[1], coords[0]}, orb.Point{coords[3], coords[2]}}.Bound()
+	sanitizedData, _ := json.Marshal(coords[0:4])
+	return geom, "bbox", sanitizedData, nil
 }
 
 // check the filesystem for the result JSON
 // if it's not started yet, return the position in the queue
 func (h *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
+	allowOrigin(w, r, h.settings().CORSOrigins)
+	if hub := sentry.GetHubFromContext(r.Context()); hub != nil {
+		hub.Scope().SetUser(sentry.User{IPAddress: clientIP(r)})
+	}
+	// submissions and the uploads of reservations carry a region.
+	if r.Method == "POST" || r.Method == "PUT" {
+		if !decompressBody(w, r) {
+			return
+		}
+		if limit := h.settings().RegionLimits.MaxBodyBytes; limit > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+		}
+		defer r.Body.Close()
+	}
+	if strings.HasPrefix(r.URL.Path, "/api/admin/") {
+		h.serveAdmin(w, r)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/api/auth/") {
+		h.serveAuth(w, r)
+		return
+	}
+	if r.Method == "POST" && r.URL.Path == "/api/estimate" {
+		h.serveEstimate(w, r)
+		return
+	}
+	if (r.Method == "POST" && r.URL.Path == "/api/nodes") || (r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/api/nodes/")) {
+		h.serveNodes(w, r)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/api/schedules/") && r.Method != "POST" {
+		h.serveSchedule(w, r, strings.TrimPrefix(r.URL.Path, "/api/schedules/"))
+		return
+	}
+	if r.Method == "DELETE" {
+		if id, ok := deleteResultId(r.URL.Path); ok {
+			h.serveDeleteResult(w, r, id)
+			return
+		}
+		h.serveCancel(w, r)
+		return
+	}
+	if r.Method == "PUT" && strings.HasPrefix(r.URL.Path, "/api/reservations/") {
+		h.serveUpload(w, r, strings.TrimPrefix(r.URL.Path, "/api/reservations/"))
+		return
+	}
 	if r.Method == "POST" {
-		geom, sanitized_name, sanitized_type, sanitized_region, err := parseInput(r.Body)
-
-		if err != nil {
-			w.WriteHeader(400)
+		key, ok := h.requireScope(w, r, scopeSubmit, h.settings().RequireAPIKeys)
+		if !ok {
 			return
 		}
-
-		if GetSum(h.image, geom) > h.nodesLimit {
-			w.WriteHeader(400)
-			fmt.Fprintf(w, "Error: the limit of nodes was exceeded.")
+		// a batch is limited by its number of tasks.
+		if r.URL.Path != "/api/batch" && !h.limitSubmissions(w, r, 1) {
 			return
 		}
 
-		task := Task{Uuid: uuid.New().String(), SanitizedName: sanitized_name, SanitizedRegionType: sanitized_type, SanitizedRegionData: sanitized_region}
-
-		select {
-		case h.queue <- task:
-			var progress Progress
-			h.progressMutex.Lock()
-			h.progress[task.Uuid] = progress
-			h.progressMutex.Unlock()
-			w.WriteHeader(201)
-			fmt.Fprintf(w, task.Uuid)
-		default:
-			w.WriteHeader(503)
+		if r.URL.Path == "/api/reservations" {
+			h.serveReserve(w, r, key)
+			return
+		}
+		if r.URL.Path == "/api/batch" {
+			h.serveBatch(w, r, key)
+			return
+		}
+		var created *Created
+		if id, ok := retryId(r.URL.Path); ok {
+			created = h.serveRetry(w, r, key, id)
+		} else {
+			created = h.submitTask(w, r, key, h.newUuid(uuid.NewString), true)
+		}
+		if created != nil {
+			h.recordUserJob(r, created)
+			writeCreated(w, created)
 		}
 	} else {
 		if r.URL.Path == "/api" || r.URL.Path == "/api/" {
-			l := len(h.queue)
-
-			h.lastUpdated.mutex.Lock()
+			l := h.queue.Len()
 
-			if time.Since(h.lastUpdated.checkedAt).Seconds() > 10 {
-				cmd := exec.Command(h.exec, "query", h.data, "timestamp")
-				timestampRaw, _ := cmd.Output()
-				timestamp, err := time.Parse(time.RFC3339, strings.TrimSpace(string(timestampRaw)))
-				if err == nil {
-					h.lastUpdated.timestamp = timestamp
-					h.lastUpdated.checkedAt = time.Now()
-				}
-			}
-
-			timestamp := h.lastUpdated.timestamp
-			h.lastUpdated.mutex.Unlock()
+			timestamp := h.dataTimestamp()
 
 			w.Header().Set("Content-Type", "application/json")
 
@@ -377,35 +1148,78 @@ func (h *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			if time.Now().Sub(timestamp).Minutes() > 15 {
 				status = "warn"
 			}
+			failures, extracts := h.failures.Window(time.Now())
+			settings := h.settings()
+			status = worseStatus(status, extractStatus(failures, extracts, settings.MaxFailureRate))
 
-			json.NewEncoder(w).Encode(SystemState{status, l, h.nodesLimit, timestamp.Format(time.RFC3339)})
+			json.NewEncoder(w).Encode(SystemState{status, l, settings.NodesLimit, timestamp.Format(time.RFC3339), h.scheduler, settings.softLimit(), failures})
+		} else if r.URL.Path == "/api/quota" {
+			key, err := h.authenticate(r)
+			if key == nil {
+				if err == nil {
+					err = errors.New("an API key is required")
+				}
+				w.WriteHeader(401)
+				fmt.Fprintf(w, "Error: %s", err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(h.quotas.Status(key))
+		} else if r.URL.Path == "/api/results" {
+			h.serveResults(w, r)
+		} else if r.URL.Path == "/api/jobs" {
+			h.serveRecentJobs(w, r)
+		} else if r.URL.Path == "/api/me" || r.URL.Path == "/api/me/jobs" {
+			h.serveMe(w, r)
+		} else if strings.HasPrefix(r.URL.Path, "/api/batch/") {
+			h.serveBatchStatus(w, strings.TrimPrefix(r.URL.Path, "/api/batch/"))
+		} else if r.URL.Path == "/api/capabilities" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(h.capabilities())
+		} else if r.URL.Path == "/api/popularity" {
+			h.servePopularity(w, r)
 		} else if r.URL.Path == "/api/nodes.png" {
 			w.Header().Set("Content-Type", "image/png")
 			w.Write(imageBytes)
 		} else {
 			parts := strings.Split(r.URL.Path, "/")
+			if len(parts) == 4 && parts[0] == "" && parts[1] == "api" && parts[3] == "download" {
+				h.serveDownload(w, r, parts[2])
+				return
+			}
+			if len(parts) == 4 && parts[0] == "" && parts[1] == "api" && parts[3] == "region" {
+				h.serveRegion(w, r, parts[2])
+				return
+			}
 			if len(parts) != 3 || parts[0] != "" || parts[1] != "api" {
 				w.WriteHeader(404)
 				return
 			}
 			uuid := parts[2]
+			includes, err := parseIncludes(r)
+			if err != nil {
+				w.WriteHeader(400)
+				fmt.Fprintf(w, "Error: %s", err)
+				return
+			}
 
-			h.progressMutex.RLock()
-			progress, ok := h.progress[uuid]
-			h.progressMutex.RUnlock()
-
-			if ok {
+			if h.serveProgress(w, uuid, includes) {
+				return
+			}
+			if expires, ok := h.reservations.awaiting(uuid, time.Now()); ok {
 				w.Header().Set("Content-Type", "application/json")
-				json.NewEncoder(w).Encode(progress)
+				json.NewEncoder(w).Encode(Progress{AwaitingUpload: true, UploadExpiresAt: expires.UTC().Format(time.RFC3339)})
 				return
 			}
 
 			resultPath := filepath.Join(h.filesDir, uuid)
-			if _, err := os.Stat(resultPath); err == nil {
-				Openfile, _ := os.Open(resultPath)
-				defer Openfile.Close()
+			if record, err := os.ReadFile(resultPath); err == nil {
 				w.Header().Set("Content-Type", "application/json")
-				io.Copy(w, Openfile)
+				var progress Progress
+				if json.Unmarshal(record, &progress) == nil && progress.Evicted {
+					w.WriteHeader(410)
+				}
+				w.Write(h.withIncludes(h.withDownloadUrl(record, uuid, progress), uuid, includes))
 				return
 			}
 			w.WriteHeader(404)
@@ -413,17 +1227,234 @@ func (h *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func main() {
-	var (
-		bindAddress, filesDir, exec, sentryDsn string
-	)
-	var nodesLimit int
-	flag.StringVar(&bindAddress, "bind", ":8080", "IP address and port to listen on")
-	flag.StringVar(&filesDir, "filesDir", "", "Result directory")
-	flag.StringVar(&exec, "exec", "osmx", "Path to OSMX executable")
-	flag.StringVar(&sentryDsn, "sentryDsn", "", "Sentry DSN")
-	flag.IntVar(&nodesLimit, "nodesLimit", 100000000, "Nodes limit")
+// submitTask validates the body of a submission and queues it under
+// id, or with dedupe returns an identical job instead. Rejections are
+// written to w; on success the caller writes the returned body.
+func (h *Server) submitTask(w http.ResponseWriter, r *http.Request, key *APIKey, id string, dedupe bool) *Created {
+	// the job is admitted under the settings as they are now, even if
+	// they are reloaded while it waits.
+	if h.intakePaused.Load() {
+		w.WriteHeader(503)
+		fmt.Fprintf(w, "Error: submissions are paused")
+		return nil
+	}
+	settings := h.settings()
+	var err error
+	var waitForQueue time.Duration
+	if s := r.URL.Query().Get("waitForQueue"); s != "" {
+		seconds, err := strconv.ParseFloat(s, 64)
+		if err != nil || seconds < 0 {
+			w.WriteHeader(400)
+			fmt.Fprintf(w, "Error: waitForQueue must be a number of seconds")
+			return nil
+		}
+		waitForQueue = time.Duration(min(seconds, maxWaitForQueue) * float64(time.Second))
+	}
+
+	var input Input
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		input, err = decodeMultipartInput(r, settings.RegionLimits.Precision)
+	} else {
+		input, err = decodeInput(r.Body, settings.RegionLimits.Precision)
+	}
+	var geom orb.Geometry
+	var sanitized_name, sanitized_type, region_type string
+	var sanitized_region json.RawMessage
+	var subRegions []SubRegion
+	var warnings []Warning
+	if err == nil && input.FromDryRun != "" {
+		// the region was validated when the dry run was submitted.
+		var planned Task
+		planned, geom, err = h.loadDryRun(input.FromDryRun)
+		sanitized_name, sanitized_type, sanitized_region, subRegions = planned.SanitizedName, planned.SanitizedRegionType, planned.SanitizedRegionData, planned.SubRegions
+		region_type = planned.RegionType
+		warnings = planned.Warnings
+		if input.Name != "" {
+			sanitized_name = input.Name
+		}
+	} else if err == nil {
+		region_type = input.RegionType
+		input, err = h.resolveRegion(r.Context(), input)
+		if err == nil {
+			geom, sanitized_name, sanitized_type, sanitized_region, err = parseRegion(input, settings.RegionLimits)
+		}
+		if err == nil {
+			subRegions, err = parseSubRegions(input, settings.RegionLimits)
+		}
+		if err == nil && input.Exclude != nil {
+			if subRegions != nil {
+				err = errors.New("named features can't be combined with Exclude")
+			} else {
+				geom, sanitized_type, sanitized_region, warnings, err = excludeRegion(geom, sanitized_type, sanitized_region, input.Exclude, settings.RegionLimits)
+			}
+		}
+	}
+	encrypt := input.Encrypt || h.encryptResults
+	if err == nil && encrypt && h.encryptionKeys == nil {
+		err = errors.New("encryption is not configured on this server")
+	}
+	if err == nil && encrypt && subRegions != nil {
+		err = errors.New("named features can't be split when the result is encrypted")
+	}
+	if err == nil {
+		err = h.extraArgs.Validate(input.ExtraArgs)
+	}
+	if err == nil && input.CallbackUrl != "" {
+		if h.webhooks == nil {
+			err = errors.New("callbacks are not configured on this server")
+		} else {
+			err = parseCallbackUrl(input.CallbackUrl)
+		}
+	}
+	if err == nil && input.Schedule != "" {
+		err = h.checkSchedule(r, input)
+	}
+	var snapshot string
+	if err == nil && input.SnapshotTimestamp != "" {
+		snapshot, err = h.pinSnapshot(r.Context(), input.SnapshotTimestamp)
+	}
+
+	if err != nil {
+		h.failures.Fail(failureValidation, time.Now())
+		writeInputError(w, err)
+		return nil
+	}
+
+	nodes := GetSum(h.image, geom)
+	var override *LimitOverride
+	if limit := h.nodesLimitFor(r, settings); nodes > limit {
+		if override = h.limitOverride(r, nodes); override == nil {
+			h.failures.Fail(failureLimit, time.Now())
+			h.writeLimitError(w, geom, nodes, limit)
+			return nil
+		}
+	}
+	if err := h.checkScratchCapacity(nodes); err != nil {
+		h.releaseOverride(override)
+		h.failures.Fail(failureLimit, time.Now())
+		w.WriteHeader(400)
+		fmt.Fprintf(w, "Error: %s", err)
+		return nil
+	}
+	dryRun := r.URL.Query().Get("dryRun") == "1"
+	estimatedSize := h.estimatedSize(nodes)
+
+	task := Task{Uuid: id, SanitizedName: sanitized_name, SanitizedRegionType: sanitized_type, SanitizedRegionData: sanitized_region, RegionType: region_type, Encrypt: encrypt, SubRegions: subRegions}
+	task.LimitOverride = override
+	task.DryRun = dryRun
+	task.SnapshotTimestamp = snapshot
+	task.ExtraArgs = input.ExtraArgs
+	task.CallbackUrl = input.CallbackUrl
+	task.Warnings = warnings
+	task.EstimatedNodes = int64(nodes)
+	task.SubmittedAt = time.Now()
+
+	// the first run of a schedule is of fresh data too.
+	if dedupe && input.Schedule == "" && r.URL.Query().Get("force") != "true" {
+		// the region is identical, so it is echoed from this submission.
+		existing, ok := h.dedupe.lookup(h, task, time.Now())
+		if !ok {
+			existing, ok = h.cachedResult(task)
+		}
+		if ok {
+			// the job that already exists needs no override.
+			h.releaseOverride(override)
+			h.popularity.Record(geom, task.SubmittedAt)
+			task.Uuid = existing
+			created := newCreated(task, estimatedSize, r)
+			created.Deduplicated = true
+			return created
+		}
+	}
+	if !dryRun {
+		if code, storageErr := h.checkStorageCapacity(estimatedSize); storageErr != nil {
+			h.releaseOverride(override)
+			h.failures.Fail(failureLimit, time.Now())
+			writeStorageError(w, code, storageErr)
+			return nil
+		}
+	}
+
+	if key != nil {
+		if quotaErr := h.quotas.Reserve(key, int64(nodes)); quotaErr != nil {
+			h.releaseOverride(override)
+			h.failures.Fail(failureLimit, time.Now())
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(429)
+			json.NewEncoder(w).Encode(quotaErr)
+			return nil
+		}
+		task.KeyName = key.Name
+	}
+	task.Priority = h.priority(key, nodes)
+
+	// register the task before it can be picked up, so a fast
+	// worker's progress isn't overwritten.
+	h.setProgress(task.Uuid, Progress{Priority: task.Priority})
+	h.persistJob(task, jobQueued)
+	var pushed bool
+	if waitForQueue > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), waitForQueue)
+		pushed = h.queue.PushWait(ctx, task, nodes)
+		cancel()
+	} else {
+		pushed = h.queue.Push(task, nodes)
+	}
+	if pushed && r.Context().Err() != nil {
+		// the client went away while waiting and will never learn the uuid.
+		if _, ok := h.queue.Remove(task.Uuid); ok {
+			pushed = false
+		}
+	}
+	if !pushed {
+		h.takeProgress(task.Uuid)
+		h.forgetJob(task.Uuid)
+		if key != nil {
+			h.quotas.Release(key.Name, task.EstimatedNodes)
+		}
+		h.releaseOverride(override)
+		w.WriteHeader(503)
+		return nil
+	}
+	if dedupe {
+		h.dedupe.add(h, task, time.Now())
+	}
+	h.popularity.Record(geom, task.SubmittedAt)
+	created := newCreated(task, estimatedSize, r)
+	created.DeleteToken = h.recordOwner(task)
+	if input.Schedule != "" {
+		if id, err := h.addSchedule(input.Schedule, task, created.DeleteToken); err != nil {
+			// the first run is queued regardless.
+			fmt.Println("adding schedule of", task.Uuid, err)
+		} else {
+			created.ScheduleId = id
+			created.LatestUrl = "/api/schedules/" + id + "/latest"
+		}
+	}
+	return created
+}
+
+// newCreated is the response to an accepted task, echoing its region
+// unless ?echoRegion=false.
+func newCreated(task Task, estimatedSize int64, r *http.Request) *Created {
+	created := Created{Uuid: task.Uuid, SnapshotTimestamp: task.SnapshotTimestamp, EstimatedSizeBytes: estimatedSize, Warnings: task.Warnings}
+	if r.URL.Query().Get("echoRegion") != "false" {
+		created.SanitizedRegionType = task.SanitizedRegionType
+		created.SanitizedRegionData = task.SanitizedRegionData
+		if bound, ok := regionBound(task); ok {
+			created.Bbox = &[4]float64{bound.Min[0], bound.Min[1], bound.Max[0], bound.Max[1]}
+		}
+	}
+	return &created
+}
 
+func writeCreated(w http.ResponseWriter, created *Created) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	json.NewEncoder(w).Encode(created)
+}
+
+func main() {
 	flag.Usage = func() {
 		fmt.Printf("SliceOSM API server\n\n")
 		fmt.Printf("Usage: %s [OPTIONS] OSMX_FILE\n\n", os.Args[0])
@@ -431,17 +1462,43 @@ func main() {
 		flag.PrintDefaults()
 	}
 
-	flag.Parse()
+	config, err := parseConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(2)
+	}
 
-	if filesDir == "" {
+	if config.FilesDir == "" {
 		fmt.Println("Error: missing required option -filesDir")
 		flag.Usage()
 		os.Exit(2)
 	}
 
-	tmpDir := os.Getenv("TMPDIR")
-	if tmpDir == "" {
-		tmpDir = "/tmp"
+	if config.Scheduler != "fifo" && config.Scheduler != "sjf" {
+		fmt.Println("Error: -scheduler must be fifo or sjf")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if config.Workers < 0 {
+		fmt.Println("Error: -workers must not be negative")
+		flag.Usage()
+		os.Exit(2)
+	}
+	limits := extractLimits{
+		nice:        config.ExtractNice,
+		ioClass:     config.ExtractIOClass,
+		cgroup:      config.ExtractCgroup,
+		memoryBytes: config.ExtractMemory,
+	}
+	if err := limits.prepare(); err != nil {
+		fmt.Println("Error:", err)
+		flag.Usage()
+		os.Exit(2)
+	}
+	if config.LargeJobNodes > 0 && config.LargeWorkers < 1 {
+		fmt.Println("Error: -largeWorkers must be at least 1")
+		flag.Usage()
+		os.Exit(2)
 	}
 
 	if flag.NArg() != 1 {
@@ -450,11 +1507,12 @@ func main() {
 		os.Exit(2)
 	}
 
-	data := flag.Arg(0)
+	data := config.Data
+	filesDir := config.FilesDir
 
-	if sentryDsn != "" {
+	if config.SentryDsn != "" {
 		err := sentry.Init(sentry.ClientOptions{
-			Dsn: sentryDsn,
+			Dsn: config.SentryDsn,
 		})
 
 		if err != nil {
@@ -462,6 +1520,180 @@ func main() {
 		}
 	}
 
+	settings, err := loadSettings(config)
+	if err != nil {
+		fmt.Println("Error", err)
+		os.Exit(1)
+	}
+
+	var webhooks *Webhooks
+	if config.WebhookSecretFile != "" {
+		webhooks, err = loadWebhooks(config.WebhookSecretFile)
+		if err != nil {
+			fmt.Println("Error loading webhook secret:", err)
+			os.Exit(1)
+		}
+	}
+
+	var downloadLinks *DownloadLinks
+	if config.DownloadSecretFile != "" {
+		downloadLinks, err = loadDownloadLinks(config.DownloadSecretFile, time.Duration(config.DownloadMinutes*float64(time.Minute)))
+		if err != nil {
+			fmt.Println("Error loading download secret:", err)
+			os.Exit(1)
+		}
+	}
+
+	var objectStore *ObjectStore
+	if config.ObjectStoreUrl != "" {
+		objectStore, err = loadObjectStore(config.ObjectStoreUrl, config.ObjectPublicUrl, config.ObjectStoreRegion, config.ObjectStoreKeyFile)
+		if err != nil {
+			fmt.Println("Error loading object store:", err)
+			os.Exit(1)
+		}
+	}
+
+	var encryptionKeys *EncryptionKeys
+	if config.EncryptionKeyFile != "" {
+		var err error
+		encryptionKeys, err = loadEncryptionKeys(config.EncryptionKeyFile)
+		if err != nil {
+			fmt.Println("Error loading encryption keys:", err)
+			os.Exit(1)
+		}
+	} else if config.EncryptResults {
+		fmt.Println("Error: -encryptResults requires -encryptionKeyFile")
+		os.Exit(2)
+	}
+
+	quotas, err := NewQuotaStore(filesDir)
+	if err != nil {
+		fmt.Println("Error loading quota usage:", err)
+		os.Exit(1)
+	}
+
+	var limitOverrides *LimitOverrides
+	if config.OverrideSecretFile != "" {
+		limitOverrides, err = loadLimitOverrides(config.OverrideSecretFile, filesDir)
+		if err != nil {
+			fmt.Println("Error loading limit override secret:", err)
+			os.Exit(1)
+		}
+	}
+
+	if removed, err := removePartFiles(filesDir); err != nil {
+		fmt.Println("Error removing partial files:", err)
+		os.Exit(1)
+	} else if removed > 0 {
+		fmt.Println("removed", removed, "partial files left by a crash")
+	}
+
+	if reconstructed, err := backfillRecords(filesDir); err != nil {
+		fmt.Println("Error reconstructing completion records:", err)
+	} else if len(reconstructed) > 0 {
+		fmt.Println("reconstructed", len(reconstructed), "completion records")
+	}
+
+	results, err := LoadResultIndex(filesDir)
+	if err != nil {
+		fmt.Println("Error indexing results:", err)
+		os.Exit(1)
+	}
+
+	blobs, err := LoadBlobStore(filesDir)
+	if err != nil {
+		fmt.Println("Error loading result blobs:", err)
+		os.Exit(1)
+	}
+
+	jobs, err := OpenJobStore(filesDir)
+	if err != nil {
+		fmt.Println("Error opening the job queue:", err)
+		os.Exit(1)
+	}
+
+	var relations *Relations
+	if config.OSMApiUrl != "" {
+		relations = NewRelations(config.OSMApiUrl)
+	}
+
+	var places *Places
+	if config.NominatimUrl != "" {
+		places = NewPlaces(config.NominatimUrl)
+	}
+
+	var regionUrls *RegionUrls
+	if config.RegionUrlAllowlist != "" {
+		regionUrls = NewRegionUrls(config.RegionUrlAllowlist)
+	}
+
+	var boundaries *Boundaries
+	if config.BoundariesFile != "" {
+		boundaries, err = LoadBoundaries(config.BoundariesFile)
+		if err != nil {
+			fmt.Println("Error loading boundaries:", err)
+			os.Exit(1)
+		}
+	}
+
+	var osmAuth *OSMAuth
+	if config.OSMClientId != "" {
+		osmAuth, err = loadOSMAuth(config.OSMClientId, config.OSMSecretFile, config.OSMRedirectUrl, config.OSMUrl)
+		if err != nil {
+			fmt.Println("Error loading the OpenStreetMap login:", err)
+			os.Exit(1)
+		}
+	}
+	userJobs, err := LoadUserJobs(filesDir)
+	if err != nil {
+		fmt.Println("Error loading user job histories:", err)
+		os.Exit(1)
+	}
+	deadLetters, err := LoadDeadLetters(filesDir)
+	if err != nil {
+		fmt.Println("Error loading failed jobs:", err)
+		os.Exit(1)
+	}
+	schedules, err := LoadSchedules(filesDir)
+	if err != nil {
+		fmt.Println("Error loading schedules:", err)
+		os.Exit(1)
+	}
+
+	popularity, err := LoadPopularity(filesDir)
+	if err != nil {
+		fmt.Println("Error loading popularity counts:", err)
+		os.Exit(1)
+	}
+
+	statsFile := config.StatsFile
+	if statsFile == "" {
+		statsFile = filepath.Join(filesDir, "stats.prom")
+	}
+	var buckets [3][]float64
+	for i, s := range []string{config.QueueWaitBuckets, config.ExtractBuckets, config.SizeBuckets} {
+		if buckets[i], err = parseBuckets(s); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(2)
+		}
+	}
+	metrics, err := LoadMetrics(statsFile, buckets[0], buckets[1], buckets[2])
+	if err != nil {
+		fmt.Println("Error loading stats snapshot:", err)
+		os.Exit(1)
+	}
+
+	extraArgs, err := parseExtraArgsAllowlist(config.ExtraArgsAllowlist)
+	if err != nil {
+		fmt.Println("Error: -extraArgsAllowlist:", err)
+		os.Exit(2)
+	}
+	proxies, err := parseTrustedProxies(config.TrustedProxies)
+	if err != nil {
+		fmt.Println("Error: -trustedProxies:", err)
+		os.Exit(2)
+	}
+
 	img, err := png.Decode(bytes.NewReader(imageBytes))
 	if err != nil {
 		fmt.Println("Error decoding file:", err)
@@ -469,16 +1701,123 @@ func main() {
 	}
 
 	srv := Server{
-		filesDir:   filesDir,
-		tmpDir:     tmpDir,
-		exec:       exec,
-		data:       data,
-		image:      img,
-		nodesLimit: nodesLimit,
+		filesDir:  filesDir,
+		tmpDir:    config.TmpDir,
+		exec:      config.Exec,
+		extractor: &osmxExtractor{exec: config.Exec, limits: limits},
+		data:      data,
+		image:     img,
+		scheduler: config.Scheduler,
+
+		limitOverrides: limitOverrides,
+
+		extraArgs:  extraArgs,
+		quotas:     quotas,
+		results:    results,
+		blobs:      blobs,
+		metrics:    metrics,
+		popularity: popularity,
+		jobs:       jobs,
+
+		encryptionKeys: encryptionKeys,
+		webhooks:       webhooks,
+		relations:      relations,
+		places:         places,
+		regionUrls:     regionUrls,
+		boundaries:     boundaries,
+		objectStore:    objectStore,
+		downloadLinks:  downloadLinks,
+		osmAuth:        osmAuth,
+		userJobs:       userJobs,
+		schedules:      schedules,
+		deadLetters:    deadLetters,
+		userNodesLimit: config.UserNodesLimit,
+		workerCount:    config.Workers,
+		largeJobNodes:  config.LargeJobNodes,
+		largeWorkers:   config.LargeWorkers,
+		smallJobNodes:  config.SmallJobNodes,
+		dedupeWindow:   time.Duration(config.DedupeMinutes * float64(time.Minute)),
+		cacheStaleness: time.Duration(config.CacheStaleMinutes * float64(time.Minute)),
+		encryptResults: config.EncryptResults,
+		md5Checksums:   config.MD5Checksums,
+
+		reloader: NewReloader(os.Args[1:], flag.CommandLine),
+	}
+	srv.setSettings(settings)
+	srv.osmxVersion = srv.queryVersion()
+	fmt.Println("osmx version:", srv.osmxVersion)
+
+	mode, err := strconv.ParseUint(config.SocketMode, 8, 32)
+	if err != nil {
+		fmt.Println("Error: -socketMode must be an octal file mode")
+		os.Exit(2)
+	}
+	listener, socketPath, err := listen(config.BindAddress, os.FileMode(mode))
+	if err != nil {
+		log.Fatal(err)
 	}
+
 	srv.StartWorkers()
-	fmt.Printf("Starting server on %s\n", bindAddress)
+	if n, err := srv.restoreJobs(); err != nil {
+		fmt.Println("Error restoring the job queue:", err)
+		os.Exit(1)
+	} else if n > 0 {
+		fmt.Println("restored", n, "jobs")
+	}
+	srv.StartStats(15 * time.Second)
+	srv.StartPopularity(time.Minute)
+	// retention and the stall monitor do nothing while their settings
+	// are 0, which a reload can change; schedules only submit the tasks
+	// that asked for one.
+	srv.StartRetention(time.Minute)
+	srv.StartStallMonitor(time.Minute)
+	srv.StartSchedules(time.Minute)
 	sentryHandler := sentryhttp.New(sentryhttp.Options{})
-	http.Handle("/", sentryHandler.Handle(&srv))
-	log.Fatal(http.ListenAndServe(bindAddress, nil))
+	var handler http.Handler = sentryHandler.Handle(&srv)
+	if config.LogRequests {
+		handler = accessLog(handler)
+	}
+	handler = resolveClientIP(proxies, handler)
+	httpServer := &http.Server{Handler: handler, ConnContext: connContext}
+
+	shutdown := make(chan struct{})
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+		<-stop
+		// progress can still be polled while running jobs finish; a
+		// second signal kills them.
+		fmt.Println("shutting down, signal again to stop running jobs now")
+		force := make(chan struct{})
+		go func() {
+			<-stop
+			close(force)
+		}()
+		srv.Drain(time.Duration(config.ShutdownGrace*float64(time.Minute)), force)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		httpServer.Shutdown(ctx)
+		close(shutdown)
+	}()
+
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		for range hup {
+			srv.reload()
+		}
+	}()
+
+	fmt.Printf("Starting server on %s\n", listener.Addr())
+	if err := httpServer.Serve(listener); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-shutdown
+	srv.jobs.Close()
+	if err := srv.cleanScratch(); err != nil {
+		fmt.Println(err)
+	}
+	if socketPath != "" {
+		os.Remove(socketPath)
+	}
 }
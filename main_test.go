@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"github.com/stretchr/testify/assert"
 	"image/png"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestGetPixel(t *testing.T) {
@@ -68,3 +73,239 @@ func TestGeoJSONMultiPolygonTooFewCoords(t *testing.T) {
 	_, _, _, _, err = parseInput(strings.NewReader(`{"Name":"a_name", "RegionType":"geojson", "RegionData":{"type":"MultiPolygon","coordinates":[[[],[]]]}}`))
 	assert.NotNil(t, err)
 }
+
+// fakeOsmx writes a shell script standing in for osmx: it reports the
+// given timestamp, prints two progress lines, and copies a small valid
+// pbf to the output path. before runs ahead of the second progress line.
+func fakeOsmx(t *testing.T, before string) string {
+	dir := t.TempDir()
+	pbf := appendBlob(nil, "OSMHeader", []byte("header"))
+	pbf = appendBlob(pbf, "OSMData", make([]byte, 100))
+	pbfPath := filepath.Join(dir, "fixture.osm.pbf")
+	os.WriteFile(pbfPath, pbf, 0644)
+
+	script := `#!/bin/sh
+if [ "$1" = "query" ]; then echo 2024-01-01T00:00:00Z; exit 0; fi
+if [ "$1" = "--version" ]; then echo osmx 0.0.4; exit 0; fi
+echo '{"Timestamp":"2024-01-01T00:00:00Z","CellsTotal":10,"CellsProg":0,"NodesTotal":0,"NodesProg":0,"ElemsTotal":0,"ElemsProg":0}'
+` + before + `
+echo '{"Timestamp":"2024-01-01T00:00:00Z","CellsTotal":10,"CellsProg":10,"NodesTotal":100,"NodesProg":100,"ElemsTotal":120,"ElemsProg":120}'
+cp ` + pbfPath + ` "$3"
+`
+	path := filepath.Join(dir, "osmx")
+	os.WriteFile(path, []byte(script), 0755)
+	return path
+}
+
+func newTestServer(t *testing.T, exec string) *Server {
+	file, _ := os.Open("z12_red_green.png")
+	defer file.Close()
+	img, _ := png.Decode(file)
+	quotas, _ := NewQuotaStore(t.TempDir())
+	filesDir := t.TempDir()
+	results, _ := LoadResultIndex(filesDir)
+	blobs, _ := LoadBlobStore(filesDir)
+	metrics, _ := LoadMetrics(filepath.Join(filesDir, "stats.prom"), []float64{1, 10}, []float64{1, 10}, []float64{1000, 1e6})
+	popularity, _ := LoadPopularity(filesDir)
+	jobs, _ := OpenJobStore(filesDir)
+	t.Cleanup(func() { jobs.Close() })
+	deadLetters, _ := LoadDeadLetters(filesDir)
+	schedules, _ := LoadSchedules(filesDir)
+	h := &Server{
+		filesDir:     filesDir,
+		tmpDir:       t.TempDir(),
+		exec:         exec,
+		extractor:    &osmxExtractor{exec: exec},
+		data:         "planet.osmx",
+		image:        img,
+		nodesLimit:   100000000,
+		scheduler:    "fifo",
+		regionLimits: defaultRegionLimits,
+		apiKeys:      make(map[string]*APIKey),
+		quotas:       quotas,
+		results:      results,
+		blobs:        blobs,
+		metrics:      metrics,
+		popularity:   popularity,
+		jobs:         jobs,
+		deadLetters:  deadLetters,
+		schedules:    schedules,
+
+		maxFailureRate: defaultMaxFailureRate,
+	}
+	h.osmxVersion = h.queryVersion()
+	// workers the test started are stopped before its directories are
+	// removed, killing the jobs they are running.
+	t.Cleanup(func() {
+		if h.pool != nil {
+			force := make(chan struct{})
+			close(force)
+			h.Drain(0, force)
+		}
+	})
+	return h
+}
+
+// getProgress reads the status of a job through the API.
+func getProgress(h *Server, uuid string) (int, Progress) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/"+uuid, nil))
+	var progress Progress
+	json.NewDecoder(w.Body).Decode(&progress)
+	return w.Code, progress
+}
+
+func submit(h *Server, body string) (int, string) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/api/", strings.NewReader(body)))
+	if w.Code != 201 {
+		return w.Code, w.Body.String()
+	}
+	var created Created
+	json.NewDecoder(w.Body).Decode(&created)
+	return w.Code, created.Uuid
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(10 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+const richmond = `{"Name":"richmond","RegionType":"bbox","RegionData":[37.5272,-77.4571,37.5530,-77.4133]}`
+
+func TestRunTask(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.StartWorkers()
+	code, uuid := submit(h, richmond)
+	assert.Equal(t, 201, code)
+
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, uuid)
+		return progress.Complete
+	})
+	_, progress := getProgress(h, uuid)
+	assert.Equal(t, int64(100), progress.NodesTotal)
+	assert.Equal(t, "2024-01-01T00:00:00Z", progress.DataTimestamp)
+	assert.NotEmpty(t, progress.FinishedAt)
+	_, err := os.Stat(filepath.Join(h.filesDir, uuid+".osm.pbf"))
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(h.filesDir, uuid+"_region.json"))
+	assert.Nil(t, err)
+	assert.Empty(t, scratchFiles(h))
+}
+
+// progress never goes backwards or disappears while a job completes.
+func TestProgressMonotonic(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, `sleep 0.05 > /dev/null
+echo '{"Timestamp":"2024-01-01T00:00:00Z","CellsTotal":10,"CellsProg":5,"NodesTotal":0,"NodesProg":50,"ElemsTotal":0,"ElemsProg":0}'
+sleep 0.05 > /dev/null`))
+	h.StartWorkers()
+	_, uuid := submit(h, richmond)
+
+	var last Progress
+	for !last.Complete {
+		code, progress := getProgress(h, uuid)
+		if !assert.Equal(t, 200, code) {
+			return
+		}
+		assert.GreaterOrEqual(t, progress.CellsProg, last.CellsProg)
+		assert.GreaterOrEqual(t, progress.NodesProg, last.NodesProg)
+		last = progress
+	}
+	assert.Equal(t, int64(10), last.CellsProg)
+}
+
+func TestProvenance(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.data = filepath.Join(t.TempDir(), "planet.osmx")
+	os.WriteFile(h.data, []byte("data"), 0644)
+	h.StartWorkers()
+	_, uuid := submit(h, richmond)
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, uuid)
+		return progress.Complete
+	})
+
+	_, progress := getProgress(h, uuid)
+	p := progress.Provenance
+	assert.NotNil(t, p)
+	assert.Equal(t, h.data, p.DataPath)
+	assert.Equal(t, int64(4), p.DataSizeBytes)
+	assert.NotEmpty(t, p.DataModified)
+	assert.Equal(t, "osmx 0.0.4", p.OsmxVersion)
+	assert.Equal(t, []string{h.exec, "extract", h.data}, p.Args[0:3])
+
+	var task Task
+	b, _ := os.ReadFile(filepath.Join(h.filesDir, uuid+"_region.json"))
+	json.Unmarshal(b, &task)
+	assert.Equal(t, p, task.Provenance)
+}
+
+func TestWaitForQueue(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.queue = NewScheduler("fifo", 1)
+	h.progress = make(map[string]Progress)
+	h.progressJSON = make(map[string][]byte)
+	h.queue.Push(Task{Uuid: "blocking"}, 0)
+
+	code, _ := submit(h, richmond)
+	assert.Equal(t, 503, code)
+
+	// the client disconnects while waiting
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/api/?waitForQueue=10", strings.NewReader(richmond)).WithContext(ctx))
+	assert.Equal(t, 503, w.Code)
+	assert.Equal(t, 1, h.queue.Len())
+	assert.Empty(t, h.progress)
+
+	// space frees up while waiting
+	time.AfterFunc(50*time.Millisecond, func() { h.queue.Pop() })
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/api/?waitForQueue=10", strings.NewReader(richmond)))
+	assert.Equal(t, 201, w.Code)
+	var created Created
+	json.NewDecoder(w.Body).Decode(&created)
+	assert.Equal(t, 1, h.queue.Position(created.Uuid))
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/api/?waitForQueue=soon", strings.NewReader(richmond)))
+	assert.Equal(t, 400, w.Code)
+}
+
+func TestCreatedEchoesRegion(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.StartWorkers()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/api/", strings.NewReader(`{"Name":"x","RegionType":"geojson","RegionData":{"type":"Polygon","coordinates":[[[-77.45711111,37.553],[-77.4571,37.5272],[-77.4133,37.5272],[-77.4133,37.553],[-77.45711111,37.553]]]}}`)))
+	assert.Equal(t, 201, w.Code)
+	var created Created
+	json.NewDecoder(w.Body).Decode(&created)
+	assert.Equal(t, "geojson", created.SanitizedRegionType)
+	assert.Equal(t, &[4]float64{-77.457111, 37.5272, -77.4133, 37.553}, created.Bbox)
+
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, created.Uuid)
+		return progress.Complete
+	})
+	var task Task
+	b, _ := os.ReadFile(filepath.Join(h.filesDir, created.Uuid+"_region.json"))
+	json.Unmarshal(b, &task)
+	assert.Equal(t, string(task.SanitizedRegionData), string(created.SanitizedRegionData))
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/api/?echoRegion=false", strings.NewReader(richmond)))
+	assert.Equal(t, 201, w.Code)
+	assert.NotContains(t, w.Body.String(), "SanitizedRegionData")
+	json.NewDecoder(w.Body).Decode(&created)
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, created.Uuid)
+		return progress.Complete
+	})
+}
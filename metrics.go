@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+const (
+	defaultQueueWaitBuckets = "1,10,60,300,1800,3600,14400"
+	defaultExtractBuckets   = "10,60,300,1800,3600,14400,43200"
+	defaultSizeBuckets      = "1e6,1e7,1e8,1e9,1e10,1e11"
+)
+
+// A cumulative histogram in the Prometheus text exposition format.
+type Histogram struct {
+	Name   string
+	Help   string
+	Bounds []float64
+	// observations per bucket, not cumulative; the last is +Inf.
+	Counts []uint64
+	Sum    float64
+}
+
+func NewHistogram(name string, help string, bounds []float64) *Histogram {
+	return &Histogram{Name: name, Help: help, Bounds: bounds, Counts: make([]uint64, len(bounds)+1)}
+}
+
+func (x *Histogram) Observe(v float64) {
+	i, _ := slices.BinarySearch(x.Bounds, v)
+	x.Counts[i]++
+	x.Sum += v
+}
+
+func (x *Histogram) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", x.Name, x.Help, x.Name)
+	var total uint64
+	for i, count := range x.Counts {
+		total += count
+		le := "+Inf"
+		if i < len(x.Bounds) {
+			le = strconv.FormatFloat(x.Bounds[i], 'g', -1, 64)
+		}
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", x.Name, le, total)
+	}
+	fmt.Fprintf(w, "%s_sum %s\n%s_count %d\n", x.Name, strconv.FormatFloat(x.Sum, 'g', -1, 64), x.Name, total)
+}
+
+// restore takes the counts of the histogram from the samples of a
+// previous snapshot, if its buckets had the same bounds.
+func (x *Histogram) restore(samples map[string]float64) bool {
+	cumulative := make([]uint64, len(x.Counts))
+	for i := range x.Counts {
+		le := "+Inf"
+		if i < len(x.Bounds) {
+			le = strconv.FormatFloat(x.Bounds[i], 'g', -1, 64)
+		}
+		v, ok := samples[x.Name+"_bucket{le=\""+le+"\"}"]
+		if !ok {
+			return false
+		}
+		cumulative[i] = uint64(v)
+	}
+	buckets := 0
+	for name := range samples {
+		if strings.HasPrefix(name, x.Name+"_bucket{") {
+			buckets++
+		}
+	}
+	if buckets != len(x.Counts) {
+		return false
+	}
+	var previous uint64
+	for i, c := range cumulative {
+		if c < previous {
+			return false
+		}
+		x.Counts[i] = c - previous
+		previous = c
+	}
+	x.Sum = samples[x.Name+"_sum"]
+	return true
+}
+
+// parseBuckets parses increasing, comma separated bucket bounds.
+func parseBuckets(s string) ([]float64, error) {
+	var bounds []float64
+	for _, field := range strings.Split(s, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket bound %q", field)
+		}
+		if len(bounds) > 0 && v <= bounds[len(bounds)-1] {
+			return nil, errors.New("bucket bounds must be increasing")
+		}
+		bounds = append(bounds, v)
+	}
+	return bounds, nil
+}
+
+// Metrics is a snapshot of server stats and per-job histograms,
+// rewritten atomically to a file for node_exporter's textfile collector.
+type Metrics struct {
+	mutex     sync.Mutex
+	path      string
+	queueWait *Histogram
+	extract   *Histogram
+	size      *Histogram
+}
+
+// LoadMetrics creates the histograms with the given bucket bounds,
+// carrying over the counts of the snapshot at path from a previous run.
+// A histogram whose bounds have changed starts over.
+func LoadMetrics(path string, queueWait, extract, size []float64) (*Metrics, error) {
+	m := &Metrics{
+		path:      path,
+		queueWait: NewHistogram("sliceosm_queue_wait_seconds", "Time jobs waited in the queue for a worker.", queueWait),
+		extract:   NewHistogram("sliceosm_extract_duration_seconds", "Time from a worker starting a job to its completion.", extract),
+		size:      NewHistogram("sliceosm_output_size_bytes", "Size of completed pbf results.", size),
+	}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	} else if err != nil {
+		return nil, err
+	}
+	samples := make(map[string]float64)
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		line := scanner.Text()
+		i := strings.LastIndexByte(line, ' ')
+		if strings.HasPrefix(line, "#") || i < 0 {
+			continue
+		}
+		if v, err := strconv.ParseFloat(line[i+1:], 64); err == nil {
+			samples[line[:i]] = v
+		}
+	}
+	for _, x := range []*Histogram{m.queueWait, m.extract, m.size} {
+		if !x.restore(samples) {
+			fmt.Println("not restoring", x.Name, "from", path)
+		}
+	}
+	return m, nil
+}
+
+// ObserveJob records a completed job.
+func (m *Metrics) ObserveJob(wait time.Duration, elapsed time.Duration, size int64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.queueWait.Observe(wait.Seconds())
+	m.extract.Observe(elapsed.Seconds())
+	m.size.Observe(float64(size))
+}
+
+// Write replaces the snapshot with the current stats and histograms.
+func (m *Metrics) Write(stats Stats) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# HELP sliceosm_queue_size Jobs waiting for a worker.\n# TYPE sliceosm_queue_size gauge\nsliceosm_queue_size %d\n", stats.QueueSize)
+	fmt.Fprintf(&b, "# HELP sliceosm_running_jobs Jobs being extracted.\n# TYPE sliceosm_running_jobs gauge\nsliceosm_running_jobs %d\n", stats.Running)
+	fmt.Fprintf(&b, "# HELP sliceosm_workers Workers extracting jobs, including those finishing a job after a resize.\n# TYPE sliceosm_workers gauge\nsliceosm_workers %d\n", stats.WorkersRunning)
+	var pollers int64
+	for _, n := range stats.Pollers {
+		pollers += n
+	}
+	fmt.Fprintf(&b, "# HELP sliceosm_pollers Requests polling job progress.\n# TYPE sliceosm_pollers gauge\nsliceosm_pollers %d\n", pollers)
+	fmt.Fprintf(&b, "# HELP sliceosm_reclaimed_bytes_total Bytes freed in filesDir by expiring and evicting results.\n# TYPE sliceosm_reclaimed_bytes_total counter\nsliceosm_reclaimed_bytes_total %d\n", stats.ReclaimedBytes)
+	m.queueWait.write(&b)
+	m.extract.write(&b)
+	m.size.write(&b)
+	return writeFileAtomic(m.path, b.Bytes())
+}
+
+// writeStats writes the metrics snapshot, logging failures.
+func (h *Server) writeStats() {
+	if err := h.metrics.Write(h.stats()); err != nil {
+		fmt.Println(err)
+		sentry.CaptureException(err)
+	}
+}
+
+// StartStats rewrites the metrics snapshot periodically, so the
+// gauges stay current between completions.
+func (h *Server) StartStats(interval time.Duration) {
+	go func() {
+		for {
+			h.writeStats()
+			time.Sleep(interval)
+		}
+	}()
+}
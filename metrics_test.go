@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogram(t *testing.T) {
+	x := NewHistogram("test_seconds", "Test.", []float64{1, 10})
+	x.Observe(0.5)
+	x.Observe(1)
+	x.Observe(5)
+	x.Observe(100)
+	var b strings.Builder
+	x.write(&b)
+	assert.Equal(t, `# HELP test_seconds Test.
+# TYPE test_seconds histogram
+test_seconds_bucket{le="1"} 2
+test_seconds_bucket{le="10"} 3
+test_seconds_bucket{le="+Inf"} 4
+test_seconds_sum 106.5
+test_seconds_count 4
+`, b.String())
+}
+
+func TestMetricsRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.prom")
+	m, err := LoadMetrics(path, []float64{1, 10}, []float64{60}, []float64{1e6})
+	assert.Nil(t, err)
+	m.ObserveJob(2*time.Second, 30*time.Second, 2e6)
+	m.ObserveJob(20*time.Second, 90*time.Second, 1000)
+	assert.Nil(t, m.Write(Stats{QueueSize: 3}))
+	b, _ := os.ReadFile(path)
+	assert.Contains(t, string(b), "sliceosm_queue_size 3\n")
+	assert.Contains(t, string(b), `sliceosm_output_size_bytes_bucket{le="1e+06"} 1`)
+
+	m, err = LoadMetrics(path, []float64{1, 10}, []float64{60}, []float64{1e6})
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{0, 1, 1}, m.queueWait.Counts)
+	assert.Equal(t, 22.0, m.queueWait.Sum)
+	assert.Equal(t, []uint64{1, 1}, m.size.Counts)
+
+	// the histogram with new bounds starts over, the others carry on.
+	m, err = LoadMetrics(path, []float64{1, 10}, []float64{30, 60}, []float64{1e6})
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{0, 0, 0}, m.extract.Counts)
+	assert.Equal(t, []uint64{0, 1, 1}, m.queueWait.Counts)
+}
+
+func TestParseBuckets(t *testing.T) {
+	bounds, err := parseBuckets("1, 10,1e3")
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{1, 10, 1000}, bounds)
+	_, err = parseBuckets("10,1")
+	assert.NotNil(t, err)
+	_, err = parseBuckets("1,x")
+	assert.NotNil(t, err)
+}
+
+func TestStatsOnCompletion(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.StartWorkers()
+	_, id := submit(h, richmond)
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, id)
+		return progress.Complete
+	})
+	b, err := os.ReadFile(filepath.Join(h.filesDir, "stats.prom"))
+	assert.Nil(t, err)
+	assert.Contains(t, string(b), "sliceosm_extract_duration_seconds_count 1\n")
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/paulmach/orb/maptile"
+)
+
+// most tiles in one POST /api/nodes request.
+const maxNodesTiles = 1024
+
+// rasterGeneration identifies the loaded node density raster, so
+// cached tile values are revalidated when it changes.
+var rasterGeneration = sync.OnceValue(func() string {
+	sum := sha256.Sum256(imageBytes)
+	return hex.EncodeToString(sum[:8])
+})
+
+// parseTile reads a z/x/y tile in the zoom range coverRegion uses.
+func parseTile(s string) (maptile.Tile, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 3 {
+		return maptile.Tile{}, fmt.Errorf("tile %q is not z/x/y", s)
+	}
+	var n [3]uint64
+	for i, part := range parts {
+		v, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return maptile.Tile{}, fmt.Errorf("tile %q is not z/x/y", s)
+		}
+		n[i] = v
+	}
+	if n[0] > 14 || n[1] >= 1<<n[0] || n[2] >= 1<<n[0] {
+		return maptile.Tile{}, fmt.Errorf("tile %q is out of range", s)
+	}
+	return maptile.New(uint32(n[1]), uint32(n[2]), maptile.Zoom(n[0])), nil
+}
+
+// tileEstimate uses the same GetPixel as GetSum, so clients summing
+// Values and multiplying by 32 match the server's estimate exactly.
+func (h *Server) tileEstimate(t maptile.Tile) TileEstimate {
+	pixel := GetPixel(h.image, int(t.Z), int(t.X), int(t.Y))
+	b := t.Bound()
+	return TileEstimate{
+		Tile:   fmt.Sprintf("%d/%d/%d", t.Z, t.X, t.Y),
+		Value:  pixel,
+		Nodes:  int(pixel * 32),
+		Bounds: [4]float64{b.Min[0], b.Min[1], b.Max[0], b.Max[1]},
+	}
+}
+
+// serveNodes handles GET /api/nodes/{z}/{x}/{y} and POST /api/nodes
+// with a body of {"Tiles": ["z/x/y", ...]}.
+func (h *Server) serveNodes(w http.ResponseWriter, r *http.Request) {
+	etag := `"` + rasterGeneration() + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+
+	if r.Method == "GET" {
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(304)
+			return
+		}
+		t, err := parseTile(strings.TrimPrefix(r.URL.Path, "/api/nodes/"))
+		if err != nil {
+			w.WriteHeader(400)
+			fmt.Fprintf(w, "Error: %s", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h.tileEstimate(t))
+		return
+	}
+
+	var body struct{ Tiles []string }
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, `Error: body must be {"Tiles": ["z/x/y", ...]}`)
+		return
+	}
+	if len(body.Tiles) > maxNodesTiles {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, "Error: at most %d tiles can be requested at once", maxNodesTiles)
+		return
+	}
+	tiles := make([]TileEstimate, 0, len(body.Tiles))
+	for _, s := range body.Tiles {
+		t, err := parseTile(s)
+		if err != nil {
+			w.WriteHeader(400)
+			fmt.Fprintf(w, "Error: %s", err)
+			return
+		}
+		tiles = append(tiles, h.tileEstimate(t))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct{ Tiles []TileEstimate }{tiles})
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServeNodesTile(t *testing.T) {
+	h := newTestServer(t, "osmx")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/nodes/14/2620/6331", nil))
+	assert.Equal(t, 200, w.Code)
+	var tile TileEstimate
+	json.NewDecoder(w.Body).Decode(&tile)
+	assert.Equal(t, 249.125, tile.Value)
+	assert.Equal(t, 7972, tile.Nodes)
+	etag := w.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	r := httptest.NewRequest("GET", "/api/nodes/14/2620/6331", nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, 304, w.Code)
+
+	for _, path := range []string{"/api/nodes/15/0/0", "/api/nodes/2/4/0", "/api/nodes/a/b/c", "/api/nodes/1/0"} {
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		assert.Equal(t, 400, w.Code, path)
+	}
+}
+
+// summing the batch values matches the server's own estimate.
+func TestServeNodesBatch(t *testing.T) {
+	h := newTestServer(t, "osmx")
+	geom := orb.Bound{Min: orb.Point{-77.4571, 37.5272}, Max: orb.Point{-77.4133, 37.553}}
+	covering, _ := coverRegion(geom)
+	var tiles []string
+	for tile := range covering {
+		tiles = append(tiles, fmt.Sprintf("%d/%d/%d", tile.Z, tile.X, tile.Y))
+	}
+	body, _ := json.Marshal(map[string][]string{"Tiles": tiles})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/api/nodes", strings.NewReader(string(body))))
+	assert.Equal(t, 200, w.Code)
+	var result struct{ Tiles []TileEstimate }
+	json.NewDecoder(w.Body).Decode(&result)
+	sum := 0.0
+	for _, tile := range result.Tiles {
+		sum += tile.Value
+	}
+	assert.Equal(t, GetSum(h.image, geom), int(sum*32))
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/api/nodes", strings.NewReader(`{"Tiles":["20/0/0"]}`)))
+	assert.Equal(t, 400, w.Code)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// An S3 compatible bucket that completed results and their records are
+// uploaded to, so that downloads can be served from it or a CDN in front
+// of it. Google Cloud Storage is reached through its XML API with an
+// HMAC key. Objects are never deleted by the server.
+type ObjectStore struct {
+	endpoint  *url.URL // the bucket, with any key prefix
+	publicUrl string
+	region    string
+	keys      objectStoreKeys
+	client    *http.Client
+}
+
+// the content of -objectStoreKeyFile.
+type objectStoreKeys struct {
+	AccessKeyId     string
+	SecretAccessKey string
+}
+
+func loadObjectStore(storeUrl string, publicUrl string, region string, keyFile string) (*ObjectStore, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(storeUrl, "/"))
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, errors.New("-objectStoreUrl must be an absolute http or https URL")
+	}
+	if keyFile == "" {
+		return nil, errors.New("-objectStoreUrl needs -objectStoreKeyFile")
+	}
+	b, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	var keys objectStoreKeys
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return nil, err
+	}
+	if keys.AccessKeyId == "" || keys.SecretAccessKey == "" {
+		return nil, errors.New("the key file needs an AccessKeyId and a SecretAccessKey")
+	}
+	if publicUrl == "" {
+		publicUrl = endpoint.String()
+	}
+	return &ObjectStore{
+		endpoint:  endpoint,
+		publicUrl: strings.TrimSuffix(publicUrl, "/"),
+		region:    region,
+		keys:      keys,
+		// uploads of large results are only bounded by the job's context.
+		client: &http.Client{},
+	}, nil
+}
+
+// URL is where the object of a name is served from.
+func (s *ObjectStore) URL(name string) string {
+	return s.publicUrl + "/" + name
+}
+
+// PutFile uploads a file as the object of a name. sum is the hex
+// SHA-256 of its content.
+func (s *ObjectStore) PutFile(ctx context.Context, name string, path string, sum string, contentType string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return s.put(ctx, name, f, stat.Size(), sum, contentType)
+}
+
+// PutBytes uploads b as the object of a name.
+func (s *ObjectStore) PutBytes(ctx context.Context, name string, b []byte, contentType string) error {
+	sum := sha256.Sum256(b)
+	return s.put(ctx, name, bytes.NewReader(b), int64(len(b)), hex.EncodeToString(sum[:]), contentType)
+}
+
+func (s *ObjectStore) put(ctx context.Context, name string, body io.Reader, size int64, sum string, contentType string) error {
+	req, err := http.NewRequestWithContext(ctx, "PUT", s.endpoint.JoinPath(name).String(), body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+	s.sign(req, sum, time.Now())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("uploading %s: %w", name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		reply, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("uploading %s: %s %s", name, resp.Status, bytes.TrimSpace(reply))
+	}
+	return nil
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to a
+// request without a query, covering the host and every header already
+// set. payloadHash is the hex SHA-256 of the body.
+func (s *ObjectStore) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	values := map[string]string{"host": req.URL.Host}
+	for name, v := range req.Header {
+		values[strings.ToLower(name)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var headers strings.Builder
+	for _, name := range names {
+		headers.WriteString(name + ":" + values[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonical := strings.Join([]string{req.Method, req.URL.EscapedPath(), "", headers.String(), signedHeaders, payloadHash}, "\n")
+	canonicalSum := sha256.Sum256([]byte(canonical))
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalSum[:])
+	key := []byte("AWS4" + s.keys.SecretAccessKey)
+	for _, part := range []string{date, s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.keys.AccessKeyId, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// uploadResult uploads the pbf of a completed job, returning its public
+// URL, or "" with a warning if it couldn't be uploaded.
+func (h *Server) uploadResult(ctx context.Context, id string, path string, sum string) (string, *Warning) {
+	name := id + ".osm.pbf"
+	if err := h.objectStore.PutFile(ctx, name, path, sum, "application/octet-stream"); err != nil {
+		fmt.Println(err)
+		sentry.CaptureException(err)
+		return "", &Warning{warningUploadFailed, "the result couldn't be uploaded to object storage and is only served by the API"}
+	}
+	return h.objectStore.URL(name), nil
+}
+
+// uploadRecord uploads the completion record of a job uploaded with
+// uploadResult.
+func (h *Server) uploadRecord(ctx context.Context, id string, record []byte) {
+	if err := h.objectStore.PutBytes(ctx, id+".json", record, "application/json"); err != nil {
+		fmt.Println(err)
+		sentry.CaptureException(err)
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how long a login lasts, and how long the OAuth round trip may take.
+const sessionExpiry = 30 * 24 * time.Hour
+const loginExpiry = 10 * time.Minute
+
+const sessionCookie = "sliceosm_session"
+const stateCookie = "sliceosm_oauth_state"
+
+// An OpenStreetMap account, as returned by GET /api/me.
+type OSMUser struct {
+	Id          int64
+	DisplayName string
+}
+
+type session struct {
+	user    OSMUser
+	expires time.Time
+}
+
+// OSMAuth logs users in with their openstreetmap.org account over
+// OAuth 2.0. Sessions are only held in memory, so users log in again
+// after a restart.
+type OSMAuth struct {
+	clientId     string
+	clientSecret string
+	redirectUrl  string
+	osmUrl       string
+	client       *http.Client
+
+	mutex    sync.Mutex
+	sessions map[string]*session // by the SHA-256 of the cookie
+}
+
+func loadOSMAuth(clientId string, secretFile string, redirectUrl string, osmUrl string) (*OSMAuth, error) {
+	b, err := os.ReadFile(secretFile)
+	if err != nil {
+		return nil, err
+	}
+	secret := strings.TrimSpace(string(b))
+	if secret == "" {
+		return nil, errors.New("the client secret is empty")
+	}
+	if u, err := url.Parse(redirectUrl); err != nil || u.Host == "" {
+		return nil, errors.New("-osmRedirectUrl must be the absolute URL of /api/auth/callback")
+	}
+	return &OSMAuth{
+		clientId:     clientId,
+		clientSecret: secret,
+		redirectUrl:  redirectUrl,
+		osmUrl:       strings.TrimSuffix(osmUrl, "/"),
+		client:       &http.Client{Timeout: 10 * time.Second},
+		sessions:     make(map[string]*session),
+	}, nil
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// secure is whether cookies are only sent over https, as the callback is.
+func (a *OSMAuth) secure() bool {
+	return strings.HasPrefix(a.redirectUrl, "https:")
+}
+
+// setCookie sets a cookie for maxAge, or deletes it if maxAge is negative.
+func (a *OSMAuth) setCookie(w http.ResponseWriter, name string, value string, maxAge time.Duration) {
+	seconds := int(maxAge.Seconds())
+	if maxAge < 0 {
+		seconds = -1
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/api/",
+		MaxAge:   seconds,
+		HttpOnly: true,
+		Secure:   a.secure(),
+		// not sent with cross-site POSTs, so another site can't submit
+		// jobs as the user.
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// User is the logged in user of a request, or nil.
+func (a *OSMAuth) User(r *http.Request, now time.Time) *OSMUser {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	s, ok := a.sessions[hashToken(cookie.Value)]
+	if !ok || now.After(s.expires) {
+		return nil
+	}
+	user := s.user
+	return &user
+}
+
+// returnPath is where to send the user after logging in: a path on this
+// server, never another site.
+func returnPath(s string) string {
+	if !strings.HasPrefix(s, "/") || strings.HasPrefix(s, "//") || strings.HasPrefix(s, "/\\") {
+		return "/"
+	}
+	return s
+}
+
+// serveLogin handles GET /api/auth/login?return=, redirecting to the
+// openstreetmap.org authorization page.
+func (a *OSMAuth) serveLogin(w http.ResponseWriter, r *http.Request) {
+	state := randomToken()
+	a.setCookie(w, stateCookie, state+"|"+url.QueryEscape(returnPath(r.URL.Query().Get("return"))), loginExpiry)
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {a.clientId},
+		"redirect_uri":  {a.redirectUrl},
+		"scope":         {"read_prefs"},
+		"state":         {state},
+	}
+	http.Redirect(w, r, a.osmUrl+"/oauth2/authorize?"+query.Encode(), http.StatusFound)
+}
+
+// exchange trades an authorization code for the account that granted it.
+func (a *OSMAuth) exchange(code string) (OSMUser, error) {
+	resp, err := a.client.PostForm(a.osmUrl+"/oauth2/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {a.redirectUrl},
+		"client_id":     {a.clientId},
+		"client_secret": {a.clientSecret},
+	})
+	if err != nil {
+		return OSMUser{}, err
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	resp.Body.Close()
+	if resp.StatusCode != 200 || err != nil || token.AccessToken == "" {
+		return OSMUser{}, fmt.Errorf("token request failed with status %d", resp.StatusCode)
+	}
+
+	req, err := http.NewRequest("GET", a.osmUrl+"/api/0.6/user/details.json", nil)
+	if err != nil {
+		return OSMUser{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	resp, err = a.client.Do(req)
+	if err != nil {
+		return OSMUser{}, err
+	}
+	defer resp.Body.Close()
+	var details struct {
+		User struct {
+			Id          int64  `json:"id"`
+			DisplayName string `json:"display_name"`
+		} `json:"user"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&details); resp.StatusCode != 200 || err != nil || details.User.Id == 0 {
+		return OSMUser{}, fmt.Errorf("user details request failed with status %d", resp.StatusCode)
+	}
+	return OSMUser{Id: details.User.Id, DisplayName: details.User.DisplayName}, nil
+}
+
+// serveCallback handles GET /api/auth/callback?code=&state=, where
+// openstreetmap.org sends the user back, and starts a session.
+func (a *OSMAuth) serveCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	cookie, err := r.Cookie(stateCookie)
+	var state, escaped string
+	if err == nil {
+		state, escaped, _ = strings.Cut(cookie.Value, "|")
+	}
+	if state == "" || query.Get("state") != state {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, "Error: the login expired or was started elsewhere, please log in again")
+		return
+	}
+	a.setCookie(w, stateCookie, "", -1)
+	if query.Get("code") == "" {
+		// the user declined, or OSM returned an error.
+		w.WriteHeader(403)
+		fmt.Fprintf(w, "Error: the login was not authorized")
+		return
+	}
+	user, err := a.exchange(query.Get("code"))
+	if err != nil {
+		fmt.Println("osm login:", err)
+		w.WriteHeader(502)
+		fmt.Fprintf(w, "Error: logging in with OpenStreetMap failed")
+		return
+	}
+
+	token := randomToken()
+	now := time.Now()
+	a.mutex.Lock()
+	for hash, s := range a.sessions {
+		if now.After(s.expires) {
+			delete(a.sessions, hash)
+		}
+	}
+	a.sessions[hashToken(token)] = &session{user: user, expires: now.Add(sessionExpiry)}
+	a.mutex.Unlock()
+	a.setCookie(w, sessionCookie, token, sessionExpiry)
+	path, _ := url.QueryUnescape(escaped)
+	http.Redirect(w, r, returnPath(path), http.StatusFound)
+}
+
+// serveLogout handles POST /api/auth/logout.
+func (a *OSMAuth) serveLogout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		a.mutex.Lock()
+		delete(a.sessions, hashToken(cookie.Value))
+		a.mutex.Unlock()
+	}
+	a.setCookie(w, sessionCookie, "", -1)
+	w.WriteHeader(204)
+}
+
+// serveAuth routes /api/auth/.
+func (h *Server) serveAuth(w http.ResponseWriter, r *http.Request) {
+	if h.osmAuth == nil {
+		w.WriteHeader(404)
+		return
+	}
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/api/auth/login":
+		h.osmAuth.serveLogin(w, r)
+	case r.Method == "GET" && r.URL.Path == "/api/auth/callback":
+		h.osmAuth.serveCallback(w, r)
+	case r.Method == "POST" && r.URL.Path == "/api/auth/logout":
+		h.osmAuth.serveLogout(w, r)
+	default:
+		w.WriteHeader(404)
+	}
+}
+
+// osmUser is the logged in user of a request, or nil.
+func (h *Server) osmUser(r *http.Request) *OSMUser {
+	if h.osmAuth == nil {
+		return nil
+	}
+	return h.osmAuth.User(r, time.Now())
+}
+
+// nodesLimitFor is the nodes limit of a request: -userNodesLimit for
+// logged in users if it is higher.
+func (h *Server) nodesLimitFor(r *http.Request, settings Settings) int {
+	if h.userNodesLimit > settings.NodesLimit && h.osmUser(r) != nil {
+		return h.userNodesLimit
+	}
+	return settings.NodesLimit
+}
+
+// serveMe handles GET /api/me and GET /api/me/jobs.
+func (h *Server) serveMe(w http.ResponseWriter, r *http.Request) {
+	user := h.osmUser(r)
+	if user == nil {
+		w.WriteHeader(401)
+		fmt.Fprintf(w, "Error: not logged in")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Path == "/api/me" {
+		json.NewEncoder(w).Encode(user)
+		return
+	}
+	json.NewEncoder(w).Encode(JobSummaries{Jobs: h.userJobs.Summaries(h, user.Id)})
+}
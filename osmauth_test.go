@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeOSM is an openstreetmap.org that grants the code "good".
+func fakeOSM(t *testing.T) *httptest.Server {
+	osm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/oauth2/token":
+			r.ParseForm()
+			if r.PostForm.Get("code") != "good" || r.PostForm.Get("client_secret") != "s3cret" {
+				w.WriteHeader(400)
+				return
+			}
+			w.Write([]byte(`{"access_token": "token", "token_type": "Bearer"}`))
+		case "/api/0.6/user/details.json":
+			if r.Header.Get("Authorization") != "Bearer token" {
+				w.WriteHeader(401)
+				return
+			}
+			w.Write([]byte(`{"user": {"id": 42, "display_name": "mapper"}}`))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	t.Cleanup(osm.Close)
+	return osm
+}
+
+// login logs in through the fake OSM and returns the session cookie.
+func login(t *testing.T, h *Server) *http.Cookie {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/auth/login?return=/jobs", nil))
+	assert.Equal(t, 302, w.Code)
+	location, _ := url.Parse(w.Header().Get("Location"))
+	assert.Equal(t, "/oauth2/authorize", location.Path)
+	state := location.Query().Get("state")
+	stateCookie := w.Result().Cookies()[0]
+
+	r := httptest.NewRequest("GET", "/api/auth/callback?code=good&state="+state, nil)
+	r.AddCookie(stateCookie)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, 302, w.Code)
+	assert.Equal(t, "/jobs", w.Header().Get("Location"))
+	for _, c := range w.Result().Cookies() {
+		if c.Name == sessionCookie {
+			return c
+		}
+	}
+	t.Fatal("no session cookie")
+	return nil
+}
+
+func withOSMAuth(t *testing.T, h *Server) *Server {
+	secret := filepath.Join(t.TempDir(), "secret")
+	os.WriteFile(secret, []byte("s3cret\n"), 0600)
+	auth, err := loadOSMAuth("client", secret, "https://sliceosm.example/api/auth/callback", fakeOSM(t).URL)
+	assert.Nil(t, err)
+	h.osmAuth = auth
+	h.userJobs, _ = LoadUserJobs(h.filesDir)
+	return h
+}
+
+func TestOSMLogin(t *testing.T) {
+	h := withOSMAuth(t, newTestServer(t, fakeOsmx(t, "")))
+	session := login(t, h)
+	assert.True(t, session.HttpOnly)
+	assert.True(t, session.Secure)
+
+	r := httptest.NewRequest("GET", "/api/me", nil)
+	r.AddCookie(session)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, 200, w.Code)
+	var user OSMUser
+	json.NewDecoder(w.Body).Decode(&user)
+	assert.Equal(t, OSMUser{Id: 42, DisplayName: "mapper"}, user)
+
+	r = httptest.NewRequest("POST", "/api/auth/logout", nil)
+	r.AddCookie(session)
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	r = httptest.NewRequest("GET", "/api/me", nil)
+	r.AddCookie(session)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, 401, w.Code)
+}
+
+func TestOSMLoginRejected(t *testing.T) {
+	h := withOSMAuth(t, newTestServer(t, fakeOsmx(t, "")))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/auth/login?return=//evil.example", nil))
+	state := w.Result().Cookies()[0]
+	assert.True(t, strings.HasSuffix(state.Value, "|%2F"))
+
+	// without the state cookie of the browser that started the login.
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/auth/callback?code=good&state=forged", nil))
+	assert.Equal(t, 400, w.Code)
+
+	value, _, _ := strings.Cut(state.Value, "|")
+	r := httptest.NewRequest("GET", "/api/auth/callback?code=bad&state="+value, nil)
+	r.AddCookie(state)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, 502, w.Code)
+
+	// not configured.
+	h.osmAuth = nil
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/auth/login", nil))
+	assert.Equal(t, 404, w.Code)
+}
+
+func TestUserNodesLimit(t *testing.T) {
+	h := withOSMAuth(t, newTestServer(t, fakeOsmx(t, "")))
+	// no workers: submissions stay queued.
+	h.queue = NewScheduler("fifo", 10)
+	h.progress = make(map[string]Progress)
+	h.nodesLimit = 1
+	h.userNodesLimit = 100000000
+	session := login(t, h)
+
+	code, _ := submit(h, richmond)
+	assert.Equal(t, 400, code)
+
+	r := httptest.NewRequest("POST", "/api/", strings.NewReader(richmond))
+	r.AddCookie(session)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, 201, w.Code)
+	var created Created
+	json.NewDecoder(w.Body).Decode(&created)
+
+	r = httptest.NewRequest("GET", "/api/me/jobs", nil)
+	r.AddCookie(session)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	var page JobSummaries
+	json.NewDecoder(w.Body).Decode(&page)
+	assert.Len(t, page.Jobs, 1)
+	assert.Equal(t, created.Uuid, page.Jobs[0].Uuid)
+	assert.Equal(t, "queued", page.Jobs[0].Status)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/me/jobs", nil))
+	assert.Equal(t, 401, w.Code)
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"math"
+	"sort"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/planar"
+)
+
+// overlay rebuilds polygons from the edges of the input rings, keeping
+// every edge that separates a point where inside is true from one where
+// it is false. Union, difference and clipping are all expressed as an
+// inside predicate over the same set of candidate edges, which keeps the
+// output free of overlaps and self-intersections.
+func overlay(rings []orb.Ring, inside func(orb.Point) bool) orb.MultiPolygon {
+	var segs []segment
+	for _, ring := range rings {
+		for i := 0; i+1 < len(ring); i++ {
+			if ring[i] != ring[i+1] {
+				segs = append(segs, segment{ring[i], ring[i+1]})
+			}
+		}
+		if len(ring) > 1 && ring[0] != ring[len(ring)-1] {
+			segs = append(segs, segment{ring[len(ring)-1], ring[0]})
+		}
+	}
+
+	edges := splitSegments(segs)
+
+	var kept []segment
+	for _, e := range edges {
+		dx, dy := e.b[0]-e.a[0], e.b[1]-e.a[1]
+		length := math.Hypot(dx, dy)
+		eps := math.Max(length*1e-4, 1e-12)
+		nx, ny := -dy/length*eps, dx/length*eps
+		mid := orb.Point{(e.a[0] + e.b[0]) / 2, (e.a[1] + e.b[1]) / 2}
+		left := inside(orb.Point{mid[0] + nx, mid[1] + ny})
+		right := inside(orb.Point{mid[0] - nx, mid[1] - ny})
+		if left == right {
+			continue
+		}
+		if left {
+			kept = append(kept, e)
+		} else {
+			kept = append(kept, segment{e.b, e.a})
+		}
+	}
+
+	return assemblePolygons(linkRings(kept))
+}
+
+// unionPolygons dissolves overlapping polygons into a single MultiPolygon.
+func unionPolygons(polys []orb.Polygon) orb.MultiPolygon {
+	index := newPolygonIndex(polys)
+	return overlay(polygonRings(polys), index.contains)
+}
+
+// differencePolygons removes every part of b from a.
+func differencePolygons(a orb.MultiPolygon, b orb.MultiPolygon) orb.MultiPolygon {
+	ia := newPolygonIndex(a)
+	ib := newPolygonIndex(b)
+	rings := append(polygonRings(a), polygonRings(b)...)
+	return overlay(rings, func(p orb.Point) bool {
+		return ia.contains(p) && !ib.contains(p)
+	})
+}
+
+// clipPolygons returns the part of mp within the bound.
+func clipPolygons(mp orb.MultiPolygon, bound orb.Bound) orb.MultiPolygon {
+	index := newPolygonIndex(mp)
+	rings := append(polygonRings(mp), bound.ToRing())
+	return overlay(rings, func(p orb.Point) bool {
+		return bound.Contains(p) && index.contains(p)
+	})
+}
+
+func polygonRings(polys []orb.Polygon) []orb.Ring {
+	var rings []orb.Ring
+	for _, p := range polys {
+		rings = append(rings, p...)
+	}
+	return rings
+}
+
+type segment struct {
+	a, b orb.Point
+}
+
+func (s segment) bound() orb.Bound {
+	return orb.Bound{Min: s.a, Max: s.a}.Extend(s.b)
+}
+
+func cross(o, a, b orb.Point) float64 {
+	return (a[0]-o[0])*(b[1]-o[1]) - (a[1]-o[1])*(b[0]-o[0])
+}
+
+// onSegment reports whether p, already known to be collinear with s,
+// lies strictly between its endpoints.
+func onSegment(s segment, p orb.Point) bool {
+	if p == s.a || p == s.b {
+		return false
+	}
+	return s.bound().Contains(p)
+}
+
+// splitSegments cuts every segment at its intersections with the others
+// and drops duplicates, so that the result only meets at endpoints.
+func splitSegments(segs []segment) []segment {
+	bounds := make([]orb.Bound, len(segs))
+	for i, s := range segs {
+		bounds[i] = s.bound()
+	}
+	grid := newGridIndex(bounds)
+
+	cuts := make([][]orb.Point, len(segs))
+	for i, s := range segs {
+		cuts[i] = []orb.Point{s.a, s.b}
+	}
+
+	for i, s := range segs {
+		grid.query(bounds[i], func(j int) {
+			if j <= i || !bounds[i].Intersects(bounds[j]) {
+				return
+			}
+			t := segs[j]
+			d1 := cross(s.a, s.b, t.a)
+			d2 := cross(s.a, s.b, t.b)
+			d3 := cross(t.a, t.b, s.a)
+			d4 := cross(t.a, t.b, s.b)
+			if ((d1 > 0 && d2 < 0) || (d1 < 0 && d2 > 0)) && ((d3 > 0 && d4 < 0) || (d3 < 0 && d4 > 0)) {
+				f := d3 / (d3 - d4)
+				p := orb.Point{s.a[0] + (s.b[0]-s.a[0])*f, s.a[1] + (s.b[1]-s.a[1])*f}
+				cuts[i] = append(cuts[i], p)
+				cuts[j] = append(cuts[j], p)
+				return
+			}
+			if d1 == 0 && onSegment(s, t.a) {
+				cuts[i] = append(cuts[i], t.a)
+			}
+			if d2 == 0 && onSegment(s, t.b) {
+				cuts[i] = append(cuts[i], t.b)
+			}
+			if d3 == 0 && onSegment(t, s.a) {
+				cuts[j] = append(cuts[j], s.a)
+			}
+			if d4 == 0 && onSegment(t, s.b) {
+				cuts[j] = append(cuts[j], s.b)
+			}
+		})
+	}
+
+	seen := make(map[segment]bool)
+	var out []segment
+	for i, s := range segs {
+		pts := cuts[i]
+		dx, dy := s.b[0]-s.a[0], s.b[1]-s.a[1]
+		sort.Slice(pts, func(x, y int) bool {
+			return (pts[x][0]-s.a[0])*dx+(pts[x][1]-s.a[1])*dy < (pts[y][0]-s.a[0])*dx+(pts[y][1]-s.a[1])*dy
+		})
+		for k := 0; k+1 < len(pts); k++ {
+			a, b := pts[k], pts[k+1]
+			if a == b {
+				continue
+			}
+			key := segment{a, b}
+			if b[0] < a[0] || (b[0] == a[0] && b[1] < a[1]) {
+				key = segment{b, a}
+			}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			out = append(out, segment{a, b})
+		}
+	}
+	return out
+}
+
+// linkRings walks directed edges into closed rings, always taking the
+// sharpest left turn so that the region stays on the left of every ring.
+func linkRings(edges []segment) []orb.Ring {
+	outgoing := make(map[orb.Point][]int)
+	for i, e := range edges {
+		outgoing[e.a] = append(outgoing[e.a], i)
+	}
+	used := make([]bool, len(edges))
+
+	var rings []orb.Ring
+	for start := range edges {
+		if used[start] {
+			continue
+		}
+		ring := orb.Ring{edges[start].a}
+		cur := start
+		closed := false
+		for {
+			used[cur] = true
+			e := edges[cur]
+			if e.b == ring[0] {
+				closed = true
+				break
+			}
+			ring = append(ring, e.b)
+
+			back := math.Atan2(e.a[1]-e.b[1], e.a[0]-e.b[0])
+			next := -1
+			best := math.Inf(1)
+			for _, k := range outgoing[e.b] {
+				if used[k] {
+					continue
+				}
+				n := edges[k]
+				angle := back - math.Atan2(n.b[1]-n.a[1], n.b[0]-n.a[0])
+				for angle <= 0 {
+					angle += 2 * math.Pi
+				}
+				for angle > 2*math.Pi {
+					angle -= 2 * math.Pi
+				}
+				if angle < best {
+					best = angle
+					next = k
+				}
+			}
+			if next < 0 {
+				break
+			}
+			cur = next
+		}
+		if closed && len(ring) >= 3 {
+			rings = append(rings, append(ring, ring[0]))
+		}
+	}
+	return rings
+}
+
+// assemblePolygons groups counter-clockwise shells with the clockwise
+// holes they contain.
+func assemblePolygons(rings []orb.Ring) orb.MultiPolygon {
+	var shells, holes []orb.Ring
+	for _, r := range rings {
+		if ringArea(r) > 0 {
+			shells = append(shells, r)
+		} else {
+			holes = append(holes, r)
+		}
+	}
+
+	result := make(orb.MultiPolygon, len(shells))
+	for i, s := range shells {
+		result[i] = orb.Polygon{s}
+	}
+	for _, h := range holes {
+		// a point just left of the first edge is inside the enclosing shell
+		a, b := h[0], h[1]
+		dx, dy := b[0]-a[0], b[1]-a[1]
+		length := math.Hypot(dx, dy)
+		eps := math.Max(length*1e-4, 1e-12)
+		probe := orb.Point{(a[0]+b[0])/2 - dy/length*eps, (a[1]+b[1])/2 + dx/length*eps}
+
+		owner := -1
+		for i, s := range shells {
+			if !s.Bound().Contains(probe) || !planar.RingContains(s, probe) {
+				continue
+			}
+			if owner < 0 || ringArea(s) < ringArea(shells[owner]) {
+				owner = i
+			}
+		}
+		if owner >= 0 {
+			result[owner] = append(result[owner], h)
+		}
+	}
+	return result
+}
+
+// ringArea is the signed shoelace area, positive for counter-clockwise rings.
+func ringArea(r orb.Ring) float64 {
+	sum := 0.0
+	for i := 0; i+1 < len(r); i++ {
+		sum += r[i][0]*r[i+1][1] - r[i+1][0]*r[i][1]
+	}
+	return sum / 2
+}
+
+// polygonIndex answers "is this point inside any of the polygons".
+type polygonIndex struct {
+	polys  []orb.Polygon
+	bounds []orb.Bound
+	grid   *gridIndex
+}
+
+func newPolygonIndex(polys []orb.Polygon) *polygonIndex {
+	bounds := make([]orb.Bound, len(polys))
+	for i, p := range polys {
+		bounds[i] = p.Bound()
+	}
+	return &polygonIndex{polys: polys, bounds: bounds, grid: newGridIndex(bounds)}
+}
+
+func (ix *polygonIndex) contains(p orb.Point) bool {
+	found := false
+	ix.grid.query(orb.Bound{Min: p, Max: p}, func(i int) {
+		if !found && ix.bounds[i].Contains(p) && planar.PolygonContains(ix.polys[i], p) {
+			found = true
+		}
+	})
+	return found
+}
+
+// gridIndex is a uniform grid over item bounds, sized so that each cell
+// holds a handful of items on average.
+type gridIndex struct {
+	bound  orb.Bound
+	nx, ny int
+	cells  [][]int
+}
+
+func newGridIndex(bounds []orb.Bound) *gridIndex {
+	g := &gridIndex{nx: 1, ny: 1}
+	if len(bounds) == 0 {
+		g.cells = make([][]int, 1)
+		return g
+	}
+	g.bound = bounds[0]
+	for _, b := range bounds[1:] {
+		g.bound = g.bound.Union(b)
+	}
+	n := int(math.Ceil(math.Sqrt(float64(len(bounds)))))
+	w, h := g.bound.Max[0]-g.bound.Min[0], g.bound.Max[1]-g.bound.Min[1]
+	if w > 0 && h > 0 {
+		aspect := w / h
+		g.nx = clampInt(int(math.Round(float64(n)*math.Sqrt(aspect))), 1, 4*n)
+		g.ny = clampInt(int(math.Round(float64(n)/math.Sqrt(aspect))), 1, 4*n)
+	} else if w > 0 {
+		g.nx = n
+	} else if h > 0 {
+		g.ny = n
+	}
+	g.cells = make([][]int, g.nx*g.ny)
+	for i, b := range bounds {
+		x0, y0, x1, y1 := g.cellRange(b)
+		for x := x0; x <= x1; x++ {
+			for y := y0; y <= y1; y++ {
+				g.cells[y*g.nx+x] = append(g.cells[y*g.nx+x], i)
+			}
+		}
+	}
+	return g
+}
+
+func (g *gridIndex) cellRange(b orb.Bound) (int, int, int, int) {
+	cell := func(v, min, max float64, n int) int {
+		if max <= min {
+			return 0
+		}
+		return clampInt(int((v-min)/(max-min)*float64(n)), 0, n-1)
+	}
+	return cell(b.Min[0], g.bound.Min[0], g.bound.Max[0], g.nx),
+		cell(b.Min[1], g.bound.Min[1], g.bound.Max[1], g.ny),
+		cell(b.Max[0], g.bound.Min[0], g.bound.Max[0], g.nx),
+		cell(b.Max[1], g.bound.Min[1], g.bound.Max[1], g.ny)
+}
+
+// query calls fn once for every item whose cells overlap b.
+func (g *gridIndex) query(b orb.Bound, fn func(int)) {
+	if !g.bound.Intersects(b) {
+		return
+	}
+	x0, y0, x1, y1 := g.cellRange(b)
+	if x0 == x1 && y0 == y1 {
+		for _, i := range g.cells[y0*g.nx+x0] {
+			fn(i)
+		}
+		return
+	}
+	seen := make(map[int]bool)
+	for x := x0; x <= x1; x++ {
+		for y := y0; y <= y1; y++ {
+			for _, i := range g.cells[y*g.nx+x] {
+				if !seen[i] {
+					seen[i] = true
+					fn(i)
+				}
+			}
+		}
+	}
+}
+
+func clampInt(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
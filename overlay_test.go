@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/planar"
+	"github.com/stretchr/testify/assert"
+)
+
+func square(x, y, size float64) orb.Polygon {
+	return orb.Polygon{{{x, y}, {x + size, y}, {x + size, y + size}, {x, y + size}, {x, y}}}
+}
+
+func TestUnionOverlapping(t *testing.T) {
+	result := unionPolygons([]orb.Polygon{square(0, 0, 2), square(1, 1, 2)})
+	assert.Equal(t, 1, len(result))
+	assert.Equal(t, 1, len(result[0]))
+	assert.InDelta(t, 7.0, planar.Area(result), 1e-9)
+}
+
+func TestUnionDisjoint(t *testing.T) {
+	result := unionPolygons([]orb.Polygon{square(0, 0, 1), square(5, 5, 1)})
+	assert.Equal(t, 2, len(result))
+	assert.InDelta(t, 2.0, planar.Area(result), 1e-9)
+}
+
+func TestUnionSharedEdge(t *testing.T) {
+	result := unionPolygons([]orb.Polygon{square(0, 0, 1), square(1, 0, 1)})
+	assert.Equal(t, 1, len(result))
+	assert.InDelta(t, 2.0, planar.Area(result), 1e-9)
+}
+
+func TestUnionContained(t *testing.T) {
+	result := unionPolygons([]orb.Polygon{square(0, 0, 4), square(1, 1, 1)})
+	assert.Equal(t, 1, len(result))
+	assert.Equal(t, 1, len(result[0]))
+	assert.InDelta(t, 16.0, planar.Area(result), 1e-9)
+}
+
+func TestDifferenceMakesHole(t *testing.T) {
+	result := differencePolygons(orb.MultiPolygon{square(0, 0, 4)}, orb.MultiPolygon{square(1, 1, 1)})
+	assert.Equal(t, 1, len(result))
+	assert.Equal(t, 2, len(result[0]))
+	assert.InDelta(t, 15.0, planar.Area(result), 1e-9)
+	assert.True(t, ringArea(result[0][0]) > 0)
+	assert.True(t, ringArea(result[0][1]) < 0)
+}
+
+func TestDifferenceSplits(t *testing.T) {
+	result := differencePolygons(orb.MultiPolygon{square(0, 0, 3)}, orb.MultiPolygon{{{{1, -1}, {2, -1}, {2, 4}, {1, 4}, {1, -1}}}})
+	assert.Equal(t, 2, len(result))
+	assert.InDelta(t, 6.0, planar.Area(result), 1e-9)
+}
+
+func TestClipPolygons(t *testing.T) {
+	result := clipPolygons(orb.MultiPolygon{square(0, 0, 4)}, orb.Bound{Min: orb.Point{2, -1}, Max: orb.Point{6, 6}})
+	assert.Equal(t, 1, len(result))
+	assert.InDelta(t, 8.0, planar.Area(result), 1e-9)
+}
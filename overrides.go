@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const defaultOverrideMinutes = 24 * 60
+
+// The claims of a limit override token: a pre-approved submission of up
+// to MaxNodes nodes, over the hard nodes limit, before Expires. A token
+// is used up by the first submission it lets through, and the claims
+// are recorded in that job's provenance.
+type LimitOverride struct {
+	Id       string
+	MaxNodes int
+	Expires  int64
+}
+
+// LimitOverrides signs and checks the tokens of the X-Limit-Override
+// header with the secret of -limitOverrideSecretFile. The ids of used
+// tokens are persisted as overrides.json in filesDir until they expire,
+// so a token can't be used again after a restart.
+type LimitOverrides struct {
+	secret []byte
+
+	mutex sync.Mutex
+	path  string
+	used  map[string]int64
+}
+
+func loadLimitOverrides(secretFile string, filesDir string) (*LimitOverrides, error) {
+	b, err := os.ReadFile(secretFile)
+	if err != nil {
+		return nil, err
+	}
+	secret := bytes.TrimSpace(b)
+	if len(secret) == 0 {
+		return nil, errors.New("the secret is empty")
+	}
+	o := &LimitOverrides{
+		secret: secret,
+		path:   filepath.Join(filesDir, "overrides.json"),
+		used:   make(map[string]int64),
+	}
+	b, err = os.ReadFile(o.path)
+	if os.IsNotExist(err) {
+		return o, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &o.used); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func (o *LimitOverrides) signature(payload string) []byte {
+	mac := hmac.New(sha256.New, o.secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// Token encodes the claims and their signature.
+func (o *LimitOverrides) Token(claims LimitOverride) string {
+	b, _ := json.Marshal(claims)
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(o.signature(payload))
+}
+
+// Check returns the claims of a token that is signed, unexpired and
+// allows nodes.
+func (o *LimitOverrides) Check(token string, nodes int, now time.Time) (LimitOverride, error) {
+	var claims LimitOverride
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return claims, errors.New("malformed token")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(sig, o.signature(payload)) {
+		return claims, errors.New("bad signature")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil || json.Unmarshal(b, &claims) != nil || claims.Id == "" {
+		return claims, errors.New("malformed claims")
+	}
+	if now.Unix() > claims.Expires {
+		return claims, fmt.Errorf("token %s expired", claims.Id)
+	}
+	if nodes > claims.MaxNodes {
+		return claims, fmt.Errorf("token %s allows %d nodes, not %d", claims.Id, claims.MaxNodes, nodes)
+	}
+	return claims, nil
+}
+
+// Use records that a token was used, returning false if it already
+// was. Tokens that expired are forgotten, since they are refused anyway.
+func (o *LimitOverrides) Use(claims LimitOverride, now time.Time) (bool, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	for id, expires := range o.used {
+		if expires < now.Unix() {
+			delete(o.used, id)
+		}
+	}
+	if _, ok := o.used[claims.Id]; ok {
+		return false, nil
+	}
+	o.used[claims.Id] = claims.Expires
+	return true, o.save()
+}
+
+// Release forgets that a token was used, so a submission it let
+// through that was then rejected doesn't use it up.
+func (o *LimitOverrides) Release(claims LimitOverride) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if _, ok := o.used[claims.Id]; !ok {
+		return nil
+	}
+	delete(o.used, claims.Id)
+	return o.save()
+}
+
+func (o *LimitOverrides) save() error {
+	b, err := json.Marshal(o.used)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(o.path, b)
+}
+
+// limitOverride returns the override of the X-Limit-Override header of
+// a submission of nodes over the nodes limit, or nil. The token is held
+// from then on, so that concurrent submissions can't both use it, and
+// must be given back with releaseOverride if the submission isn't
+// queued after all. Why a token is refused is only logged: the
+// submission is rejected as if it had no token, so the response can't
+// be used to probe tokens.
+func (h *Server) limitOverride(r *http.Request, nodes int) *LimitOverride {
+	token := r.Header.Get("X-Limit-Override")
+	if token == "" || h.limitOverrides == nil {
+		return nil
+	}
+	claims, err := h.limitOverrides.Check(token, nodes, time.Now())
+	if err == nil {
+		var fresh bool
+		fresh, err = h.limitOverrides.Use(claims, time.Now())
+		if err == nil && !fresh {
+			err = fmt.Errorf("token %s was already used", claims.Id)
+		}
+	}
+	if err != nil {
+		fmt.Printf("limit override refused: %s\n", err)
+		return nil
+	}
+	fmt.Printf("limit override %s allows %d nodes, up to %d\n", claims.Id, nodes, claims.MaxNodes)
+	return &claims
+}
+
+// releaseOverride gives back the token of a submission that was let
+// through by it but then rejected or deduplicated, so it can be used
+// again.
+func (h *Server) releaseOverride(override *LimitOverride) {
+	if override == nil {
+		return
+	}
+	if err := h.limitOverrides.Release(*override); err != nil {
+		fmt.Printf("releasing limit override %s: %s\n", override.Id, err)
+		return
+	}
+	fmt.Printf("limit override %s released\n", override.Id)
+}
+
+// serveLimitOverride handles POST /api/admin/limitOverride, issuing a
+// token for {"MaxNodes": n} that expires after Minutes, a day by
+// default.
+func (h *Server) serveLimitOverride(w http.ResponseWriter, r *http.Request) {
+	if h.limitOverrides == nil {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, "Error: limit overrides are not configured on this server")
+		return
+	}
+	var body struct {
+		MaxNodes int
+		Minutes  float64
+		Id       string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.MaxNodes <= 0 || body.Minutes < 0 {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, "Error: MaxNodes must be a positive number of nodes")
+		return
+	}
+	if body.Minutes == 0 {
+		body.Minutes = defaultOverrideMinutes
+	}
+	if body.Id == "" {
+		body.Id = uuid.NewString()
+	}
+	expires := time.Now().Add(time.Duration(body.Minutes * float64(time.Minute))).Unix()
+	claims := LimitOverride{Id: body.Id, MaxNodes: body.MaxNodes, Expires: expires}
+	fmt.Printf("limit override %s issued for %d nodes by operator\n", claims.Id, claims.MaxNodes)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Token   string
+		Id      string
+		Expires string
+	}{h.limitOverrides.Token(claims), claims.Id, time.Unix(expires, 0).UTC().Format(time.RFC3339)})
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newLimitOverrides(t *testing.T, filesDir string) *LimitOverrides {
+	path := filepath.Join(t.TempDir(), "override.secret")
+	os.WriteFile(path, []byte("sekrit\n"), 0600)
+	o, err := loadLimitOverrides(path, filesDir)
+	assert.Nil(t, err)
+	return o
+}
+
+func TestLimitOverrideToken(t *testing.T) {
+	o := newLimitOverrides(t, t.TempDir())
+	now := time.Now()
+	token := o.Token(LimitOverride{Id: "county", MaxNodes: 1000, Expires: now.Add(time.Hour).Unix()})
+	claims, err := o.Check(token, 1000, now)
+	assert.Nil(t, err)
+	assert.Equal(t, "county", claims.Id)
+
+	_, err = o.Check(token, 1001, now)
+	assert.EqualError(t, err, "token county allows 1000 nodes, not 1001")
+	_, err = o.Check(token, 10, now.Add(2*time.Hour))
+	assert.EqualError(t, err, "token county expired")
+	payload, signature, _ := strings.Cut(token, ".")
+	forged := o.Token(LimitOverride{Id: "county", MaxNodes: 1000000, Expires: now.Add(time.Hour).Unix()})
+	forgedPayload, _, _ := strings.Cut(forged, ".")
+	_, err = o.Check(forgedPayload+"."+signature, 10, now)
+	assert.EqualError(t, err, "bad signature")
+	_, err = o.Check(payload, 10, now)
+	assert.EqualError(t, err, "malformed token")
+
+	other := &LimitOverrides{secret: []byte("other")}
+	_, err = other.Check(token, 10, now)
+	assert.EqualError(t, err, "bad signature")
+}
+
+func TestLimitOverride(t *testing.T) {
+	h := withAdmin(newTestServer(t, fakeOsmx(t, "")))
+	h.nodesLimit = 10
+	h.limitOverrides = newLimitOverrides(t, h.filesDir)
+	h.StartWorkers()
+	submitWith := func(token string) (int, string) {
+		r := httptest.NewRequest("POST", "/api/", strings.NewReader(richmond))
+		if token != "" {
+			r.Header.Set("X-Limit-Override", token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code, w.Body.String()
+	}
+
+	r := httptest.NewRequest("POST", "/api/admin/limitOverride", strings.NewReader(`{"MaxNodes": 100000000, "Id": "richmond"}`))
+	r.Header.Set("Authorization", "Bearer admin")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, 200, w.Code)
+	var issued struct{ Token, Id string }
+	json.NewDecoder(w.Body).Decode(&issued)
+	assert.Equal(t, "richmond", issued.Id)
+
+	// a refused token gets the response of no token.
+	code, refused := submitWith("")
+	assert.Equal(t, 400, code)
+	for _, token := range []string{"garbage", issued.Token + "x", h.limitOverrides.Token(LimitOverride{Id: "small", MaxNodes: 11, Expires: time.Now().Add(time.Hour).Unix()})} {
+		code, body := submitWith(token)
+		assert.Equal(t, 400, code)
+		assert.Equal(t, refused, body)
+	}
+
+	code, body := submitWith(issued.Token)
+	assert.Equal(t, 201, code)
+	var created Created
+	json.Unmarshal([]byte(body), &created)
+	// a token is used once.
+	code, body = submitWith(issued.Token)
+	assert.Equal(t, 400, code)
+	assert.Equal(t, refused, body)
+
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, created.Uuid)
+		return progress.Complete
+	})
+	_, progress := getProgress(h, created.Uuid)
+	if assert.NotNil(t, progress.Provenance) && assert.NotNil(t, progress.Provenance.LimitOverride) {
+		assert.Equal(t, "richmond", progress.Provenance.LimitOverride.Id)
+		assert.Equal(t, 100000000, progress.Provenance.LimitOverride.MaxNodes)
+	}
+}
+
+func TestUseOverride(t *testing.T) {
+	filesDir := t.TempDir()
+	o := newLimitOverrides(t, filesDir)
+	now := time.Now()
+	claims := LimitOverride{Id: "a", MaxNodes: 1, Expires: now.Add(time.Hour).Unix()}
+	fresh, err := o.Use(claims, now)
+	assert.Nil(t, err)
+	assert.True(t, fresh)
+	// used tokens are remembered across restarts.
+	fresh, _ = newLimitOverrides(t, filesDir).Use(claims, now)
+	assert.False(t, fresh)
+	// expired tokens are forgotten.
+	fresh, _ = o.Use(claims, now.Add(2*time.Hour))
+	assert.True(t, fresh)
+}
+
+func TestOverrideReleased(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.nodesLimit = 10
+	h.limitOverrides = newLimitOverrides(t, h.filesDir)
+	h.apiKeys[hashAPIKey("small")] = &APIKey{Name: "small", DailyNodesQuota: 1}
+	token := h.limitOverrides.Token(LimitOverride{Id: "richmond", MaxNodes: 100000000, Expires: time.Now().Add(time.Hour).Unix()})
+	submit := func(key string) int {
+		r := httptest.NewRequest("POST", "/api/", strings.NewReader(richmond))
+		r.Header.Set("X-Limit-Override", token)
+		if key != "" {
+			r.Header.Set("Authorization", "Bearer "+key)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// neither a full queue nor a quota uses up the token.
+	h.queue = NewScheduler("fifo", 1)
+	h.progress = make(map[string]Progress)
+	h.progressJSON = make(map[string][]byte)
+	h.queue.Push(Task{Uuid: "blocking"}, 0)
+	assert.Equal(t, 503, submit(""))
+	h.queue.Pop()
+	assert.Equal(t, 429, submit("small"))
+
+	assert.Equal(t, 201, submit(""))
+	// once queued, it is.
+	h.queue.Pop()
+	assert.Equal(t, 400, submit(""))
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// limits from the OSM PBF format specification.
+const (
+	maxBlobHeaderSize = 64 * 1024
+	maxBlobSize       = 32 * 1024 * 1024
+)
+
+// verifyPBF walks the blob headers of an osm.pbf file without decoding
+// the blobs: every header must parse, the first blob must be an
+// OSMHeader, the rest OSMData, and the last blob must end exactly at
+// the end of the file. Returns the number of blobs.
+func verifyPBF(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	reader := bufio.NewReader(f)
+	var offset int64
+	blobs := 0
+	for offset < stat.Size() {
+		var length uint32
+		if err := binary.Read(reader, binary.BigEndian, &length); err != nil {
+			return blobs, fmt.Errorf("truncated blob header length at offset %d", offset)
+		}
+		if length == 0 || length > maxBlobHeaderSize {
+			return blobs, fmt.Errorf("invalid blob header length %d at offset %d", length, offset)
+		}
+		header := make([]byte, length)
+		if _, err := io.ReadFull(reader, header); err != nil {
+			return blobs, fmt.Errorf("truncated blob header at offset %d", offset)
+		}
+		blobType, dataSize, err := parseBlobHeader(header)
+		if err != nil {
+			return blobs, fmt.Errorf("%s at offset %d", err, offset)
+		}
+		if (blobs == 0 && blobType != "OSMHeader") || (blobs > 0 && blobType != "OSMData") {
+			return blobs, fmt.Errorf("unexpected blob type %q at offset %d", blobType, offset)
+		}
+		if dataSize <= 0 || dataSize > maxBlobSize {
+			return blobs, fmt.Errorf("invalid blob size %d at offset %d", dataSize, offset)
+		}
+		if _, err := reader.Discard(int(dataSize)); err != nil {
+			return blobs, fmt.Errorf("truncated blob at offset %d", offset)
+		}
+		offset += 4 + int64(length) + dataSize
+		blobs++
+	}
+	if blobs == 0 {
+		return 0, errors.New("file is empty")
+	}
+	return blobs, nil
+}
+
+// parseBlobHeader decodes the type (field 1) and datasize (field 3) of
+// a BlobHeader message, skipping the optional indexdata.
+func parseBlobHeader(b []byte) (string, int64, error) {
+	var blobType string
+	var dataSize int64 = -1
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return "", 0, errors.New("malformed blob header")
+		}
+		b = b[n:]
+		switch tag & 7 {
+		case 0:
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				return "", 0, errors.New("malformed blob header")
+			}
+			b = b[n:]
+			if tag>>3 == 3 {
+				dataSize = int64(v)
+			}
+		case 2:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return "", 0, errors.New("malformed blob header")
+			}
+			if tag>>3 == 1 {
+				blobType = string(b[n : n+int(l)])
+			}
+			b = b[n+int(l):]
+		default:
+			return "", 0, errors.New("malformed blob header")
+		}
+	}
+	if blobType == "" || dataSize < 0 {
+		return "", 0, errors.New("incomplete blob header")
+	}
+	return blobType, dataSize, nil
+}
+
+// The elements of an osm.pbf file, counted by countElements.
+type ElementCounts struct {
+	Nodes     int64
+	Ways      int64
+	Relations int64
+}
+
+// countElements decodes the OSMData blobs of a verified osm.pbf file,
+// counting the entities of their primitive groups. Only raw and zlib
+// blobs are supported, which is what osmx writes.
+func countElements(path string) (*ElementCounts, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	counts := &ElementCounts{}
+	for {
+		var length uint32
+		if err := binary.Read(reader, binary.BigEndian, &length); err == io.EOF {
+			return counts, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if length == 0 || length > maxBlobHeaderSize {
+			return nil, fmt.Errorf("invalid blob header length %d", length)
+		}
+		header := make([]byte, length)
+		if _, err := io.ReadFull(reader, header); err != nil {
+			return nil, err
+		}
+		blobType, dataSize, err := parseBlobHeader(header)
+		if err != nil {
+			return nil, err
+		}
+		if dataSize <= 0 || dataSize > maxBlobSize {
+			return nil, fmt.Errorf("invalid blob size %d", dataSize)
+		}
+		blob := make([]byte, dataSize)
+		if _, err := io.ReadFull(reader, blob); err != nil {
+			return nil, err
+		}
+		if blobType != "OSMData" {
+			continue
+		}
+		block, err := blobData(blob)
+		if err != nil {
+			return nil, err
+		}
+		if err := counts.add(block); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// blobData is the uncompressed content of a Blob message.
+func blobData(blob []byte) ([]byte, error) {
+	var data []byte
+	var size uint64
+	var compressed bool
+	err := protoFields(blob, func(field uint64, v uint64, b []byte) error {
+		switch field {
+		case 1:
+			data = b
+		case 2:
+			size = v
+		case 3:
+			data, compressed = b, true
+		case 4, 5, 6, 7:
+			return errors.New("unsupported blob compression")
+		}
+		return nil
+	})
+	if err != nil || !compressed {
+		return data, err
+	}
+	if size > maxBlobSize {
+		return nil, fmt.Errorf("invalid raw blob size %d", size)
+	}
+	z, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer z.Close()
+	raw := make([]byte, size)
+	if _, err := io.ReadFull(z, raw); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// add counts the elements of a PrimitiveBlock.
+func (c *ElementCounts) add(block []byte) error {
+	return protoFields(block, func(field uint64, v uint64, group []byte) error {
+		if field != 2 {
+			return nil
+		}
+		return protoFields(group, func(field uint64, v uint64, b []byte) error {
+			switch field {
+			case 1:
+				c.Nodes++
+			case 2:
+				// the ids of DenseNodes are a packed field of varints,
+				// one per node.
+				return protoFields(b, func(field uint64, v uint64, ids []byte) error {
+					if field == 1 {
+						for _, x := range ids {
+							if x < 0x80 {
+								c.Nodes++
+							}
+						}
+					}
+					return nil
+				})
+			case 3:
+				c.Ways++
+			case 4:
+				c.Relations++
+			}
+			return nil
+		})
+	})
+}
+
+// protoFields calls fn with the number and value of each field of a
+// protobuf message: v for varints, b for length-delimited fields.
+func protoFields(b []byte, fn func(field uint64, v uint64, b []byte) error) error {
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errors.New("malformed message")
+		}
+		b = b[n:]
+		var v uint64
+		var data []byte
+		switch tag & 7 {
+		case 0:
+			v, n = binary.Uvarint(b)
+			if n <= 0 {
+				return errors.New("malformed message")
+			}
+			b = b[n:]
+		case 1:
+			if len(b) < 8 {
+				return errors.New("malformed message")
+			}
+			b = b[8:]
+		case 2:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return errors.New("malformed message")
+			}
+			data = b[n : n+int(l)]
+			b = b[n+int(l):]
+		case 5:
+			if len(b) < 4 {
+				return errors.New("malformed message")
+			}
+			b = b[4:]
+		default:
+			return errors.New("malformed message")
+		}
+		if err := fn(tag>>3, v, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
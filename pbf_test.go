@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func appendBlob(b []byte, blobType string, data []byte) []byte {
+	header := []byte{0x0a, byte(len(blobType))}
+	header = append(header, blobType...)
+	header = append(header, 0x18)
+	header = binary.AppendUvarint(header, uint64(len(data)))
+	b = binary.BigEndian.AppendUint32(b, uint32(len(header)))
+	b = append(b, header...)
+	return append(b, data...)
+}
+
+func writePBF(t *testing.T, b []byte) string {
+	path := filepath.Join(t.TempDir(), "test.osm.pbf")
+	os.WriteFile(path, b, 0644)
+	return path
+}
+
+func TestVerifyPBF(t *testing.T) {
+	b := appendBlob(nil, "OSMHeader", []byte("header"))
+	b = appendBlob(b, "OSMData", make([]byte, 1000))
+	b = appendBlob(b, "OSMData", make([]byte, 10))
+	blobs, err := verifyPBF(writePBF(t, b))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, blobs)
+}
+
+func TestVerifyPBFTruncated(t *testing.T) {
+	b := appendBlob(nil, "OSMHeader", []byte("header"))
+	b = appendBlob(b, "OSMData", make([]byte, 1000))
+	_, err := verifyPBF(writePBF(t, b[:len(b)-1]))
+	assert.NotNil(t, err)
+
+	_, err = verifyPBF(writePBF(t, append(b, 0, 0)))
+	assert.NotNil(t, err)
+}
+
+func TestVerifyPBFBlobOrder(t *testing.T) {
+	b := appendBlob(nil, "OSMData", make([]byte, 10))
+	_, err := verifyPBF(writePBF(t, b))
+	assert.EqualError(t, err, `unexpected blob type "OSMData" at offset 0`)
+}
+
+func TestVerifyPBFEmpty(t *testing.T) {
+	_, err := verifyPBF(writePBF(t, nil))
+	assert.NotNil(t, err)
+}
+
+// appendField appends a length-delimited protobuf field.
+func appendField(b []byte, field int, data []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field<<3|2))
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+func TestCountElements(t *testing.T) {
+	// a group of 3 dense nodes, the last with a multi-byte id delta, and
+	// a group of a node, 2 ways and a relation.
+	dense := appendField(nil, 1, []byte{0x02, 0x02, 0x80, 0x01})
+	group := appendField(nil, 2, dense)
+	block := appendField(nil, 2, group)
+	group = appendField(nil, 1, []byte{0x08, 0x02})
+	group = appendField(group, 3, []byte{0x08, 0x04})
+	group = appendField(group, 3, []byte{0x08, 0x06})
+	group = appendField(group, 4, []byte{0x08, 0x08})
+	block = appendField(block, 2, group)
+
+	var z bytes.Buffer
+	w := zlib.NewWriter(&z)
+	w.Write(block)
+	w.Close()
+	zlibBlob := append([]byte{0x10}, binary.AppendUvarint(nil, uint64(len(block)))...)
+	zlibBlob = appendField(zlibBlob, 3, z.Bytes())
+
+	b := appendBlob(nil, "OSMHeader", appendField(nil, 1, nil))
+	b = appendBlob(b, "OSMData", zlibBlob)
+	b = appendBlob(b, "OSMData", appendField(nil, 1, block))
+	counts, err := countElements(writePBF(t, b))
+	assert.Nil(t, err)
+	assert.Equal(t, ElementCounts{Nodes: 8, Ways: 4, Relations: 2}, *counts)
+
+	b = appendBlob(nil, "OSMHeader", appendField(nil, 1, nil))
+	b = appendBlob(b, "OSMData", appendField(nil, 7, []byte("zstd")))
+	_, err = countElements(writePBF(t, b))
+	assert.EqualError(t, err, "unsupported blob compression")
+}
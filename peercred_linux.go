@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+func peerCredentials(c *net.UnixConn) string {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return "unix"
+	}
+	var cred *syscall.Ucred
+	raw.Control(func(fd uintptr) {
+		cred, err = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil || cred == nil {
+		return "unix"
+	}
+	return fmt.Sprintf("unix:pid=%d,uid=%d,gid=%d", cred.Pid, cred.Uid, cred.Gid)
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import (
+	"net"
+)
+
+func peerCredentials(c *net.UnixConn) string {
+	return "unix"
+}
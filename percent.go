@@ -0,0 +1,92 @@
+package main
+
+import "math"
+
+// the share of a job's time each stage takes relative to extracting,
+// until the results with stage durations are enough to measure it.
+var defaultStageWeights = map[string]float64{
+	"extracting": 1,
+	"splitting":  0.5,
+	"finalizing": 0.1,
+}
+
+// stage weights are measured once this many results have durations.
+const minTimedResults = 20
+
+// stageWeights are the relative lengths of the stages of a job, from
+// the durations of completed results once there are enough.
+func (h *Server) stageWeights() map[string]float64 {
+	if weights, ok := h.results.StageWeights(minTimedResults); ok {
+		return weights
+	}
+	return defaultStageWeights
+}
+
+// extractedFraction is how far osmx is through an extract. It reports
+// cells, then nodes, then elements, each counted as a third; a counter
+// whose total isn't known yet counts as not started.
+func extractedFraction(progress Progress) float64 {
+	fraction := 0.0
+	for _, c := range [3][2]int64{
+		{progress.CellsProg, progress.CellsTotal},
+		{progress.NodesProg, progress.NodesTotal},
+		{progress.ElemsProg, progress.ElemsTotal},
+	} {
+		if c[1] > 0 {
+			fraction += math.Min(float64(c[0])/float64(c[1]), 1) / 3
+		}
+	}
+	return fraction
+}
+
+// stagePercent is the percentage of a job done at the start of its stage
+// plus the fraction of the stage it is through, weighting the stages the
+// job has. Stages after extracting don't report progress, so they count
+// as half done.
+func stagePercent(weights map[string]float64, progress Progress, splitting bool) float64 {
+	stages := []string{"extracting", "finalizing"}
+	if splitting {
+		stages = []string{"extracting", "splitting", "finalizing"}
+	}
+	total := 0.0
+	for _, stage := range stages {
+		total += weights[stage]
+	}
+	if total <= 0 {
+		return 0
+	}
+	current := progress.Stage
+	if current == "" {
+		current = "extracting"
+	}
+	done := 0.0
+	for _, stage := range stages {
+		if stage != current {
+			done += weights[stage]
+			continue
+		}
+		if stage == "extracting" {
+			done += weights[stage] * extractedFraction(progress)
+		} else {
+			done += weights[stage] / 2
+		}
+		break
+	}
+	return math.Min(100*done/total, 100)
+}
+
+// percentComplete is the progress of a running job as a percentage. It
+// never goes down for a job, though osmx revises its totals upward as it
+// goes.
+func (h *Server) percentComplete(uuid string, progress Progress) float64 {
+	weights := h.stageWeights()
+	h.runningMutex.Lock()
+	defer h.runningMutex.Unlock()
+	job, ok := h.running[uuid]
+	if !ok {
+		return progress.PercentComplete
+	}
+	percent := stagePercent(weights, progress, len(job.task.SubRegions) > 0)
+	job.percent = math.Max(job.percent, percent)
+	return job.percent
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// progress lines of an osmx extract, where the node and element totals
+// are revised upward as cells are read.
+var revisedProgress = []Progress{
+	{CellsTotal: 12, CellsProg: 0},
+	{CellsTotal: 12, CellsProg: 6},
+	{CellsTotal: 12, CellsProg: 12, NodesTotal: 40000, NodesProg: 0},
+	{CellsTotal: 12, CellsProg: 12, NodesTotal: 40000, NodesProg: 30000},
+	{CellsTotal: 12, CellsProg: 12, NodesTotal: 95000, NodesProg: 31000},
+	{CellsTotal: 12, CellsProg: 12, NodesTotal: 95000, NodesProg: 95000, ElemsTotal: 2000, ElemsProg: 1500},
+	{CellsTotal: 12, CellsProg: 12, NodesTotal: 95000, NodesProg: 95000, ElemsTotal: 9000, ElemsProg: 1600},
+	{CellsTotal: 12, CellsProg: 12, NodesTotal: 95000, NodesProg: 95000, ElemsTotal: 9000, ElemsProg: 9000},
+}
+
+func TestExtractedFraction(t *testing.T) {
+	assert.Equal(t, 0.0, extractedFraction(Progress{}))
+	assert.Equal(t, 0.0, extractedFraction(Progress{NodesProg: 5}))
+	assert.InDelta(t, 0.5, extractedFraction(Progress{CellsTotal: 10, CellsProg: 10, NodesTotal: 4, NodesProg: 2}), 1e-9)
+	assert.InDelta(t, 1.0, extractedFraction(revisedProgress[len(revisedProgress)-1]), 1e-9)
+}
+
+func TestStagePercent(t *testing.T) {
+	weights := map[string]float64{"extracting": 1, "splitting": 1, "finalizing": 2}
+	half := Progress{Stage: "extracting", CellsTotal: 1, CellsProg: 1, NodesTotal: 2, NodesProg: 1, ElemsTotal: 4, ElemsProg: 0}
+	assert.InDelta(t, 100.0/6, stagePercent(weights, half, false), 1e-9)
+	assert.InDelta(t, 200.0/3, stagePercent(weights, Progress{Stage: "finalizing"}, false), 1e-9)
+	assert.InDelta(t, 100.0*1.5/4, stagePercent(weights, Progress{Stage: "splitting"}, true), 1e-9)
+	assert.Equal(t, 0.0, stagePercent(map[string]float64{}, half, false))
+}
+
+func TestStageWeights(t *testing.T) {
+	ix := &ResultIndex{}
+	_, ok := ix.StageWeights(2)
+	assert.False(t, ok)
+	ix.Add(ResultEntry{Uuid: "a", stageDurations: map[string]float64{"extracting": 100, "finalizing": 10}})
+	ix.Add(ResultEntry{Uuid: "b", stageDurations: map[string]float64{"extracting": 100, "splitting": 100, "finalizing": 30}})
+	ix.Add(ResultEntry{Uuid: "c"})
+	weights, ok := ix.StageWeights(2)
+	assert.True(t, ok)
+	assert.Equal(t, 1.0, weights["extracting"])
+	assert.Equal(t, 1.0, weights["splitting"])
+	assert.Equal(t, 0.2, weights["finalizing"])
+
+	ix.Remove("b")
+	_, ok = ix.StageWeights(2)
+	assert.False(t, ok)
+}
+
+func TestPercentCompleteMonotonic(t *testing.T) {
+	x := newFakeExtractor()
+	x.reports = revisedProgress
+	x.step = make(chan struct{})
+	h := newFakeServer(t, x)
+	_, uuid := submit(h, richmond)
+
+	previous := 0.0
+	for i, report := range revisedProgress {
+		x.step <- struct{}{}
+		waitFor(t, func() bool {
+			_, progress := getProgress(h, uuid)
+			return progress.CellsProg == report.CellsProg && progress.NodesTotal == report.NodesTotal &&
+				progress.NodesProg == report.NodesProg && progress.ElemsTotal == report.ElemsTotal && progress.ElemsProg == report.ElemsProg
+		})
+		_, progress := getProgress(h, uuid)
+		assert.GreaterOrEqual(t, progress.PercentComplete, previous, fmt.Sprintf("line %d", i))
+		if !progress.Complete {
+			assert.Less(t, progress.PercentComplete, 100.0)
+		}
+		previous = progress.PercentComplete
+	}
+	// the revised totals would have gone backwards.
+	naive := stagePercent(defaultStageWeights, revisedProgress[4], false)
+	assert.Less(t, naive, stagePercent(defaultStageWeights, revisedProgress[3], false))
+
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, uuid)
+		return progress.Complete
+	})
+	_, progress := getProgress(h, uuid)
+	assert.Equal(t, 100.0, progress.PercentComplete)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+// the usage policy of nominatim.openstreetmap.org allows one request a
+// second.
+const nominatimInterval = time.Second
+
+// upper bound on the response of Nominatim for one search.
+const maxPlaceBytes = 16 << 20
+
+// Places geocodes place names to their boundaries with the /search
+// call of a Nominatim server.
+type Places struct {
+	nominatimUrl string
+	client       *http.Client
+
+	mutex sync.Mutex
+	next  time.Time // earliest time of the next request
+}
+
+func NewPlaces(nominatimUrl string) *Places {
+	return &Places{
+		nominatimUrl: strings.TrimSuffix(nominatimUrl, "/"),
+		client:       &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// wait blocks until a request is allowed by nominatimInterval.
+func (ps *Places) wait(ctx context.Context) error {
+	ps.mutex.Lock()
+	now := time.Now()
+	at := ps.next
+	if at.Before(now) {
+		at = now
+	}
+	ps.next = at.Add(nominatimInterval)
+	ps.mutex.Unlock()
+
+	timer := time.NewTimer(at.Sub(now))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Resolve returns the boundary of the best match for query, and its
+// name.
+func (ps *Places) Resolve(ctx context.Context, query string) (orb.Geometry, string, error) {
+	if err := ps.wait(ctx); err != nil {
+		return nil, "", err
+	}
+	params := url.Values{"q": {query}, "format": {"jsonv2"}, "polygon_geojson": {"1"}, "limit": {"1"}}
+	req, err := http.NewRequestWithContext(ctx, "GET", ps.nominatimUrl+"/search?"+params.Encode(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("User-Agent", "sliceosm-api")
+	resp, err := ps.client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("geocoding %q: %w", query, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, "", fmt.Errorf("geocoding %q: Nominatim returned %s", query, resp.Status)
+	}
+	var results []struct {
+		Name        string
+		DisplayName string `json:"display_name"`
+		Geojson     json.RawMessage
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxPlaceBytes)).Decode(&results); err != nil {
+		return nil, "", fmt.Errorf("geocoding %q: %w", query, err)
+	}
+	if len(results) == 0 {
+		return nil, "", fmt.Errorf("no place matches %q", query)
+	}
+	place := results[0]
+	g, err := geojson.UnmarshalGeometry(place.Geojson)
+	if err != nil {
+		return nil, "", fmt.Errorf("geocoding %q: %w", query, err)
+	}
+	switch g.Geometry().(type) {
+	case orb.Polygon, orb.MultiPolygon:
+	default:
+		return nil, "", fmt.Errorf("place %q has no boundary", place.DisplayName)
+	}
+	name := place.Name
+	if name == "" {
+		name = place.DisplayName
+	}
+	return g.Geometry(), name, nil
+}
+
+// resolvePlace turns a place region into the geojson region of the
+// boundary it is geocoded to.
+func (h *Server) resolvePlace(ctx context.Context, input Input) (Input, error) {
+	if h.places == nil {
+		return input, errors.New("place regions are not configured on this server")
+	}
+	var query string
+	if json.Unmarshal(input.RegionData, &query) != nil || strings.TrimSpace(query) == "" {
+		return input, errors.New("input place must be a place name")
+	}
+	geom, name, err := h.places.Resolve(ctx, query)
+	if err != nil {
+		return input, err
+	}
+	return resolvedInput(input, geom, name), nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/paulmach/orb"
+	"github.com/stretchr/testify/assert"
+)
+
+func newFakeNominatim(t *testing.T) *httptest.Server {
+	nominatim := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("q") {
+		case "Richmond, Virginia":
+			w.Write([]byte(`[{"name":"Richmond","display_name":"Richmond, Virginia, United States","geojson":{"type":"Polygon","coordinates":[[[-77.4571,37.5530],[-77.4571,37.5272],[-77.4133,37.5272],[-77.4133,37.5530],[-77.4571,37.5530]]]}}]`))
+		case "Statue of Liberty":
+			w.Write([]byte(`[{"name":"Statue of Liberty","display_name":"Statue of Liberty, New York","geojson":{"type":"Point","coordinates":[-74.0445,40.6892]}}]`))
+		default:
+			w.Write([]byte(`[]`))
+		}
+	}))
+	t.Cleanup(nominatim.Close)
+	return nominatim
+}
+
+func TestPlaceResolve(t *testing.T) {
+	places := NewPlaces(newFakeNominatim(t).URL)
+	geom, name, err := places.Resolve(context.Background(), "Richmond, Virginia")
+	assert.Nil(t, err)
+	assert.Equal(t, "Richmond", name)
+	_, isPolygon := geom.(orb.Polygon)
+	assert.True(t, isPolygon)
+
+	places.next = time.Time{}
+	_, _, err = places.Resolve(context.Background(), "Statue of Liberty")
+	assert.Equal(t, `place "Statue of Liberty, New York" has no boundary`, err.Error())
+
+	places.next = time.Time{}
+	_, _, err = places.Resolve(context.Background(), "Atlantis")
+	assert.Equal(t, `no place matches "Atlantis"`, err.Error())
+}
+
+func TestPlaceThrottle(t *testing.T) {
+	places := NewPlaces("http://localhost")
+	assert.Nil(t, places.wait(context.Background()))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, places.wait(ctx))
+}
+
+func TestPlaceRegion(t *testing.T) {
+	h := newTestServer(t, "osmx")
+	_, err := h.resolveRegion(context.Background(), Input{RegionType: "place", RegionData: json.RawMessage(`"Richmond, Virginia"`)})
+	assert.Equal(t, "place regions are not configured on this server", err.Error())
+
+	h.places = NewPlaces(newFakeNominatim(t).URL)
+	input, err := h.resolveRegion(context.Background(), Input{RegionType: "place", RegionData: json.RawMessage(`"Richmond, Virginia"`)})
+	assert.Nil(t, err)
+	assert.Equal(t, "Richmond", input.Name)
+	assert.Equal(t, "geojson", input.RegionType)
+
+	_, err = h.resolveRegion(context.Background(), Input{RegionType: "place", RegionData: json.RawMessage(`" "`)})
+	assert.Equal(t, "input place must be a place name", err.Error())
+
+	h.places.next = time.Time{}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/api/estimate", strings.NewReader(`{"RegionType":"place","RegionData":"Richmond, Virginia"}`)))
+	assert.Equal(t, 200, w.Code)
+
+	capabilities := (&Server{queue: NewScheduler("fifo", 10), places: h.places}).capabilities()
+	assert.Contains(t, capabilities.RegionTypes, "place")
+}
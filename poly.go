@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+// an Osmosis polygon filter file in a JSON string, stored as a GeoJSON
+// region.
+func parsePolyRegion(input Input, _ RegionLimits) (orb.Geometry, string, json.RawMessage, error) {
+	var data string
+	if err := json.Unmarshal(input.RegionData, &data); err != nil {
+		return nil, "", nil, errors.New("input poly is invalid")
+	}
+	polygons, err := parsePoly(data)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	var geom orb.Geometry = polygons
+	if len(polygons) == 1 {
+		geom = polygons[0]
+	}
+	sanitizedData, _ := geojson.NewGeometry(geom).MarshalJSON()
+	return geom, "geojson", sanitizedData, nil
+}
+
+// parsePoly reads the sections of a .poly file: a name line, then rings
+// of "lon lat" lines each ending in END, and a final END. A ring whose
+// name starts with ! is a hole in the polygon before it.
+func parsePoly(data string) (orb.MultiPolygon, error) {
+	var lines []string
+	for _, line := range strings.Split(data, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		return nil, errors.New("poly does not contain a polygon")
+	}
+
+	var polygons orb.MultiPolygon
+	// the first line is the name of the file.
+	i := 1
+	for i < len(lines) && lines[i] != "END" {
+		hole := strings.HasPrefix(lines[i], "!")
+		i++
+		var ring orb.Ring
+		for ; i < len(lines) && lines[i] != "END"; i++ {
+			fields := strings.Fields(lines[i])
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("poly line %q is not a coordinate pair", lines[i])
+			}
+			lon, err := strconv.ParseFloat(fields[0], 64)
+			if err != nil {
+				return nil, fmt.Errorf("poly line %q is not a coordinate pair", lines[i])
+			}
+			lat, err := strconv.ParseFloat(fields[1], 64)
+			if err != nil {
+				return nil, fmt.Errorf("poly line %q is not a coordinate pair", lines[i])
+			}
+			if lon < -180 || lon > 180 || lat < -90 || lat > 90 {
+				return nil, errors.New("poly coordinates are out of range")
+			}
+			ring = append(ring, orb.Point{lon, lat})
+		}
+		if i == len(lines) {
+			return nil, errors.New("poly ring is missing its END")
+		}
+		i++
+		if len(ring) > 0 && !ring.Closed() {
+			ring = append(ring, ring[0])
+		}
+		if len(ring) < 4 {
+			return nil, errors.New("ring does not have enough coordinates")
+		}
+		if hole {
+			if len(polygons) == 0 {
+				return nil, errors.New("poly hole comes before any polygon")
+			}
+			polygons[len(polygons)-1] = append(polygons[len(polygons)-1], ring)
+		} else {
+			polygons = append(polygons, orb.Polygon{ring})
+		}
+	}
+	if len(polygons) == 0 {
+		return nil, errors.New("poly does not contain a polygon")
+	}
+	return polygons, nil
+}
+
+// encodePoly writes the polygons of a sanitized region as a .poly
+// file named name, false for a geometry that has none.
+func encodePoly(name string, geom orb.Geometry) ([]byte, bool) {
+	var polygons orb.MultiPolygon
+	switch g := geom.(type) {
+	case orb.Bound:
+		polygons = orb.MultiPolygon{{g.ToRing()}}
+	case orb.Polygon:
+		polygons = orb.MultiPolygon{g}
+	case orb.MultiPolygon:
+		polygons = g
+	default:
+		return nil, false
+	}
+	if name = strings.Join(strings.Fields(name), " "); name == "" {
+		name = "polygon"
+	}
+	var b bytes.Buffer
+	b.WriteString(name + "\n")
+	section := 0
+	for _, polygon := range polygons {
+		for i, ring := range polygon {
+			section++
+			if i > 0 {
+				b.WriteByte('!')
+			}
+			fmt.Fprintf(&b, "%d\n", section)
+			for _, p := range ring {
+				fmt.Fprintf(&b, "   %s   %s\n", strconv.FormatFloat(p[0], 'f', -1, 64), strconv.FormatFloat(p[1], 'f', -1, 64))
+			}
+			b.WriteString("END\n")
+		}
+	}
+	b.WriteString("END\n")
+	return b.Bytes(), true
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/stretchr/testify/assert"
+)
+
+const richmondPoly = `richmond
+1
+   -77.4571   37.5272
+   -77.4133   37.5272
+   -77.4133   37.5530
+   -77.4571   37.5530
+END
+!2
+   -77.4400   37.5350
+   -77.4300   37.5350
+   -77.4300   37.5450
+END
+3
+   -77.40   37.50
+   -77.39   37.50
+   -77.39   37.51
+   -77.40   37.50
+END
+END
+`
+
+func polyInput(poly string) string {
+	data, _ := json.Marshal(poly)
+	return `{"Name":"richmond","RegionType":"poly","RegionData":` + string(data) + `}`
+}
+
+func TestParsePoly(t *testing.T) {
+	polygons, err := parsePoly(richmondPoly)
+	assert.Nil(t, err)
+	assert.Len(t, polygons, 2)
+	assert.Len(t, polygons[0], 2)
+	// rings are closed.
+	assert.Equal(t, orb.Point{-77.4400, 37.5350}, polygons[0][1][3])
+	assert.Len(t, polygons[1], 1)
+
+	_, err = parsePoly("x\n!1\n 0 0\n 1 0\n 1 1\nEND\nEND\n")
+	assert.EqualError(t, err, "poly hole comes before any polygon")
+	_, err = parsePoly("x\n1\n 0 0\n 1 0\n 1 1\n")
+	assert.EqualError(t, err, "poly ring is missing its END")
+	_, err = parsePoly("x\n1\n 0 0 0\nEND\nEND\n")
+	assert.NotNil(t, err)
+	_, err = parsePoly("x\n1\n 0 0\n 1 0\nEND\nEND\n")
+	assert.EqualError(t, err, "ring does not have enough coordinates")
+	_, err = parsePoly("x\nEND\n")
+	assert.EqualError(t, err, "poly does not contain a polygon")
+	_, err = parsePoly("x\n1\n 0 0\n 1 0\n 1 91\nEND\nEND\n")
+	assert.EqualError(t, err, "poly coordinates are out of range")
+}
+
+func TestEncodePoly(t *testing.T) {
+	polygons, _ := parsePoly(richmondPoly)
+	b, ok := encodePoly("rich\nmond", polygons)
+	assert.True(t, ok)
+	assert.True(t, strings.HasPrefix(string(b), "rich mond\n1\n   -77.4571   37.5272\n"))
+	again, err := parsePoly(string(b))
+	assert.Nil(t, err)
+	assert.Equal(t, polygons, again)
+
+	b, _ = encodePoly("", orb.Bound{Min: orb.Point{0, 1}, Max: orb.Point{2, 3}})
+	assert.Equal(t, "polygon\n1\n   0   1\n   2   1\n   2   3\n   0   3\n   0   1\nEND\nEND\n", string(b))
+	_, ok = encodePoly("x", orb.Point{0, 0})
+	assert.False(t, ok)
+}
+
+func TestSubmitPoly(t *testing.T) {
+	h := newTestServer(t, "osmx")
+	h.progress = make(map[string]Progress)
+	h.progressJSON = make(map[string][]byte)
+	h.queue = NewScheduler("fifo", 10)
+	created := submitAs(h, polyInput(richmondPoly), "")
+	assert.NotEmpty(t, created.Uuid)
+	assert.Equal(t, "geojson", created.SanitizedRegionType)
+	assert.Contains(t, string(created.SanitizedRegionData), `"MultiPolygon"`)
+}
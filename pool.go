@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"sync"
+)
+
+// workerPool is the number of workers wanted and the ids of those
+// running, each the name of its scratch directory. When it shrinks,
+// workers above the size finish their job and leave; ids are reused
+// by workers started later.
+type workerPool struct {
+	mutex sync.Mutex
+	size  int
+	ids   map[int]bool
+}
+
+func newWorkerPool() *workerPool {
+	return &workerPool{ids: make(map[int]bool)}
+}
+
+// leave retires the worker if there are more than the pool's size.
+func (p *workerPool) leave(id int) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if len(p.ids) <= p.size {
+		return false
+	}
+	delete(p.ids, id)
+	return true
+}
+
+func (p *workerPool) release(id int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.ids, id)
+}
+
+// Size is the number of workers wanted and the number running, which
+// is higher while retired workers finish their jobs.
+func (p *workerPool) Size() (int, int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.size, len(p.ids)
+}
+
+// maxWorkerNodes is the largest node estimate of the jobs a worker may
+// take, or 0 for any: workers past the first -largeWorkers only take
+// jobs up to -largeJobNodes, so large jobs can't hold every worker.
+func (h *Server) maxWorkerNodes(id int) int {
+	if h.largeJobNodes <= 0 || id < h.largeWorkers {
+		return 0
+	}
+	return h.largeJobNodes
+}
+
+// SetWorkers resizes the worker pool, starting workers right away and
+// retiring idle ones; busy workers over the size leave once their job
+// finishes.
+func (h *Server) SetWorkers(n int) error {
+	if n < 1 {
+		return errors.New("there must be at least one worker")
+	}
+	pool := h.pool
+	pool.mutex.Lock()
+	pool.size = n
+	var started []int
+	for id := 0; len(pool.ids) < n; id++ {
+		if pool.ids[id] {
+			continue
+		}
+		if err := os.MkdirAll(h.scratchDir(id), 0755); err != nil {
+			pool.mutex.Unlock()
+			return err
+		}
+		pool.ids[id] = true
+		started = append(started, id)
+	}
+	pool.mutex.Unlock()
+
+	for _, id := range started {
+		h.workers.Add(1)
+		go h.worker(id, h.queue, pool)
+	}
+	h.queue.Wake()
+	return nil
+}
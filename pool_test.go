@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetWorkers(t *testing.T) {
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "started")
+	gate := filepath.Join(dir, "gate")
+	h := withAdmin(newTestServer(t, fakeOsmx(t, `touch `+marker+`; while [ ! -f `+gate+` ]; do sleep 0.05; done`)))
+	h.workerCount = 3
+	h.StartWorkers()
+	wanted, running := h.pool.Size()
+	assert.Equal(t, 3, wanted)
+	assert.Equal(t, 3, running)
+
+	_, uuid := submit(h, richmond)
+	waitFor(t, func() bool {
+		_, err := os.Stat(marker)
+		return err == nil
+	})
+	// the idle workers leave right away, the busy one stays.
+	assert.Equal(t, 200, adminRequest(h, "/api/admin/workers", `{"Workers": 1}`))
+	waitFor(t, func() bool {
+		_, running := h.pool.Size()
+		return running == 1
+	})
+
+	// a new worker takes the queued jobs.
+	assert.Equal(t, 200, adminRequest(h, "/api/admin/workers", `{"Workers": 2}`))
+	assert.Equal(t, 2, h.stats().WorkersRunning)
+	_, second := submit(h, richmond)
+	waitFor(t, func() bool { return h.stats().Running == 2 })
+
+	assert.Equal(t, 400, adminRequest(h, "/api/admin/workers", `{"Workers": 0}`))
+	os.WriteFile(gate, nil, 0644)
+	for _, id := range []string{uuid, second} {
+		waitFor(t, func() bool {
+			_, progress := getProgress(h, id)
+			return progress.Complete
+		})
+	}
+	assert.Equal(t, 2, h.stats().Workers)
+}
+
+func TestLargeJobWorkers(t *testing.T) {
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "started")
+	gate := filepath.Join(dir, "gate")
+	h := newTestServer(t, fakeOsmx(t, `touch `+marker+`; while [ ! -f `+gate+` ]; do sleep 0.05; done`))
+	h.workerCount = 3
+	// every job is large, so they run one at a time.
+	h.largeJobNodes = 1
+	h.largeWorkers = 1
+	h.StartWorkers()
+	_, first := submit(h, richmond)
+	_, second := submit(h, richmond)
+	waitFor(t, func() bool {
+		_, err := os.Stat(marker)
+		return err == nil
+	})
+	time.Sleep(200 * time.Millisecond)
+	stats := h.stats()
+	assert.Equal(t, 1, stats.Running)
+	assert.Equal(t, 1, stats.QueueSize)
+
+	os.WriteFile(gate, nil, 0644)
+	for _, id := range []string{first, second} {
+		waitFor(t, func() bool {
+			_, progress := getProgress(h, id)
+			return progress.Complete
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/maptile"
+	"github.com/paulmach/orb/maptile/tilecover"
+)
+
+// zoom of the popularity grid: 4096 tiles, each about 600km across.
+const popularityZoom = 6
+
+// months of counts kept; older months are dropped.
+const popularityMonths = 3
+
+// How often each tile was covered by an accepted region, by month,
+// persisted as popularity.json in filesDir. Only tile counts are kept,
+// nothing about who asked.
+type PopularityStore struct {
+	mutex  sync.Mutex
+	path   string
+	months map[string]map[maptile.Tile]int64 // YYYY-MM in UTC
+	dirty  bool
+}
+
+// the persisted form, with tiles as z/x/y.
+type popularityFile map[string]map[string]int64
+
+// a grid tile and how often it was requested.
+type TileCount struct {
+	Tile  maptile.Tile
+	Count int64
+}
+
+func LoadPopularity(filesDir string) (*PopularityStore, error) {
+	p := &PopularityStore{
+		path:   filepath.Join(filesDir, "popularity.json"),
+		months: make(map[string]map[maptile.Tile]int64),
+	}
+	b, err := os.ReadFile(p.path)
+	if os.IsNotExist(err) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var saved popularityFile
+	if err := json.Unmarshal(b, &saved); err != nil {
+		return nil, err
+	}
+	for month, tiles := range saved {
+		counts := make(map[maptile.Tile]int64)
+		for name, n := range tiles {
+			var z, x, y uint32
+			if _, err := fmt.Sscanf(name, "%d/%d/%d", &z, &x, &y); err != nil {
+				return nil, fmt.Errorf("%s: bad tile %q", p.path, name)
+			}
+			counts[maptile.New(x, y, maptile.Zoom(z))] = n
+		}
+		p.months[month] = counts
+	}
+	return p, nil
+}
+
+// Record counts each grid tile covering an accepted region once.
+func (p *PopularityStore) Record(geom orb.Geometry, now time.Time) {
+	covering, err := tilecover.Geometry(geom, popularityZoom)
+	if err != nil || len(covering) == 0 {
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	month := monthOf(now)
+	counts, ok := p.months[month]
+	if !ok {
+		counts = make(map[maptile.Tile]int64)
+		p.months[month] = counts
+		p.prune(now)
+	}
+	for t := range covering {
+		counts[t]++
+	}
+	p.dirty = true
+}
+
+// prune drops the months before the last popularityMonths. Requires
+// the mutex.
+func (p *PopularityStore) prune(now time.Time) {
+	now = now.UTC()
+	oldest := monthOf(time.Date(now.Year(), now.Month()-popularityMonths+1, 1, 0, 0, 0, 0, time.UTC))
+	for month := range p.months {
+		if month < oldest {
+			delete(p.months, month)
+		}
+	}
+}
+
+// Counts sums the given months, most requested tile first.
+func (p *PopularityStore) Counts(months []string) []TileCount {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	sum := make(map[maptile.Tile]int64)
+	for _, month := range months {
+		for t, n := range p.months[month] {
+			sum[t] += n
+		}
+	}
+	counts := make([]TileCount, 0, len(sum))
+	for t, n := range sum {
+		counts = append(counts, TileCount{t, n})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		if counts[i].Tile.Y != counts[j].Tile.Y {
+			return counts[i].Tile.Y < counts[j].Tile.Y
+		}
+		return counts[i].Tile.X < counts[j].Tile.X
+	})
+	return counts
+}
+
+// Save writes the counts if any were recorded since the last save.
+func (p *PopularityStore) Save() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if !p.dirty {
+		return nil
+	}
+	saved := make(popularityFile)
+	for month, counts := range p.months {
+		tiles := make(map[string]int64)
+		for t, n := range counts {
+			tiles[fmt.Sprintf("%d/%d/%d", t.Z, t.X, t.Y)] = n
+		}
+		saved[month] = tiles
+	}
+	b, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(p.path, b); err != nil {
+		return err
+	}
+	p.dirty = false
+	return nil
+}
+
+// StartPopularity periodically persists the popularity counts.
+func (h *Server) StartPopularity(interval time.Duration) {
+	go func() {
+		for {
+			time.Sleep(interval)
+			if err := h.popularity.Save(); err != nil {
+				fmt.Println(err)
+				sentry.CaptureException(err)
+			}
+		}
+	}()
+}
+
+// popularityWindow is the months served by default: this month and the
+// last, so the picture isn't empty early in a month.
+func popularityWindow(now time.Time) []string {
+	now = now.UTC()
+	previous := time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, time.UTC)
+	return []string{monthOf(previous), monthOf(now)}
+}
+
+// servePopularity handles GET /api/popularity, the grid of requested
+// tiles as GeoJSON, a PNG heatmap or CSV.
+func (h *Server) servePopularity(w http.ResponseWriter, r *http.Request) {
+	months := popularityWindow(time.Now())
+	if month := r.URL.Query().Get("month"); month != "" {
+		if _, err := time.Parse("2006-01", month); err != nil {
+			w.WriteHeader(400)
+			fmt.Fprintf(w, "Error: month must be YYYY-MM")
+			return
+		}
+		months = []string{month}
+	}
+	counts := h.popularity.Counts(months)
+
+	switch r.URL.Query().Get("format") {
+	case "", "geojson":
+		fc := geojson.NewFeatureCollection()
+		for _, c := range counts {
+			f := geojson.NewFeature(c.Tile.Bound().ToPolygon())
+			f.Properties["Tile"] = fmt.Sprintf("%d/%d/%d", c.Tile.Z, c.Tile.X, c.Tile.Y)
+			f.Properties["Count"] = c.Count
+			fc.Append(f)
+		}
+		w.Header().Set("Content-Type", "application/geo+json")
+		json.NewEncoder(w).Encode(fc)
+	case "png":
+		w.Header().Set("Content-Type", "image/png")
+		png.Encode(w, popularityImage(counts))
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"z", "x", "y", "count", "min_lon", "min_lat", "max_lon", "max_lat"})
+		for _, c := range counts {
+			b := c.Tile.Bound()
+			cw.Write([]string{
+				strconv.Itoa(int(c.Tile.Z)), strconv.Itoa(int(c.Tile.X)), strconv.Itoa(int(c.Tile.Y)),
+				strconv.FormatInt(c.Count, 10),
+				strconv.FormatFloat(b.Min[0], 'f', -1, 64), strconv.FormatFloat(b.Min[1], 'f', -1, 64),
+				strconv.FormatFloat(b.Max[0], 'f', -1, 64), strconv.FormatFloat(b.Max[1], 'f', -1, 64),
+			})
+		}
+		cw.Flush()
+	default:
+		w.WriteHeader(400)
+		fmt.Fprintf(w, "Error: format must be geojson, png or csv")
+	}
+}
+
+// popularityImage draws one pixel per grid tile in web mercator order,
+// transparent where nothing was requested and more opaque red on a log
+// scale up to the most requested tile.
+func popularityImage(counts []TileCount) image.Image {
+	size := 1 << popularityZoom
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	if len(counts) == 0 {
+		return img
+	}
+	most := math.Log1p(float64(counts[0].Count))
+	for _, c := range counts {
+		alpha := 64 + 191*math.Log1p(float64(c.Count))/most
+		img.SetNRGBA(int(c.Tile.X), int(c.Tile.Y), color.NRGBA{R: 255, A: uint8(alpha)})
+	}
+	return img
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"image/png"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/maptile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPopularityRecordPersistsAndPrunes(t *testing.T) {
+	dir := t.TempDir()
+	p, err := LoadPopularity(dir)
+	assert.Nil(t, err)
+	now := time.Date(2026, 5, 10, 0, 0, 0, 0, time.UTC)
+	// spans two z6 tiles side by side.
+	two := orb.Bound{Min: orb.Point{3, 42}, Max: orb.Point{8, 43}}.ToPolygon()
+	one := orb.Bound{Min: orb.Point{6.5, 42}, Max: orb.Point{8, 43}}.ToPolygon()
+	p.Record(two, now)
+	p.Record(one, now)
+	p.Record(one, time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC))
+	assert.Nil(t, p.Save())
+
+	reloaded, err := LoadPopularity(dir)
+	assert.Nil(t, err)
+	counts := reloaded.Counts([]string{"2026-05"})
+	assert.Equal(t, []TileCount{{maptile.New(33, 23, popularityZoom), 2}, {maptile.New(32, 23, popularityZoom), 1}}, counts)
+	assert.Equal(t, 1, len(reloaded.Counts([]string{"2026-01"})))
+
+	// a new month drops those older than the window.
+	reloaded.Record(one, time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC))
+	assert.Empty(t, reloaded.Counts([]string{"2026-01"}))
+	assert.Equal(t, int64(3), reloaded.Counts([]string{"2026-05", "2026-06"})[0].Count)
+}
+
+func TestServePopularity(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.StartWorkers()
+	code, _ := submit(h, richmond)
+	assert.Equal(t, 201, code)
+	tile := maptile.At(orb.Point{-77.43, 37.54}, popularityZoom)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/api/popularity"+query, nil))
+		return w
+	}
+
+	w := get("")
+	assert.Equal(t, 200, w.Code)
+	fc, err := geojson.UnmarshalFeatureCollection(w.Body.Bytes())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(fc.Features))
+	assert.Equal(t, 1.0, fc.Features[0].Properties["Count"])
+	assert.Equal(t, "6/18/24", fc.Features[0].Properties["Tile"])
+
+	w = get("?format=csv")
+	assert.Equal(t, 200, w.Code)
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	assert.Equal(t, 2, len(lines))
+	assert.True(t, strings.HasPrefix(lines[1], "6,18,24,1,"))
+
+	w = get("?format=png")
+	assert.Equal(t, 200, w.Code)
+	img, err := png.Decode(w.Body)
+	assert.Nil(t, err)
+	_, _, _, alpha := img.At(int(tile.X), int(tile.Y)).RGBA()
+	assert.NotZero(t, alpha)
+	_, _, _, alpha = img.At(0, 0).RGBA()
+	assert.Zero(t, alpha)
+
+	w = get("?month=2000-01")
+	assert.Equal(t, 200, w.Code)
+	var empty struct{ Features []any }
+	json.NewDecoder(w.Body).Decode(&empty)
+	assert.Empty(t, empty.Features)
+
+	assert.Equal(t, 400, get("?month=last").Code)
+	assert.Equal(t, 400, get("?format=svg").Code)
+}
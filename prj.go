@@ -0,0 +1,333 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/paulmach/orb"
+)
+
+// a node of a WKT coordinate system, such as PROJCS["name",...].
+type wktNode struct {
+	name string
+	args []any // string, float64 or *wktNode
+}
+
+// child returns the first argument node named name.
+func (n *wktNode) child(name string) *wktNode {
+	for _, arg := range n.args {
+		if c, ok := arg.(*wktNode); ok && strings.EqualFold(c.name, name) {
+			return c
+		}
+	}
+	return nil
+}
+
+func (n *wktNode) number(i int) (float64, bool) {
+	if i >= len(n.args) {
+		return 0, false
+	}
+	v, ok := n.args[i].(float64)
+	return v, ok
+}
+
+func (n *wktNode) text(i int) string {
+	if i >= len(n.args) {
+		return ""
+	}
+	s, _ := n.args[i].(string)
+	return s
+}
+
+// parseWKT reads the WKT of a .prj file.
+func parseWKT(s string) (*wktNode, error) {
+	p := wktParser{s: s}
+	n, err := p.node()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.i != len(p.s) {
+		return nil, errors.New("the .prj file has trailing text")
+	}
+	return n, nil
+}
+
+type wktParser struct {
+	s string
+	i int
+}
+
+func (p *wktParser) skipSpace() {
+	for p.i < len(p.s) && unicode.IsSpace(rune(p.s[p.i])) {
+		p.i++
+	}
+}
+
+func (p *wktParser) node() (*wktNode, error) {
+	p.skipSpace()
+	start := p.i
+	for p.i < len(p.s) && (p.s[p.i] == '_' || unicode.IsLetter(rune(p.s[p.i])) || unicode.IsDigit(rune(p.s[p.i]))) {
+		p.i++
+	}
+	n := &wktNode{name: p.s[start:p.i]}
+	p.skipSpace()
+	// a bare keyword argument, like the direction in AXIS["Easting",EAST].
+	if n.name != "" && start > 0 && p.i < len(p.s) && strings.IndexByte(",])", p.s[p.i]) >= 0 {
+		return n, nil
+	}
+	if n.name == "" || p.i == len(p.s) || (p.s[p.i] != '[' && p.s[p.i] != '(') {
+		return nil, errors.New("the .prj file is not WKT")
+	}
+	p.i++
+	for {
+		p.skipSpace()
+		if p.i == len(p.s) {
+			return nil, errors.New("the .prj file is not WKT")
+		}
+		switch c := p.s[p.i]; {
+		case c == '"':
+			end := strings.IndexByte(p.s[p.i+1:], '"')
+			if end < 0 {
+				return nil, errors.New("the .prj file is not WKT")
+			}
+			n.args = append(n.args, p.s[p.i+1:p.i+1+end])
+			p.i += end + 2
+		case c == '-' || c == '+' || c == '.' || unicode.IsDigit(rune(c)):
+			start := p.i
+			for p.i < len(p.s) && strings.IndexByte("+-.eE0123456789", p.s[p.i]) >= 0 {
+				p.i++
+			}
+			v, err := strconv.ParseFloat(p.s[start:p.i], 64)
+			if err != nil {
+				return nil, errors.New("the .prj file is not WKT")
+			}
+			n.args = append(n.args, v)
+		default:
+			child, err := p.node()
+			if err != nil {
+				return nil, err
+			}
+			n.args = append(n.args, child)
+		}
+		p.skipSpace()
+		if p.i == len(p.s) {
+			return nil, errors.New("the .prj file is not WKT")
+		}
+		switch p.s[p.i] {
+		case ',':
+			p.i++
+		case ']', ')':
+			p.i++
+			return n, nil
+		default:
+			return nil, errors.New("the .prj file is not WKT")
+		}
+	}
+}
+
+// a projection converts projected coordinates, in meters from the
+// false origin, to longitude and latitude in radians.
+type projection func(x, y float64) (lon, lat float64)
+
+// prjUnproject returns the function that converts the coordinates of
+// a .prj coordinate system to WGS84 longitude and latitude. Datum
+// shifts are not applied, which is within a few meters for NAD83 and
+// ETRS89. Only geographic coordinates and the Mercator, Transverse
+// Mercator and Lambert Conformal Conic projections are supported.
+func prjUnproject(wkt string) (func(orb.Point) orb.Point, error) {
+	root, err := parseWKT(wkt)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToUpper(root.name) {
+	case "GEOGCS":
+		return func(p orb.Point) orb.Point { return p }, nil
+	case "PROJCS":
+	default:
+		return nil, fmt.Errorf("coordinate system %s is not supported", root.name)
+	}
+
+	a, e := 6378137.0, 0.0818191908426215 // WGS84
+	if spheroid := findWKT(root, "SPHEROID"); spheroid != nil {
+		major, ok1 := spheroid.number(1)
+		invf, ok2 := spheroid.number(2)
+		if !ok1 || !ok2 {
+			return nil, errors.New("the SPHEROID of the .prj file is invalid")
+		}
+		a, e = major, 0
+		if invf != 0 {
+			f := 1 / invf
+			e = math.Sqrt(2*f - f*f)
+		}
+	}
+	params := make(map[string]float64)
+	for _, arg := range root.args {
+		if c, ok := arg.(*wktNode); ok && strings.EqualFold(c.name, "PARAMETER") {
+			if v, ok := c.number(1); ok {
+				params[strings.ToLower(c.text(0))] = v
+			}
+		}
+	}
+	unit := 1.0
+	if u := root.child("UNIT"); u != nil {
+		if v, ok := u.number(1); ok && v > 0 {
+			unit = v
+		}
+	}
+	radians := func(name string) float64 { return params[name] * math.Pi / 180 }
+	scale := 1.0
+	if k, ok := params["scale_factor"]; ok {
+		scale = k
+	}
+
+	var unproject projection
+	name := ""
+	if p := root.child("PROJECTION"); p != nil {
+		name = strings.ToLower(p.text(0))
+	}
+	switch name {
+	case "transverse_mercator":
+		unproject = transverseMercator(a, e, scale, radians("central_meridian"), radians("latitude_of_origin"))
+	case "mercator_auxiliary_sphere", "popular_visualisation_pseudo_mercator":
+		unproject = mercator(a, 0, 1, radians("central_meridian"))
+	case "mercator", "mercator_1sp", "mercator_2sp":
+		// EPSG WKT writes web mercator as Mercator_1SP on a sphere.
+		if isPseudoMercator(root) {
+			unproject = mercator(a, 0, 1, radians("central_meridian"))
+			break
+		}
+		if _, ok := params["standard_parallel_1"]; ok {
+			phi := radians("standard_parallel_1")
+			scale = math.Cos(phi) / math.Sqrt(1-e*e*math.Sin(phi)*math.Sin(phi))
+		}
+		unproject = mercator(a, e, scale, radians("central_meridian"))
+	case "lambert_conformal_conic", "lambert_conformal_conic_1sp", "lambert_conformal_conic_2sp":
+		phi1, phi2 := radians("latitude_of_origin"), radians("latitude_of_origin")
+		if _, ok := params["standard_parallel_1"]; ok {
+			phi1 = radians("standard_parallel_1")
+			phi2 = phi1
+		}
+		if _, ok := params["standard_parallel_2"]; ok {
+			phi2 = radians("standard_parallel_2")
+		}
+		unproject = lambertConformalConic(a, e, scale, radians("central_meridian"), radians("latitude_of_origin"), phi1, phi2)
+	default:
+		return nil, fmt.Errorf("projection %q is not supported, reproject the region to WGS84", name)
+	}
+	falseEasting, falseNorthing := params["false_easting"]*unit, params["false_northing"]*unit
+	return func(p orb.Point) orb.Point {
+		lon, lat := unproject(p[0]*unit-falseEasting, p[1]*unit-falseNorthing)
+		return orb.Point{lon * 180 / math.Pi, lat * 180 / math.Pi}
+	}, nil
+}
+
+// isPseudoMercator recognizes EPSG:3857 by its authority or name.
+func isPseudoMercator(root *wktNode) bool {
+	if authority := root.child("AUTHORITY"); authority != nil && authority.text(1) == "3857" {
+		return true
+	}
+	name := strings.ToLower(root.text(0))
+	return strings.Contains(name, "pseudo-mercator") || strings.Contains(name, "pseudo_mercator")
+}
+
+// findWKT returns the first node named name anywhere below n.
+func findWKT(n *wktNode, name string) *wktNode {
+	for _, arg := range n.args {
+		if c, ok := arg.(*wktNode); ok {
+			if strings.EqualFold(c.name, name) {
+				return c
+			}
+			if found := findWKT(c, name); found != nil {
+				return found
+			}
+		}
+	}
+	return nil
+}
+
+// conformalLatitude inverts the isometric latitude t of the ellipsoid
+// with eccentricity e, as in Snyder's equation 7-9.
+func conformalLatitude(t, e float64) float64 {
+	phi := math.Pi/2 - 2*math.Atan(t)
+	for i := 0; i < 15; i++ {
+		esin := e * math.Sin(phi)
+		next := math.Pi/2 - 2*math.Atan(t*math.Pow((1-esin)/(1+esin), e/2))
+		if math.Abs(next-phi) < 1e-12 {
+			return next
+		}
+		phi = next
+	}
+	return phi
+}
+
+func mercator(a, e, k0, lon0 float64) projection {
+	return func(x, y float64) (float64, float64) {
+		return lon0 + x/(a*k0), conformalLatitude(math.Exp(-y/(a*k0)), e)
+	}
+}
+
+// transverseMercator is the inverse of Snyder's equations 8-9 to 8-25.
+func transverseMercator(a, e, k0, lon0, lat0 float64) projection {
+	e2 := e * e
+	e4, e6 := e2*e2, e2*e2*e2
+	ep2 := e2 / (1 - e2)
+	meridian := func(phi float64) float64 {
+		return a * ((1-e2/4-3*e4/64-5*e6/256)*phi -
+			(3*e2/8+3*e4/32+45*e6/1024)*math.Sin(2*phi) +
+			(15*e4/256+45*e6/1024)*math.Sin(4*phi) -
+			(35*e6/3072)*math.Sin(6*phi))
+	}
+	m0 := meridian(lat0)
+	e1 := (1 - math.Sqrt(1-e2)) / (1 + math.Sqrt(1-e2))
+	return func(x, y float64) (float64, float64) {
+		mu := (m0 + y/k0) / (a * (1 - e2/4 - 3*e4/64 - 5*e6/256))
+		phi1 := mu + (3*e1/2-27*math.Pow(e1, 3)/32)*math.Sin(2*mu) +
+			(21*e1*e1/16-55*math.Pow(e1, 4)/32)*math.Sin(4*mu) +
+			(151*math.Pow(e1, 3)/96)*math.Sin(6*mu) +
+			(1097*math.Pow(e1, 4)/512)*math.Sin(8*mu)
+		sin, cos, tan := math.Sin(phi1), math.Cos(phi1), math.Tan(phi1)
+		c1 := ep2 * cos * cos
+		t1 := tan * tan
+		n1 := a / math.Sqrt(1-e2*sin*sin)
+		r1 := a * (1 - e2) / math.Pow(1-e2*sin*sin, 1.5)
+		d := x / (n1 * k0)
+		lat := phi1 - (n1*tan/r1)*(d*d/2-
+			(5+3*t1+10*c1-4*c1*c1-9*ep2)*math.Pow(d, 4)/24+
+			(61+90*t1+298*c1+45*t1*t1-252*ep2-3*c1*c1)*math.Pow(d, 6)/720)
+		lon := lon0 + (d-(1+2*t1+c1)*math.Pow(d, 3)/6+
+			(5-2*c1+28*t1-3*c1*c1+8*ep2+24*t1*t1)*math.Pow(d, 5)/120)/cos
+		return lon, lat
+	}
+}
+
+// lambertConformalConic is the inverse of Snyder's equations 15-1 to
+// 15-11, with one standard parallel when phi1 equals phi2.
+func lambertConformalConic(a, e, k0, lon0, lat0, phi1, phi2 float64) projection {
+	m := func(phi float64) float64 {
+		return math.Cos(phi) / math.Sqrt(1-e*e*math.Sin(phi)*math.Sin(phi))
+	}
+	t := func(phi float64) float64 {
+		esin := e * math.Sin(phi)
+		return math.Tan(math.Pi/4-phi/2) / math.Pow((1-esin)/(1+esin), e/2)
+	}
+	n := math.Sin(phi1)
+	if math.Abs(phi1-phi2) > 1e-10 {
+		n = (math.Log(m(phi1)) - math.Log(m(phi2))) / (math.Log(t(phi1)) - math.Log(t(phi2)))
+	}
+	f := m(phi1) / (n * math.Pow(t(phi1), n))
+	rho0 := a * k0 * f * math.Pow(t(lat0), n)
+	return func(x, y float64) (float64, float64) {
+		sign := 1.0
+		if n < 0 {
+			sign = -1
+		}
+		rho := sign * math.Hypot(x, rho0-y)
+		theta := math.Atan2(sign*x, sign*(rho0-y))
+		return lon0 + theta/n, conformalLatitude(math.Pow(rho/(a*k0*f), 1/n), e)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrjGeographic(t *testing.T) {
+	unproject, err := prjUnproject(`GEOGCS["GCS_WGS_1984",DATUM["D_WGS_1984",SPHEROID["WGS_1984",6378137.0,298.257223563]],PRIMEM["Greenwich",0.0],UNIT["Degree",0.0174532925199433]]`)
+	assert.Nil(t, err)
+	assert.Equal(t, orb.Point{-77.4, 37.5}, unproject(orb.Point{-77.4, 37.5}))
+}
+
+// the OGC WKT of EPSG:3857, with bare AXIS directions.
+func TestPrjPseudoMercator(t *testing.T) {
+	unproject, err := prjUnproject(`PROJCS["WGS 84 / Pseudo-Mercator",GEOGCS["WGS 84",DATUM["WGS_1984",SPHEROID["WGS 84",6378137,298.257223563]]],PROJECTION["Mercator_1SP"],PARAMETER["central_meridian",0],PARAMETER["scale_factor",1],PARAMETER["false_easting",0],PARAMETER["false_northing",0],UNIT["metre",1],AXIS["Easting",EAST],AXIS["Northing",NORTH],AUTHORITY["EPSG","3857"]]`)
+	assert.Nil(t, err)
+	p := unproject(orb.Point{-8622484.93, 4512848.66})
+	assert.InDelta(t, -77.4571, p[0], 1e-6)
+	assert.InDelta(t, 37.5272, p[1], 1e-5)
+}
+
+// the numerical examples of Snyder, Map Projections: A Working Manual,
+// on the Clarke 1866 ellipsoid.
+func TestPrjTransverseMercator(t *testing.T) {
+	unproject, err := prjUnproject(`PROJCS["test",GEOGCS["NAD27",DATUM["North_American_Datum_1927",SPHEROID["Clarke 1866",6378206.4,294.978698213898]]],PROJECTION["Transverse_Mercator"],PARAMETER["latitude_of_origin",0],PARAMETER["central_meridian",-75],PARAMETER["scale_factor",0.9996],PARAMETER["false_easting",0],PARAMETER["false_northing",0],UNIT["metre",1]]`)
+	assert.Nil(t, err)
+	p := unproject(orb.Point{127106.5, 4484124.4})
+	assert.InDelta(t, -73.5, p[0], 1e-5)
+	assert.InDelta(t, 40.5, p[1], 1e-5)
+}
+
+func TestPrjLambertConformalConic(t *testing.T) {
+	unproject, err := prjUnproject(`PROJCS["test",GEOGCS["NAD27",DATUM["North_American_Datum_1927",SPHEROID["Clarke 1866",6378206.4,294.978698213898]]],PROJECTION["Lambert_Conformal_Conic_2SP"],PARAMETER["standard_parallel_1",33],PARAMETER["standard_parallel_2",45],PARAMETER["latitude_of_origin",23],PARAMETER["central_meridian",-96],PARAMETER["false_easting",0],PARAMETER["false_northing",0],UNIT["metre",1]]`)
+	assert.Nil(t, err)
+	p := unproject(orb.Point{1894410.9, 1564649.5})
+	assert.InDelta(t, -75, p[0], 1e-5)
+	assert.InDelta(t, 35, p[1], 1e-5)
+
+	// the same point with coordinates in US survey feet.
+	unproject, err = prjUnproject(`PROJCS["test",GEOGCS["NAD27",DATUM["North_American_Datum_1927",SPHEROID["Clarke 1866",6378206.4,294.978698213898]]],PROJECTION["Lambert_Conformal_Conic_2SP"],PARAMETER["standard_parallel_1",33],PARAMETER["standard_parallel_2",45],PARAMETER["latitude_of_origin",23],PARAMETER["central_meridian",-96],PARAMETER["false_easting",0],PARAMETER["false_northing",0],UNIT["US survey foot",0.304800609601219]]`)
+	assert.Nil(t, err)
+	p = unproject(orb.Point{1894410.9 / 0.304800609601219, 1564649.5 / 0.304800609601219})
+	assert.InDelta(t, -75, p[0], 1e-5)
+	assert.InDelta(t, 35, p[1], 1e-5)
+}
+
+func TestPrjUnsupported(t *testing.T) {
+	_, err := prjUnproject(`PROJCS["test",GEOGCS["WGS 84",DATUM["WGS_1984",SPHEROID["WGS 84",6378137,298.257223563]]],PROJECTION["Albers_Conic_Equal_Area"],UNIT["metre",1]]`)
+	assert.Equal(t, `projection "albers_conic_equal_area" is not supported, reproject the region to WGS84`, err.Error())
+	_, err = prjUnproject(`PROJCS["test"`)
+	assert.NotNil(t, err)
+}
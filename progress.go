@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+)
+
+// setProgress replaces the in-memory progress of a job. Once a job has
+// started its progress is encoded here, by the worker, so polling
+// clients are served cached bytes instead of re-encoding under the lock.
+// Queued jobs are encoded on request since their QueuePosition changes.
+func (h *Server) setProgress(uuid string, progress Progress) {
+	var encoded []byte
+	if progress.StartedAt != "" {
+		encoded, _ = json.Marshal(progress)
+		encoded = append(encoded, '\n')
+	}
+	h.progressMutex.Lock()
+	h.progress[uuid] = progress
+	if encoded != nil {
+		h.progressJSON[uuid] = encoded
+	} else {
+		delete(h.progressJSON, uuid)
+	}
+	h.progressMutex.Unlock()
+}
+
+func (h *Server) currentProgress(uuid string) Progress {
+	h.progressMutex.RLock()
+	defer h.progressMutex.RUnlock()
+	return h.progress[uuid]
+}
+
+// hasProgress reports whether a job is queued or running.
+func (h *Server) hasProgress(uuid string) bool {
+	h.progressMutex.RLock()
+	defer h.progressMutex.RUnlock()
+	_, ok := h.progress[uuid]
+	return ok
+}
+
+// setStage marks the stage a running job has reached.
+func (h *Server) setStage(uuid string, stage string) {
+	progress := h.currentProgress(uuid)
+	progress.Stage = stage
+	progress.Stalled = false
+	progress.PercentComplete = h.percentComplete(uuid, progress)
+	h.setProgress(uuid, progress)
+}
+
+// takeProgress removes a job from memory, returning its last progress.
+func (h *Server) takeProgress(uuid string) Progress {
+	h.progressMutex.Lock()
+	progress := h.progress[uuid]
+	delete(h.progress, uuid)
+	delete(h.progressJSON, uuid)
+	h.progressMutex.Unlock()
+	h.pollers.Delete(uuid)
+	return progress
+}
+
+// serveProgress writes the progress of a queued or running job with
+// the documents in includes, returning false if the job is not in
+// memory.
+func (h *Server) serveProgress(w http.ResponseWriter, uuid string, includes []string) bool {
+	h.progressMutex.RLock()
+	encoded := h.progressJSON[uuid]
+	progress, ok := h.progress[uuid]
+	h.progressMutex.RUnlock()
+	if !ok {
+		return false
+	}
+
+	counter, _ := h.pollers.LoadOrStore(uuid, new(atomic.Int64))
+	counter.(*atomic.Int64).Add(1)
+	defer counter.(*atomic.Int64).Add(-1)
+
+	w.Header().Set("Content-Type", "application/json")
+	if encoded == nil {
+		progress.QueuePosition = h.queue.Position(uuid)
+		encoded, _ = json.Marshal(progress)
+		encoded = append(encoded, '\n')
+	}
+	w.Write(h.withIncludes(encoded, uuid, includes))
+	return true
+}
+
+// activePollers counts the requests currently reading the progress of
+// each job, omitting jobs nobody is polling.
+func (h *Server) activePollers() map[string]int64 {
+	pollers := make(map[string]int64)
+	h.pollers.Range(func(key, value any) bool {
+		if n := value.(*atomic.Int64).Load(); n > 0 {
+			pollers[key.(string)] = n
+		}
+		return true
+	})
+	return pollers
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newProgressServer() *Server {
+	return &Server{progress: make(map[string]Progress), progressJSON: make(map[string][]byte), queue: NewScheduler("fifo", 10)}
+}
+
+func TestServeProgress(t *testing.T) {
+	h := newProgressServer()
+	h.queue.Push(Task{Uuid: "a"}, 0)
+	h.queue.Push(Task{Uuid: "b"}, 0)
+	h.setProgress("b", Progress{})
+
+	w := httptest.NewRecorder()
+	assert.True(t, h.serveProgress(w, "b", nil))
+	var progress Progress
+	json.NewDecoder(w.Body).Decode(&progress)
+	assert.Equal(t, 2, progress.QueuePosition)
+
+	h.setProgress("b", Progress{StartedAt: "2024-01-01T00:00:00Z", NodesProg: 5})
+	w = httptest.NewRecorder()
+	h.serveProgress(w, "b", nil)
+	assert.Equal(t, string(h.progressJSON["b"]), w.Body.String())
+	assert.Contains(t, w.Body.String(), `"NodesProg":5`)
+
+	h.takeProgress("b")
+	assert.False(t, h.serveProgress(httptest.NewRecorder(), "b", nil))
+	assert.Empty(t, h.progressJSON)
+}
+
+// blockingWriter holds a poll open until released.
+type blockingWriter struct {
+	*httptest.ResponseRecorder
+	release chan struct{}
+}
+
+func (b blockingWriter) Write(p []byte) (int, error) {
+	<-b.release
+	return b.ResponseRecorder.Write(p)
+}
+
+func TestActivePollers(t *testing.T) {
+	h := newProgressServer()
+	h.setProgress("a", Progress{StartedAt: "2024-01-01T00:00:00Z"})
+
+	release := make(chan struct{})
+	done := make(chan struct{})
+	for i := 0; i < 3; i++ {
+		go func() {
+			h.serveProgress(blockingWriter{httptest.NewRecorder(), release}, "a", nil)
+			done <- struct{}{}
+		}()
+	}
+	waitFor(t, func() bool { return h.activePollers()["a"] == 3 })
+	close(release)
+	for i := 0; i < 3; i++ {
+		<-done
+	}
+	assert.Empty(t, h.activePollers())
+}
+
+// polling throughput while the worker emits progress as fast as it can.
+func BenchmarkPollProgress(b *testing.B) {
+	h := newProgressServer()
+	h.setProgress("a", Progress{StartedAt: "2024-01-01T00:00:00Z"})
+	stop := make(chan struct{})
+	go func() {
+		for i := int64(0); ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				h.setProgress("a", Progress{StartedAt: "2024-01-01T00:00:00Z", NodesProg: i})
+			}
+		}
+	}()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			h.serveProgress(httptest.NewRecorder(), "a", nil)
+		}
+	})
+	close(stop)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// What ran to produce a result, so it can be reproduced or explained
+// after the data file has been rotated or osmx upgraded.
+type Provenance struct {
+	// the data file after resolving symlinks, and its identity when
+	// the extract started.
+	DataPath      string
+	DataModified  string `json:",omitempty"`
+	DataSizeBytes int64  `json:",omitempty"`
+
+	OsmxVersion string `json:",omitempty"`
+
+	// the full argument vector passed to osmx.
+	Args []string
+
+	// the token that let the job through the nodes limit.
+	LimitOverride *LimitOverride `json:",omitempty"`
+}
+
+// queryVersion asks osmx for its version, once at startup.
+func (h *Server) queryVersion() string {
+	out, err := exec.Command(h.exec, "--version").Output()
+	if err != nil {
+		return ""
+	}
+	version, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	return version
+}
+
+func (h *Server) provenance(args []string) *Provenance {
+	p := &Provenance{DataPath: h.data, OsmxVersion: h.osmxVersion, Args: append([]string{h.exec}, args...)}
+	if resolved, err := filepath.EvalSymlinks(h.data); err == nil {
+		if abs, err := filepath.Abs(resolved); err == nil {
+			p.DataPath = abs
+		}
+	}
+	if stat, err := os.Stat(p.DataPath); err == nil {
+		p.DataModified = stat.ModTime().UTC().Format(time.RFC3339)
+		p.DataSizeBytes = stat.Size()
+	}
+	return p
+}
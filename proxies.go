@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// TrustedProxies are the reverse proxies whose forwarding headers name
+// the client. Headers from any other address are ignored, since clients
+// can send whatever they like.
+type TrustedProxies struct {
+	prefixes []netip.Prefix
+	unix     bool // connections over a unix domain socket
+}
+
+// parseTrustedProxies parses a comma separated list of CIDRs or single
+// addresses, and "unix" for a proxy connecting over a unix domain socket.
+func parseTrustedProxies(s string) (TrustedProxies, error) {
+	var t TrustedProxies
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if entry == "unix" {
+			t.unix = true
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			t.prefixes = append(t.prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return TrustedProxies{}, fmt.Errorf("invalid trusted proxy %q", entry)
+		}
+		t.prefixes = append(t.prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return t, nil
+}
+
+func (t TrustedProxies) trusts(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range t.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP is the address of the client that made the request. When the
+// connection is from a trusted proxy, the forwarding headers are walked
+// from the nearest hop outward past further trusted proxies, and the
+// first untrusted address is the client. The RFC 7239 Forwarded header
+// is preferred, then X-Forwarded-For, then X-Real-IP. A malformed hop
+// stops the walk at the last address that was well formed.
+func (t TrustedProxies) ClientIP(r *http.Request) string {
+	peer := remoteAddr(r)
+	var client netip.Addr
+	if _, ok := r.Context().Value(peerKey{}).(string); ok {
+		if !t.unix {
+			return peer
+		}
+	} else {
+		addr, ok := parseHop(peer)
+		if !ok {
+			return peer
+		}
+		if !t.trusts(addr) {
+			return addr.Unmap().String()
+		}
+		client = addr
+	}
+
+	hops := forwardedHops(r.Header)
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseHop(hops[i])
+		if !ok {
+			break
+		}
+		client = addr
+		if !t.trusts(addr) {
+			break
+		}
+	}
+	if !client.IsValid() {
+		return peer
+	}
+	return client.Unmap().String()
+}
+
+// forwardedHops lists the forwarded addresses of a request, the client
+// first and the nearest proxy last.
+func forwardedHops(header http.Header) []string {
+	var hops []string
+	if values := header.Values("Forwarded"); len(values) > 0 {
+		for _, element := range strings.Split(strings.Join(values, ","), ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					hops = append(hops, strings.Trim(value, `"`))
+				}
+			}
+		}
+		return hops
+	}
+	if values := header.Values("X-Forwarded-For"); len(values) > 0 {
+		for _, hop := range strings.Split(strings.Join(values, ","), ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+		return hops
+	}
+	if value := header.Get("X-Real-Ip"); value != "" {
+		return []string{strings.TrimSpace(value)}
+	}
+	return nil
+}
+
+// parseHop parses an address as it appears in a forwarding header or in
+// RemoteAddr: bare, with a port, or an IPv6 address in brackets.
+func parseHop(hop string) (netip.Addr, bool) {
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		hop = host
+	}
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(hop, "["), "]"))
+	if err != nil || addr.Zone() != "" {
+		return netip.Addr{}, false
+	}
+	return addr, true
+}
+
+type clientIPKey struct{}
+
+// resolveClientIP records the client address of every request, so the
+// access log, Sentry and the download counters all see the same one.
+func resolveClientIP(trusted TrustedProxies, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), clientIPKey{}, trusted.ClientIP(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requestHub is the Sentry hub of a request, carrying its client.
+func requestHub(r *http.Request) *sentry.Hub {
+	if hub := sentry.GetHubFromContext(r.Context()); hub != nil {
+		return hub
+	}
+	return sentry.CurrentHub()
+}
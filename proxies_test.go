@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func proxiedRequest(remote string, header map[string]string) *http.Request {
+	r := httptest.NewRequest("GET", "/api/", nil)
+	r.RemoteAddr = remote
+	for k, v := range header {
+		r.Header.Add(k, v)
+	}
+	return r
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.0/8, 192.0.2.1,::1,unix")
+	assert.Nil(t, err)
+	assert.True(t, proxies.unix)
+	assert.Equal(t, 3, len(proxies.prefixes))
+
+	_, err = parseTrustedProxies("10.0.0.0/8,proxy.local")
+	assert.NotNil(t, err)
+	_, err = parseTrustedProxies("10.0.0.0/33")
+	assert.NotNil(t, err)
+
+	proxies, err = parseTrustedProxies("")
+	assert.Nil(t, err)
+	assert.Empty(t, proxies.prefixes)
+}
+
+func TestClientIPUntrusted(t *testing.T) {
+	proxies, _ := parseTrustedProxies("10.0.0.0/8")
+	// spoofed headers from a client that isn't a proxy are ignored.
+	r := proxiedRequest("203.0.113.5:4000", map[string]string{"X-Forwarded-For": "1.2.3.4", "X-Real-IP": "1.2.3.4", "Forwarded": "for=1.2.3.4"})
+	assert.Equal(t, "203.0.113.5", proxies.ClientIP(r))
+
+	var none TrustedProxies
+	r = proxiedRequest("10.0.0.1:4000", map[string]string{"X-Forwarded-For": "1.2.3.4"})
+	assert.Equal(t, "10.0.0.1", none.ClientIP(r))
+}
+
+func TestClientIPChainedProxies(t *testing.T) {
+	proxies, _ := parseTrustedProxies("10.0.0.0/8")
+	// the client can prepend anything; the first untrusted hop from the
+	// right is the one that connected to our outer proxy.
+	r := proxiedRequest("10.0.0.1:4000", map[string]string{"X-Forwarded-For": "1.2.3.4, 203.0.113.5, 10.0.0.2"})
+	assert.Equal(t, "203.0.113.5", proxies.ClientIP(r))
+
+	// across several headers.
+	r = proxiedRequest("10.0.0.1:4000", nil)
+	r.Header.Add("X-Forwarded-For", "203.0.113.5")
+	r.Header.Add("X-Forwarded-For", "10.0.0.3, 10.0.0.2")
+	assert.Equal(t, "203.0.113.5", proxies.ClientIP(r))
+
+	// every hop is trusted: the leftmost is the client.
+	r = proxiedRequest("10.0.0.1:4000", map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"})
+	assert.Equal(t, "10.0.0.3", proxies.ClientIP(r))
+
+	r = proxiedRequest("10.0.0.1:4000", map[string]string{"X-Real-IP": "203.0.113.5"})
+	assert.Equal(t, "203.0.113.5", proxies.ClientIP(r))
+
+	// Forwarded wins over X-Forwarded-For.
+	r = proxiedRequest("10.0.0.1:4000", map[string]string{"Forwarded": `for=1.2.3.4, for="203.0.113.5:80";proto=https, for=10.0.0.2`, "X-Forwarded-For": "198.51.100.1"})
+	assert.Equal(t, "203.0.113.5", proxies.ClientIP(r))
+}
+
+func TestClientIPv6(t *testing.T) {
+	proxies, _ := parseTrustedProxies("::1/128,fd00::/8")
+	r := proxiedRequest("[::1]:4000", map[string]string{"X-Forwarded-For": "2001:db8::1, fd00::2"})
+	assert.Equal(t, "2001:db8::1", proxies.ClientIP(r))
+
+	r = proxiedRequest("[::1]:4000", map[string]string{"Forwarded": `for="[2001:db8::1]:4711", for="[fd00::2]"`})
+	assert.Equal(t, "2001:db8::1", proxies.ClientIP(r))
+
+	// IPv4 mapped addresses are matched and shown as IPv4.
+	proxies, _ = parseTrustedProxies("127.0.0.1")
+	r = proxiedRequest("[::ffff:127.0.0.1]:4000", map[string]string{"X-Forwarded-For": "::ffff:203.0.113.5"})
+	assert.Equal(t, "203.0.113.5", proxies.ClientIP(r))
+}
+
+func TestClientIPMalformed(t *testing.T) {
+	proxies, _ := parseTrustedProxies("10.0.0.0/8")
+	for _, header := range []map[string]string{
+		{"X-Forwarded-For": "not-an-ip, 10.0.0.2"},
+		{"X-Forwarded-For": "203.0.113.5:abc:def, 10.0.0.2"},
+		{"X-Forwarded-For": ", 10.0.0.2"},
+		{"X-Forwarded-For": "fe80::1%eth0, 10.0.0.2"},
+		{"Forwarded": "for=unknown, for=10.0.0.2"},
+		{"Forwarded": "for=_hidden;proto=http, for=10.0.0.2"},
+	} {
+		// the walk stops at the last hop that was well formed.
+		r := proxiedRequest("10.0.0.1:4000", header)
+		assert.Equal(t, "10.0.0.2", proxies.ClientIP(r), header)
+	}
+
+	r := proxiedRequest("10.0.0.1:4000", map[string]string{"X-Real-IP": "garbage"})
+	assert.Equal(t, "10.0.0.1", proxies.ClientIP(r))
+}
+
+func TestClientIPUnixSocket(t *testing.T) {
+	r := proxiedRequest("@", map[string]string{"X-Forwarded-For": "203.0.113.5"})
+	r = r.WithContext(context.WithValue(r.Context(), peerKey{}, "unix:pid=1,uid=0,gid=0"))
+
+	var none TrustedProxies
+	assert.Equal(t, "unix:pid=1,uid=0,gid=0", none.ClientIP(r))
+	proxies, _ := parseTrustedProxies("unix")
+	assert.Equal(t, "203.0.113.5", proxies.ClientIP(r))
+}
+
+func TestResolveClientIP(t *testing.T) {
+	proxies, _ := parseTrustedProxies("10.0.0.0/8")
+	var seen string
+	handler := resolveClientIP(proxies, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = clientIP(r)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), proxiedRequest("10.0.0.1:4000", map[string]string{"X-Forwarded-For": "203.0.113.5"}))
+	assert.Equal(t, "203.0.113.5", seen)
+}
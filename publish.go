@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// files being written into filesDir have this suffix until they are
+// complete and renamed into place, so one left by a crash is never
+// served or mistaken for a result.
+const partSuffix = ".part"
+
+// writeFileAtomic replaces path so readers never see a partial file,
+// even after a crash: the content is synced before the rename, and the
+// rename before returning.
+func writeFileAtomic(path string, b []byte) error {
+	part := path + partSuffix
+	f, err := os.Create(part)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(part, path)
+	}
+	if err != nil {
+		os.Remove(part)
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// publishFile moves a finished file from scratch to dst in filesDir,
+// syncing it first so a crash can't leave dst truncated. When scratch
+// is on another filesystem it is copied to a .part file next to dst
+// and renamed from there.
+func publishFile(src string, dst string) error {
+	if err := syncFile(src); err != nil {
+		return err
+	}
+	err := os.Rename(src, dst)
+	if errors.Is(err, syscall.EXDEV) {
+		err = copyFile(src, dst)
+		if err == nil {
+			err = os.Remove(src)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(dst))
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	part := dst + partSuffix
+	out, err := os.Create(part)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(part, dst)
+	}
+	if err != nil {
+		os.Remove(part)
+	}
+	return err
+}
+
+func syncFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// syncDir persists the renames in a directory.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	// some filesystems can't sync directories, which is no worse than
+	// not trying.
+	if err := d.Sync(); err != nil && !errors.Is(err, syscall.EINVAL) {
+		return err
+	}
+	return nil
+}
+
+// removePartFiles deletes the .part files a crash left in filesDir,
+// returning how many there were.
+func removePartFiles(filesDir string) (int, error) {
+	removed := 0
+	err := filepath.WalkDir(filesDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(d.Name(), partSuffix) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		fmt.Println("removed partial file", path)
+		removed++
+		return nil
+	})
+	return removed, err
+}
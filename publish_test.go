@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "record")
+	assert.Nil(t, writeFileAtomic(path, []byte("one")))
+	assert.Nil(t, writeFileAtomic(path, []byte("two")))
+	b, _ := os.ReadFile(path)
+	assert.Equal(t, "two", string(b))
+	_, err := os.Stat(path + partSuffix)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestPublishFile(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "a.osm.pbf")
+	os.WriteFile(src, []byte("pbf"), 0644)
+	dst := filepath.Join(t.TempDir(), "b.osm.pbf")
+	assert.Nil(t, publishFile(src, dst))
+	b, _ := os.ReadFile(dst)
+	assert.Equal(t, "pbf", string(b))
+	_, err := os.Stat(src)
+	assert.True(t, os.IsNotExist(err))
+
+	// the copy used across filesystems.
+	os.WriteFile(src, []byte("other"), 0644)
+	assert.Nil(t, copyFile(src, dst))
+	b, _ = os.ReadFile(dst)
+	assert.Equal(t, "other", string(b))
+	_, err = os.Stat(dst + partSuffix)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRemovePartFiles(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "blobs"), 0755)
+	os.WriteFile(filepath.Join(dir, "quota.json.part"), []byte("{"), 0644)
+	os.WriteFile(filepath.Join(dir, "blobs", "abc.osm.pbf.part"), []byte("x"), 0644)
+	os.WriteFile(filepath.Join(dir, "quota.json"), []byte("{}"), 0644)
+
+	removed, err := removePartFiles(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, removed)
+	_, err = os.Stat(filepath.Join(dir, "blobs", "abc.osm.pbf.part"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, "quota.json"))
+	assert.Nil(t, err)
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Monthly and daily usage per API key, persisted as quota.json in filesDir so it
+// survives restarts. Usage counts the actual NodesTotal and size of
+// completed extracts; estimates of jobs still in the queue are held
+// against the quota until they finish. The results each key keeps
+// stored are persisted as stored_results.json.
+type QuotaStore struct {
+	mutex      sync.Mutex
+	path       string
+	usage      map[string]*Usage
+	pending    map[string]int64
+	storedPath string
+	stored     map[string][]StoredResult // by key name, oldest first
+}
+
+// A completed result counted against the storage quota of its key.
+type StoredResult struct {
+	Uuid      string
+	SizeBytes int64
+}
+
+type Usage struct {
+	Month string // YYYY-MM in UTC
+	Nodes int64
+	Bytes int64
+	// the nodes of Day, YYYY-MM-DD in UTC.
+	Day      string `json:",omitempty"`
+	DayNodes int64  `json:",omitempty"`
+}
+
+// returned by GET /api/quota and in quota rejections.
+type QuotaStatus struct {
+	Key            string
+	Month          string
+	NodesUsed      int64
+	NodesPending   int64
+	NodesQuota     int64
+	BytesUsed      int64
+	BytesQuota     int64
+	RemainingNodes int64
+	ResetAt        string
+
+	Day                 string
+	DayNodesUsed        int64
+	DailyNodesQuota     int64
+	RemainingDailyNodes int64
+	DailyResetAt        string
+
+	// bytes of the key's results in storage, which doesn't reset.
+	StoredBytes       int64
+	StorageBytesQuota int64
+}
+
+type QuotaError struct {
+	Error string
+	QuotaStatus
+}
+
+func NewQuotaStore(filesDir string) (*QuotaStore, error) {
+	q := &QuotaStore{
+		path:       filepath.Join(filesDir, "quota.json"),
+		usage:      make(map[string]*Usage),
+		pending:    make(map[string]int64),
+		storedPath: filepath.Join(filesDir, "stored_results.json"),
+		stored:     make(map[string][]StoredResult),
+	}
+	b, err := os.ReadFile(q.path)
+	if err == nil {
+		err = json.Unmarshal(b, &q.usage)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	b, err = os.ReadFile(q.storedPath)
+	if err == nil {
+		err = json.Unmarshal(b, &q.stored)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return q, nil
+}
+
+func monthOf(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+func dayOf(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+func nextDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+}
+
+func nextMonth(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// current returns the usage of the key for this month and day,
+// resetting them at their boundaries. Requires the mutex.
+func (q *QuotaStore) current(name string, now time.Time) *Usage {
+	u, ok := q.usage[name]
+	if !ok || u.Month != monthOf(now) {
+		u = &Usage{Month: monthOf(now)}
+		q.usage[name] = u
+	}
+	if u.Day != dayOf(now) {
+		u.Day = dayOf(now)
+		u.DayNodes = 0
+	}
+	return u
+}
+
+func (q *QuotaStore) status(key *APIKey, now time.Time) QuotaStatus {
+	u := q.current(key.Name, now)
+	s := QuotaStatus{
+		Key:          key.Name,
+		Month:        u.Month,
+		NodesUsed:    u.Nodes,
+		NodesPending: q.pending[key.Name],
+		NodesQuota:   key.MonthlyNodesQuota,
+		BytesUsed:    u.Bytes,
+		BytesQuota:   key.MonthlyBytesQuota,
+		ResetAt:      nextMonth(now).Format(time.RFC3339),
+
+		Day:             u.Day,
+		DayNodesUsed:    u.DayNodes,
+		DailyNodesQuota: key.DailyNodesQuota,
+		DailyResetAt:    nextDay(now).Format(time.RFC3339),
+
+		StorageBytesQuota: key.StorageBytesQuota,
+	}
+	for _, result := range q.stored[key.Name] {
+		s.StoredBytes += result.SizeBytes
+	}
+	if key.MonthlyNodesQuota > 0 {
+		s.RemainingNodes = max(0, key.MonthlyNodesQuota-u.Nodes-s.NodesPending)
+	}
+	if key.DailyNodesQuota > 0 {
+		s.RemainingDailyNodes = max(0, key.DailyNodesQuota-u.DayNodes-s.NodesPending)
+	}
+	return s
+}
+
+func (q *QuotaStore) Status(key *APIKey) QuotaStatus {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.status(key, time.Now())
+}
+
+// Reserve holds the estimated nodes of a new job against the key's
+// quota, or returns an error if the job would exceed it.
+func (q *QuotaStore) Reserve(key *APIKey, nodes int64) *QuotaError {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	now := time.Now()
+	s := q.status(key, now)
+	if key.MonthlyNodesQuota > 0 && s.NodesUsed+s.NodesPending+nodes > key.MonthlyNodesQuota {
+		return &QuotaError{fmt.Sprintf("the monthly nodes quota would be exceeded, %d nodes remaining", s.RemainingNodes), s}
+	}
+	if key.DailyNodesQuota > 0 && s.DayNodesUsed+s.NodesPending+nodes > key.DailyNodesQuota {
+		return &QuotaError{fmt.Sprintf("the daily nodes quota would be exceeded, %d nodes remaining until %s", s.RemainingDailyNodes, s.DailyResetAt), s}
+	}
+	if key.MonthlyBytesQuota > 0 && s.BytesUsed >= key.MonthlyBytesQuota {
+		return &QuotaError{"the monthly bytes quota is exhausted", s}
+	}
+	if key.StorageBytesQuota > 0 && !key.ExpireOverQuota && s.StoredBytes >= key.StorageBytesQuota {
+		return &QuotaError{"the storage quota is full until results expire", s}
+	}
+	q.pending[key.Name] += nodes
+	return nil
+}
+
+// Hold holds the estimate of a job restored after a restart, which was
+// checked against the quota when it was submitted.
+func (q *QuotaStore) Hold(name string, nodes int64) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.pending[name] += nodes
+}
+
+// Release drops the hold of a job that did not complete.
+func (q *QuotaStore) Release(name string, estimate int64) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.release(name, estimate)
+}
+
+func (q *QuotaStore) release(name string, estimate int64) {
+	q.pending[name] -= estimate
+	if q.pending[name] <= 0 {
+		delete(q.pending, name)
+	}
+}
+
+// Record replaces the hold of a completed job with its actual size.
+func (q *QuotaStore) Record(name string, estimate int64, nodes int64, bytes int64) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.release(name, estimate)
+	u := q.current(name, time.Now())
+	u.Nodes += nodes
+	u.DayNodes += nodes
+	u.Bytes += bytes
+	return q.save()
+}
+
+func (q *QuotaStore) save() error {
+	b, err := json.Marshal(q.usage)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(q.path, b)
+}
+
+// Store counts a completed result against the storage quota of a key.
+func (q *QuotaStore) Store(name string, id string, bytes int64) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.stored[name] = append(q.stored[name], StoredResult{id, bytes})
+	return q.saveStored()
+}
+
+// Unstore stops counting a result that was deleted.
+func (q *QuotaStore) Unstore(id string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for name, results := range q.stored {
+		for i, result := range results {
+			if result.Uuid != id {
+				continue
+			}
+			if len(results) == 1 {
+				delete(q.stored, name)
+			} else {
+				q.stored[name] = append(results[:i], results[i+1:]...)
+			}
+			return q.saveStored()
+		}
+	}
+	return nil
+}
+
+// OverStorage lists the oldest results of a key to delete for the rest
+// to fit in its storage quota. The newest result is always kept.
+func (q *QuotaStore) OverStorage(key *APIKey) []string {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if key.StorageBytesQuota <= 0 {
+		return nil
+	}
+	results := q.stored[key.Name]
+	var total int64
+	for _, result := range results {
+		total += result.SizeBytes
+	}
+	var over []string
+	for i := 0; i < len(results)-1 && total > key.StorageBytesQuota; i++ {
+		over = append(over, results[i].Uuid)
+		total -= results[i].SizeBytes
+	}
+	return over
+}
+
+func (q *QuotaStore) saveStored() error {
+	b, err := json.Marshal(q.stored)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(q.storedPath, b)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuotaReserveAndRecord(t *testing.T) {
+	q, err := NewQuotaStore(t.TempDir())
+	assert.Nil(t, err)
+	key := &APIKey{Name: "partner", MonthlyNodesQuota: 1000}
+
+	assert.Nil(t, q.Reserve(key, 600))
+	quotaErr := q.Reserve(key, 600)
+	assert.NotNil(t, quotaErr)
+	assert.Equal(t, int64(400), quotaErr.RemainingNodes)
+
+	assert.Nil(t, q.Record("partner", 600, 500, 1234))
+	status := q.Status(key)
+	assert.Equal(t, int64(500), status.NodesUsed)
+	assert.Equal(t, int64(0), status.NodesPending)
+	assert.Equal(t, int64(1234), status.BytesUsed)
+	assert.Equal(t, int64(500), status.RemainingNodes)
+}
+
+func TestQuotaPersistsAndResets(t *testing.T) {
+	dir := t.TempDir()
+	q, _ := NewQuotaStore(dir)
+	key := &APIKey{Name: "partner", MonthlyNodesQuota: 1000}
+	q.Record("partner", 0, 700, 0)
+
+	reloaded, err := NewQuotaStore(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(700), reloaded.Status(key).NodesUsed)
+
+	reloaded.usage["partner"].Month = "2000-01"
+	assert.Equal(t, int64(0), reloaded.Status(key).NodesUsed)
+}
+
+func TestQuotaEndpoint(t *testing.T) {
+	q, _ := NewQuotaStore(t.TempDir())
+	h := Server{quotas: q, apiKeys: map[string]*APIKey{hashAPIKey("secret"): {Name: "partner", MonthlyNodesQuota: 1000}}}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/quota", nil))
+	assert.Equal(t, 401, w.Code)
+
+	r := httptest.NewRequest("GET", "/api/quota", nil)
+	r.Header.Set("Authorization", "Bearer wrong")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, 401, w.Code)
+
+	r = httptest.NewRequest("GET", "/api/quota", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, 200, w.Code)
+	var status QuotaStatus
+	json.NewDecoder(w.Body).Decode(&status)
+	assert.Equal(t, "partner", status.Key)
+	assert.Equal(t, int64(1000), status.RemainingNodes)
+}
+
+func TestDailyQuota(t *testing.T) {
+	q, _ := NewQuotaStore(t.TempDir())
+	key := &APIKey{Name: "partner", MonthlyNodesQuota: 10000, DailyNodesQuota: 1000}
+
+	assert.Nil(t, q.Reserve(key, 600))
+	quotaErr := q.Reserve(key, 600)
+	assert.NotNil(t, quotaErr)
+	assert.Contains(t, quotaErr.Error, "daily")
+	assert.Equal(t, int64(400), quotaErr.RemainingDailyNodes)
+	assert.Equal(t, int64(9400), quotaErr.RemainingNodes)
+
+	q.Record("partner", 600, 900, 0)
+	status := q.Status(key)
+	assert.Equal(t, int64(900), status.DayNodesUsed)
+	assert.Equal(t, int64(100), status.RemainingDailyNodes)
+	assert.NotNil(t, q.Reserve(key, 200))
+
+	// a new day resets the daily usage but not the monthly.
+	q.usage["partner"].Day = "2000-01-01"
+	status = q.Status(key)
+	assert.Equal(t, int64(0), status.DayNodesUsed)
+	assert.Equal(t, int64(900), status.NodesUsed)
+	assert.Nil(t, q.Reserve(key, 200))
+}
+
+func TestStorageQuota(t *testing.T) {
+	dir := t.TempDir()
+	q, _ := NewQuotaStore(dir)
+	key := &APIKey{Name: "partner", StorageBytesQuota: 1000}
+	assert.Nil(t, q.Store("partner", "a", 600))
+	assert.Nil(t, q.Reserve(key, 1))
+	assert.Nil(t, q.Store("partner", "b", 500))
+	quotaErr := q.Reserve(key, 1)
+	assert.NotNil(t, quotaErr)
+	assert.Equal(t, int64(1100), quotaErr.StoredBytes)
+	assert.Equal(t, []string{"a"}, q.OverStorage(key))
+
+	// with ExpireOverQuota, jobs are accepted and the oldest results go.
+	expiring := &APIKey{Name: "partner", StorageBytesQuota: 1000, ExpireOverQuota: true}
+	assert.Nil(t, q.Reserve(expiring, 1))
+
+	reloaded, err := NewQuotaStore(dir)
+	assert.Nil(t, err)
+	assert.Nil(t, reloaded.Unstore("a"))
+	assert.Equal(t, int64(500), reloaded.Status(key).StoredBytes)
+	assert.Nil(t, reloaded.OverStorage(key))
+	assert.Nil(t, reloaded.Unstore("b"))
+	assert.Empty(t, reloaded.stored)
+}
+
+func TestExpireOverStorage(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.apiKeys = map[string]*APIKey{hashAPIKey("secret"): {Name: "partner", StorageBytesQuota: 1, ExpireOverQuota: true}}
+	h.StartWorkers()
+	submitWithKey := func() string {
+		r := httptest.NewRequest("POST", "/api/", strings.NewReader(richmond))
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		var created Created
+		json.NewDecoder(w.Body).Decode(&created)
+		waitFor(t, func() bool {
+			_, progress := getProgress(h, created.Uuid)
+			return progress.Complete
+		})
+		return created.Uuid
+	}
+	first := submitWithKey()
+	second := submitWithKey()
+	assert.NotEqual(t, first, second)
+	code, _ := getProgress(h, first)
+	assert.Equal(t, 404, code)
+	code, _ = getProgress(h, second)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, second, h.quotas.stored["partner"][0].Uuid)
+	assert.Len(t, h.quotas.stored["partner"], 1)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultSubmitBurst = 10
+
+// rateLimiter is a token bucket for each client IP: a bucket holds up to
+// burst tokens and refills at rate tokens a second. Buckets are only
+// held in memory, and dropped once they have refilled, which is the same
+// as a new client's.
+type rateLimiter struct {
+	mutex    sync.Mutex
+	rate     float64 // 0 for no limit
+	burst    float64
+	buckets  map[string]*tokenBucket
+	prunedAt time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// refill adds the tokens earned since the bucket was last updated.
+func (l *rateLimiter) refill(b *tokenBucket, now time.Time) {
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.updated).Seconds()*l.rate)
+	b.updated = now
+}
+
+func (l *rateLimiter) limits() (float64, float64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.rate, l.burst
+}
+
+// setLimits changes the rate and burst of every bucket, keeping the
+// tokens each holds up to the new burst. Turning the limit on starts
+// every client with a full bucket.
+func (l *rateLimiter) setLimits(rate float64, burst float64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.rate <= 0 {
+		l.buckets = nil
+	}
+	now := time.Now()
+	for _, b := range l.buckets {
+		l.refill(b, now)
+		b.tokens = min(burst, b.tokens)
+	}
+	l.rate = rate
+	l.burst = burst
+}
+
+// take spends n tokens of the client's bucket if it holds at least one,
+// so a batch larger than the burst is accepted but leaves the bucket in
+// debt. Otherwise it returns how long until a token is earned.
+func (l *rateLimiter) take(ip string, n int, now time.Time) (time.Duration, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.rate <= 0 {
+		return 0, true
+	}
+	if l.buckets == nil {
+		l.buckets = make(map[string]*tokenBucket)
+	}
+	if now.Sub(l.prunedAt) > time.Minute {
+		for client, b := range l.buckets {
+			if l.refill(b, now); b.tokens >= l.burst {
+				delete(l.buckets, client)
+			}
+		}
+		l.prunedAt = now
+	}
+
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[ip] = b
+	}
+	l.refill(b, now)
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / l.rate * float64(time.Second)), false
+	}
+	b.tokens -= float64(n)
+	return 0, true
+}
+
+// limitSubmissions spends n submissions of the client's rate limit, or
+// writes 429 with Retry-After and returns false.
+func (h *Server) limitSubmissions(w http.ResponseWriter, r *http.Request, n int) bool {
+	wait, ok := h.submitLimiter.take(clientIP(r), n, time.Now())
+	if ok {
+		return true
+	}
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.WriteHeader(429)
+	fmt.Fprintf(w, "Error: too many submissions, retry in %d seconds", seconds)
+	return false
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := rateLimiter{rate: 1, burst: 2}
+	now := time.Now()
+	_, ok := l.take("a", 1, now)
+	assert.True(t, ok)
+	_, ok = l.take("a", 1, now)
+	assert.True(t, ok)
+	wait, ok := l.take("a", 1, now)
+	assert.False(t, ok)
+	assert.Equal(t, time.Second, wait)
+	_, ok = l.take("b", 1, now)
+	assert.True(t, ok)
+	_, ok = l.take("a", 1, now.Add(time.Second))
+	assert.True(t, ok)
+
+	// a batch may overdraw the bucket, and is paid back before the next.
+	_, ok = l.take("c", 5, now)
+	assert.True(t, ok)
+	wait, ok = l.take("c", 1, now.Add(time.Second))
+	assert.False(t, ok)
+	assert.Equal(t, 3*time.Second, wait)
+
+	// buckets in debt aren't pruned.
+	l.take("e", 500, now)
+	l.take("d", 1, now.Add(2*time.Minute))
+	assert.Contains(t, l.buckets, "e")
+	assert.NotContains(t, l.buckets, "c")
+	assert.NotContains(t, l.buckets, "a")
+
+	unlimited := rateLimiter{}
+	for i := 0; i < 100; i++ {
+		_, ok = unlimited.take("a", 1, now)
+		assert.True(t, ok)
+	}
+}
+
+func TestSetLimits(t *testing.T) {
+	l := rateLimiter{rate: 0.001, burst: 10}
+	now := time.Now().Add(time.Second)
+	l.take("a", 1, now)
+	// a lower burst caps the tokens a client has saved.
+	l.setLimits(0.001, 3)
+	for i := 0; i < 3; i++ {
+		_, ok := l.take("a", 1, now)
+		assert.True(t, ok)
+	}
+	_, ok := l.take("a", 1, now)
+	assert.False(t, ok)
+
+	// turning the limit off and on again forgets the debt.
+	l.setLimits(0, 3)
+	l.setLimits(0.001, 3)
+	_, ok = l.take("a", 1, now)
+	assert.True(t, ok)
+}
+
+func TestSubmitRateLimit(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	// no workers: submissions stay queued.
+	h.queue = NewScheduler("fifo", 10)
+	h.progress = make(map[string]Progress)
+	h.submitLimiter = rateLimiter{rate: 1.0 / 60, burst: 2}
+	proxies, _ := parseTrustedProxies("192.0.2.1")
+	handler := resolveClientIP(proxies, h)
+	post := func(target string, body string, forwardedFor string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", target, strings.NewReader(body))
+		if forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	assert.Equal(t, 201, post("/api/", richmond, "198.51.100.1").Code)
+	assert.Equal(t, 201, post("/api/?force=true", richmond, "198.51.100.1").Code)
+	w := post("/api/", richmond, "198.51.100.1")
+	assert.Equal(t, 429, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+
+	// another client behind the proxy has its own limit, but not a batch
+	// larger than the burst after it is spent.
+	assert.Equal(t, 201, post("/api/batch", `[`+richmond+`,`+richmond+`,`+richmond+`]`, "198.51.100.2").Code)
+	w = post("/api/batch", `[`+richmond+`]`, "198.51.100.2")
+	assert.Equal(t, 429, w.Code)
+	assert.Equal(t, "120", w.Header().Get("Retry-After"))
+}
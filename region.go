@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// serveRegion writes the sanitized task of a job, as in its
+// {uuid}_region.json, so its region can be drawn again later. A queued
+// job has no region.json yet and is served from the queue. With
+// ?format=poly only the region is written, as an Osmosis .poly file.
+func (h *Server) serveRegion(w http.ResponseWriter, r *http.Request, uuid string) {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "poly" {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, "Error: format must be json or poly")
+		return
+	}
+	b, err := os.ReadFile(filepath.Join(h.filesDir, uuid+"_region.json"))
+	if err != nil {
+		task, ok := h.queue.Find(uuid)
+		if !ok {
+			w.WriteHeader(404)
+			return
+		}
+		if b, err = json.Marshal(task); err != nil {
+			w.WriteHeader(500)
+			return
+		}
+	}
+	if format == "poly" {
+		poly, ok := regionPoly(b)
+		if !ok {
+			w.WriteHeader(500)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(poly)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// regionPoly converts an encoded task to the .poly file of its region.
+func regionPoly(b []byte) ([]byte, bool) {
+	var task Task
+	if json.Unmarshal(b, &task) != nil {
+		return nil, false
+	}
+	geom, ok := regionGeometry(task)
+	if !ok {
+		return nil, false
+	}
+	return encodePoly(task.SanitizedName, geom)
+}
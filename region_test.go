@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getRegion(h *Server, id string) (int, Task) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/"+id+"/region", nil))
+	var task Task
+	json.Unmarshal(w.Body.Bytes(), &task)
+	return w.Code, task
+}
+
+func TestRegion(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.StartWorkers()
+	_, id := submit(h, richmond)
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, id)
+		return progress.Complete
+	})
+	code, task := getRegion(h, id)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, id, task.Uuid)
+	assert.Equal(t, "richmond", task.SanitizedName)
+	assert.NotNil(t, task.Provenance)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/"+id+"/region?format=poly", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "richmond\n1\n   -77.4571   37.5272\n   -77.4133   37.5272\n   -77.4133   37.553\n   -77.4571   37.553\n   -77.4571   37.5272\nEND\nEND\n", w.Body.String())
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/"+id+"/region?format=kml", nil))
+	assert.Equal(t, 400, w.Code)
+
+	code, _ = getRegion(h, "00000000-0000-0000-0000-000000000000")
+	assert.Equal(t, 404, code)
+}
+
+func TestRegionQueued(t *testing.T) {
+	h := newTestServer(t, "osmx")
+	h.progress = make(map[string]Progress)
+	h.progressJSON = make(map[string][]byte)
+	h.queue = NewScheduler("fifo", 10)
+	_, id := submit(h, richmond)
+
+	code, task := getRegion(h, id)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, "richmond", task.SanitizedName)
+	assert.Equal(t, "bbox", task.SanitizedRegionType)
+	assert.JSONEq(t, `[37.5272,-77.4571,37.553,-77.4133]`, string(task.SanitizedRegionData))
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// osmx chooses how to read a region file by its extension, so every
+// sanitized region type maps to exactly one file format it understands.
+// Parsers of other input formats convert to one of these.
+var osmxRegionFormats = map[string]struct {
+	extension string
+	encode    func(json.RawMessage) ([]byte, error)
+}{
+	// min_lat,min_lon,max_lat,max_lon
+	"bbox": {"bbox", func(data json.RawMessage) ([]byte, error) {
+		var coords []float64
+		if err := json.Unmarshal(data, &coords); err != nil || len(coords) != 4 {
+			return nil, fmt.Errorf("invalid bbox region %s", data)
+		}
+		return bytes.Trim(data, "[]"), nil
+	}},
+	// [[min_lat,min_lon,max_lat,max_lon],...], as their union
+	"bboxes": {"geojson", encodeBboxes},
+	"geojson": {"geojson", func(data json.RawMessage) ([]byte, error) {
+		return data, nil
+	}},
+}
+
+// writeRegionFile writes the sanitized region of a task in the format
+// handed to osmx, returning its path.
+func writeRegionFile(dir string, task Task) (string, error) {
+	format, ok := osmxRegionFormats[task.SanitizedRegionType]
+	if !ok {
+		return "", fmt.Errorf("region type %q has no osmx representation", task.SanitizedRegionType)
+	}
+	b, err := format.encode(task.SanitizedRegionData)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, task.Uuid+"."+format.extension)
+	return path, os.WriteFile(path, b, 0644)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/stretchr/testify/assert"
+)
+
+// every accepted RegionType sanitizes to a region osmx can read.
+func TestRegionFileForEveryType(t *testing.T) {
+	samples := map[string]string{
+		"bbox":       richmond,
+		"geojson":    `{"RegionType":"geojson","RegionData":{"type":"Polygon","coordinates":[[[-77.4571,37.5530],[-77.4571,37.5272],[-77.4133,37.5272],[-77.4133,37.5530],[-77.4571,37.5530]]]}}`,
+		"gpx":        gpxInput(`<gpx><trk><trkseg><trkpt lat="37.53" lon="-77.45"/><trkpt lat="37.54" lon="-77.44"/></trkseg></trk></gpx>`, 100),
+		"poly":       polyInput(richmondPoly),
+		"circle":     `{"RegionType":"circle","RegionData":[-77.4352,37.5401,1000]}`,
+		"tiles":      `{"RegionType":"tiles","RegionData":["14/4667/6346"]}`,
+		"h3":         `{"RegionType":"h3","RegionData":["872a8e3b4ffffff"]}`,
+		"shapefile":  shapefileInput(zipFiles(map[string][]byte{"a.shp": shpFile([]orb.Ring{richmondRing})})),
+		"geopackage": geoPackageInput(t, "areas", 1),
+		"kml":        kmlInput(richmondKML),
+		"kmz":        kmzInput(zipFiles(map[string][]byte{"doc.kml": []byte(richmondKML)})),
+	}
+	dir := t.TempDir()
+	for regionType := range regionParsers {
+		sample, ok := samples[regionType]
+		if !assert.True(t, ok, "no sample for RegionType %s", regionType) {
+			continue
+		}
+		var input Input
+		assert.Nil(t, json.Unmarshal([]byte(sample), &input))
+		_, _, sanitizedType, sanitizedData, err := parseRegion(input, defaultRegionLimits)
+		assert.Nil(t, err, regionType)
+
+		path, err := writeRegionFile(dir, Task{Uuid: regionType, SanitizedRegionType: sanitizedType, SanitizedRegionData: sanitizedData})
+		assert.Nil(t, err, regionType)
+		assert.Equal(t, "."+osmxRegionFormats[sanitizedType].extension, filepath.Ext(path))
+		b, _ := os.ReadFile(path)
+		assert.NotEmpty(t, b)
+	}
+}
+
+func TestRegionFileBbox(t *testing.T) {
+	path, err := writeRegionFile(t.TempDir(), Task{Uuid: "a", SanitizedRegionType: "bbox", SanitizedRegionData: json.RawMessage(`[37.5272,-77.4571,37.553,-77.4133]`)})
+	assert.Nil(t, err)
+	b, _ := os.ReadFile(path)
+	assert.Equal(t, "37.5272,-77.4571,37.553,-77.4133", string(b))
+
+	_, err = writeRegionFile(t.TempDir(), Task{Uuid: "a", SanitizedRegionType: "gpx", SanitizedRegionData: json.RawMessage(`"<gpx/>"`)})
+	assert.True(t, strings.Contains(err.Error(), "no osmx representation"))
+}
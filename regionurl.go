@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/paulmach/orb/encoding/wkt"
+)
+
+// upper bound on a region file fetched from a url.
+const maxRegionUrlBytes = 64 << 20
+
+// RegionUrls fetches the region files of url regions. Only hosts of
+// the allowlist are fetched from, or with * any host whose addresses
+// are public, so clients can't reach the loopback, private or link-local
+// addresses of the server's network.
+type RegionUrls struct {
+	anyHost bool
+	hosts   map[string]bool
+	client  *http.Client
+}
+
+func NewRegionUrls(allowlist string) *RegionUrls {
+	ru := &RegionUrls{hosts: make(map[string]bool)}
+	for _, host := range strings.Split(allowlist, ",") {
+		host = strings.ToLower(strings.TrimSpace(host))
+		if host == "*" {
+			ru.anyHost = true
+		} else if host != "" {
+			ru.hosts[host] = true
+		}
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	publicDialer := &net.Dialer{Timeout: 10 * time.Second, Control: publicAddressOnly}
+	ru.client = &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			// no proxy from the environment, which would be dialed
+			// instead of the host.
+			Proxy: nil,
+			DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				host, _, _ := net.SplitHostPort(address)
+				if ru.hosts[strings.ToLower(host)] {
+					return dialer.DialContext(ctx, network, address)
+				}
+				return publicDialer.DialContext(ctx, network, address)
+			},
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			return ru.allowed(req.URL)
+		},
+	}
+	return ru
+}
+
+// allowed checks the scheme and host of u against the allowlist.
+func (ru *RegionUrls) allowed(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("input url must be http or https")
+	}
+	if u.Hostname() == "" {
+		return errors.New("input url is invalid")
+	}
+	if !ru.anyHost && !ru.hosts[strings.ToLower(u.Hostname())] {
+		return fmt.Errorf("url regions are not allowed from %s", u.Hostname())
+	}
+	return nil
+}
+
+// publicAddressOnly refuses connections to addresses that aren't
+// public, checked once the host is resolved so a DNS name can't point
+// into the server's network.
+func publicAddressOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return fmt.Errorf("%s is not a public address", host)
+	}
+	return nil
+}
+
+// Fetch returns the file at rawUrl.
+func (ru *RegionUrls) Fetch(ctx context.Context, rawUrl string) ([]byte, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, errors.New("input url is invalid")
+	}
+	if err := ru.allowed(u); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "sliceosm-api")
+	resp, err := ru.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %w", u.Redacted(), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("fetching %s: %s", u.Redacted(), resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxRegionUrlBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %w", u.Redacted(), err)
+	}
+	if len(b) > maxRegionUrlBytes {
+		return nil, fmt.Errorf("%s is larger than %d bytes", u.Redacted(), maxRegionUrlBytes)
+	}
+	return b, nil
+}
+
+// resolveUrl turns a url region into the region of the file it points
+// to: GeoJSON, WKT, or else an Osmosis polygon filter file.
+func (h *Server) resolveUrl(ctx context.Context, input Input) (Input, error) {
+	if h.regionUrls == nil {
+		return input, errors.New("url regions are not configured on this server")
+	}
+	var rawUrl string
+	if json.Unmarshal(input.RegionData, &rawUrl) != nil {
+		return input, errors.New("input url must be a JSON string")
+	}
+	b, err := h.regionUrls.Fetch(ctx, rawUrl)
+	if err != nil {
+		return input, err
+	}
+	text := strings.TrimSpace(strings.TrimPrefix(string(b), "\ufeff"))
+	upper := strings.ToUpper(text)
+	switch {
+	case strings.HasPrefix(text, "{"):
+		if !json.Valid([]byte(text)) {
+			return input, errors.New("the url is not valid GeoJSON")
+		}
+		input.RegionType = "geojson"
+		input.RegionData = json.RawMessage(text)
+	case strings.HasPrefix(upper, "POLYGON") || strings.HasPrefix(upper, "MULTIPOLYGON"):
+		geom, err := wkt.Unmarshal(text)
+		if err != nil {
+			return input, fmt.Errorf("the url is not valid WKT: %w", err)
+		}
+		input = resolvedInput(input, geom, "")
+	default:
+		input.RegionType = "poly"
+		input.RegionData, _ = json.Marshal(text)
+	}
+	return input, nil
+}
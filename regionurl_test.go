@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/stretchr/testify/assert"
+)
+
+// newRegionFiles serves region files by path.
+func newRegionFiles(t *testing.T) *httptest.Server {
+	files := map[string]string{
+		"/richmond.geojson": `{"type":"Polygon","coordinates":[[[-77.4571,37.5530],[-77.4571,37.5272],[-77.4133,37.5272],[-77.4133,37.5530],[-77.4571,37.5530]]]}`,
+		"/richmond.wkt":     "POLYGON ((-77.4571 37.5272, -77.4133 37.5272, -77.4133 37.5530, -77.4571 37.5530, -77.4571 37.5272))\n",
+		"/richmond.poly":    richmondPoly,
+		"/large.geojson":    strings.Repeat(" ", maxRegionUrlBytes+1),
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/moved" {
+			http.Redirect(w, r, "http://example.com/richmond.geojson", http.StatusFound)
+			return
+		}
+		file, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(file))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func urlInput(u string) Input {
+	data, _ := json.Marshal(u)
+	return Input{RegionType: "url", RegionData: data}
+}
+
+func TestUrlRegion(t *testing.T) {
+	files := newRegionFiles(t)
+	h := newTestServer(t, "osmx")
+	_, err := h.resolveRegion(context.Background(), urlInput(files.URL+"/richmond.geojson"))
+	assert.Equal(t, "url regions are not configured on this server", err.Error())
+
+	h.regionUrls = NewRegionUrls("127.0.0.1")
+	for _, path := range []string{"/richmond.geojson", "/richmond.wkt", "/richmond.poly"} {
+		input, err := h.resolveRegion(context.Background(), urlInput(files.URL+path))
+		assert.Nil(t, err, path)
+		geom, _, _, _, err := parseRegion(input, defaultRegionLimits)
+		assert.Nil(t, err, path)
+		assert.True(t, geom.Bound().Contains(orb.Point{-77.43, 37.54}), path)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/api/estimate", strings.NewReader(`{"RegionType":"url","RegionData":"`+files.URL+`/richmond.wkt"}`)))
+	assert.Equal(t, 200, w.Code)
+
+	capabilities := (&Server{queue: NewScheduler("fifo", 10), regionUrls: h.regionUrls}).capabilities()
+	assert.Contains(t, capabilities.RegionTypes, "url")
+}
+
+func TestUrlRegionRefused(t *testing.T) {
+	files := newRegionFiles(t)
+	h := newTestServer(t, "osmx")
+	h.regionUrls = NewRegionUrls("127.0.0.1")
+	for u, message := range map[string]string{
+		"http:///richmond.wkt":            "input url is invalid",
+		"file:///etc/passwd":              "input url must be http or https",
+		"http://example.com/richmond.wkt": "url regions are not allowed from example.com",
+		files.URL + "/missing.geojson":    "fetching " + files.URL + "/missing.geojson: 404 Not Found",
+		files.URL + "/large.geojson":      files.URL + "/large.geojson is larger than 67108864 bytes",
+		files.URL + "/moved":              `fetching ` + files.URL + `/moved: Get "http://example.com/richmond.geojson": url regions are not allowed from example.com`,
+	} {
+		_, err := h.resolveRegion(context.Background(), urlInput(u))
+		assert.Equal(t, message, err.Error(), u)
+	}
+
+	// any host, but not the loopback address of the test server.
+	h.regionUrls = NewRegionUrls("*")
+	_, err := h.resolveRegion(context.Background(), urlInput(files.URL+"/richmond.geojson"))
+	assert.True(t, strings.Contains(err.Error(), "127.0.0.1 is not a public address"), err.Error())
+}
+
+func TestPublicAddressOnly(t *testing.T) {
+	for _, address := range []string{"127.0.0.1:80", "10.1.2.3:80", "192.168.1.1:443", "169.254.169.254:80", "[::1]:80", "[fd00::1]:80", "0.0.0.0:80"} {
+		assert.NotNil(t, publicAddressOnly("tcp", address, nil), address)
+	}
+	assert.Nil(t, publicAddressOnly("tcp", "140.82.112.3:443", nil))
+	assert.Nil(t, publicAddressOnly("tcp6", "[2606:4700::1111]:443", nil))
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/planar"
+)
+
+// upper bound on the response of the OSM API for one relation.
+const maxRelationBytes = 64 << 20
+
+// Relations resolves OSM relation ids to their boundaries with the
+// /full call of the OSM API.
+type Relations struct {
+	apiUrl string
+	client *http.Client
+}
+
+func NewRelations(apiUrl string) *Relations {
+	return &Relations{
+		apiUrl: strings.TrimSuffix(apiUrl, "/"),
+		client: &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+type osmElement struct {
+	Type    string
+	Id      int64
+	Lat     float64
+	Lon     float64
+	Nodes   []int64
+	Members []struct {
+		Type string
+		Ref  int64
+		Role string
+	}
+	Tags map[string]string
+}
+
+// Resolve returns the MultiPolygon of the outer and inner ways of a
+// relation, and its name tag.
+func (rs *Relations) Resolve(ctx context.Context, id int64) (orb.MultiPolygon, string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/api/0.6/relation/%d/full.json", rs.apiUrl, id), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := rs.client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("fetching relation %d: %w", id, err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case 200:
+	case 404:
+		return nil, "", fmt.Errorf("relation %d does not exist", id)
+	case 410:
+		return nil, "", fmt.Errorf("relation %d has been deleted", id)
+	default:
+		return nil, "", fmt.Errorf("fetching relation %d: the OSM API returned %s", id, resp.Status)
+	}
+	var full struct{ Elements []osmElement }
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxRelationBytes)).Decode(&full); err != nil {
+		return nil, "", fmt.Errorf("fetching relation %d: %w", id, err)
+	}
+	return relationPolygons(id, full.Elements)
+}
+
+// relationPolygons joins the member ways of relation id into rings and
+// puts each inner ring in the outer ring that contains it. Members that
+// aren't ways, such as labels and subareas, are skipped.
+func relationPolygons(id int64, elements []osmElement) (orb.MultiPolygon, string, error) {
+	nodes := make(map[int64]orb.Point)
+	ways := make(map[int64][]int64)
+	var relation *osmElement
+	for i, e := range elements {
+		switch e.Type {
+		case "node":
+			nodes[e.Id] = orb.Point{e.Lon, e.Lat}
+		case "way":
+			ways[e.Id] = e.Nodes
+		case "relation":
+			if e.Id == id {
+				relation = &elements[i]
+			}
+		}
+	}
+	if relation == nil {
+		return nil, "", fmt.Errorf("relation %d does not exist", id)
+	}
+
+	var outerWays, innerWays [][]int64
+	for _, m := range relation.Members {
+		if m.Type != "way" || (m.Role != "outer" && m.Role != "inner" && m.Role != "") {
+			continue
+		}
+		way, ok := ways[m.Ref]
+		if !ok {
+			return nil, "", fmt.Errorf("relation %d is missing way %d", id, m.Ref)
+		}
+		if m.Role == "inner" {
+			innerWays = append(innerWays, way)
+		} else {
+			outerWays = append(outerWays, way)
+		}
+	}
+	outers, err := joinRings(outerWays, nodes)
+	if err != nil {
+		return nil, "", fmt.Errorf("relation %d: %w", id, err)
+	}
+	if len(outers) == 0 {
+		return nil, "", fmt.Errorf("relation %d has no outer ways", id)
+	}
+	inners, err := joinRings(innerWays, nodes)
+	if err != nil {
+		return nil, "", fmt.Errorf("relation %d: %w", id, err)
+	}
+
+	mp := make(orb.MultiPolygon, len(outers))
+	for i, outer := range outers {
+		mp[i] = orb.Polygon{outer}
+	}
+	for _, inner := range inners {
+		for i := range mp {
+			if planar.RingContains(mp[i][0], inner[0]) {
+				mp[i] = append(mp[i], inner)
+				break
+			}
+		}
+	}
+	return mp, relation.Tags["name"], nil
+}
+
+// joinRings joins ways end to end, reversing them as needed, until each
+// chain closes into a ring.
+func joinRings(ways [][]int64, nodes map[int64]orb.Point) ([]orb.Ring, error) {
+	var pending [][]int64
+	for _, w := range ways {
+		if len(w) > 0 {
+			pending = append(pending, w)
+		}
+	}
+	var rings []orb.Ring
+	for len(pending) > 0 {
+		chain := append([]int64(nil), pending[0]...)
+		pending = pending[1:]
+		for chain[0] != chain[len(chain)-1] {
+			last := chain[len(chain)-1]
+			found := false
+			for i, w := range pending {
+				if w[len(w)-1] == last {
+					reversed := make([]int64, len(w))
+					for j, n := range w {
+						reversed[len(w)-1-j] = n
+					}
+					w = reversed
+				}
+				if w[0] == last {
+					chain = append(chain, w[1:]...)
+					pending = append(pending[:i], pending[i+1:]...)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, errors.New("its ways don't form closed rings")
+			}
+		}
+		if len(chain) < 4 {
+			return nil, errors.New("a ring does not have enough nodes")
+		}
+		ring := make(orb.Ring, len(chain))
+		for i, n := range chain {
+			p, ok := nodes[n]
+			if !ok {
+				return nil, fmt.Errorf("node %d is missing", n)
+			}
+			ring[i] = p
+		}
+		rings = append(rings, ring)
+	}
+	return rings, nil
+}
+
+// resolveRelation turns a relation region into the geojson region of
+// its boundary.
+func (h *Server) resolveRelation(ctx context.Context, input Input) (Input, error) {
+	if h.relations == nil {
+		return input, errors.New("relation regions are not configured on this server")
+	}
+	var id int64
+	if json.Unmarshal(input.RegionData, &id) != nil || id <= 0 {
+		return input, errors.New("input relation must be a positive relation id")
+	}
+	mp, name, err := h.relations.Resolve(ctx, id)
+	if err != nil {
+		return input, err
+	}
+	var geom orb.Geometry = mp
+	if len(mp) == 1 {
+		geom = mp[0]
+	}
+	return resolvedInput(input, geom, name), nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/stretchr/testify/assert"
+)
+
+// relation 1 is a square around Richmond split into two ways, with a
+// hole, a label node and a subarea.
+const richmondRelation = `{"elements":[
+{"type":"node","id":1,"lat":37.5272,"lon":-77.4571},
+{"type":"node","id":2,"lat":37.5272,"lon":-77.4133},
+{"type":"node","id":3,"lat":37.5530,"lon":-77.4133},
+{"type":"node","id":4,"lat":37.5530,"lon":-77.4571},
+{"type":"node","id":5,"lat":37.53,"lon":-77.44},
+{"type":"node","id":6,"lat":37.53,"lon":-77.43},
+{"type":"node","id":7,"lat":37.54,"lon":-77.43},
+{"type":"node","id":8,"lat":37.54,"lon":-77.44},
+{"type":"way","id":10,"nodes":[1,2,3]},
+{"type":"way","id":11,"nodes":[1,4,3]},
+{"type":"way","id":12,"nodes":[5,6,7,8,5]},
+{"type":"relation","id":1,"members":[
+  {"type":"way","ref":10,"role":"outer"},
+  {"type":"way","ref":11,"role":"outer"},
+  {"type":"way","ref":12,"role":"inner"},
+  {"type":"node","ref":5,"role":"label"},
+  {"type":"relation","ref":2,"role":"subarea"}],
+ "tags":{"name":"Richmond","type":"boundary"}}]}`
+
+func newFakeOSMApi(t *testing.T) *httptest.Server {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/0.6/relation/1/full.json":
+			w.Write([]byte(richmondRelation))
+		case "/api/0.6/relation/3/full.json":
+			w.WriteHeader(410)
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	t.Cleanup(api.Close)
+	return api
+}
+
+func TestRelationResolve(t *testing.T) {
+	api := newFakeOSMApi(t)
+	mp, name, err := NewRelations(api.URL).Resolve(context.Background(), 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "Richmond", name)
+	assert.Equal(t, 1, len(mp))
+	assert.Equal(t, 2, len(mp[0]))
+	assert.Equal(t, 5, len(mp[0][0]))
+	assert.Equal(t, orb.Bound{Min: orb.Point{-77.4571, 37.5272}, Max: orb.Point{-77.4133, 37.553}}, mp.Bound())
+
+	_, _, err = NewRelations(api.URL).Resolve(context.Background(), 2)
+	assert.Equal(t, "relation 2 does not exist", err.Error())
+	_, _, err = NewRelations(api.URL).Resolve(context.Background(), 3)
+	assert.Equal(t, "relation 3 has been deleted", err.Error())
+}
+
+func TestRelationUnclosed(t *testing.T) {
+	var full struct{ Elements []osmElement }
+	json.Unmarshal([]byte(richmondRelation), &full)
+	full.Elements[9].Nodes = []int64{1, 4}
+	_, _, err := relationPolygons(1, full.Elements)
+	assert.Equal(t, "relation 1: its ways don't form closed rings", err.Error())
+}
+
+func TestRelationRegion(t *testing.T) {
+	h := newTestServer(t, "osmx")
+	input, err := h.resolveRelation(context.Background(), Input{RegionType: "relation", RegionData: json.RawMessage(`1`)})
+	assert.Equal(t, "relation regions are not configured on this server", err.Error())
+
+	h.relations = NewRelations(newFakeOSMApi(t).URL)
+	input, err = h.resolveRelation(context.Background(), Input{RegionType: "relation", RegionData: json.RawMessage(`1`)})
+	assert.Nil(t, err)
+	assert.Equal(t, "Richmond", input.Name)
+	assert.Equal(t, "geojson", input.RegionType)
+	geom, _, _, _, err := parseRegion(input, defaultRegionLimits)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(geom.(orb.Polygon)))
+
+	input, _ = h.resolveRelation(context.Background(), Input{Name: "mine", RegionType: "relation", RegionData: json.RawMessage(`1`)})
+	assert.Equal(t, "mine", input.Name)
+	_, err = h.resolveRelation(context.Background(), Input{RegionType: "relation", RegionData: json.RawMessage(`"Richmond"`)})
+	assert.Equal(t, "input relation must be a positive relation id", err.Error())
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/api/estimate", strings.NewReader(`{"RegionType":"relation","RegionData":1}`)))
+	assert.Equal(t, 200, w.Code)
+
+	capabilities := (&Server{queue: NewScheduler("fifo", 10), relations: h.relations}).capabilities()
+	assert.Contains(t, capabilities.RegionTypes, "relation")
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// how long a reserved uuid waits for its upload.
+const reservationExpiry = 10 * time.Minute
+
+// The response to POST /api/reservations.
+type Reservation struct {
+	Uuid      string
+	UploadURL string
+	ExpiresAt string
+}
+
+type reservation struct {
+	key *APIKey
+	// the logged in user who reserved the uuid, whose history the job
+	// is added to.
+	user    *OSMUser
+	expires time.Time
+	// set while an upload is being validated, and once it was accepted.
+	uploading bool
+	created   *Created
+}
+
+// reservationStore holds uuids handed out before their region is
+// uploaded. Accepted reservations are kept until they expire so a
+// repeated upload gets the same response.
+type reservationStore struct {
+	mutex    sync.Mutex
+	reserved map[string]*reservation
+}
+
+// expire drops reservations past their expiry. The mutex must be held.
+func (s *reservationStore) expire(now time.Time) {
+	for id, res := range s.reserved {
+		if now.After(res.expires) && !res.uploading {
+			delete(s.reserved, id)
+		}
+	}
+}
+
+func (s *reservationStore) reserve(id string, key *APIKey, user *OSMUser, now time.Time) time.Time {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.reserved == nil {
+		s.reserved = make(map[string]*reservation)
+	}
+	s.expire(now)
+	expires := now.Add(reservationExpiry)
+	s.reserved[id] = &reservation{key: key, user: user, expires: expires}
+	return expires
+}
+
+// awaiting reports whether id is reserved and its upload not yet accepted.
+func (s *reservationStore) awaiting(id string, now time.Time) (time.Time, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.expire(now)
+	res, ok := s.reserved[id]
+	if !ok || res.created != nil {
+		return time.Time{}, false
+	}
+	return res.expires, true
+}
+
+// serveReserve handles POST /api/reservations.
+func (h *Server) serveReserve(w http.ResponseWriter, r *http.Request, key *APIKey) {
+	id := h.newUuid(uuid.NewString)
+	expires := h.reservations.reserve(id, key, h.osmUser(r), time.Now())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	json.NewEncoder(w).Encode(Reservation{
+		Uuid:      id,
+		UploadURL: "/api/reservations/" + id,
+		ExpiresAt: expires.UTC().Format(time.RFC3339),
+	})
+}
+
+// serveUpload handles PUT /api/reservations/{uuid}, submitting the
+// body as a task under the reserved uuid.
+func (h *Server) serveUpload(w http.ResponseWriter, r *http.Request, id string) {
+	s := &h.reservations
+	s.mutex.Lock()
+	s.expire(time.Now())
+	res, ok := s.reserved[id]
+	if !ok {
+		s.mutex.Unlock()
+		w.WriteHeader(404)
+		fmt.Fprintf(w, "Error: the reservation does not exist or has expired")
+		return
+	}
+	if res.created != nil {
+		created := res.created
+		s.mutex.Unlock()
+		writeCreated(w, created)
+		return
+	}
+	if res.uploading {
+		s.mutex.Unlock()
+		w.WriteHeader(409)
+		fmt.Fprintf(w, "Error: an upload to this reservation is in progress")
+		return
+	}
+	res.uploading = true
+	s.mutex.Unlock()
+
+	// a rejected upload leaves the reservation open for another try.
+	created := h.submitTask(w, r, res.key, id, false)
+
+	s.mutex.Lock()
+	res.uploading = false
+	res.created = created
+	s.mutex.Unlock()
+	if created != nil {
+		if res.user != nil {
+			h.addUserJob(*res.user, created)
+		}
+		writeCreated(w, created)
+	}
+}
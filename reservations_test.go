@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func reserve(h *Server) Reservation {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/api/reservations", nil))
+	var res Reservation
+	json.NewDecoder(w.Body).Decode(&res)
+	return res
+}
+
+func upload(h *Server, url string, body string) (int, string) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("PUT", url, strings.NewReader(body)))
+	if w.Code != 201 {
+		return w.Code, w.Body.String()
+	}
+	var created Created
+	json.NewDecoder(w.Body).Decode(&created)
+	return w.Code, created.Uuid
+}
+
+func TestReservation(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.StartWorkers()
+	res := reserve(h)
+	assert.NotEmpty(t, res.Uuid)
+	assert.Equal(t, "/api/reservations/"+res.Uuid, res.UploadURL)
+
+	code, progress := getProgress(h, res.Uuid)
+	assert.Equal(t, 200, code)
+	assert.True(t, progress.AwaitingUpload)
+	assert.Equal(t, res.ExpiresAt, progress.UploadExpiresAt)
+
+	// a rejected upload leaves the reservation open.
+	code, _ = upload(h, res.UploadURL, `{"Name":"bad","RegionType":"bbox","RegionData":[1,2]}`)
+	assert.Equal(t, 400, code)
+	_, progress = getProgress(h, res.Uuid)
+	assert.True(t, progress.AwaitingUpload)
+
+	code, id := upload(h, res.UploadURL, richmond)
+	assert.Equal(t, 201, code)
+	assert.Equal(t, res.Uuid, id)
+
+	// a repeated upload is not queued again.
+	code, id = upload(h, res.UploadURL, richmond)
+	assert.Equal(t, 201, code)
+	assert.Equal(t, res.Uuid, id)
+
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, res.Uuid)
+		return progress.Complete
+	})
+	_, progress = getProgress(h, res.Uuid)
+	assert.False(t, progress.AwaitingUpload)
+}
+
+func TestReservationExpiry(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.StartWorkers()
+	now := time.Now()
+	id := "reserved"
+	h.reservations.reserve(id, nil, nil, now)
+	_, ok := h.reservations.awaiting(id, now.Add(reservationExpiry-time.Second))
+	assert.True(t, ok)
+	_, ok = h.reservations.awaiting(id, now.Add(reservationExpiry+time.Second))
+	assert.False(t, ok)
+	assert.Empty(t, h.reservations.reserved)
+
+	code, _ := upload(h, "/api/reservations/"+id, richmond)
+	assert.Equal(t, 404, code)
+}
+
+func TestReservationUploadTooLarge(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.regionLimits.MaxBodyBytes = 1000
+	res := reserve(h)
+	code, body := upload(h, res.UploadURL, string(detailedRegion(50)))
+	assert.Equal(t, 413, code)
+	var limitErr RegionLimitError
+	json.Unmarshal([]byte(body), &limitErr)
+	assert.Equal(t, "maxBodyBytes", limitErr.Limit)
+}
+
+func TestReservationUserJob(t *testing.T) {
+	h := withOSMAuth(t, newTestServer(t, fakeOsmx(t, "")))
+	// no workers: the upload stays queued.
+	h.queue = NewScheduler("fifo", 10)
+	h.progress = make(map[string]Progress)
+	h.progressJSON = make(map[string][]byte)
+	session := login(t, h)
+	r := httptest.NewRequest("POST", "/api/reservations", nil)
+	r.AddCookie(session)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	var res Reservation
+	json.NewDecoder(w.Body).Decode(&res)
+
+	// the upload URL is handed to a tool that isn't logged in.
+	code, id := upload(h, res.UploadURL, richmond)
+	assert.Equal(t, 201, code)
+
+	r = httptest.NewRequest("GET", "/api/me/jobs", nil)
+	r.AddCookie(session)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	var page JobSummaries
+	json.NewDecoder(w.Body).Decode(&page)
+	assert.Len(t, page.Jobs, 1)
+	assert.Equal(t, id, page.Jobs[0].Uuid)
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/google/uuid"
+)
+
+// results younger than this are never evicted, so a client polling for
+// a job that just finished can still fetch it.
+const evictionGracePeriod = 10 * time.Minute
+
+// updateRecord rewrites the completion record of a job in place. f
+// returns false to leave the record unchanged.
+func (h *Server) updateRecord(id string, f func(*Progress) bool) error {
+	h.recordsMutex.Lock()
+	defer h.recordsMutex.Unlock()
+	path := filepath.Join(h.filesDir, id)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var progress Progress
+	if err := json.Unmarshal(b, &progress); err != nil {
+		return err
+	}
+	if !f(&progress) {
+		return nil
+	}
+	b, err = json.Marshal(progress)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, b)
+}
+
+// StartRetention periodically deletes jobs older than -resultTTLHours
+// and evicts results while filesDir is over -maxFilesBytes.
+func (h *Server) StartRetention(interval time.Duration) {
+	go func() {
+		for {
+			if _, err := h.expireResults(time.Now()); err != nil {
+				fmt.Println(err)
+				sentry.CaptureException(err)
+			}
+			if _, err := h.enforceDiskBudget(); err != nil {
+				fmt.Println(err)
+				sentry.CaptureException(err)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// expireResults deletes the jobs that finished, failed or were evicted
+// more than resultTTL before now, with their record and region.json,
+// so that they are unknown afterwards.
+func (h *Server) expireResults(now time.Time) (int, error) {
+	ttl := h.settings().ResultTTL
+	if ttl <= 0 {
+		return 0, nil
+	}
+	entries, err := os.ReadDir(h.filesDir)
+	if err != nil {
+		return 0, err
+	}
+	expired := 0
+	var freed int64
+	for _, d := range entries {
+		if d.IsDir() || uuid.Validate(d.Name()) != nil || h.hasProgress(d.Name()) {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(h.filesDir, d.Name()))
+		var progress Progress
+		if err != nil || json.Unmarshal(b, &progress) != nil || !(progress.Complete || progress.Failed || progress.Evicted) {
+			continue
+		}
+		finished, err := time.Parse(time.RFC3339, progress.FinishedAt)
+		if err != nil || now.Sub(finished) <= ttl {
+			continue
+		}
+		bytes, err := h.expire(d.Name(), progress)
+		if err != nil {
+			return expired, err
+		}
+		fmt.Println("expired", d.Name(), "freeing", bytes, "bytes")
+		expired++
+		freed += bytes
+	}
+	if expired > 0 {
+		fmt.Println("expired", expired, "jobs older than", ttl, "freeing", freed, "bytes")
+	}
+	return expired, nil
+}
+
+// expire deletes a finished job and everything of it in filesDir,
+// returning the bytes freed.
+func (h *Server) expire(id string, progress Progress) (int64, error) {
+	files := append(resultFiles(id), id+"_region.json")
+	var freed int64
+	for _, name := range append(files, id) {
+		if info, err := os.Lstat(filepath.Join(h.filesDir, name)); err == nil {
+			freed += info.Size()
+		}
+	}
+	if progress.Blob != "" && !h.blobs.Shared(id) {
+		if info, err := os.Stat(filepath.Join(h.filesDir, progress.Blob)); err == nil {
+			freed += info.Size()
+		}
+	}
+	for _, name := range files {
+		if err := os.Remove(filepath.Join(h.filesDir, name)); err != nil && !os.IsNotExist(err) {
+			return 0, err
+		}
+	}
+	if err := h.blobs.Release(id); err != nil {
+		return 0, err
+	}
+	if err := h.results.Remove(id); err != nil {
+		return 0, err
+	}
+	if err := h.deadLetters.Remove(id); err != nil {
+		return 0, err
+	}
+	if err := h.quotas.Unstore(id); err != nil {
+		return 0, err
+	}
+	if err := h.jobs.DeleteOwner(id); err != nil {
+		return 0, err
+	}
+	// the record goes last, so the job isn't unknown while it has files.
+	h.recordsMutex.Lock()
+	err := os.Remove(filepath.Join(h.filesDir, id))
+	h.recordsMutex.Unlock()
+	h.results.Forget(id)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	h.reclaimedBytes.Add(freed)
+	return freed, nil
+}
+
+// expireOverStorage deletes the oldest results of an API key with
+// ExpireOverQuota until the rest fit in its storage quota.
+func (h *Server) expireOverStorage(name string) {
+	key := h.keyNamed(name)
+	if key == nil || !key.ExpireOverQuota {
+		return
+	}
+	for _, id := range h.quotas.OverStorage(key) {
+		var progress Progress
+		if b, err := os.ReadFile(filepath.Join(h.filesDir, id)); err == nil {
+			json.Unmarshal(b, &progress)
+		}
+		bytes, err := h.expire(id, progress)
+		if err != nil {
+			fmt.Println(err)
+			sentry.CaptureException(err)
+			return
+		}
+		fmt.Println("expired", id, "of key", name, "over its storage quota, freeing", bytes, "bytes")
+	}
+}
+
+type evictionCandidate struct {
+	uuid       string
+	downloaded bool
+	finishedAt string
+	bytes      int64
+}
+
+// enforceDiskBudget deletes completed results until filesDir fits in
+// maxFilesBytes: results that were downloaded at least once go first,
+// then the oldest. Each evicted job keeps a record so status queries
+// return 410.
+func (h *Server) enforceDiskBudget() (int, error) {
+	budget := h.settings().MaxFilesBytes
+	if budget <= 0 {
+		return 0, nil
+	}
+	var total int64
+	err := filepath.WalkDir(h.filesDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		// the queue database is allocated in large steps and never
+		// shrinks, and nothing can be evicted from it.
+		if path == filepath.Join(h.filesDir, "queue.db") {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			total += info.Size()
+		}
+		return nil
+	})
+	if err != nil || total <= budget {
+		return 0, err
+	}
+
+	grace := time.Now().Add(-evictionGracePeriod).UTC().Format(time.RFC3339)
+	entries, err := os.ReadDir(h.filesDir)
+	if err != nil {
+		return 0, err
+	}
+	var candidates []evictionCandidate
+	for _, d := range entries {
+		if d.IsDir() || uuid.Validate(d.Name()) != nil {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(h.filesDir, d.Name()))
+		var progress Progress
+		if err != nil || json.Unmarshal(b, &progress) != nil || !progress.Complete || progress.FinishedAt > grace {
+			continue
+		}
+		c := evictionCandidate{uuid: d.Name(), downloaded: progress.Downloads > 0, finishedAt: progress.FinishedAt}
+		for _, name := range resultFiles(d.Name()) {
+			if info, err := os.Lstat(filepath.Join(h.filesDir, name)); err == nil {
+				c.bytes += info.Size()
+			}
+		}
+		if progress.Blob != "" && !h.blobs.Shared(d.Name()) {
+			if info, err := os.Stat(filepath.Join(h.filesDir, progress.Blob)); err == nil {
+				c.bytes += info.Size()
+			}
+		}
+		candidates = append(candidates, c)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].downloaded != candidates[j].downloaded {
+			return candidates[i].downloaded
+		}
+		return candidates[i].finishedAt < candidates[j].finishedAt
+	})
+
+	evicted := 0
+	for _, c := range candidates {
+		if total <= budget {
+			break
+		}
+		if err := h.evict(c.uuid, "evicted for space"); err != nil {
+			return evicted, err
+		}
+		fmt.Println("evicted", c.uuid, "freeing", c.bytes, "bytes")
+		h.reclaimedBytes.Add(c.bytes)
+		total -= c.bytes
+		evicted++
+	}
+	return evicted, nil
+}
+
+// resultFiles are the artifacts of a completed job besides its record
+// and its region.json, which is kept so that the job can be retried.
+func resultFiles(id string) []string {
+	return []string{id + ".osm.pbf", id + ".osm.pbf.enc", id + "_split.zip"}
+}
+
+// evict deletes the artifacts of a result and marks its record as
+// evicted for the reason.
+func (h *Server) evict(id string, reason string) error {
+	err := h.updateRecord(id, func(p *Progress) bool {
+		p.Complete = false
+		p.Evicted = true
+		p.Error = reason
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	for _, name := range resultFiles(id) {
+		if err := os.Remove(filepath.Join(h.filesDir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := h.blobs.Release(id); err != nil {
+		return err
+	}
+	if err := h.quotas.Unstore(id); err != nil {
+		return err
+	}
+	h.noteFinished(id)
+	return h.results.Remove(id)
+}
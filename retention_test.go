@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeResult fakes a completed job with a pbf of size bytes.
+func writeResult(t *testing.T, h *Server, finishedAt time.Time, downloads int64, size int) string {
+	id := uuid.New().String()
+	record, _ := json.Marshal(Progress{Complete: true, FinishedAt: finishedAt.UTC().Format(time.RFC3339), Downloads: downloads})
+	os.WriteFile(filepath.Join(h.filesDir, id), record, 0644)
+	os.WriteFile(filepath.Join(h.filesDir, id+".osm.pbf"), make([]byte, size), 0644)
+	return id
+}
+
+func TestEnforceDiskBudget(t *testing.T) {
+	h := newTestServer(t, "osmx")
+	h.StartWorkers()
+	old := time.Now().Add(-48 * time.Hour)
+	oldest := writeResult(t, h, old, 0, 10000)
+	downloaded := writeResult(t, h, old.Add(time.Hour), 1, 10000)
+	newer := writeResult(t, h, old.Add(2*time.Hour), 0, 10000)
+	young := writeResult(t, h, time.Now(), 1, 10000)
+
+	h.maxFilesBytes = 25000
+	evicted, err := h.enforceDiskBudget()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, evicted)
+
+	for _, id := range []string{downloaded, oldest} {
+		_, err := os.Stat(filepath.Join(h.filesDir, id+".osm.pbf"))
+		assert.True(t, os.IsNotExist(err))
+		code, progress := getProgress(h, id)
+		assert.Equal(t, 410, code)
+		assert.Equal(t, "evicted for space", progress.Error)
+	}
+	for _, id := range []string{newer, young} {
+		code, progress := getProgress(h, id)
+		assert.Equal(t, 200, code)
+		assert.True(t, progress.Complete)
+	}
+
+	// results within the grace period are kept even over budget
+	h.maxFilesBytes = 1
+	evicted, _ = h.enforceDiskBudget()
+	assert.Equal(t, 1, evicted)
+	code, _ := getProgress(h, young)
+	assert.Equal(t, 200, code)
+}
+
+func TestExpireResults(t *testing.T) {
+	h := newTestServer(t, "osmx")
+	now := time.Now()
+	old := writeResult(t, h, now.Add(-49*time.Hour), 1, 10000)
+	os.WriteFile(filepath.Join(h.filesDir, old+"_region.json"), []byte("{}"), 0644)
+	recent := writeResult(t, h, now.Add(-47*time.Hour), 0, 10000)
+	failed := uuid.New().String()
+	record, _ := json.Marshal(Progress{Failed: true, FinishedAt: now.Add(-72 * time.Hour).UTC().Format(time.RFC3339)})
+	os.WriteFile(filepath.Join(h.filesDir, failed), record, 0644)
+
+	// nothing expires without a TTL.
+	expired, err := h.expireResults(now)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, expired)
+
+	oldRecord, _ := os.ReadFile(filepath.Join(h.filesDir, old))
+	h.resultTTL = 48 * time.Hour
+	expired, err = h.expireResults(now)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, expired)
+	for _, name := range []string{old, old + ".osm.pbf", old + "_region.json", failed} {
+		_, err := os.Stat(filepath.Join(h.filesDir, name))
+		assert.True(t, os.IsNotExist(err), name)
+	}
+	code, _ := getProgress(h, old)
+	assert.Equal(t, 404, code)
+	code, _ = getProgress(h, recent)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, int64(len(oldRecord)+10000+2+len(record)), h.reclaimedBytes.Load())
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// retryId is the uuid of a POST /api/{uuid}/retry.
+func retryId(path string) (string, bool) {
+	rest, ok := strings.CutPrefix(path, "/api/")
+	if !ok {
+		return "", false
+	}
+	id, ok := strings.CutSuffix(rest, "/retry")
+	return id, ok && uuid.Validate(id) == nil
+}
+
+// retryInput is the submission that runs a task again: its sanitized
+// region, as a FeatureCollection when it had named features, and its
+// options.
+func retryInput(task Task) (json.RawMessage, error) {
+	input := map[string]any{
+		"Name":       task.SanitizedName,
+		"RegionType": task.SanitizedRegionType,
+		"RegionData": task.SanitizedRegionData,
+		"Encrypt":    task.Encrypt,
+		"ExtraArgs":  task.ExtraArgs,
+	}
+	if len(task.SubRegions) > 0 {
+		type feature struct {
+			Type       string            `json:"type"`
+			Properties map[string]string `json:"properties"`
+			Geometry   json.RawMessage   `json:"geometry"`
+		}
+		collection := struct {
+			Type     string    `json:"type"`
+			Features []feature `json:"features"`
+		}{Type: "FeatureCollection"}
+		for _, sub := range task.SubRegions {
+			collection.Features = append(collection.Features, feature{"Feature", map[string]string{"name": sub.Name}, sub.Region})
+		}
+		input["RegionType"] = "geojson"
+		input["RegionData"] = collection
+	}
+	return json.Marshal(input)
+}
+
+// serveRetry handles POST /api/{uuid}/retry: a failed or evicted job is
+// submitted again from its region.json under a new uuid, like a new
+// submission, and its record points to the new job.
+func (h *Server) serveRetry(w http.ResponseWriter, r *http.Request, key *APIKey, id string) *Created {
+	if h.hasProgress(id) {
+		w.WriteHeader(409)
+		fmt.Fprintf(w, "Error: the job hasn't finished")
+		return nil
+	}
+	var record Progress
+	b, err := os.ReadFile(filepath.Join(h.filesDir, id))
+	if err != nil || json.Unmarshal(b, &record) != nil {
+		w.WriteHeader(404)
+		return nil
+	}
+	if !record.Failed && !record.Evicted {
+		w.WriteHeader(409)
+		fmt.Fprintf(w, "Error: only a failed or evicted job can be retried")
+		return nil
+	}
+	var task Task
+	b, err = os.ReadFile(filepath.Join(h.filesDir, id+"_region.json"))
+	if err != nil || json.Unmarshal(b, &task) != nil {
+		w.WriteHeader(410)
+		fmt.Fprintf(w, "Error: the job's region is no longer available")
+		return nil
+	}
+	b, err = retryInput(task)
+	if err != nil {
+		w.WriteHeader(500)
+		return nil
+	}
+
+	retry := r.Clone(r.Context())
+	retry.Body = io.NopCloser(bytes.NewReader(b))
+	retry.Header.Del("Content-Type")
+	query := retry.URL.Query()
+	if task.DryRun {
+		query.Set("dryRun", "1")
+	} else {
+		query.Del("dryRun")
+	}
+	retry.URL.RawQuery = query.Encode()
+	created := h.submitTask(w, retry, key, h.newUuid(uuid.NewString), true)
+	if created == nil {
+		return nil
+	}
+	err = h.updateRecord(id, func(p *Progress) bool {
+		p.RetriedAs = created.Uuid
+		return true
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Println("retrying", id, err)
+	}
+	return created
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func retry(h *Server, id string) (int, Created) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/api/"+id+"/retry", nil))
+	var created Created
+	json.NewDecoder(w.Body).Decode(&created)
+	return w.Code, created
+}
+
+func TestRetryFailed(t *testing.T) {
+	broken := filepath.Join(t.TempDir(), "broken")
+	os.WriteFile(broken, nil, 0644)
+	h := newTestServer(t, fakeOsmx(t, `if [ -f `+broken+` ]; then exit 3; fi`))
+	h.StartWorkers()
+	_, failed := submit(h, richmond)
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, failed)
+		return progress.Failed
+	})
+
+	os.Remove(broken)
+	code, created := retry(h, failed)
+	assert.Equal(t, 201, code)
+	assert.NotEqual(t, failed, created.Uuid)
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, created.Uuid)
+		return progress.Complete
+	})
+	var task Task
+	b, _ := os.ReadFile(filepath.Join(h.filesDir, created.Uuid+"_region.json"))
+	json.Unmarshal(b, &task)
+	assert.Equal(t, "richmond", task.SanitizedName)
+	_, progress := getProgress(h, failed)
+	assert.Equal(t, created.Uuid, progress.RetriedAs)
+
+	// a complete job has nothing to retry.
+	code, _ = retry(h, created.Uuid)
+	assert.Equal(t, 409, code)
+	code, _ = retry(h, "00000000-0000-0000-0000-000000000000")
+	assert.Equal(t, 404, code)
+}
+
+func TestRetryEvicted(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.StartWorkers()
+	_, id := submit(h, `{"Name":"parts","RegionType":"geojson","RegionData":{"type":"FeatureCollection","features":[`+
+		`{"type":"Feature","properties":{"name":"a"},"geometry":{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1],[0,0]]]}},`+
+		`{"type":"Feature","properties":{"name":"b"},"geometry":{"type":"Polygon","coordinates":[[[2,0],[3,0],[3,1],[2,1],[2,0]]]}}]}}`)
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, id)
+		return progress.Complete
+	})
+	assert.Nil(t, h.evict(id, "evicted to free disk space"))
+	// the region outlives the result.
+	_, err := os.Stat(filepath.Join(h.filesDir, id+"_region.json"))
+	assert.Nil(t, err)
+
+	code, created := retry(h, id)
+	assert.Equal(t, 201, code)
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, created.Uuid)
+		return progress.Complete
+	})
+	_, progress := getProgress(h, created.Uuid)
+	assert.Equal(t, []string{"a", "b"}, []string{progress.SubRegions[0].Name, progress.SubRegions[1].Name})
+
+	os.Remove(filepath.Join(h.filesDir, id+"_region.json"))
+	code, _ = retry(h, id)
+	assert.Equal(t, 410, code)
+}
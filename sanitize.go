@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+// limits applied while sanitizing a submitted region.
+type RegionLimits struct {
+	// decimal places kept in coordinates; 6 is about 10 cm.
+	Precision int
+	// largest allowed serialization of the sanitized region.
+	MaxRegionBytes int
+	// largest request body, once decompressed.
+	MaxBodyBytes int64
+	// most vertices and polygons of a parsed region, before it is
+	// rounded or covered with tiles.
+	MaxVertices int
+	MaxPolygons int
+}
+
+var defaultRegionLimits = RegionLimits{Precision: 6, MaxRegionBytes: 2 << 20, MaxBodyBytes: 128 << 20, MaxVertices: 1000000, MaxPolygons: 10000}
+
+// the body of a request over one of the limits of RegionLimits, named
+// by Limit, the flag that sets it.
+type RegionLimitError struct {
+	Error string
+	Limit string
+	Max   int64
+	// the vertices or polygons of the region; 0 for a body, which is
+	// not read past the limit.
+	Value int64 `json:",omitempty"`
+}
+
+// regionLimitExceeded carries a RegionLimitError through the error
+// returns of the parsers.
+type regionLimitExceeded struct {
+	body RegionLimitError
+}
+
+func (e *regionLimitExceeded) Error() string {
+	return e.body.Error
+}
+
+// bodyError is the error of a request body that can't be read: a
+// RegionLimitError if it was cut off at -maxBodyBytes, and message
+// otherwise.
+func bodyError(err error, message string) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return &regionLimitExceeded{RegionLimitError{
+			Error: fmt.Sprintf("the request body is larger than the limit of %d bytes", tooLarge.Limit),
+			Limit: "maxBodyBytes",
+			Max:   tooLarge.Limit,
+		}}
+	}
+	return errors.New(message)
+}
+
+// checkRegionComplexity refuses a parsed region with more vertices or
+// polygons than the limits, before its cover is computed.
+func checkRegionComplexity(geom orb.Geometry, limits RegionLimits) error {
+	switch v := geom.(type) {
+	case orb.Bound:
+		return checkComplexity(5, 1, limits)
+	case orb.Polygon:
+		return checkComplexity(countVertices(v), 1, limits)
+	case orb.MultiPolygon:
+		return checkPolygonsComplexity(v, limits)
+	}
+	return nil
+}
+
+// checkPolygonsComplexity refuses the polygons of a region before they
+// are unioned, which takes time quadratic in their vertices.
+func checkPolygonsComplexity(polygons []orb.Polygon, limits RegionLimits) error {
+	vertices := 0
+	for _, polygon := range polygons {
+		vertices += countVertices(polygon)
+	}
+	return checkComplexity(vertices, len(polygons), limits)
+}
+
+func checkComplexity(vertices int, polygons int, limits RegionLimits) error {
+	if limits.MaxVertices > 0 && vertices > limits.MaxVertices {
+		return &regionLimitExceeded{RegionLimitError{
+			Error: fmt.Sprintf("the region has %d vertices, more than the limit of %d", vertices, limits.MaxVertices),
+			Limit: "maxRegionVertices",
+			Max:   int64(limits.MaxVertices),
+			Value: int64(vertices),
+		}}
+	}
+	if limits.MaxPolygons > 0 && polygons > limits.MaxPolygons {
+		return &regionLimitExceeded{RegionLimitError{
+			Error: fmt.Sprintf("the region has %d polygons, more than the limit of %d", polygons, limits.MaxPolygons),
+			Limit: "maxRegionPolygons",
+			Max:   int64(limits.MaxPolygons),
+			Value: int64(polygons),
+		}}
+	}
+	return nil
+}
+
+func countVertices(polygon orb.Polygon) int {
+	n := 0
+	for _, ring := range polygon {
+		n += len(ring)
+	}
+	return n
+}
+
+// writeInputError writes the error of an invalid submission: a
+// RegionLimitError as JSON with 413, a GeometryError as JSON with 400,
+// and anything else as text with 400.
+func writeInputError(w http.ResponseWriter, err error) {
+	var exceeded *regionLimitExceeded
+	if errors.As(err, &exceeded) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(413)
+		json.NewEncoder(w).Encode(exceeded.body)
+		return
+	}
+	var invalid *invalidGeometry
+	if errors.As(err, &invalid) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(invalid.body)
+		return
+	}
+	w.WriteHeader(400)
+	fmt.Fprintf(w, "Error: %s", err)
+}
+
+// roundRegion rounds the coordinates of a parsed region and serializes
+// it again. Rings that collapse below 4 distinct points are dropped,
+// and it is an error if nothing is left.
+func roundRegion(geom orb.Geometry, regionType string, data json.RawMessage, precision int) (orb.Geometry, json.RawMessage, error) {
+	factor := math.Pow10(precision)
+	round := func(v float64) float64 {
+		return math.Round(v*factor) / factor
+	}
+
+	switch regionType {
+	case "bbox":
+		var coords []float64
+		json.Unmarshal(data, &coords)
+		for i := range coords {
+			coords[i] = round(coords[i])
+		}
+		rounded, _ := json.Marshal(coords)
+		return orb.MultiPoint{orb.Point{coords[1], coords[0]}, orb.Point{coords[3], coords[2]}}.Bound(), rounded, nil
+	case "bboxes":
+		var boxes [][]float64
+		json.Unmarshal(data, &boxes)
+		for i, box := range boxes {
+			for j := range box {
+				box[j] = round(box[j])
+			}
+			if box[0] >= box[2] || box[1] >= box[3] {
+				return nil, nil, fmt.Errorf("bbox %d collapses when rounded to %d decimals", i, precision)
+			}
+		}
+		rounded, _ := json.Marshal(boxes)
+		return bboxesGeometry(boxes), rounded, nil
+	case "geojson":
+		var mp orb.MultiPolygon
+		switch v := geom.(type) {
+		case orb.Polygon:
+			mp = orb.MultiPolygon{v}
+		case orb.MultiPolygon:
+			mp = v
+		default:
+			return geom, data, nil
+		}
+
+		var result orb.MultiPolygon
+		for _, polygon := range mp {
+			var rounded orb.Polygon
+			for i, ring := range polygon {
+				r := roundRing(ring, round)
+				if len(r) < 4 {
+					if i == 0 {
+						break
+					}
+					continue
+				}
+				rounded = append(rounded, r)
+			}
+			if len(rounded) > 0 {
+				result = append(result, rounded)
+			}
+		}
+		if len(result) == 0 {
+			return nil, nil, fmt.Errorf("region collapses when rounded to %d decimals", precision)
+		}
+
+		var rounded orb.Geometry = result
+		if _, ok := geom.(orb.Polygon); ok {
+			rounded = result[0]
+		}
+		sanitizedData, _ := geojson.NewGeometry(rounded).MarshalJSON()
+		return rounded, sanitizedData, nil
+	}
+	return geom, data, nil
+}
+
+// roundRing rounds each vertex and drops consecutive duplicates.
+func roundRing(ring orb.Ring, round func(float64) float64) orb.Ring {
+	var out orb.Ring
+	for _, p := range ring {
+		q := orb.Point{round(p[0]), round(p[1])}
+		if len(out) > 0 && out[len(out)-1] == q {
+			continue
+		}
+		out = append(out, q)
+	}
+	if len(out) > 1 && out[0] != out[len(out)-1] {
+		out = append(out, out[0])
+	}
+	// a closed ring needs 3 distinct vertices plus the closing one.
+	distinct := make(map[orb.Point]bool)
+	for _, p := range out {
+		distinct[p] = true
+	}
+	if len(distinct) < 3 {
+		return nil
+	}
+	return out
+}
+
+func checkRegionSize(data json.RawMessage, limits RegionLimits) error {
+	if limits.MaxRegionBytes > 0 && len(data) > limits.MaxRegionBytes {
+		return fmt.Errorf("sanitized region is %d bytes, larger than the limit of %d bytes", len(data), limits.MaxRegionBytes)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/png"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoundRegionPrecision(t *testing.T) {
+	_, _, _, data, err := parseInput(strings.NewReader(`{"Name":"a_name", "RegionType":"geojson", "RegionData":{"type":"Polygon","coordinates":[[[0.123456789012345,0],[1,1.987654321098765],[1,0],[0.123456789012345,0]]]}}`))
+	assert.Nil(t, err)
+	assert.Equal(t, `{"type":"Polygon","coordinates":[[[0.123457,0],[1,1.987654],[1,0],[0.123457,0]]]}`, string(data))
+}
+
+func TestRoundRegionBbox(t *testing.T) {
+	_, _, _, data, err := parseInput(strings.NewReader(`{"Name":"a_name", "RegionType":"bbox", "RegionData":[0.1234567,0,1,1]}`))
+	assert.Nil(t, err)
+	assert.Equal(t, `[0.123457,0,1,1]`, string(data))
+
+	_, _, _, _, err = parseInput(strings.NewReader(`{"Name":"a_name", "RegionType":"bbox", "RegionData":[0,0,0.0000001,1]}`))
+	assert.NotNil(t, err)
+}
+
+func TestRoundRegionCollapses(t *testing.T) {
+	_, _, _, _, err := parseInput(strings.NewReader(`{"Name":"a_name", "RegionType":"geojson", "RegionData":{"type":"Polygon","coordinates":[[[0,0],[0.0000001,0],[0.0000001,0.0000001],[0,0]]]}}`))
+	assert.EqualError(t, err, "region collapses when rounded to 6 decimals")
+}
+
+func TestRoundRegionDropsCollapsedHole(t *testing.T) {
+	geom, _, _, _, err := parseInput(strings.NewReader(`{"Name":"a_name", "RegionType":"geojson", "RegionData":{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1],[0,0]],[[0.5,0.5],[0.5000001,0.5],[0.5000001,0.5000001],[0.5,0.5]]]}}`))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(geom.(orb.Polygon)))
+}
+
+func TestMaxRegionBytes(t *testing.T) {
+	input, _ := decodeInput(strings.NewReader(`{"Name":"a_name", "RegionType":"geojson", "RegionData":{"type":"Polygon","coordinates":[[[0,0],[1,1],[1,0],[0,0]]]}}`), defaultRegionLimits.Precision)
+	_, _, _, _, err := parseRegion(input, RegionLimits{Precision: 6, MaxRegionBytes: 20})
+	assert.EqualError(t, err, "sanitized region is 60 bytes, larger than the limit of 20 bytes")
+}
+
+func TestRoundingKeepsEstimate(t *testing.T) {
+	file, _ := os.Open("z12_red_green.png")
+	defer file.Close()
+	img, _ := png.Decode(file)
+
+	precise := orb.Polygon{{{-77.457112345678901, 37.553012345678901}, {-77.457198765432109, 37.527212345678901}, {-77.413312345678901, 37.527298765432109}, {-77.413398765432109, 37.553098765432109}, {-77.457112345678901, 37.553012345678901}}}
+	rounded, _, err := roundRegion(precise.Clone(), "geojson", nil, 6)
+	assert.Nil(t, err)
+	before := float64(GetSum(img, precise))
+	after := float64(GetSum(img, rounded))
+	assert.InDelta(t, before, after, before*0.01)
+}
+
+func TestRegionComplexityLimits(t *testing.T) {
+	limits := RegionLimits{Precision: 6, MaxVertices: 10, MaxPolygons: 2}
+	square := orb.Polygon{{{0, 0}, {1, 0}, {1, 1}, {0, 1}, {0, 0}}}
+	assert.Nil(t, checkRegionComplexity(orb.MultiPolygon{square, square}, limits))
+	assert.Nil(t, checkRegionComplexity(orb.Bound{Max: orb.Point{1, 1}}, limits))
+
+	err := checkRegionComplexity(orb.MultiPolygon{square, square, square}, limits)
+	assert.Equal(t, "the region has 15 vertices, more than the limit of 10", err.Error())
+	limits.MaxVertices = 0
+	err = checkRegionComplexity(orb.MultiPolygon{square, square, square}, limits)
+	assert.Equal(t, "the region has 3 polygons, more than the limit of 2", err.Error())
+}
+
+func TestRegionLimitErrors(t *testing.T) {
+	h := newTestServer(t, "osmx")
+	h.regionLimits.MaxVertices = 100
+	submit := func(path string, body []byte, encoding string) (int, RegionLimitError) {
+		r := httptest.NewRequest("POST", path, bytes.NewReader(body))
+		r.Header.Set("Content-Encoding", encoding)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		var limitErr RegionLimitError
+		json.NewDecoder(w.Body).Decode(&limitErr)
+		return w.Code, limitErr
+	}
+
+	code, limitErr := submit("/api/", detailedRegion(200), "")
+	assert.Equal(t, 413, code)
+	assert.Equal(t, RegionLimitError{Error: "the region has 201 vertices, more than the limit of 100", Limit: "maxRegionVertices", Max: 100, Value: 201}, limitErr)
+	code, _ = submit("/api/estimate", detailedRegion(200), "")
+	assert.Equal(t, 413, code)
+
+	var region map[string]json.RawMessage
+	json.Unmarshal(detailedRegion(200), &region)
+	exclude, _ := json.Marshal(map[string]json.RawMessage{"RegionType": []byte(`"bbox"`), "RegionData": []byte(`[37.5,-77.5,37.6,-77.4]`), "Exclude": region["RegionData"]})
+	code, limitErr = submit("/api/estimate", exclude, "")
+	assert.Equal(t, 413, code)
+	assert.Equal(t, "maxRegionVertices", limitErr.Limit)
+
+	h.regionLimits.MaxBodyBytes = 1000
+	code, limitErr = submit("/api/", detailedRegion(50), "")
+	assert.Equal(t, 413, code)
+	assert.Equal(t, RegionLimitError{Error: "the request body is larger than the limit of 1000 bytes", Limit: "maxBodyBytes", Max: 1000}, limitErr)
+	// the limit is on the body once decompressed.
+	code, limitErr = submit("/api/", gzipped(string(detailedRegion(50))), "gzip")
+	assert.Equal(t, 413, code)
+	assert.Equal(t, "maxBodyBytes", limitErr.Limit)
+	code, _ = submit("/api/batch", []byte("["+strings.Repeat(richmond+",", 30)+richmond+"]"), "")
+	assert.Equal(t, 413, code)
+	code, _ = submit("/api/estimate", []byte(richmond), "")
+	assert.Equal(t, 200, code)
+
+	capabilities := (&Server{queue: NewScheduler("fifo", 10), regionLimits: h.regionLimits}).capabilities()
+	assert.Equal(t, int64(1000), capabilities.MaxBodyBytes)
+	assert.Equal(t, 100, capabilities.MaxVertices)
+	assert.Equal(t, 10000, capabilities.MaxPolygons)
+}
+
+func TestRegionComplexityBeforeUnion(t *testing.T) {
+	// the overlapping squares union into one polygon, so the limit is
+	// only hit if it is checked before.
+	limits := RegionLimits{Precision: 6, MaxPolygons: 2}
+	var placemarks strings.Builder
+	var features []string
+	for i := 0; i < 3; i++ {
+		x := -77.45 + 0.01*float64(i)
+		ring := fmt.Sprintf("%f,37.53 %f,37.53 %f,37.55 %f,37.55 %f,37.53", x, x+0.02, x+0.02, x, x)
+		placemarks.WriteString("<Placemark><Polygon><outerBoundaryIs><LinearRing><coordinates>" + ring + "</coordinates></LinearRing></outerBoundaryIs></Polygon></Placemark>")
+		features = append(features, fmt.Sprintf(`{"type":"Feature","properties":{},"geometry":{"type":"Polygon","coordinates":[[[%f,37.53],[%f,37.53],[%f,37.55],[%f,37.55],[%f,37.53]]]}}`, x, x+0.02, x+0.02, x, x))
+	}
+	kml, _ := json.Marshal(`<kml xmlns="http://www.opengis.net/kml/2.2"><Document>` + placemarks.String() + `</Document></kml>`)
+	for _, input := range []Input{
+		{RegionType: "kml", RegionData: kml},
+		{RegionType: "geojson", RegionData: json.RawMessage(`{"type":"FeatureCollection","features":[` + strings.Join(features, ",") + `]}`)},
+	} {
+		_, _, _, _, err := parseRegion(input, limits)
+		var exceeded *regionLimitExceeded
+		assert.True(t, errors.As(err, &exceeded), input.RegionType)
+		assert.Equal(t, int64(3), exceeded.body.Value)
+
+		_, _, _, _, err = parseRegion(input, defaultRegionLimits)
+		assert.Nil(t, err)
+	}
+}
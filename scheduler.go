@@ -0,0 +1,363 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// how many estimated nodes a task is worth per second spent waiting
+// in the sjf scheduler, so that large jobs can't starve.
+const defaultAgingNodesPerSecond = 100000
+
+// jobs up to this many estimated nodes, about a city, are high priority.
+const defaultSmallJobNodes = 1000000
+
+// how long a task waits before it is moved up a tier, so a steady
+// stream of higher priority tasks can't starve the lower tiers.
+const defaultTierAging = 15 * time.Minute
+
+// priority tiers of queued tasks. A task of a higher tier is started
+// before any of a lower one that hasn't waited long enough to be moved
+// up to it.
+const (
+	priorityHigh   = "high"
+	priorityNormal = "normal"
+	priorityLow    = "low"
+)
+
+// tiers in the order they are started; tasks without a priority, such
+// as those requeued from their region.json, are normal.
+func priorityTier(priority string) int {
+	switch priority {
+	case priorityHigh:
+		return 0
+	case priorityLow:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// The pending set of tasks. Workers pull from it under a lock, taking
+// the highest priority tier first, where tasks are moved up a tier for
+// every tierAging they have waited; within a tier the policy decides
+// which task goes next:
+//
+//	fifo: in order of submission.
+//	sjf:  smallest node estimate first, with an aging term.
+type Scheduler struct {
+	mutex               sync.Mutex
+	cond                *sync.Cond
+	space               *sync.Cond
+	policy              string
+	capacity            int
+	agingNodesPerSecond float64
+	tierAging           time.Duration
+	tasks               taskHeap
+	seq                 int64
+	closed              bool
+	// workers stop taking tasks, which stay queued.
+	halted bool
+}
+
+type queuedTask struct {
+	task       Task
+	nodes      int
+	enqueuedAt time.Time
+	tier       int // -1 for tasks put at the head of the queue
+	key        float64
+	seq        int64
+}
+
+// before is whether a is started before b.
+func (a *queuedTask) before(b *queuedTask) bool {
+	if a.tier != b.tier {
+		return a.tier < b.tier
+	}
+	if a.key != b.key {
+		return a.key < b.key
+	}
+	return a.seq < b.seq
+}
+
+type taskHeap []*queuedTask
+
+func (q taskHeap) Len() int            { return len(q) }
+func (q taskHeap) Less(i, j int) bool  { return q[i].before(q[j]) }
+func (q taskHeap) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *taskHeap) Push(x interface{}) { *q = append(*q, x.(*queuedTask)) }
+func (q *taskHeap) Pop() interface{} {
+	old := *q
+	n := len(old)
+	item := old[n-1]
+	*q = old[0 : n-1]
+	return item
+}
+
+func NewScheduler(policy string, capacity int) *Scheduler {
+	s := &Scheduler{policy: policy, capacity: capacity, agingNodesPerSecond: defaultAgingNodesPerSecond, tierAging: defaultTierAging}
+	s.cond = sync.NewCond(&s.mutex)
+	s.space = sync.NewCond(&s.mutex)
+	return s
+}
+
+// Push adds a task with its node estimate, returning false if the queue is full.
+func (s *Scheduler) Push(task Task, nodes int) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.push(task, nodes)
+}
+
+// PushWait is like Push, but waits for space in the queue until ctx is done.
+func (s *Scheduler) PushWait(ctx context.Context, task Task, nodes int) bool {
+	stop := context.AfterFunc(ctx, func() {
+		s.mutex.Lock()
+		s.space.Broadcast()
+		s.mutex.Unlock()
+	})
+	defer stop()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for !s.closed && len(s.tasks) >= s.capacity && ctx.Err() == nil {
+		s.space.Wait()
+	}
+	return s.push(task, nodes)
+}
+
+func (s *Scheduler) push(task Task, nodes int) bool {
+	if s.closed || len(s.tasks) >= s.capacity {
+		return false
+	}
+	now := time.Now()
+	s.seq++
+	item := &queuedTask{task: task, nodes: nodes, enqueuedAt: now, tier: priorityTier(task.Priority), seq: s.seq}
+	if s.policy == "sjf" {
+		// waiting lowers the effective size linearly, so the ordering
+		// between two queued tasks never changes and the heap stays valid.
+		item.key = float64(nodes) + s.agingNodesPerSecond*float64(now.UnixNano())/1e9
+	} else {
+		item.key = float64(s.seq)
+	}
+	heap.Push(&s.tasks, item)
+	// every worker, since some may only take small tasks.
+	s.cond.Broadcast()
+	return true
+}
+
+// PushFront puts an already admitted task at the head of the queue,
+// ignoring the capacity.
+func (s *Scheduler) PushFront(task Task, nodes int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.seq++
+	heap.Push(&s.tasks, &queuedTask{task: task, nodes: int(nodes), enqueuedAt: time.Now(), tier: -1, key: math.Inf(-1), seq: s.seq})
+	s.cond.Broadcast()
+}
+
+// Remove takes a queued task out of the queue.
+func (s *Scheduler) Remove(uuid string) (Task, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i, item := range s.tasks {
+		if item.task.Uuid == uuid {
+			heap.Remove(&s.tasks, i)
+			s.space.Signal()
+			return item.task, true
+		}
+	}
+	return Task{}, false
+}
+
+// Find returns a queued task.
+func (s *Scheduler) Find(uuid string) (Task, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, item := range s.tasks {
+		if item.task.Uuid == uuid {
+			return item.task, true
+		}
+	}
+	return Task{}, false
+}
+
+// SetPriority moves a queued task to the tier of priority, returning
+// the task as it is now.
+func (s *Scheduler) SetPriority(uuid string, priority string) (Task, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i, item := range s.tasks {
+		if item.task.Uuid == uuid {
+			item.task.Priority = priority
+			item.tier = priorityTier(priority)
+			heap.Fix(&s.tasks, i)
+			return item.task, true
+		}
+	}
+	return Task{}, false
+}
+
+// RemoveAll empties the queue, returning the tasks in the order they
+// would have started.
+func (s *Scheduler) RemoveAll() []Task {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.promote(time.Now())
+	tasks := make([]Task, 0, len(s.tasks))
+	for len(s.tasks) > 0 {
+		tasks = append(tasks, heap.Pop(&s.tasks).(*queuedTask).task)
+	}
+	s.space.Broadcast()
+	return tasks
+}
+
+// Pop blocks until a task is available, returning false once the
+// scheduler is closed and empty, or halted.
+func (s *Scheduler) Pop() (Task, bool) {
+	return s.PopUnless(nil, 0)
+}
+
+// PopUnless is Pop for a worker that may be retired or only take small
+// tasks: it takes the first task estimated at up to maxNodes, or any
+// task if maxNodes is 0, and returns false once retire returns true,
+// which is asked before taking a task and each time the worker is woken
+// up, such as by Wake. Once closed, it returns false when no task it
+// may take is left.
+func (s *Scheduler) PopUnless(retire func() bool, maxNodes int) (Task, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	next := -1
+	for {
+		if retire != nil && retire() {
+			return Task{}, false
+		}
+		s.promote(time.Now())
+		next = s.next(maxNodes)
+		if next >= 0 || s.closed || s.halted {
+			break
+		}
+		s.cond.Wait()
+	}
+	if next < 0 || s.halted {
+		return Task{}, false
+	}
+	item := heap.Remove(&s.tasks, next).(*queuedTask)
+	s.space.Signal()
+	return item.task, true
+}
+
+// promote moves each task up a tier for every tierAging it has waited
+// since it was queued, up to the high tier. Tasks put at the head of
+// the queue stay there.
+func (s *Scheduler) promote(now time.Time) {
+	if s.tierAging <= 0 {
+		return
+	}
+	changed := false
+	for _, item := range s.tasks {
+		if item.tier <= 0 {
+			continue
+		}
+		tier := max(priorityTier(item.task.Priority)-int(now.Sub(item.enqueuedAt)/s.tierAging), 0)
+		if tier != item.tier {
+			item.tier = tier
+			changed = true
+		}
+	}
+	if changed {
+		heap.Init(&s.tasks)
+	}
+}
+
+// next is the index of the first task estimated at up to maxNodes, or
+// of the first task if maxNodes is 0, or -1 if there is none.
+func (s *Scheduler) next(maxNodes int) int {
+	if len(s.tasks) == 0 {
+		return -1
+	}
+	if maxNodes <= 0 {
+		// the root of the heap.
+		return 0
+	}
+	next := -1
+	for i, item := range s.tasks {
+		if item.nodes <= maxNodes && (next < 0 || item.before(s.tasks[next])) {
+			next = i
+		}
+	}
+	return next
+}
+
+// Close wakes up all waiting workers; tasks still queued are drained first.
+func (s *Scheduler) Close() {
+	s.mutex.Lock()
+	s.closed = true
+	s.mutex.Unlock()
+	s.cond.Broadcast()
+	s.space.Broadcast()
+}
+
+// Halt stops handing out tasks, leaving those queued in place, and
+// wakes up all waiting workers.
+func (s *Scheduler) Halt() {
+	s.mutex.Lock()
+	s.halted = true
+	s.mutex.Unlock()
+	s.cond.Broadcast()
+}
+
+// Wake wakes up all waiting workers, to ask whether they are retired.
+func (s *Scheduler) Wake() {
+	// under the mutex, so a worker between asking and waiting isn't missed.
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.cond.Broadcast()
+}
+
+func (s *Scheduler) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.tasks)
+}
+
+// Position returns the 1-based place of the task in the queue,
+// or 0 if it is not queued.
+func (s *Scheduler) Position(uuid string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.promote(time.Now())
+	var target *queuedTask
+	for _, item := range s.tasks {
+		if item.task.Uuid == uuid {
+			target = item
+			break
+		}
+	}
+	if target == nil {
+		return 0
+	}
+	position := 1
+	for _, item := range s.tasks {
+		if item != target && item.before(target) {
+			position++
+		}
+	}
+	return position
+}
+
+// priority is the tier a submission is queued in: high for premium API
+// keys and small jobs, normal for other jobs with a key, and low for
+// other anonymous jobs.
+func (h *Server) priority(key *APIKey, nodes int) string {
+	switch {
+	case key != nil && key.Premium, h.smallJobNodes > 0 && nodes <= h.smallJobNodes:
+		return priorityHigh
+	case key != nil:
+		return priorityNormal
+	default:
+		return priorityLow
+	}
+}
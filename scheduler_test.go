@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchedulerFifo(t *testing.T) {
+	s := NewScheduler("fifo", 10)
+	s.Push(Task{Uuid: "a"}, 300)
+	s.Push(Task{Uuid: "b"}, 100)
+	s.Push(Task{Uuid: "c"}, 200)
+	assert.Equal(t, 2, s.Position("b"))
+	for _, expected := range []string{"a", "b", "c"} {
+		task, ok := s.Pop()
+		assert.True(t, ok)
+		assert.Equal(t, expected, task.Uuid)
+	}
+}
+
+func TestSchedulerSjf(t *testing.T) {
+	s := NewScheduler("sjf", 10)
+	s.Push(Task{Uuid: "a"}, 300)
+	s.Push(Task{Uuid: "b"}, 100)
+	s.Push(Task{Uuid: "c"}, 200)
+	assert.Equal(t, 1, s.Position("b"))
+	assert.Equal(t, 3, s.Position("a"))
+	assert.Equal(t, 0, s.Position("missing"))
+	for _, expected := range []string{"b", "c", "a"} {
+		task, _ := s.Pop()
+		assert.Equal(t, expected, task.Uuid)
+	}
+}
+
+func TestSchedulerSjfAging(t *testing.T) {
+	s := NewScheduler("sjf", 10)
+	s.Push(Task{Uuid: "big"}, 1000000)
+	// pretend the big task has been waiting for a minute
+	s.tasks[0].key -= 60 * s.agingNodesPerSecond
+	s.Push(Task{Uuid: "small"}, 1000)
+	task, _ := s.Pop()
+	assert.Equal(t, "big", task.Uuid)
+}
+
+func TestSchedulerCapacity(t *testing.T) {
+	s := NewScheduler("fifo", 1)
+	assert.True(t, s.Push(Task{Uuid: "a"}, 0))
+	assert.False(t, s.Push(Task{Uuid: "b"}, 0))
+	assert.Equal(t, 1, s.Len())
+}
+
+func TestSchedulerPushWait(t *testing.T) {
+	s := NewScheduler("fifo", 1)
+	s.Push(Task{Uuid: "a"}, 0)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		s.Pop()
+	}()
+	assert.True(t, s.PushWait(context.Background(), Task{Uuid: "b"}, 0))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.False(t, s.PushWait(ctx, Task{Uuid: "c"}, 0))
+}
+
+func TestSchedulerClose(t *testing.T) {
+	s := NewScheduler("fifo", 1)
+	s.Push(Task{Uuid: "a"}, 0)
+	s.Close()
+	task, ok := s.Pop()
+	assert.True(t, ok)
+	assert.Equal(t, "a", task.Uuid)
+	_, ok = s.Pop()
+	assert.False(t, ok)
+}
+
+func TestSchedulerPriority(t *testing.T) {
+	for _, policy := range []string{"fifo", "sjf"} {
+		s := NewScheduler(policy, 10)
+		s.Push(Task{Uuid: "planet", Priority: priorityLow}, 100)
+		s.Push(Task{Uuid: "partner", Priority: priorityNormal}, 300)
+		s.Push(Task{Uuid: "requeued"}, 200)
+		s.Push(Task{Uuid: "city", Priority: priorityHigh}, 400)
+		s.PushFront(Task{Uuid: "restarted", Priority: priorityLow}, 500)
+		assert.Equal(t, 2, s.Position("city"))
+		assert.Equal(t, 5, s.Position("planet"))
+		for _, expected := range []string{"restarted", "city"} {
+			task, _ := s.Pop()
+			assert.Equal(t, expected, task.Uuid, policy)
+		}
+		// the normal tier keeps the policy's order.
+		task, _ := s.Pop()
+		if policy == "sjf" {
+			assert.Equal(t, "requeued", task.Uuid)
+		} else {
+			assert.Equal(t, "partner", task.Uuid)
+		}
+		s.Pop()
+		task, _ = s.Pop()
+		assert.Equal(t, "planet", task.Uuid, policy)
+	}
+}
+
+func TestSchedulerTierAging(t *testing.T) {
+	for _, policy := range []string{"fifo", "sjf"} {
+		s := NewScheduler(policy, 10)
+		s.tierAging = 20 * time.Millisecond
+		s.Push(Task{Uuid: "planet", Priority: priorityLow}, 100)
+		// a steady stream of higher priority tasks, one for each started.
+		started := false
+		for i := 0; i < 100 && !started; i++ {
+			s.Push(Task{Uuid: "city", Priority: priorityHigh}, 1)
+			s.Push(Task{Uuid: "partner", Priority: priorityNormal}, 1)
+			task, _ := s.Pop()
+			started = task.Uuid == "planet"
+			time.Sleep(5 * time.Millisecond)
+		}
+		assert.True(t, started, policy)
+	}
+
+	// a task that hasn't waited yet is still behind higher tiers.
+	s := NewScheduler("fifo", 10)
+	s.Push(Task{Uuid: "planet", Priority: priorityLow}, 100)
+	s.Push(Task{Uuid: "partner", Priority: priorityNormal}, 100)
+	assert.Equal(t, 2, s.Position("planet"))
+	// pretend both have waited for two tierAging, up to the high tier.
+	for _, item := range s.tasks {
+		item.enqueuedAt = item.enqueuedAt.Add(-2 * defaultTierAging)
+	}
+	assert.Equal(t, 1, s.Position("planet"))
+}
+
+func TestPriority(t *testing.T) {
+	h := &Server{smallJobNodes: 1000}
+	assert.Equal(t, priorityHigh, h.priority(nil, 1000))
+	assert.Equal(t, priorityLow, h.priority(nil, 1001))
+	assert.Equal(t, priorityNormal, h.priority(&APIKey{Name: "partner"}, 1001))
+	assert.Equal(t, priorityHigh, h.priority(&APIKey{Name: "partner", Premium: true}, 1e9))
+
+	h.smallJobNodes = 0
+	assert.Equal(t, priorityLow, h.priority(nil, 0))
+}
+
+func TestPriorityInStatus(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.StartWorkers()
+	_, uuid := submit(h, richmond)
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, uuid)
+		return progress.Complete
+	})
+	_, progress := getProgress(h, uuid)
+	assert.Equal(t, priorityLow, progress.Priority)
+}
+
+func TestHaltKeepsQueued(t *testing.T) {
+	s := NewScheduler("fifo", 10)
+	s.Push(Task{Uuid: "a"}, 1)
+	s.Halt()
+	_, ok := s.Pop()
+	assert.False(t, ok)
+	assert.Equal(t, 1, s.Len())
+}
+
+func TestPopUnlessRetired(t *testing.T) {
+	s := NewScheduler("fifo", 10)
+	var retired atomic.Bool
+	done := make(chan bool)
+	go func() {
+		_, ok := s.PopUnless(retired.Load, 0)
+		done <- ok
+	}()
+	// woken without being retired, the worker keeps waiting.
+	s.Wake()
+	retired.Store(true)
+	s.Wake()
+	assert.False(t, <-done)
+	assert.True(t, s.Push(Task{Uuid: "a"}, 1))
+	assert.Equal(t, 1, s.Len())
+}
+
+func TestPopSmallTasks(t *testing.T) {
+	s := NewScheduler("fifo", 10)
+	s.Push(Task{Uuid: "large"}, 500)
+	s.Push(Task{Uuid: "small"}, 50)
+	task, ok := s.PopUnless(nil, 100)
+	assert.True(t, ok)
+	assert.Equal(t, "small", task.Uuid)
+
+	// once closed, a worker for small tasks leaves the large ones.
+	s.Close()
+	_, ok = s.PopUnless(nil, 100)
+	assert.False(t, ok)
+	task, ok = s.Pop()
+	assert.True(t, ok)
+	assert.Equal(t, "large", task.Uuid)
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// the runs of a schedule that are listed; older results are left to
+// -resultTTLHours.
+const maxScheduleRuns = 10
+
+// the schedules a submission can refresh on, as names or cron
+// shortcuts like @weekly.
+var scheduleNames = []string{"daily", "weekly", "monthly"}
+
+// nextRun is when a schedule is next due after t.
+func nextRun(schedule string, t time.Time) (time.Time, error) {
+	switch strings.TrimPrefix(strings.ToLower(strings.TrimSpace(schedule)), "@") {
+	case "daily":
+		return t.AddDate(0, 0, 1), nil
+	case "weekly":
+		return t.AddDate(0, 0, 7), nil
+	case "monthly":
+		return t.AddDate(0, 1, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("Schedule must be one of %s", strings.Join(scheduleNames, ", "))
+}
+
+// A recurring extract: the sanitized region of the submission that
+// created it, submitted again against fresh data each time it is due.
+type Schedule struct {
+	Id       string
+	Schedule string
+	// the submission of each run, as POST /api/{uuid}/retry makes it.
+	Input     json.RawMessage
+	KeyName   string `json:",omitempty"`
+	TokenHash string
+	CreatedAt string
+	NextRunAt time.Time
+	// the uuids of the latest runs, oldest first.
+	Runs []string
+	// why the last run wasn't submitted.
+	LastError string `json:",omitempty"`
+}
+
+// The response to GET /api/schedules/{id}.
+type ScheduleStatus struct {
+	Id        string
+	Schedule  string
+	CreatedAt string
+	NextRunAt string
+	LastError string `json:",omitempty"`
+	// the uuid of the newest complete run, whose result is at
+	// /api/schedules/{id}/latest.
+	Latest string `json:",omitempty"`
+	Runs   []JobEntry
+}
+
+// The schedules, persisted as schedules.json in filesDir.
+type ScheduleStore struct {
+	mutex     sync.Mutex
+	path      string
+	schedules map[string]*Schedule
+}
+
+func LoadSchedules(filesDir string) (*ScheduleStore, error) {
+	s := &ScheduleStore{
+		path:      filepath.Join(filesDir, "schedules.json"),
+		schedules: make(map[string]*Schedule),
+	}
+	b, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.schedules); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// save writes the schedules; the mutex must be held.
+func (s *ScheduleStore) save() error {
+	b, err := json.Marshal(s.schedules)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, b)
+}
+
+func (s *ScheduleStore) Add(schedule Schedule) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.schedules[schedule.Id] = &schedule
+	return s.save()
+}
+
+func (s *ScheduleStore) Get(id string) (Schedule, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	schedule, ok := s.schedules[id]
+	if !ok {
+		return Schedule{}, false
+	}
+	return *schedule, true
+}
+
+func (s *ScheduleStore) Delete(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.schedules, id)
+	return s.save()
+}
+
+// Due lists the schedules due at now.
+func (s *ScheduleStore) Due(now time.Time) []Schedule {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var due []Schedule
+	for _, schedule := range s.schedules {
+		if !schedule.NextRunAt.After(now) {
+			due = append(due, *schedule)
+		}
+	}
+	slices.SortFunc(due, func(a, b Schedule) int { return a.NextRunAt.Compare(b.NextRunAt) })
+	return due
+}
+
+// Ran records the outcome of a run of a schedule that wasn't deleted
+// meanwhile: the uuid it was queued as, or why it wasn't.
+func (s *ScheduleStore) Ran(id string, run string, runErr string, next time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	schedule, ok := s.schedules[id]
+	if !ok {
+		return nil
+	}
+	if run != "" {
+		schedule.Runs = append(schedule.Runs, run)
+		if len(schedule.Runs) > maxScheduleRuns {
+			schedule.Runs = schedule.Runs[len(schedule.Runs)-maxScheduleRuns:]
+		}
+	}
+	schedule.LastError = runErr
+	schedule.NextRunAt = next
+	return s.save()
+}
+
+// addSchedule records the schedule of an accepted submission, whose
+// task is its first run.
+func (h *Server) addSchedule(name string, task Task, deleteToken string) (string, error) {
+	input, err := retryInput(task)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	next, err := nextRun(name, now)
+	if err != nil {
+		return "", err
+	}
+	schedule := Schedule{
+		Id:        h.newUuid(uuid.NewString),
+		Schedule:  strings.TrimPrefix(strings.ToLower(strings.TrimSpace(name)), "@"),
+		Input:     input,
+		KeyName:   task.KeyName,
+		TokenHash: hashAPIKey(deleteToken),
+		CreatedAt: now.UTC().Format(time.RFC3339),
+		NextRunAt: next,
+		Runs:      []string{task.Uuid},
+	}
+	return schedule.Id, h.schedules.Add(schedule)
+}
+
+// StartSchedules submits the schedules as they fall due.
+func (h *Server) StartSchedules(interval time.Duration) {
+	go func() {
+		for {
+			h.runSchedules(time.Now())
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// runSchedules submits each due schedule like a new submission of its
+// API key, skipping runs that were missed. A schedule that can't be
+// queued now, because the queue is full or intake is paused, is tried
+// again on the next pass; other rejections wait for the next run.
+func (h *Server) runSchedules(now time.Time) {
+	for _, schedule := range h.schedules.Due(now) {
+		next, _ := nextRun(schedule.Schedule, schedule.NextRunAt)
+		for !next.After(now) {
+			next, _ = nextRun(schedule.Schedule, next)
+		}
+		var key *APIKey
+		if schedule.KeyName != "" {
+			for _, k := range h.settings().APIKeys {
+				if k.Name == schedule.KeyName {
+					key = k
+				}
+			}
+			if key == nil {
+				h.recordRun(schedule, "", fmt.Sprintf("the API key %s no longer exists", schedule.KeyName), next)
+				continue
+			}
+		}
+
+		r, _ := http.NewRequest("POST", "/api/", bytes.NewReader(schedule.Input))
+		r.Header.Set("Content-Type", "application/json")
+		mw := &memberWriter{header: make(http.Header), code: 200}
+		created := h.submitTask(mw, r, key, h.newUuid(uuid.NewString), false)
+		if created != nil {
+			fmt.Printf("schedule %s queued %s\n", schedule.Id, created.Uuid)
+			h.recordRun(schedule, created.Uuid, "", next)
+			continue
+		}
+		rejection := mw.rejection(0)
+		fmt.Printf("schedule %s not queued: %s\n", schedule.Id, rejection.Error)
+		if rejection.Status == 503 {
+			next = schedule.NextRunAt
+		}
+		h.recordRun(schedule, "", rejection.Error, next)
+	}
+}
+
+func (h *Server) recordRun(schedule Schedule, run string, runErr string, next time.Time) {
+	if err := h.schedules.Ran(schedule.Id, run, runErr, next); err != nil {
+		fmt.Println("recording schedule", schedule.Id, err)
+	}
+}
+
+// serveSchedule handles GET /api/schedules/{id}, /api/schedules/{id}/latest
+// and DELETE /api/schedules/{id}.
+func (h *Server) serveSchedule(w http.ResponseWriter, r *http.Request, path string) {
+	id, latest := strings.CutSuffix(path, "/latest")
+	var schedule Schedule
+	ok := false
+	if uuid.Validate(id) == nil && h.schedules != nil {
+		schedule, ok = h.schedules.Get(id)
+	}
+	if !ok {
+		w.WriteHeader(404)
+		return
+	}
+
+	if r.Method == "DELETE" && !latest {
+		h.serveDeleteSchedule(w, r, schedule)
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(405)
+		return
+	}
+	status := ScheduleStatus{Id: schedule.Id, Schedule: schedule.Schedule, CreatedAt: schedule.CreatedAt, NextRunAt: schedule.NextRunAt.UTC().Format(time.RFC3339), LastError: schedule.LastError, Runs: []JobEntry{}}
+	for i := len(schedule.Runs) - 1; i >= 0; i-- {
+		entry := h.jobEntry(schedule.Runs[i])
+		if status.Latest == "" && entry.State == "complete" {
+			status.Latest = entry.Uuid
+		}
+		status.Runs = append(status.Runs, entry)
+	}
+	if latest {
+		if status.Latest == "" {
+			w.WriteHeader(404)
+			fmt.Fprintf(w, "Error: no run of the schedule has completed yet")
+			return
+		}
+		target := "/api/" + status.Latest + "/download"
+		if h.downloadLinks != nil {
+			target = h.downloadLinks.URL(status.Latest, time.Now())
+		}
+		http.Redirect(w, r, target, http.StatusFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// serveDeleteSchedule stops a schedule, leaving the results of its
+// runs. It takes the DeleteToken of its first run, the API key that
+// created it or an admin key.
+func (h *Server) serveDeleteSchedule(w http.ResponseWriter, r *http.Request, schedule Schedule) {
+	key, err := h.authenticate(r)
+	if err != nil {
+		w.WriteHeader(401)
+		fmt.Fprintf(w, "Error: %s", err)
+		return
+	}
+	token := r.Header.Get(deleteTokenHeader)
+	allowed := key != nil && (key.can(scopeAdmin) || (schedule.KeyName != "" && key.Name == schedule.KeyName))
+	if token != "" && subtle.ConstantTimeCompare([]byte(hashAPIKey(token)), []byte(schedule.TokenHash)) == 1 {
+		allowed = true
+	}
+	if !allowed {
+		w.WriteHeader(403)
+		fmt.Fprintf(w, "Error: not allowed to delete this schedule")
+		return
+	}
+	if err := h.schedules.Delete(schedule.Id); err != nil {
+		w.WriteHeader(500)
+		fmt.Fprintf(w, "Error: %s", err)
+		return
+	}
+	fmt.Println("schedule", schedule.Id, "deleted")
+	w.WriteHeader(204)
+}
+
+// checkSchedule validates the Schedule of a submission.
+func (h *Server) checkSchedule(r *http.Request, input Input) error {
+	if h.schedules == nil {
+		return errors.New("schedules are not available on this server")
+	}
+	if r.URL.Path == "/api/batch" {
+		return errors.New("the tasks of a batch can't be scheduled")
+	}
+	if r.URL.Query().Get("dryRun") == "1" {
+		return errors.New("a dry run can't be scheduled")
+	}
+	if input.SnapshotTimestamp != "" {
+		return errors.New("a scheduled extract can't pin a snapshot")
+	}
+	_, err := nextRun(input.Schedule, time.Now())
+	return err
+}
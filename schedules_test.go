@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const weeklyRichmond = `{"Name":"richmond","RegionType":"bbox","RegionData":[37.5272,-77.4571,37.5530,-77.4133],"Schedule":"weekly"}`
+
+func TestNextRun(t *testing.T) {
+	start := time.Date(2026, 1, 31, 12, 0, 0, 0, time.UTC)
+	next, err := nextRun("daily", start)
+	assert.Nil(t, err)
+	assert.Equal(t, start.Add(24*time.Hour), next)
+	next, _ = nextRun("@weekly", start)
+	assert.Equal(t, time.Date(2026, 2, 7, 12, 0, 0, 0, time.UTC), next)
+	next, _ = nextRun("Monthly", start)
+	assert.Equal(t, time.Date(2026, 3, 3, 12, 0, 0, 0, time.UTC), next)
+	_, err = nextRun("every tuesday", start)
+	assert.EqualError(t, err, "Schedule must be one of daily, weekly, monthly")
+}
+
+func getSchedule(h *Server, id string) (int, ScheduleStatus) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/schedules/"+id, nil))
+	var status ScheduleStatus
+	json.NewDecoder(w.Body).Decode(&status)
+	return w.Code, status
+}
+
+func TestSchedule(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.apiKeys[hashAPIKey("user")] = &APIKey{Name: "user"}
+	h.apiKeys[hashAPIKey("other")] = &APIKey{Name: "other"}
+	h.StartWorkers()
+	r := httptest.NewRequest("POST", "/api/", strings.NewReader(weeklyRichmond))
+	r.Header.Set("Authorization", "Bearer user")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, 201, w.Code)
+	var created Created
+	json.NewDecoder(w.Body).Decode(&created)
+	assert.NotEmpty(t, created.ScheduleId)
+	assert.Equal(t, "/api/schedules/"+created.ScheduleId+"/latest", created.LatestUrl)
+
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, created.Uuid)
+		return progress.Complete
+	})
+	code, status := getSchedule(h, created.ScheduleId)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, "weekly", status.Schedule)
+	assert.Equal(t, created.Uuid, status.Latest)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", created.LatestUrl, nil))
+	assert.Equal(t, 302, w.Code)
+	assert.Equal(t, "/api/"+created.Uuid+"/download", w.Header().Get("Location"))
+
+	// nothing is due until a week later, and missed runs are skipped.
+	h.runSchedules(time.Now())
+	_, status = getSchedule(h, created.ScheduleId)
+	assert.Equal(t, 1, len(status.Runs))
+	later := time.Now().Add(20 * 24 * time.Hour)
+	h.runSchedules(later)
+	_, status = getSchedule(h, created.ScheduleId)
+	assert.Equal(t, 2, len(status.Runs))
+	rerun := status.Runs[0].Uuid
+	assert.NotEqual(t, created.Uuid, rerun)
+	next, _ := time.Parse(time.RFC3339, status.NextRunAt)
+	assert.True(t, next.After(later))
+	assert.True(t, next.Before(later.Add(8*24*time.Hour)))
+
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, rerun)
+		return progress.Complete
+	})
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", created.LatestUrl, nil))
+	assert.Equal(t, "/api/"+rerun+"/download", w.Header().Get("Location"))
+
+	// a full queue is tried again on the next pass.
+	h.intakePaused.Store(true)
+	h.runSchedules(later.Add(10 * 24 * time.Hour))
+	_, status = getSchedule(h, created.ScheduleId)
+	assert.Equal(t, "submissions are paused", status.LastError)
+	assert.Equal(t, next.UTC().Format(time.RFC3339), status.NextRunAt)
+	h.intakePaused.Store(false)
+
+	// only the key that created it, an admin key or the delete token
+	// may delete it.
+	r = httptest.NewRequest("DELETE", "/api/schedules/"+created.ScheduleId, nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, 403, w.Code)
+	r.Header.Set("Authorization", "Bearer other")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, 403, w.Code)
+	r.Header.Set(deleteTokenHeader, "wrong")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, 403, w.Code)
+	// the DeleteToken of the first run may, without the key.
+	r.Header.Del("Authorization")
+	r.Header.Set(deleteTokenHeader, created.DeleteToken)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, 204, w.Code)
+	code, _ = getSchedule(h, created.ScheduleId)
+	assert.Equal(t, 404, code)
+	// the results of its runs are kept.
+	code, _ = getProgress(h, rerun)
+	assert.Equal(t, 200, code)
+
+	schedules, _ := LoadSchedules(h.filesDir)
+	assert.Equal(t, 0, len(schedules.schedules))
+}
+
+func TestScheduleRejected(t *testing.T) {
+	h := newTestServer(t, "osmx")
+	for path, body := range map[string]string{
+		"/api/":          strings.Replace(weeklyRichmond, "weekly", "hourly", 1),
+		"/api/?dryRun=1": weeklyRichmond,
+		"/api/batch":     "[" + weeklyRichmond + "]",
+		"/api/?pinned=1": strings.Replace(weeklyRichmond, `"Schedule"`, `"SnapshotTimestamp":"now","Schedule"`, 1),
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", path, strings.NewReader(body)))
+		assert.Equal(t, 400, w.Code, path)
+	}
+	code, _ := getSchedule(h, "00000000-0000-4000-8000-000000000000")
+	assert.Equal(t, 404, code)
+}
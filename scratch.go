@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// default estimate of output size per node, roughly the planet pbf
+// divided by its node count.
+const defaultBytesPerNode = 10
+
+// scratchDir is where worker id writes the region and pbf of its
+// current job, so workers never touch each other's files.
+func (h *Server) scratchDir(id int) string {
+	return filepath.Join(h.tmpDir, fmt.Sprintf("worker-%d", id))
+}
+
+// jobScratchDir holds the files of one job of worker id, removed
+// together once the job ends.
+func (h *Server) jobScratchDir(id int, uuid string) string {
+	return filepath.Join(h.scratchDir(id), uuid)
+}
+
+// prepareScratch creates an empty scratch directory for each worker,
+// removing anything left by a previous run.
+func (h *Server) prepareScratch(workers int) error {
+	if err := h.cleanScratch(); err != nil {
+		return err
+	}
+	for id := 0; id < workers; id++ {
+		if err := os.MkdirAll(h.scratchDir(id), 0755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cleanScratch removes all worker scratch directories.
+func (h *Server) cleanScratch() error {
+	dirs, err := filepath.Glob(filepath.Join(h.tmpDir, "worker-*"))
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkScratchCapacity rejects jobs whose estimated output could never
+// fit in the scratch filesystem, such as a small tmpfs.
+func (h *Server) checkScratchCapacity(nodes int) error {
+	bytesPerNode := h.settings().BytesPerNode
+	if bytesPerNode <= 0 {
+		return nil
+	}
+	total, _, err := diskSpace(h.tmpDir)
+	if err != nil {
+		return nil
+	}
+	estimated := uint64(float64(nodes) * bytesPerNode)
+	if estimated > total {
+		return fmt.Errorf("the estimated output of %d bytes is larger than the scratch space of %d bytes", estimated, total)
+	}
+	return nil
+}
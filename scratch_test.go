@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// scratchFiles lists the files left in the worker scratch directories.
+func scratchFiles(h *Server) []string {
+	var files []string
+	filepath.WalkDir(h.tmpDir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files
+}
+
+func TestPrepareScratch(t *testing.T) {
+	h := &Server{tmpDir: t.TempDir()}
+	os.MkdirAll(filepath.Join(h.tmpDir, "worker-7"), 0755)
+	os.WriteFile(filepath.Join(h.tmpDir, "worker-7", "orphan.osm.pbf"), []byte("x"), 0644)
+	os.WriteFile(filepath.Join(h.tmpDir, "unrelated"), []byte("x"), 0644)
+
+	assert.Nil(t, h.prepareScratch(2))
+	for _, name := range []string{"worker-0", "worker-1"} {
+		info, err := os.Stat(filepath.Join(h.tmpDir, name))
+		assert.Nil(t, err)
+		assert.True(t, info.IsDir())
+	}
+	_, err := os.Stat(filepath.Join(h.tmpDir, "worker-7"))
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, []string{filepath.Join(h.tmpDir, "unrelated")}, scratchFiles(h))
+
+	assert.Nil(t, h.cleanScratch())
+	_, err = os.Stat(filepath.Join(h.tmpDir, "worker-0"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestScratchCapacity(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.StartWorkers()
+	total, _, err := diskSpace(h.tmpDir)
+	if err != nil {
+		t.Skip(err)
+	}
+	h.bytesPerNode = float64(total)
+	code, body := submit(h, richmond)
+	assert.Equal(t, 400, code)
+	assert.Contains(t, body, "larger than the scratch space")
+
+	h.bytesPerNode = 0
+	code, _ = submit(h, richmond)
+	assert.Equal(t, 201, code)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Settings are the options that a reload can change while the server
+// runs. Jobs already admitted keep the limits they were checked against.
+type Settings struct {
+	NodesLimit       int
+	SoftNodesLimit   int
+	RegionLimits     RegionLimits
+	APIKeys          map[string]*APIKey
+	RequireAPIKeys   bool
+	MaxFilesBytes    int64
+	ResultTTL        time.Duration
+	StorageMargin    int64
+	BytesPerNode     float64
+	StallAfter       time.Duration
+	KillStalledAfter time.Duration
+	MaxFailureRate   float64
+	CORSOrigins      []string
+	SubmitsPerSecond float64
+	SubmitBurst      int
+}
+
+// settings is a consistent snapshot of the reloadable settings.
+func (h *Server) settings() Settings {
+	h.settingsMutex.RLock()
+	defer h.settingsMutex.RUnlock()
+	rate, burst := h.submitLimiter.limits()
+	return Settings{
+		NodesLimit:       h.nodesLimit,
+		SoftNodesLimit:   h.softNodesLimit,
+		RegionLimits:     h.regionLimits,
+		APIKeys:          h.apiKeys,
+		RequireAPIKeys:   h.requireAPIKeys,
+		MaxFilesBytes:    h.maxFilesBytes,
+		ResultTTL:        h.resultTTL,
+		StorageMargin:    h.storageMargin,
+		BytesPerNode:     h.bytesPerNode,
+		StallAfter:       h.stallAfter,
+		KillStalledAfter: h.killStalledAfter,
+		MaxFailureRate:   h.maxFailureRate,
+		CORSOrigins:      h.corsOrigins,
+		SubmitsPerSecond: rate,
+		SubmitBurst:      int(burst),
+	}
+}
+
+// setSettings swaps in all of the reloadable settings at once.
+func (h *Server) setSettings(s Settings) {
+	h.settingsMutex.Lock()
+	defer h.settingsMutex.Unlock()
+	h.nodesLimit = s.NodesLimit
+	h.softNodesLimit = s.SoftNodesLimit
+	h.regionLimits = s.RegionLimits
+	h.apiKeys = s.APIKeys
+	h.requireAPIKeys = s.RequireAPIKeys
+	h.maxFilesBytes = s.MaxFilesBytes
+	h.resultTTL = s.ResultTTL
+	h.storageMargin = s.StorageMargin
+	h.bytesPerNode = s.BytesPerNode
+	h.stallAfter = s.StallAfter
+	h.killStalledAfter = s.KillStalledAfter
+	h.maxFailureRate = s.MaxFailureRate
+	h.corsOrigins = s.CORSOrigins
+	h.submitLimiter.setLimits(s.SubmitsPerSecond, float64(s.SubmitBurst))
+}
+
+// softLimit is the nodes limit clients warn at: -softNodesLimit, or the
+// hard NodesLimit when it is unset or above it.
+func (s Settings) softLimit() int {
+	if s.SoftNodesLimit <= 0 || s.SoftNodesLimit > s.NodesLimit {
+		return s.NodesLimit
+	}
+	return s.SoftNodesLimit
+}
+
+func parseCORSOrigins(s string) []string {
+	var origins []string
+	for _, origin := range strings.Split(s, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
+
+// allowOrigin sets Access-Control-Allow-Origin for a request from one
+// of the allowed origins. Without any configured every origin is
+// allowed.
+func allowOrigin(w http.ResponseWriter, r *http.Request, origins []string) {
+	if origins == nil || slices.Contains(origins, "*") {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	w.Header().Add("Vary", "Origin")
+	if origin := r.Header.Get("Origin"); origin != "" && slices.Contains(origins, origin) {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strings"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/planar"
+)
+
+// upper bound on the uncompressed .shp and .prj files of a shapefile
+// region.
+const maxShapefileBytes = 64 << 20
+
+// shape types of polygon layers, plain and with Z or M values.
+var polygonShapeTypes = map[int32]bool{5: true, 15: true, 25: true}
+
+// a zipped shapefile, base64 encoded in a JSON string, whose polygon
+// layer is reprojected to WGS84 by its .prj and stored as a GeoJSON
+// region.
+func parseShapefileRegion(input Input, limits RegionLimits) (orb.Geometry, string, json.RawMessage, error) {
+	var zipped []byte
+	if err := json.Unmarshal(input.RegionData, &zipped); err != nil {
+		return nil, "", nil, errors.New("input shapefile must be a base64 encoded zip")
+	}
+	shp, prj, err := readShapefileZip(zipped)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	unproject := func(p orb.Point) orb.Point { return p }
+	if prj != nil {
+		if unproject, err = prjUnproject(string(prj)); err != nil {
+			return nil, "", nil, err
+		}
+	}
+	polygons, err := parseShp(shp, unproject)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	for _, polygon := range polygons {
+		for _, ring := range polygon {
+			for _, p := range ring {
+				if !(p[0] >= -180 && p[0] <= 180 && p[1] >= -90 && p[1] <= 90) {
+					if prj == nil {
+						return nil, "", nil, errors.New("shapefile coordinates are not longitude and latitude and it has no .prj file")
+					}
+					return nil, "", nil, errors.New("shapefile coordinates are out of range")
+				}
+			}
+		}
+	}
+	if err := checkPolygonsComplexity(polygons, limits); err != nil {
+		return nil, "", nil, err
+	}
+	union := unionPolygons(polygons)
+	if len(union) == 0 {
+		return nil, "", nil, errors.New("shapefile has no polygons")
+	}
+	var geom orb.Geometry = union
+	if len(union) == 1 {
+		geom = union[0]
+	}
+	sanitizedData, _ := geojson.NewGeometry(geom).MarshalJSON()
+	return geom, "geojson", sanitizedData, nil
+}
+
+// readShapefileZip returns the first polygon .shp in a zip and the .prj
+// next to it, which is nil if there is none.
+func readShapefileZip(zipped []byte) ([]byte, []byte, error) {
+	archive, err := zip.NewReader(bytes.NewReader(zipped), int64(len(zipped)))
+	if err != nil {
+		return nil, nil, errors.New("input shapefile is not a zip")
+	}
+	files := make(map[string]*zip.File)
+	for _, f := range archive.File {
+		files[strings.ToLower(f.Name)] = f
+	}
+	for _, f := range archive.File {
+		name := strings.ToLower(f.Name)
+		if path.Ext(name) != ".shp" || strings.HasPrefix(path.Base(name), ".") {
+			continue
+		}
+		shp, err := readZipFile(f)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(shp) < 100 || !polygonShapeTypes[int32(binary.LittleEndian.Uint32(shp[32:36]))] {
+			continue
+		}
+		var prj []byte
+		if f, ok := files[strings.TrimSuffix(name, ".shp")+".prj"]; ok {
+			if prj, err = readZipFile(f); err != nil {
+				return nil, nil, err
+			}
+		}
+		return shp, prj, nil
+	}
+	return nil, nil, errors.New("the zip has no .shp file of polygons")
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	r, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", f.Name, err)
+	}
+	defer r.Close()
+	b, err := io.ReadAll(io.LimitReader(r, maxShapefileBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", f.Name, err)
+	}
+	if len(b) > maxShapefileBytes {
+		return nil, fmt.Errorf("%s is larger than %d bytes", f.Name, maxShapefileBytes)
+	}
+	return b, nil
+}
+
+// parseShp reads the polygon records of a .shp file. Clockwise rings
+// are outer rings and counterclockwise rings are holes of the outer
+// ring that contains them, once unprojected.
+func parseShp(shp []byte, unproject func(orb.Point) orb.Point) ([]orb.Polygon, error) {
+	if len(shp) < 100 || binary.BigEndian.Uint32(shp[0:4]) != 9994 {
+		return nil, errors.New("the .shp file is invalid")
+	}
+	var outers []orb.Polygon
+	var holes []orb.Ring
+	for offset := 100; offset+8 <= len(shp); {
+		length := int(binary.BigEndian.Uint32(shp[offset+4:offset+8])) * 2
+		start := offset + 8
+		offset = start + length
+		if length < 4 || offset > len(shp) {
+			return nil, errors.New("the .shp file is truncated")
+		}
+		record := shp[start:offset]
+		shapeType := int32(binary.LittleEndian.Uint32(record[0:4]))
+		if shapeType == 0 {
+			continue
+		}
+		if !polygonShapeTypes[shapeType] || len(record) < 44 {
+			return nil, errors.New("the .shp file has a record that isn't a polygon")
+		}
+		numParts := int(binary.LittleEndian.Uint32(record[36:40]))
+		numPoints := int(binary.LittleEndian.Uint32(record[40:44]))
+		pointsAt := 44 + 4*numParts
+		if numParts < 0 || numPoints < 0 || pointsAt+16*numPoints > len(record) {
+			return nil, errors.New("the .shp file has an invalid polygon")
+		}
+		for part := 0; part < numParts; part++ {
+			from := int(binary.LittleEndian.Uint32(record[44+4*part:]))
+			to := numPoints
+			if part+1 < numParts {
+				to = int(binary.LittleEndian.Uint32(record[44+4*(part+1):]))
+			}
+			if from < 0 || from > to || to > numPoints {
+				return nil, errors.New("the .shp file has an invalid polygon")
+			}
+			var ring orb.Ring
+			for i := from; i < to; i++ {
+				at := pointsAt + 16*i
+				ring = append(ring, unproject(orb.Point{
+					math.Float64frombits(binary.LittleEndian.Uint64(record[at:])),
+					math.Float64frombits(binary.LittleEndian.Uint64(record[at+8:])),
+				}))
+			}
+			if len(ring) < 4 {
+				continue
+			}
+			if ring.Orientation() == orb.CW {
+				outers = append(outers, orb.Polygon{ring})
+			} else {
+				holes = append(holes, ring)
+			}
+		}
+	}
+	for _, hole := range holes {
+		for i := range outers {
+			if planar.RingContains(outers[i][0], hole[0]) {
+				outers[i] = append(outers[i], hole)
+				break
+			}
+		}
+	}
+	return outers, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/stretchr/testify/assert"
+)
+
+// richmondRing is clockwise, an outer ring in a shapefile.
+var richmondRing = orb.Ring{{-77.4571, 37.5272}, {-77.4571, 37.5530}, {-77.4133, 37.5530}, {-77.4133, 37.5272}, {-77.4571, 37.5272}}
+
+// shpFile encodes a .shp file with a polygon record of the rings of
+// each record.
+func shpFile(records ...[]orb.Ring) []byte {
+	var body []byte
+	for i, rings := range records {
+		var content []byte
+		content = binary.LittleEndian.AppendUint32(content, 5)
+		content = append(content, make([]byte, 32)...)
+		points := 0
+		for _, ring := range rings {
+			points += len(ring)
+		}
+		content = binary.LittleEndian.AppendUint32(content, uint32(len(rings)))
+		content = binary.LittleEndian.AppendUint32(content, uint32(points))
+		start := 0
+		for _, ring := range rings {
+			content = binary.LittleEndian.AppendUint32(content, uint32(start))
+			start += len(ring)
+		}
+		for _, ring := range rings {
+			for _, p := range ring {
+				content = binary.LittleEndian.AppendUint64(content, math.Float64bits(p[0]))
+				content = binary.LittleEndian.AppendUint64(content, math.Float64bits(p[1]))
+			}
+		}
+		body = binary.BigEndian.AppendUint32(body, uint32(i+1))
+		body = binary.BigEndian.AppendUint32(body, uint32(len(content)/2))
+		body = append(body, content...)
+	}
+	header := make([]byte, 100)
+	binary.BigEndian.PutUint32(header[0:], 9994)
+	binary.BigEndian.PutUint32(header[24:], uint32((100+len(body))/2))
+	binary.LittleEndian.PutUint32(header[28:], 1000)
+	binary.LittleEndian.PutUint32(header[32:], 5)
+	return append(header, body...)
+}
+
+// zipFiles zips files by name.
+func zipFiles(files map[string][]byte) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, b := range files {
+		f, _ := w.Create(name)
+		f.Write(b)
+	}
+	w.Close()
+	return buf.Bytes()
+}
+
+func shapefileInput(zipped []byte) string {
+	data, _ := json.Marshal(zipped)
+	return `{"Name":"a_name","RegionType":"shapefile","RegionData":` + string(data) + `}`
+}
+
+func TestShapefileRegion(t *testing.T) {
+	hole := orb.Ring{{-77.44, 37.53}, {-77.43, 37.53}, {-77.43, 37.54}, {-77.44, 37.54}, {-77.44, 37.53}}
+	zipped := zipFiles(map[string][]byte{"richmond/richmond.shp": shpFile([]orb.Ring{richmondRing, hole}), "richmond/richmond.dbf": {}})
+	geom, _, regiontype, _, err := parseInput(strings.NewReader(shapefileInput(zipped)))
+	assert.Nil(t, err)
+	assert.Equal(t, "geojson", regiontype)
+	poly, isPolygon := geom.(orb.Polygon)
+	assert.True(t, isPolygon)
+	assert.Equal(t, 2, len(poly))
+	assert.Equal(t, richmondRing.Bound(), poly.Bound())
+
+	other := orb.Ring{{10, 10}, {10, 10.1}, {10.1, 10.1}, {10.1, 10}, {10, 10}}
+	geom, _, _, _, err = parseInput(strings.NewReader(shapefileInput(zipFiles(map[string][]byte{"a.shp": shpFile([]orb.Ring{richmondRing}, []orb.Ring{other})}))))
+	assert.Nil(t, err)
+	_, isMultiPolygon := geom.(orb.MultiPolygon)
+	assert.True(t, isMultiPolygon)
+}
+
+func TestShapefileProjected(t *testing.T) {
+	// web mercator, as ESRI writes EPSG:3857.
+	prj := `PROJCS["WGS_1984_Web_Mercator_Auxiliary_Sphere",GEOGCS["GCS_WGS_1984",DATUM["D_WGS_1984",SPHEROID["WGS_1984",6378137.0,298.257223563]],PRIMEM["Greenwich",0.0],UNIT["Degree",0.0174532925199433]],PROJECTION["Mercator_Auxiliary_Sphere"],PARAMETER["False_Easting",0.0],PARAMETER["False_Northing",0.0],PARAMETER["Central_Meridian",0.0],PARAMETER["Standard_Parallel_1",0.0],PARAMETER["Auxiliary_Sphere_Type",0.0],UNIT["Meter",1.0]]`
+	var projected orb.Ring
+	for _, p := range richmondRing {
+		projected = append(projected, orb.Point{
+			6378137 * p[0] * math.Pi / 180,
+			6378137 * math.Log(math.Tan(math.Pi/4+p[1]*math.Pi/360)),
+		})
+	}
+	zipped := zipFiles(map[string][]byte{"a.shp": shpFile([]orb.Ring{projected}), "a.prj": []byte(prj)})
+	geom, _, _, _, err := parseInput(strings.NewReader(shapefileInput(zipped)))
+	assert.Nil(t, err)
+	bound := geom.Bound()
+	assert.InDelta(t, -77.4571, bound.Min[0], 1e-6)
+	assert.InDelta(t, 37.5272, bound.Min[1], 1e-6)
+	assert.InDelta(t, 37.5530, bound.Max[1], 1e-6)
+
+	_, _, _, _, err = parseInput(strings.NewReader(shapefileInput(zipFiles(map[string][]byte{"a.shp": shpFile([]orb.Ring{projected})}))))
+	assert.Equal(t, "shapefile coordinates are not longitude and latitude and it has no .prj file", err.Error())
+}
+
+func TestShapefileInvalid(t *testing.T) {
+	for _, input := range []string{
+		`{"Name":"a_name","RegionType":"shapefile","RegionData":"not base64"}`,
+		shapefileInput([]byte("not a zip")),
+		shapefileInput(zipFiles(map[string][]byte{"a.txt": []byte("a")})),
+		shapefileInput(zipFiles(map[string][]byte{"a.shp": shpFile([]orb.Ring{richmondRing})[:120]})),
+	} {
+		_, _, _, _, err := parseInput(strings.NewReader(input))
+		assert.NotNil(t, err, input)
+	}
+}
+
+func TestShapefileUpload(t *testing.T) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("Name", "study area")
+	form.WriteField("RegionType", "shapefile")
+	file, _ := form.CreateFormFile("RegionData", "area.zip")
+	file.Write(zipFiles(map[string][]byte{"area.shp": shpFile([]orb.Ring{richmondRing})}))
+	form.Close()
+
+	r := httptest.NewRequest("POST", "/api/", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	input, err := decodeMultipartInput(r, defaultRegionLimits.Precision)
+	assert.Nil(t, err)
+	geom, _, _, _, err := parseRegion(input, defaultRegionLimits)
+	assert.Nil(t, err)
+	assert.Equal(t, richmondRing.Bound(), geom.Bound())
+}
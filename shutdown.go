@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// how long running extracts may take to finish on shutdown by default.
+const defaultShutdownGraceMinutes = 5
+
+// Drain stops accepting submissions and starting queued jobs, then
+// waits for the running jobs to finish, for up to grace or until force
+// is closed. Jobs still running then are killed and stay in queue.db as
+// running, to start over first on the next start; queued jobs are
+// already there.
+func (h *Server) Drain(grace time.Duration, force <-chan struct{}) {
+	h.intakePaused.Store(true)
+	h.queue.Halt()
+	done := make(chan struct{})
+	go func() {
+		h.workers.Wait()
+		close(done)
+	}()
+
+	h.runningMutex.Lock()
+	running := len(h.running)
+	h.runningMutex.Unlock()
+	if running > 0 {
+		fmt.Println("waiting up to", grace, "for", running, "running jobs to finish")
+	}
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return
+	case <-timer.C:
+	case <-force:
+	}
+
+	// a worker may take its job just after the first round.
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		h.runningMutex.Lock()
+		for _, job := range h.running {
+			job.cancel(&jobStopped{shutdown: true})
+		}
+		h.runningMutex.Unlock()
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDrainWaitsForRunning(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "started")
+	h := newTestServer(t, fakeOsmx(t, `touch `+marker+`; sleep 0.5`))
+	h.StartWorkers()
+	_, uuid := submit(h, richmond)
+	waitFor(t, func() bool {
+		_, err := os.Stat(marker)
+		return err == nil
+	})
+
+	h.Drain(time.Minute, nil)
+	_, progress := getProgress(h, uuid)
+	assert.True(t, progress.Complete)
+	code, _ := submit(h, richmond)
+	assert.Equal(t, 503, code)
+}
+
+func TestDrainStopsRunningAfterGrace(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "started")
+	h := newTestServer(t, fakeOsmx(t, `touch `+marker+`; sleep 30 > /dev/null`))
+	h.StartWorkers()
+	_, uuid := submit(h, richmond)
+	waitFor(t, func() bool {
+		_, err := os.Stat(marker)
+		return err == nil
+	})
+
+	start := time.Now()
+	h.Drain(100*time.Millisecond, nil)
+	assert.Less(t, time.Since(start), 10*time.Second)
+	// no failure record: the job starts over after the restart.
+	_, err := os.Stat(filepath.Join(h.filesDir, uuid))
+	assert.True(t, os.IsNotExist(err))
+	jobs, _ := h.jobs.Jobs()
+	assert.Len(t, jobs, 1)
+	assert.Equal(t, jobRunning, jobs[0].State)
+	assert.Empty(t, scratchFiles(h))
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// osmx reads the data file as it is, with no way to go back to an
+// earlier replication state. A pinned snapshot is the replication
+// timestamp the data file had when the task was submitted; a task that
+// runs after the file was updated fails instead of using newer data.
+var errSnapshotExpired = errors.New("snapshot expired")
+
+// pinSnapshot resolves a submitted SnapshotTimestamp, either "now" or
+// an RFC3339 time, to the replication timestamp of the data file.
+func (h *Server) pinSnapshot(ctx context.Context, requested string) (string, error) {
+	current, err := h.queryTimestamp(ctx)
+	if err != nil {
+		return "", errors.New("the data timestamp is unavailable")
+	}
+	if requested == "now" {
+		return current.Format(time.RFC3339), nil
+	}
+	pinned, err := time.Parse(time.RFC3339, requested)
+	if err != nil {
+		return "", errors.New("SnapshotTimestamp must be \"now\" or an RFC3339 time")
+	}
+	if pinned.Before(current) {
+		return "", errSnapshotExpired
+	}
+	if pinned.After(current) {
+		return "", fmt.Errorf("SnapshotTimestamp is newer than the data, which is at %s", current.Format(time.RFC3339))
+	}
+	return current.Format(time.RFC3339), nil
+}
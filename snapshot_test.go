@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotPinned(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.StartWorkers()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/api/", strings.NewReader(`{"Name":"richmond","RegionType":"bbox","RegionData":[37.5272,-77.4571,37.5530,-77.4133],"SnapshotTimestamp":"now"}`)))
+	assert.Equal(t, 201, w.Code)
+	var created Created
+	json.NewDecoder(w.Body).Decode(&created)
+	assert.Equal(t, "2024-01-01T00:00:00Z", created.SnapshotTimestamp)
+
+	// a later task of the batch passes the pinned timestamp on.
+	code, id := submit(h, `{"Name":"richmond","RegionType":"bbox","RegionData":[37.5272,-77.4571,37.5530,-77.4133],"SnapshotTimestamp":"2024-01-01T00:00:00Z"}`)
+	assert.Equal(t, 201, code)
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, id)
+		return progress.Complete
+	})
+	_, progress := getProgress(h, id)
+	assert.Equal(t, "2024-01-01T00:00:00Z", progress.SnapshotTimestamp)
+}
+
+func TestSnapshotRejected(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.StartWorkers()
+	for _, snapshot := range []string{"2023-12-31T00:00:00Z", "2024-01-02T00:00:00Z", "yesterday"} {
+		code, body := submit(h, `{"Name":"richmond","RegionType":"bbox","RegionData":[37.5272,-77.4571,37.5530,-77.4133],"SnapshotTimestamp":"`+snapshot+`"}`)
+		assert.Equal(t, 400, code, snapshot)
+		assert.NotEmpty(t, body)
+	}
+}
+
+// the data file was updated while the task waited in the queue.
+func TestSnapshotExpired(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.StartWorkers()
+	task := Task{Uuid: "00000001-0000-4000-8000-000000000000", SanitizedRegionType: "bbox", SanitizedRegionData: []byte(`[37.5272,-77.4571,37.5530,-77.4133]`), SnapshotTimestamp: "2023-12-31T00:00:00Z"}
+	h.setProgress(task.Uuid, Progress{})
+	h.queue.Push(task, 1)
+
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, task.Uuid)
+		return progress.Failed
+	})
+	_, progress := getProgress(h, task.Uuid)
+	assert.Equal(t, failureSnapshot, progress.FailureCategory)
+	assert.Equal(t, "snapshot expired", progress.Error)
+	assert.Equal(t, "2023-12-31T00:00:00Z", progress.SnapshotTimestamp)
+	assert.Empty(t, scratchFiles(h))
+	// not counted as a failed extract.
+	waitFor(t, func() bool {
+		failures, _ := h.failures.Window(time.Now())
+		return failures[failureSnapshot] == 1
+	})
+	_, extracts := h.failures.Window(time.Now())
+	assert.Equal(t, 0, extracts)
+}
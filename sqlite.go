@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// B-trees deeper than this are assumed to be corrupt or cyclic.
+const maxSQLiteDepth = 32
+
+// upper bounds on the B-tree pages and cells read from a single file,
+// so a crafted file can't keep a request busy.
+const (
+	maxSQLitePages = 100000
+	maxSQLiteCells = 1000000
+)
+
+// sqliteFile reads the tables of a SQLite database held in memory,
+// enough for GeoPackages. It does not read indexes, WITHOUT ROWID
+// tables or a write-ahead log.
+type sqliteFile struct {
+	data     []byte
+	pageSize int
+	usable   int
+
+	// pages and cells read so far, by all scans.
+	pagesRead int
+	cellsRead int
+}
+
+func openSQLite(data []byte) (*sqliteFile, error) {
+	if len(data) < 100 || string(data[:16]) != "SQLite format 3\x00" {
+		return nil, errors.New("not a SQLite database")
+	}
+	pageSize := int(binary.BigEndian.Uint16(data[16:18]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+	if pageSize < 512 || pageSize&(pageSize-1) != 0 {
+		return nil, errors.New("the SQLite page size is invalid")
+	}
+	return &sqliteFile{data: data, pageSize: pageSize, usable: pageSize - int(data[20])}, nil
+}
+
+func (db *sqliteFile) page(n int) ([]byte, error) {
+	start := (n - 1) * db.pageSize
+	if n < 1 || start+db.pageSize > len(db.data) {
+		return nil, fmt.Errorf("SQLite page %d is out of range", n)
+	}
+	return db.data[start : start+db.pageSize], nil
+}
+
+// scanTable calls f with the rowid and values of each row of the table
+// B-tree at page root, in rowid order.
+func (db *sqliteFile) scanTable(root int, f func(rowid int64, values []any) error) error {
+	return db.scanPage(root, 0, make(map[int]bool), f)
+}
+
+// scanPage walks the B-tree below page n. A page is in a B-tree only
+// once, so one seen again means the file is corrupt or crafted.
+func (db *sqliteFile) scanPage(n int, depth int, visited map[int]bool, f func(rowid int64, values []any) error) error {
+	if depth > maxSQLiteDepth {
+		return errors.New("the SQLite B-tree is too deep")
+	}
+	if visited[n] {
+		return fmt.Errorf("SQLite page %d is in the B-tree twice", n)
+	}
+	visited[n] = true
+	if db.pagesRead++; db.pagesRead > maxSQLitePages {
+		return fmt.Errorf("the SQLite database has more than %d pages to read", maxSQLitePages)
+	}
+	page, err := db.page(n)
+	if err != nil {
+		return err
+	}
+	header := page
+	if n == 1 {
+		header = page[100:]
+	}
+	cells := int(binary.BigEndian.Uint16(header[3:5]))
+	if db.cellsRead += cells; db.cellsRead > maxSQLiteCells {
+		return fmt.Errorf("the SQLite database has more than %d cells to read", maxSQLiteCells)
+	}
+	switch header[0] {
+	case 0x05: // interior table page
+		pointers := header[12:]
+		if len(pointers) < 2*cells {
+			return errors.New("the SQLite page is invalid")
+		}
+		for i := 0; i < cells; i++ {
+			offset := int(binary.BigEndian.Uint16(pointers[2*i:]))
+			if offset+4 > len(page) {
+				return errors.New("the SQLite page is invalid")
+			}
+			if err := db.scanPage(int(binary.BigEndian.Uint32(page[offset:])), depth+1, visited, f); err != nil {
+				return err
+			}
+		}
+		return db.scanPage(int(binary.BigEndian.Uint32(header[8:12])), depth+1, visited, f)
+	case 0x0d: // leaf table page
+		pointers := header[8:]
+		if len(pointers) < 2*cells {
+			return errors.New("the SQLite page is invalid")
+		}
+		for i := 0; i < cells; i++ {
+			offset := int(binary.BigEndian.Uint16(pointers[2*i:]))
+			if offset >= len(page) {
+				return errors.New("the SQLite page is invalid")
+			}
+			rowid, payload, err := db.cell(page[offset:])
+			if err != nil {
+				return err
+			}
+			values, err := decodeRecord(payload)
+			if err != nil {
+				return err
+			}
+			if err := f(rowid, values); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("SQLite page %d is not a table page", n)
+}
+
+// cell returns the rowid and payload of a leaf table cell, following
+// overflow pages.
+func (db *sqliteFile) cell(b []byte) (int64, []byte, error) {
+	size, n := sqliteVarint(b)
+	if n == 0 {
+		return 0, nil, errors.New("the SQLite cell is invalid")
+	}
+	b = b[n:]
+	rowid, n := sqliteVarint(b)
+	if n == 0 {
+		return 0, nil, errors.New("the SQLite cell is invalid")
+	}
+	b = b[n:]
+	if size < 0 || size > int64(len(db.data)) {
+		return 0, nil, errors.New("the SQLite cell is invalid")
+	}
+	total := int(size)
+
+	// the share of a payload kept on the page, from the file format.
+	local := total
+	if maxLocal := db.usable - 35; total > maxLocal {
+		minLocal := (db.usable-12)*32/255 - 23
+		local = minLocal + (total-minLocal)%(db.usable-4)
+		if local > maxLocal {
+			local = minLocal
+		}
+	}
+	if len(b) < local {
+		return 0, nil, errors.New("the SQLite cell is invalid")
+	}
+	payload := append([]byte(nil), b[:local]...)
+	if local == total {
+		return rowid, payload, nil
+	}
+	if len(b) < local+4 {
+		return 0, nil, errors.New("the SQLite cell is invalid")
+	}
+	next := int(binary.BigEndian.Uint32(b[local:]))
+	for len(payload) < total {
+		if next == 0 {
+			return 0, nil, errors.New("the SQLite overflow chain is truncated")
+		}
+		page, err := db.page(next)
+		if err != nil {
+			return 0, nil, err
+		}
+		next = int(binary.BigEndian.Uint32(page))
+		chunk := page[4:db.usable]
+		if remaining := total - len(payload); len(chunk) > remaining {
+			chunk = chunk[:remaining]
+		}
+		payload = append(payload, chunk...)
+	}
+	return rowid, payload, nil
+}
+
+// decodeRecord returns the int64, float64, string, []byte or nil
+// values of a record.
+func decodeRecord(b []byte) ([]any, error) {
+	headerSize, n := sqliteVarint(b)
+	// the header size counts its own varint.
+	if n == 0 || headerSize < int64(n) || headerSize > int64(len(b)) {
+		return nil, errors.New("the SQLite record is invalid")
+	}
+	header := b[n:headerSize]
+	body := b[headerSize:]
+	var values []any
+	for len(header) > 0 {
+		serial, n := sqliteVarint(header)
+		if n == 0 {
+			return nil, errors.New("the SQLite record is invalid")
+		}
+		header = header[n:]
+		size := map[int64]int{0: 0, 1: 1, 2: 2, 3: 3, 4: 4, 5: 6, 6: 8, 7: 8, 8: 0, 9: 0}[serial]
+		if serial >= 12 {
+			size = int((serial - 12) / 2)
+		}
+		if size > len(body) {
+			return nil, errors.New("the SQLite record is invalid")
+		}
+		v := body[:size]
+		body = body[size:]
+		switch {
+		case serial == 0:
+			values = append(values, nil)
+		case serial <= 6:
+			var i int64
+			for _, c := range v {
+				i = i<<8 | int64(c)
+			}
+			// sign extend from the stored width.
+			shift := 64 - 8*uint(size)
+			values = append(values, i<<shift>>shift)
+		case serial == 7:
+			values = append(values, math.Float64frombits(binary.BigEndian.Uint64(v)))
+		case serial == 8:
+			values = append(values, int64(0))
+		case serial == 9:
+			values = append(values, int64(1))
+		case serial >= 12 && serial%2 == 0:
+			values = append(values, append([]byte(nil), v...))
+		case serial >= 13:
+			values = append(values, string(v))
+		default:
+			return nil, errors.New("the SQLite record is invalid")
+		}
+	}
+	return values, nil
+}
+
+// sqliteVarint decodes a big-endian varint of up to 9 bytes, returning
+// 0 bytes read if b is too short.
+func sqliteVarint(b []byte) (int64, int) {
+	var v uint64
+	for i := 0; i < 9; i++ {
+		if i >= len(b) {
+			return 0, 0
+		}
+		if i == 8 {
+			return int64(v<<8 | uint64(b[i])), 9
+		}
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i]&0x80 == 0 {
+			return int64(v), i + 1
+		}
+	}
+	return 0, 0
+}
+
+// readTable calls f with the rowid and the values by column name of
+// each row of the named table. The INTEGER PRIMARY KEY column, stored
+// as NULL, holds the rowid.
+func (db *sqliteFile) readTable(name string, f func(rowid int64, row map[string]any) error) error {
+	var root int
+	var sql string
+	err := db.scanTable(1, func(_ int64, values []any) error {
+		if len(values) >= 5 && values[0] == "table" && strings.EqualFold(fmt.Sprint(values[1]), name) {
+			if r, ok := values[3].(int64); ok {
+				root = int(r)
+			}
+			sql, _ = values[4].(string)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if root == 0 {
+		return fmt.Errorf("the database has no table %s", name)
+	}
+	columns, rowidColumn := tableColumns(sql)
+	return db.scanTable(root, func(rowid int64, values []any) error {
+		row := make(map[string]any, len(columns))
+		for i, column := range columns {
+			if i < len(values) {
+				row[column] = values[i]
+			}
+		}
+		if rowidColumn != "" {
+			row[rowidColumn] = rowid
+		}
+		return f(rowid, row)
+	})
+}
+
+// tableColumns returns the column names of a CREATE TABLE statement,
+// lowercased, and its INTEGER PRIMARY KEY column if it has one.
+func tableColumns(sql string) ([]string, string) {
+	start, end := strings.IndexByte(sql, '('), strings.LastIndexByte(sql, ')')
+	if start < 0 || end < start {
+		return nil, ""
+	}
+	var definitions []string
+	depth, from := 0, start+1
+	for i := start + 1; i < end; i++ {
+		switch sql[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				definitions = append(definitions, sql[from:i])
+				from = i + 1
+			}
+		}
+	}
+	definitions = append(definitions, sql[from:end])
+
+	var columns []string
+	rowidColumn := ""
+	for _, definition := range definitions {
+		fields := strings.Fields(definition)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN":
+			continue
+		}
+		column := strings.ToLower(strings.Trim(fields[0], "\"`[]'"))
+		columns = append(columns, column)
+		upper := strings.ToUpper(strings.Join(fields[1:], " "))
+		if strings.HasPrefix(upper, "INTEGER") && strings.Contains(upper, "PRIMARY KEY") {
+			rowidColumn = column
+		}
+	}
+	return columns, rowidColumn
+}
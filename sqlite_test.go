@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQLiteVarint(t *testing.T) {
+	v, n := sqliteVarint([]byte{0x7f})
+	assert.Equal(t, int64(127), v)
+	assert.Equal(t, 1, n)
+	v, n = sqliteVarint([]byte{0x81, 0x00})
+	assert.Equal(t, int64(128), v)
+	assert.Equal(t, 2, n)
+	v, n = sqliteVarint([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	assert.Equal(t, int64(-1), v)
+	assert.Equal(t, 9, n)
+	_, n = sqliteVarint([]byte{0x81})
+	assert.Equal(t, 0, n)
+}
+
+func TestSQLiteTableColumns(t *testing.T) {
+	columns, rowidColumn := tableColumns(`CREATE TABLE "areas" ( "fid" INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL, "geom" POLYGON, "name" TEXT(20), CONSTRAINT u UNIQUE (name))`)
+	assert.Equal(t, []string{"fid", "geom", "name"}, columns)
+	assert.Equal(t, "fid", rowidColumn)
+}
+
+func TestSQLiteReadTable(t *testing.T) {
+	b, _ := os.ReadFile("testdata/regions.gpkg")
+	db, err := openSQLite(b)
+	assert.Nil(t, err)
+	var fids []int64
+	err = db.readTable("areas", func(rowid int64, row map[string]any) error {
+		assert.Equal(t, rowid, row["fid"])
+		fids = append(fids, rowid)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 42, len(fids))
+	assert.Equal(t, int64(42), fids[41])
+
+	assert.Equal(t, "the database has no table roads", db.readTable("roads", func(int64, map[string]any) error { return nil }).Error())
+	_, err = openSQLite([]byte("not a database"))
+	assert.NotNil(t, err)
+}
+
+func TestSQLiteSharedPages(t *testing.T) {
+	// page 1 is an interior page with both cells and its right child
+	// pointing to the leaf page 2.
+	b := make([]byte, 2*512)
+	copy(b, "SQLite format 3\x00")
+	binary.BigEndian.PutUint16(b[16:], 512)
+	header := b[100:]
+	header[0] = 0x05
+	binary.BigEndian.PutUint16(header[3:], 2)
+	binary.BigEndian.PutUint32(header[8:], 2)
+	binary.BigEndian.PutUint16(header[12:], 400)
+	binary.BigEndian.PutUint16(header[14:], 408)
+	binary.BigEndian.PutUint32(b[400:], 2)
+	binary.BigEndian.PutUint32(b[408:], 2)
+	b[512] = 0x0d
+
+	db, err := openSQLite(b)
+	assert.Nil(t, err)
+	err = db.scanTable(1, func(int64, []any) error { return nil })
+	assert.Equal(t, "SQLite page 2 is in the B-tree twice", err.Error())
+}
+
+func TestSQLiteMalformedRecord(t *testing.T) {
+	// header sizes smaller than their own varint, negative, or past the
+	// end of the record.
+	for _, record := range [][]byte{
+		{0x00, 0x01},
+		{0x81, 0x01, 0x01},
+		{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		{0x05, 0x01},
+	} {
+		_, err := decodeRecord(record)
+		assert.Equal(t, "the SQLite record is invalid", err.Error())
+	}
+	values, err := decodeRecord([]byte{0x02, 0x01, 0x07})
+	assert.Nil(t, err)
+	assert.Equal(t, []any{int64(7)}, values)
+}
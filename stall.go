@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+const defaultStallMinutes = 10
+
+// the cause given when a stalled job is killed.
+var errJobStalled = errors.New("stalled")
+
+// heartbeat records a progress line of a running job, returning
+// whether the job is still stalled: it is no longer once its counters
+// advance.
+func (h *Server) heartbeat(uuid string, progress Progress) bool {
+	counters := [3]int64{progress.CellsProg, progress.NodesProg, progress.ElemsProg}
+	h.runningMutex.Lock()
+	defer h.runningMutex.Unlock()
+	job, ok := h.running[uuid]
+	if !ok {
+		return false
+	}
+	if counters != job.counters {
+		job.counters = counters
+		job.lastProgressAt = time.Now()
+		job.stalled = false
+	}
+	return job.stalled
+}
+
+// stallExempt reports whether a stage can legitimately go without
+// progress lines: only osmx extracting the main region reports any.
+func stallExempt(stage string) bool {
+	return stage != "" && stage != "extracting"
+}
+
+// checkStalls flags running jobs whose progress hasn't advanced within
+// stallAfter, and kills those past killStalledAfter if it is set.
+func (h *Server) checkStalls(now time.Time) {
+	settings := h.settings()
+	if settings.StallAfter <= 0 {
+		return
+	}
+	type stalledJob struct {
+		job   *runningJob
+		since time.Duration
+	}
+	var flagged []stalledJob
+	h.runningMutex.Lock()
+	for uuid, job := range h.running {
+		if stallExempt(h.currentProgress(uuid).Stage) {
+			continue
+		}
+		since := now.Sub(job.lastProgressAt)
+		if since < settings.StallAfter {
+			continue
+		}
+		if settings.KillStalledAfter > 0 && since >= settings.KillStalledAfter {
+			// the worker fails the job once osmx exits.
+			job.cancel(errJobStalled)
+		}
+		if !job.stalled {
+			job.stalled = true
+			flagged = append(flagged, stalledJob{job, since})
+		}
+	}
+	h.runningMutex.Unlock()
+
+	for _, s := range flagged {
+		uuid := s.job.task.Uuid
+		progress := h.currentProgress(uuid)
+		progress.Stalled = true
+		h.setProgress(uuid, progress)
+
+		err := fmt.Errorf("job %s stalled: no progress for %s", uuid, s.since.Round(time.Second))
+		fmt.Println(err)
+		sentry.WithScope(func(scope *sentry.Scope) {
+			scope.SetContext("job", sentry.Context{
+				"uuid":       uuid,
+				"name":       s.job.task.SanitizedName,
+				"regionType": s.job.task.SanitizedRegionType,
+				"key":        s.job.task.KeyName,
+				"stage":      progress.Stage,
+				"startedAt":  progress.StartedAt,
+				"cellsProg":  progress.CellsProg,
+				"nodesProg":  progress.NodesProg,
+				"elemsProg":  progress.ElemsProg,
+			})
+			sentry.CaptureException(err)
+		})
+	}
+}
+
+// StartStallMonitor checks for stalled jobs every interval.
+func (h *Server) StartStallMonitor(interval time.Duration) {
+	go func() {
+		for {
+			time.Sleep(interval)
+			h.checkStalls(time.Now())
+		}
+	}()
+}
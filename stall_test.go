@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStallFlagged(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, "sleep 30 > /dev/null"))
+	h.stallAfter = time.Minute
+	h.StartWorkers()
+	_, uuid := submit(h, richmond)
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, uuid)
+		return progress.Stage == "extracting"
+	})
+
+	h.checkStalls(time.Now())
+	_, progress := getProgress(h, uuid)
+	assert.False(t, progress.Stalled)
+
+	h.checkStalls(time.Now().Add(2 * time.Minute))
+	_, progress = getProgress(h, uuid)
+	assert.True(t, progress.Stalled)
+	// not killed without -killStalledMinutes.
+	assert.False(t, progress.Failed)
+
+	// a line with the same counters doesn't count as progress.
+	assert.True(t, h.heartbeat(uuid, Progress{CellsProg: 0}))
+	assert.False(t, h.heartbeat(uuid, Progress{CellsProg: 5}))
+}
+
+func TestStallKilled(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, "sleep 30 > /dev/null"))
+	h.stallAfter = time.Minute
+	h.killStalledAfter = time.Hour
+	h.StartWorkers()
+	_, uuid := submit(h, richmond)
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, uuid)
+		return progress.Stage == "extracting"
+	})
+
+	h.checkStalls(time.Now().Add(2 * time.Minute))
+	_, progress := getProgress(h, uuid)
+	assert.True(t, progress.Stalled)
+	assert.False(t, progress.Failed)
+
+	h.checkStalls(time.Now().Add(2 * time.Hour))
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, uuid)
+		return progress.Failed
+	})
+	_, progress = getProgress(h, uuid)
+	assert.Equal(t, failureTimeout, progress.FailureCategory)
+	assert.Equal(t, "stalled", progress.Error)
+	assert.True(t, progress.Stalled)
+}
+
+func TestStallExempt(t *testing.T) {
+	assert.False(t, stallExempt(""))
+	assert.False(t, stallExempt("extracting"))
+	assert.True(t, stallExempt("splitting"))
+	assert.True(t, stallExempt("finalizing"))
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// the output size is estimated from completed results once they add up
+// to this many nodes, and from -bytesPerNode until then.
+const minTunedNodes = 100000000
+
+const defaultStorageMarginBytes = 1 << 30
+
+// the body of a request whose estimated output doesn't fit in filesDir
+// or tmpDir, named by Storage.
+type StorageError struct {
+	Error              string
+	Storage            string
+	EstimatedSizeBytes int64
+	AvailableBytes     int64
+}
+
+// estimatedSize is the expected size in bytes of an extract of nodes,
+// or 0 when output sizes are not estimated.
+func (h *Server) estimatedSize(nodes int) int64 {
+	bytesPerNode := h.settings().BytesPerNode
+	if bytesPerNode <= 0 {
+		return 0
+	}
+	if tuned, ok := h.results.BytesPerNode(minTunedNodes); ok {
+		bytesPerNode = tuned
+	}
+	return int64(float64(nodes) * bytesPerNode)
+}
+
+// checkStorageCapacity rejects a job whose estimated output is larger
+// than filesDir can hold, or tmpDir where it is extracted, leaving
+// storageMargin free on each: with 422 if it could never fit and 507 if
+// it doesn't fit now. Without an estimate, jobs are only rejected once
+// the free space is within the margin.
+func (h *Server) checkStorageCapacity(estimated int64) (int, *StorageError) {
+	settings := h.settings()
+	for _, storage := range []struct {
+		flag, dir, name string
+		limit           int64
+	}{
+		{"filesDir", h.filesDir, "result storage", settings.MaxFilesBytes},
+		{"tmpDir", h.tmpDir, "scratch space", 0},
+	} {
+		total, free, err := diskSpace(storage.dir)
+		if err != nil {
+			continue
+		}
+		capacity := int64(total) - settings.StorageMargin
+		if storage.limit > 0 {
+			capacity = min(capacity, storage.limit)
+		}
+		if estimated > 0 && estimated > capacity {
+			return 422, &StorageError{
+				Error:              fmt.Sprintf("the estimated output of %d bytes is larger than the %s of %d bytes", estimated, storage.name, max(capacity, 0)),
+				Storage:            storage.flag,
+				EstimatedSizeBytes: estimated,
+				AvailableBytes:     max(capacity, 0),
+			}
+		}
+		available := int64(free) - settings.StorageMargin
+		if estimated <= 0 && available <= 0 {
+			return 507, &StorageError{
+				Error:              fmt.Sprintf("the %s is full", storage.name),
+				Storage:            storage.flag,
+				EstimatedSizeBytes: estimated,
+				AvailableBytes:     0,
+			}
+		}
+		if estimated > available {
+			return 507, &StorageError{
+				Error:              fmt.Sprintf("the estimated output of %d bytes is larger than the %d bytes of %s available", estimated, max(available, 0), storage.name),
+				Storage:            storage.flag,
+				EstimatedSizeBytes: estimated,
+				AvailableBytes:     max(available, 0),
+			}
+		}
+	}
+	return 0, nil
+}
+
+func writeStorageError(w http.ResponseWriter, code int, storageErr *StorageError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(storageErr)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimatedSize(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.bytesPerNode = 10
+	assert.Equal(t, int64(1000), h.estimatedSize(100))
+
+	// too few nodes to tune the estimate from.
+	h.results.Add(ResultEntry{Uuid: "a", ChangedAt: "2024-01-01T00:00:00Z", SizeBytes: 1000, nodes: 1000})
+	assert.Equal(t, int64(1000), h.estimatedSize(100))
+
+	h.results.Add(ResultEntry{Uuid: "b", ChangedAt: "2024-01-01T00:00:01Z", SizeBytes: 2 * minTunedNodes, nodes: minTunedNodes})
+	assert.InDelta(t, 200, h.estimatedSize(100), 1)
+
+	h.results.Remove("b")
+	assert.Equal(t, int64(1000), h.estimatedSize(100))
+
+	h.bytesPerNode = 0
+	assert.Equal(t, int64(0), h.estimatedSize(100))
+}
+
+func TestCheckStorageCapacity(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	_, free, err := diskSpace(h.filesDir)
+	if err != nil {
+		t.Skip(err)
+	}
+	code, storageErr := h.checkStorageCapacity(100)
+	assert.Nil(t, storageErr)
+
+	// never fits.
+	h.maxFilesBytes = 50
+	code, storageErr = h.checkStorageCapacity(100)
+	assert.Equal(t, 422, code)
+	assert.Equal(t, int64(100), storageErr.EstimatedSizeBytes)
+	assert.Equal(t, int64(50), storageErr.AvailableBytes)
+
+	// doesn't fit now.
+	h.maxFilesBytes = 0
+	h.storageMargin = int64(free) - 10
+	code, storageErr = h.checkStorageCapacity(100)
+	assert.Equal(t, 507, code)
+	assert.Equal(t, "filesDir", storageErr.Storage)
+	assert.LessOrEqual(t, storageErr.AvailableBytes, int64(10))
+
+	// without an estimate, only a full disk is refused.
+	h.storageMargin = 0
+	_, storageErr = h.checkStorageCapacity(0)
+	assert.Nil(t, storageErr)
+	h.storageMargin = int64(free) + 1<<30
+	code, storageErr = h.checkStorageCapacity(0)
+	assert.Equal(t, 507, code)
+	assert.Equal(t, "the result storage is full", storageErr.Error)
+
+	// the scratch space is checked too.
+	h.filesDir = filepath.Join(h.filesDir, "missing")
+	code, storageErr = h.checkStorageCapacity(100)
+	assert.Equal(t, 507, code)
+	assert.Equal(t, "tmpDir", storageErr.Storage)
+}
+
+func TestStorageRejected(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	if _, _, err := diskSpace(h.filesDir); err != nil {
+		t.Skip(err)
+	}
+	h.bytesPerNode = 10
+	h.StartWorkers()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/api/", strings.NewReader(richmond)))
+	assert.Equal(t, 201, w.Code)
+	var created Created
+	json.NewDecoder(w.Body).Decode(&created)
+	assert.Greater(t, created.EstimatedSizeBytes, int64(0))
+
+	h.maxFilesBytes = 1
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/api/", strings.NewReader(richmond)))
+	assert.Equal(t, 422, w.Code)
+	var storageErr StorageError
+	json.NewDecoder(w.Body).Decode(&storageErr)
+	assert.Equal(t, created.EstimatedSizeBytes, storageErr.EstimatedSizeBytes)
+
+	// dry runs have no output.
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/api/?dryRun=1", strings.NewReader(richmond)))
+	assert.Equal(t, 201, w.Code)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// JSON nested deeper than this is refused rather than recursed into.
+const maxJSONDepth = 1000
+
+// compactRegionData reads the next JSON value of decoder one token at a
+// time and writes it back without whitespace, rounding the numbers of
+// "coordinates" arrays to precision decimals unless precision is
+// negative. A pretty printed GeoJSON coastline of tens of megabytes is
+// then never held in memory whole, only its compact rounded encoding.
+// The decoder must have UseNumber set.
+func compactRegionData(decoder *json.Decoder, precision int) (json.RawMessage, error) {
+	var round func(float64) float64
+	if precision >= 0 {
+		factor := math.Pow10(precision)
+		round = func(v float64) float64 {
+			return math.Round(v*factor) / factor
+		}
+	}
+	var buf bytes.Buffer
+	if err := compactValue(&buf, decoder, round, false, 0); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func compactValue(buf *bytes.Buffer, decoder *json.Decoder, round func(float64) float64, coordinates bool, depth int) error {
+	if depth > maxJSONDepth {
+		return errors.New("JSON is nested too deeply")
+	}
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	switch t := token.(type) {
+	case json.Delim:
+		if t == '{' {
+			buf.WriteByte('{')
+			for i := 0; decoder.More(); i++ {
+				if i > 0 {
+					buf.WriteByte(',')
+				}
+				key, err := decoder.Token()
+				if err != nil {
+					return err
+				}
+				name, _ := key.(string)
+				writeJSONString(buf, name)
+				buf.WriteByte(':')
+				if err := compactValue(buf, decoder, round, name == "coordinates", depth+1); err != nil {
+					return err
+				}
+			}
+			buf.WriteByte('}')
+		} else {
+			buf.WriteByte('[')
+			for i := 0; decoder.More(); i++ {
+				if i > 0 {
+					buf.WriteByte(',')
+				}
+				if err := compactValue(buf, decoder, round, coordinates, depth+1); err != nil {
+					return err
+				}
+			}
+			buf.WriteByte(']')
+		}
+		// the closing delimiter.
+		_, err := decoder.Token()
+		return err
+	case json.Number:
+		if coordinates && round != nil {
+			v, err := t.Float64()
+			if err != nil {
+				return err
+			}
+			buf.WriteString(strconv.FormatFloat(round(v), 'f', -1, 64))
+		} else {
+			buf.WriteString(t.String())
+		}
+	case string:
+		writeJSONString(buf, t)
+	case bool:
+		buf.WriteString(strconv.FormatBool(t))
+	case nil:
+		buf.WriteString("null")
+	}
+	return nil
+}
+
+// writeJSONString quotes s without escaping HTML, so names like
+// "Fan <district>" are kept as they were.
+func writeJSONString(buf *bytes.Buffer, s string) {
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(s)
+	// the newline Encode ends with.
+	buf.Truncate(buf.Len() - 1)
+}
+
+// decodeInput reads a JSON Input, streaming its RegionData through
+// compactRegionData and decoding the other fields as usual.
+func decodeInput(body io.Reader, precision int) (Input, error) {
+	decoder := json.NewDecoder(body)
+	decoder.UseNumber()
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return Input{}, bodyError(err, "input GeoJSON is invalid")
+	}
+	fields := make(map[string]json.RawMessage)
+	var regionData json.RawMessage
+	for decoder.More() {
+		token, err := decoder.Token()
+		key, ok := token.(string)
+		if err != nil || !ok {
+			return Input{}, bodyError(err, "input GeoJSON is invalid")
+		}
+		// field names match case-insensitively, as in encoding/json.
+		if strings.EqualFold(key, "RegionData") {
+			regionData, err = compactRegionData(decoder, precision)
+		} else {
+			var value json.RawMessage
+			err = decoder.Decode(&value)
+			fields[key] = value
+		}
+		if err != nil {
+			return Input{}, bodyError(err, "input GeoJSON is invalid")
+		}
+	}
+	if _, err := decoder.Token(); err != nil {
+		return Input{}, bodyError(err, "input GeoJSON is invalid")
+	}
+
+	var input Input
+	b, _ := json.Marshal(fields)
+	if err := json.Unmarshal(b, &input); err != nil {
+		return input, errors.New("input GeoJSON is invalid")
+	}
+	input.RegionData = regionData
+	return input, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompactRegionData(t *testing.T) {
+	decoder := json.NewDecoder(strings.NewReader(`{
+		"type": "Feature",
+		"properties": {"name": "Fan <district>", "height": 1.23456789, "tags": [true, null]},
+		"geometry": {"type": "Polygon", "coordinates": [[[-77.46000004, 37.54], [-77.45, 37.5400001], [-77.45, 37.55], [-77.46, 37.54]]]}
+	}`))
+	decoder.UseNumber()
+	data, err := compactRegionData(decoder, 6)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"type":"Feature","properties":{"name":"Fan <district>","height":1.23456789,"tags":[true,null]},"geometry":{"type":"Polygon","coordinates":[[[-77.46,37.54],[-77.45,37.54],[-77.45,37.55],[-77.46,37.54]]]}}`, string(data))
+
+	decoder = json.NewDecoder(strings.NewReader(`[ -77.46000004, 37.54 ]`))
+	decoder.UseNumber()
+	data, err = compactRegionData(decoder, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, `[-77.46000004,37.54]`, string(data))
+
+	decoder = json.NewDecoder(strings.NewReader(strings.Repeat("[", maxJSONDepth+2) + strings.Repeat("]", maxJSONDepth+2)))
+	decoder.UseNumber()
+	_, err = compactRegionData(decoder, 6)
+	assert.Equal(t, "JSON is nested too deeply", err.Error())
+}
+
+func TestDecodeInput(t *testing.T) {
+	input, err := decodeInput(strings.NewReader(`{"Name":"route","regiondata":{"type":"LineString","coordinates":[[-77.45,37.53],[-77.44,37.54]]},"RegionType":"geojson","BufferMeters":500,"Exclude":{"type":"Polygon","coordinates":[]}} trailing`), 6)
+	assert.Nil(t, err)
+	assert.Equal(t, "route", input.Name)
+	assert.Equal(t, "geojson", input.RegionType)
+	assert.Equal(t, 500.0, input.BufferMeters)
+	assert.Equal(t, `{"type":"LineString","coordinates":[[-77.45,37.53],[-77.44,37.54]]}`, string(input.RegionData))
+	assert.Equal(t, `{"type":"Polygon","coordinates":[]}`, string(input.Exclude))
+
+	for _, body := range []string{``, `[]`, `{"RegionData":{"type":}`, `{"Name":1}`, `{"RegionData":[1,2]`} {
+		_, err := decodeInput(strings.NewReader(body), 6)
+		assert.Equal(t, "input GeoJSON is invalid", err.Error(), body)
+	}
+}
+
+// a detailed region, pretty printed with full precision coordinates.
+func detailedRegion(vertices int) []byte {
+	var ring orb.Ring
+	for i := 0; i < vertices; i++ {
+		angle := 2 * math.Pi * float64(i) / float64(vertices)
+		ring = append(ring, orb.Point{-77.4352 + 0.02*math.Cos(angle), 37.5401 + 0.01*math.Sin(angle)})
+	}
+	ring = append(ring, ring[0])
+	b, _ := json.MarshalIndent(map[string]any{"Name": "coast", "RegionType": "geojson", "RegionData": geojson.NewGeometry(orb.Polygon{ring})}, "", "    ")
+	return b
+}
+
+func TestChunkedSubmission(t *testing.T) {
+	h := newTestServer(t, "osmx")
+	var transferEncoding []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		transferEncoding = r.TransferEncoding
+		h.ServeHTTP(w, r)
+	}))
+	defer s.Close()
+
+	// a body of unknown length is sent with chunked encoding.
+	body, writer := io.Pipe()
+	go func() {
+		region := detailedRegion(20000)
+		for len(region) > 0 {
+			n := min(len(region), 4096)
+			writer.Write(region[:n])
+			region = region[n:]
+		}
+		writer.Close()
+	}()
+	resp, err := http.Post(s.URL+"/api/estimate", "application/json", body)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, []string{"chunked"}, transferEncoding)
+}
+
+func TestMultipartGeoJSON(t *testing.T) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("Name", "coast")
+	form.WriteField("RegionType", "geojson")
+	file, _ := form.CreateFormFile("RegionData", "coast.geojson")
+	region := detailedRegion(1000)
+	var input struct{ RegionData json.RawMessage }
+	json.Unmarshal(region, &input)
+	file.Write(input.RegionData)
+	form.Close()
+
+	r := httptest.NewRequest("POST", "/api/", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	decoded, err := decodeMultipartInput(r, 6)
+	assert.Nil(t, err)
+	assert.False(t, bytes.ContainsAny(decoded.RegionData, " \n"))
+	assert.True(t, len(decoded.RegionData) < len(input.RegionData)/2)
+	_, _, _, _, err = parseRegion(decoded, defaultRegionLimits)
+	assert.Nil(t, err)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+// upper bound on the named features of one job, each of which is
+// extracted again for the split download.
+const maxSubRegions = 25
+
+// A named part of a FeatureCollection region. Region is the sanitized
+// GeoJSON geometry, kept in region.json but not the completion record.
+type SubRegion struct {
+	Name   string
+	Bbox   [4]float64      // min lon, min lat, max lon, max lat
+	Region json.RawMessage `json:",omitempty"`
+}
+
+var unsafeFilename = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// parseFeatureCollection reads the polygons of a FeatureCollection.
+// When every feature has a "name" property they are named features,
+// which must be unique Polygons or MultiPolygons. Otherwise, as
+// exported by most editors, names is nil and only the polygonal
+// features are kept.
+func parseFeatureCollection(data json.RawMessage) ([]string, []orb.Geometry, error) {
+	fc, err := geojson.UnmarshalFeatureCollection(data)
+	if err != nil {
+		return nil, nil, errors.New("input GeoJSON is invalid")
+	}
+	if len(fc.Features) == 0 {
+		return nil, nil, errors.New("FeatureCollection has no features")
+	}
+	for _, f := range fc.Features {
+		if name, _ := f.Properties["name"].(string); name == "" {
+			return nil, polygonalFeatures(fc), nil
+		}
+	}
+	if len(fc.Features) > maxSubRegions {
+		return nil, nil, fmt.Errorf("FeatureCollection has %d features, more than the limit of %d", len(fc.Features), maxSubRegions)
+	}
+	var names []string
+	var geoms []orb.Geometry
+	seen := make(map[string]bool)
+	for _, f := range fc.Features {
+		name, _ := f.Properties["name"].(string)
+		// names become file names in the split download.
+		filename := unsafeFilename.ReplaceAllString(name, "_")
+		if seen[filename] {
+			return nil, nil, fmt.Errorf("feature name %q is not unique", name)
+		}
+		seen[filename] = true
+		switch f.Geometry.(type) {
+		case orb.Polygon, orb.MultiPolygon:
+		default:
+			return nil, nil, errors.New("features must be Polygons or MultiPolygons")
+		}
+		names = append(names, name)
+		geoms = append(geoms, f.Geometry)
+	}
+	return names, geoms, nil
+}
+
+// polygonalFeatures are the Polygon and MultiPolygon geometries of a
+// FeatureCollection, skipping points, lines and empty features.
+func polygonalFeatures(fc *geojson.FeatureCollection) []orb.Geometry {
+	var geoms []orb.Geometry
+	for _, f := range fc.Features {
+		switch f.Geometry.(type) {
+		case orb.Polygon, orb.MultiPolygon:
+			geoms = append(geoms, f.Geometry)
+		}
+	}
+	return geoms
+}
+
+// parseFeatureCollectionRegion is the union of the features, which is
+// what the main extract runs over.
+func parseFeatureCollectionRegion(data json.RawMessage, limits RegionLimits) (orb.Geometry, string, json.RawMessage, error) {
+	_, geoms, err := parseFeatureCollection(data)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if len(geoms) == 0 {
+		return nil, "", nil, errors.New("FeatureCollection has no Polygon or MultiPolygon features")
+	}
+	var polys []orb.Polygon
+	for _, g := range geoms {
+		switch v := g.(type) {
+		case orb.Polygon:
+			polys = append(polys, v)
+		case orb.MultiPolygon:
+			polys = append(polys, v...)
+		}
+	}
+	if err := checkPolygonsComplexity(polys, limits); err != nil {
+		return nil, "", nil, err
+	}
+	var union orb.Geometry = unionPolygons(polys)
+	if mp := union.(orb.MultiPolygon); len(mp) == 1 {
+		union = mp[0]
+	}
+	sanitizedData, _ := geojson.NewGeometry(union).MarshalJSON()
+	return union, "geojson", sanitizedData, nil
+}
+
+// parseSubRegions returns the named features of a FeatureCollection
+// region, sanitized like the region itself, or nil for other regions.
+func parseSubRegions(input Input, limits RegionLimits) ([]SubRegion, error) {
+	if input.RegionType != "geojson" {
+		return nil, nil
+	}
+	var probe struct{ Type string }
+	if json.Unmarshal(input.RegionData, &probe) != nil || probe.Type != "FeatureCollection" {
+		return nil, nil
+	}
+	names, geoms, err := parseFeatureCollection(input.RegionData)
+	if err != nil || names == nil {
+		return nil, err
+	}
+	var subRegions []SubRegion
+	for i, g := range geoms {
+		data, _ := geojson.NewGeometry(g).MarshalJSON()
+		rounded, sanitizedData, err := roundRegion(g, "geojson", data, limits.Precision)
+		if err != nil {
+			return nil, fmt.Errorf("feature %q: %w", names[i], err)
+		}
+		bound := rounded.Bound()
+		subRegions = append(subRegions, SubRegion{
+			Name:   names[i],
+			Bbox:   [4]float64{bound.Min[0], bound.Min[1], bound.Max[0], bound.Max[1]},
+			Region: sanitizedData,
+		})
+	}
+	return subRegions, nil
+}
+
+// extractSubRegions runs osmx once per named feature and zips the
+// results into zipPath, one pbf per feature.
+func (h *Server) extractSubRegions(ctx context.Context, scratch string, uuid string, subRegions []SubRegion, extraArgs []string, zipPath string) error {
+	out, err := os.Create(zipPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	archive := zip.NewWriter(out)
+
+	for i, sub := range subRegions {
+		pbfPath := filepath.Join(scratch, fmt.Sprintf("%s_%d.osm.pbf", uuid, i))
+		err := func() error {
+			defer os.Remove(pbfPath)
+			regionPath, err := writeRegionFile(scratch, Task{Uuid: fmt.Sprintf("%s_%d", uuid, i), SanitizedRegionType: "geojson", SanitizedRegionData: sub.Region})
+			defer os.Remove(regionPath)
+			if err != nil {
+				return err
+			}
+			if err := h.extractor.Extract(ctx, h.data, regionPath, pbfPath, extraArgs, nil); err != nil {
+				if ctx.Err() != nil {
+					return context.Cause(ctx)
+				}
+				return fmt.Errorf("extracting %q: %w", sub.Name, err)
+			}
+			if _, err := verifyPBF(pbfPath); err != nil {
+				return fmt.Errorf("extracting %q: %w", sub.Name, err)
+			}
+			pbf, err := os.Open(pbfPath)
+			if err != nil {
+				return err
+			}
+			defer pbf.Close()
+			// pbf blobs are already compressed.
+			entry, err := archive.CreateHeader(&zip.FileHeader{Name: unsafeFilename.ReplaceAllString(sub.Name, "_") + ".osm.pbf", Method: zip.Store})
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(entry, pbf)
+			return err
+		}()
+		if err != nil {
+			return err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
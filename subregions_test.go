@@ -0,0 +1,111 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const districts = `{"Name":"districts","RegionType":"geojson","RegionData":{"type":"FeatureCollection","features":[
+{"type":"Feature","properties":{"name":"Fan District"},"geometry":{"type":"Polygon","coordinates":[[[-77.46,37.54],[-77.45,37.54],[-77.45,37.55],[-77.46,37.55],[-77.46,37.54]]]}},
+{"type":"Feature","properties":{"name":"Church Hill"},"geometry":{"type":"Polygon","coordinates":[[[-77.42,37.52],[-77.41,37.52],[-77.41,37.53],[-77.42,37.53],[-77.42,37.52]]]}}]}}`
+
+func TestParseSubRegions(t *testing.T) {
+	input, _ := decodeInput(strings.NewReader(districts), defaultRegionLimits.Precision)
+	geom, _, regionType, data, err := parseRegion(input, defaultRegionLimits)
+	assert.Nil(t, err)
+	assert.Equal(t, "geojson", regionType)
+	assert.Contains(t, string(data), "MultiPolygon")
+	assert.Equal(t, [4]float64{-77.46, 37.52, -77.41, 37.55}, [4]float64{geom.Bound().Min[0], geom.Bound().Min[1], geom.Bound().Max[0], geom.Bound().Max[1]})
+
+	subRegions, err := parseSubRegions(input, defaultRegionLimits)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(subRegions))
+	assert.Equal(t, "Church Hill", subRegions[1].Name)
+	assert.Equal(t, [4]float64{-77.42, 37.52, -77.41, 37.53}, subRegions[1].Bbox)
+
+	input, _ = decodeInput(strings.NewReader(richmond), defaultRegionLimits.Precision)
+	subRegions, err = parseSubRegions(input, defaultRegionLimits)
+	assert.Nil(t, err)
+	assert.Nil(t, subRegions)
+}
+
+func TestParseSubRegionsErrors(t *testing.T) {
+	feature := `{"type":"Feature","properties":{"name":%q},"geometry":{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1],[0,0]]]}}`
+	_, _, err := parseFeatureCollection([]byte(`{"type":"FeatureCollection","features":[` + fmt.Sprintf(feature, "a b") + `,` + fmt.Sprintf(feature, "a/b") + `]}`))
+	assert.Contains(t, err.Error(), "is not unique")
+
+	var features []string
+	for i := 0; i <= maxSubRegions; i++ {
+		features = append(features, fmt.Sprintf(feature, fmt.Sprint(i)))
+	}
+	_, _, err = parseFeatureCollection([]byte(`{"type":"FeatureCollection","features":[` + strings.Join(features, ",") + `]}`))
+	assert.Contains(t, err.Error(), "more than the limit")
+}
+
+// features without names, as exported by geojson.io or QGIS, are
+// extracted as the union of their polygons.
+func TestUnnamedFeatureCollection(t *testing.T) {
+	input, _ := decodeInput(strings.NewReader(`{"Name":"export","RegionType":"geojson","RegionData":{"type":"FeatureCollection","features":[
+{"type":"Feature","properties":{},"geometry":{"type":"Polygon","coordinates":[[[-77.46,37.54],[-77.45,37.54],[-77.45,37.55],[-77.46,37.55],[-77.46,37.54]]]}},
+{"type":"Feature","properties":{"name":"Church Hill"},"geometry":{"type":"Polygon","coordinates":[[[-77.42,37.52],[-77.41,37.52],[-77.41,37.53],[-77.42,37.53],[-77.42,37.52]]]}},
+{"type":"Feature","properties":null,"geometry":{"type":"Point","coordinates":[-77.43,37.53]}}]}}`), defaultRegionLimits.Precision)
+	geom, _, regionType, data, err := parseRegion(input, defaultRegionLimits)
+	assert.Nil(t, err)
+	assert.Equal(t, "geojson", regionType)
+	assert.Contains(t, string(data), "MultiPolygon")
+	assert.Equal(t, -77.46, geom.Bound().Min[0])
+	subRegions, err := parseSubRegions(input, defaultRegionLimits)
+	assert.Nil(t, err)
+	assert.Nil(t, subRegions)
+
+	input, _ = decodeInput(strings.NewReader(`{"RegionType":"geojson","RegionData":{"type":"FeatureCollection","features":[{"type":"Feature","properties":{},"geometry":{"type":"Point","coordinates":[0,0]}}]}}`), defaultRegionLimits.Precision)
+	_, _, _, _, err = parseRegion(input, defaultRegionLimits)
+	assert.EqualError(t, err, "FeatureCollection has no Polygon or MultiPolygon features")
+}
+
+func TestFeatureRegion(t *testing.T) {
+	input, _ := decodeInput(strings.NewReader(`{"RegionType":"geojson","RegionData":{"type":"Feature","properties":{"name":"Fan"},"geometry":{"type":"Polygon","coordinates":[[[-77.46,37.54],[-77.45,37.54],[-77.45,37.55],[-77.46,37.55],[-77.46,37.54]]]}}}`), defaultRegionLimits.Precision)
+	_, _, regionType, data, err := parseRegion(input, defaultRegionLimits)
+	assert.Nil(t, err)
+	assert.Equal(t, "geojson", regionType)
+	assert.JSONEq(t, `{"type":"Polygon","coordinates":[[[-77.46,37.54],[-77.45,37.54],[-77.45,37.55],[-77.46,37.55],[-77.46,37.54]]]}`, string(data))
+}
+
+func TestSplitDownload(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.StartWorkers()
+	code, uuid := submit(h, districts)
+	assert.Equal(t, 201, code)
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, uuid)
+		return progress.Complete
+	})
+	_, progress := getProgress(h, uuid)
+	assert.Equal(t, "Fan District", progress.SubRegions[0].Name)
+	assert.Nil(t, progress.SubRegions[0].Region)
+	assert.Contains(t, progress.StageDurations, "splitting")
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/"+uuid+"/download?split=1", nil))
+	assert.Equal(t, 200, w.Code)
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	assert.Nil(t, err)
+	assert.Equal(t, "Fan_District.osm.pbf", archive.File[0].Name)
+	assert.Equal(t, "Church_Hill.osm.pbf", archive.File[1].Name)
+	assert.Empty(t, scratchFiles(h))
+
+	_, other := submit(h, richmond)
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, other)
+		return progress.Complete
+	})
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/"+other+"/download?split=1", nil))
+	assert.Equal(t, 404, w.Code)
+}
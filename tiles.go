@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/maptile"
+)
+
+// upper bound on the tiles and tile ranges of a single tiles region.
+const maxRegionTiles = 256
+
+// deepest zoom of the tiles of a tiles region.
+const maxRegionTileZoom = 20
+
+// a list of z/x/y tiles, where x and y can also be ranges such as
+// 14/4680-4690/6260-6270, extracted as the union of their bounds and
+// stored as a GeoJSON region.
+func parseTilesRegion(input Input, _ RegionLimits) (orb.Geometry, string, json.RawMessage, error) {
+	var tiles []string
+	if err := json.Unmarshal(input.RegionData, &tiles); err != nil {
+		return nil, "", nil, errors.New("input tiles must be a list of z/x/y strings")
+	}
+	if len(tiles) == 0 {
+		return nil, "", nil, errors.New("input does not have any tiles")
+	}
+	if len(tiles) > maxRegionTiles {
+		return nil, "", nil, fmt.Errorf("input has more than %d tiles", maxRegionTiles)
+	}
+	polygons := make([]orb.Polygon, len(tiles))
+	for i, s := range tiles {
+		bound, err := parseTileRange(s)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		polygons[i] = orb.Polygon{bound.ToRing()}
+	}
+	union := unionPolygons(polygons)
+	var geom orb.Geometry = union
+	if len(union) == 1 {
+		geom = union[0]
+	}
+	sanitizedData, _ := geojson.NewGeometry(geom).MarshalJSON()
+	return geom, "geojson", sanitizedData, nil
+}
+
+// parseTileRange returns the bound of a z/x/y tile, or of the tiles in
+// a z/minX-maxX/minY-maxY range.
+func parseTileRange(s string) (orb.Bound, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 3 {
+		return orb.Bound{}, fmt.Errorf("tile %q is not z/x/y", s)
+	}
+	z, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return orb.Bound{}, fmt.Errorf("tile %q is not z/x/y", s)
+	}
+	if z > maxRegionTileZoom {
+		return orb.Bound{}, fmt.Errorf("tile %q is deeper than zoom %d", s, maxRegionTileZoom)
+	}
+	var lo, hi [2]uint64
+	for i, part := range parts[1:] {
+		min, max, isRange := strings.Cut(part, "-")
+		if !isRange {
+			max = min
+		}
+		if lo[i], err = strconv.ParseUint(min, 10, 32); err != nil {
+			return orb.Bound{}, fmt.Errorf("tile %q is not z/x/y", s)
+		}
+		if hi[i], err = strconv.ParseUint(max, 10, 32); err != nil {
+			return orb.Bound{}, fmt.Errorf("tile %q is not z/x/y", s)
+		}
+		if lo[i] > hi[i] || hi[i] >= 1<<z {
+			return orb.Bound{}, fmt.Errorf("tile %q is out of range", s)
+		}
+	}
+	zoom := maptile.Zoom(z)
+	return maptile.New(uint32(lo[0]), uint32(lo[1]), zoom).Bound().Union(maptile.New(uint32(hi[0]), uint32(hi[1]), zoom).Bound()), nil
+}
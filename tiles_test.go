@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/maptile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTilesRegion(t *testing.T) {
+	geom, _, regiontype, _, err := parseInput(strings.NewReader(`{"Name":"a_name","RegionType":"tiles","RegionData":["14/4667/6346","14/4668/6346"]}`))
+	assert.Nil(t, err)
+	assert.Equal(t, "geojson", regiontype)
+	_, isPolygon := geom.(orb.Polygon)
+	assert.True(t, isPolygon)
+	bound := maptile.New(4667, 6346, 14).Bound().Union(maptile.New(4668, 6346, 14).Bound())
+	assert.InDelta(t, bound.Min[0], geom.Bound().Min[0], 1e-6)
+	assert.InDelta(t, bound.Max[0], geom.Bound().Max[0], 1e-6)
+	assert.InDelta(t, bound.Max[1], geom.Bound().Max[1], 1e-6)
+
+	geom, _, _, _, err = parseInput(strings.NewReader(`{"Name":"a_name","RegionType":"tiles","RegionData":["14/4667/6346","10/100/100"]}`))
+	assert.Nil(t, err)
+	_, isMultiPolygon := geom.(orb.MultiPolygon)
+	assert.True(t, isMultiPolygon)
+}
+
+func TestTileRange(t *testing.T) {
+	bound, err := parseTileRange("14/4667-4669/6346-6347")
+	assert.Nil(t, err)
+	assert.Equal(t, maptile.New(4667, 6346, 14).Bound().Union(maptile.New(4669, 6347, 14).Bound()), bound)
+
+	for _, s := range []string{"14/4667", "a/1/1", "21/0/0", "1/2/0", "2/3-1/0", "2/0-x/0"} {
+		_, err := parseTileRange(s)
+		assert.NotNil(t, err, s)
+	}
+	_, _, _, _, err = parseInput(strings.NewReader(`{"Name":"a_name","RegionType":"tiles","RegionData":[]}`))
+	assert.Equal(t, "input does not have any tiles", err.Error())
+}
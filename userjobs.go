@@ -0,0 +1,115 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// the most jobs kept in a user's history; older ones are dropped.
+const maxUserJobs = 1000
+
+// A job submitted by a logged in user.
+type UserJob struct {
+	Uuid        string
+	SubmittedAt string
+}
+
+// The jobs each OpenStreetMap user submitted, oldest first, persisted as
+// user_jobs.json in filesDir. Only the user id is kept, not the name.
+type UserJobStore struct {
+	mutex sync.Mutex
+	path  string
+	jobs  map[string][]UserJob // by OSM user id
+}
+
+func LoadUserJobs(filesDir string) (*UserJobStore, error) {
+	s := &UserJobStore{
+		path: filepath.Join(filesDir, "user_jobs.json"),
+		jobs: make(map[string][]UserJob),
+	}
+	b, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.jobs); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Add records a job in the user's history. A job submitted twice, such
+// as one handed out again for an identical region, is listed once.
+func (s *UserJobStore) Add(userId int64, job UserJob) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	id := strconv.FormatInt(userId, 10)
+	jobs := s.jobs[id]
+	for i, existing := range jobs {
+		if existing.Uuid == job.Uuid {
+			jobs = append(jobs[:i], jobs[i+1:]...)
+			break
+		}
+	}
+	jobs = append(jobs, job)
+	if len(jobs) > maxUserJobs {
+		jobs = jobs[len(jobs)-maxUserJobs:]
+	}
+	s.jobs[id] = jobs
+	b, err := json.Marshal(s.jobs)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, b)
+}
+
+// Summaries lists the user's jobs, most recently submitted first.
+func (s *UserJobStore) Summaries(h *Server, userId int64) []JobSummary {
+	s.mutex.Lock()
+	jobs := append([]UserJob(nil), s.jobs[strconv.FormatInt(userId, 10)]...)
+	s.mutex.Unlock()
+	summaries := make([]JobSummary, 0, len(jobs))
+	for i := len(jobs) - 1; i >= 0; i-- {
+		summary := h.jobSummary(jobs[i].Uuid)
+		summary.SubmittedAt = cmp.Or(summary.SubmittedAt, jobs[i].SubmittedAt)
+		summaries = append(summaries, summary)
+	}
+	return summaries
+}
+
+// Uuids is the set of the user's jobs.
+func (s *UserJobStore) Uuids(userId int64) map[string]bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	jobs := s.jobs[strconv.FormatInt(userId, 10)]
+	uuids := make(map[string]bool, len(jobs))
+	for _, job := range jobs {
+		uuids[job.Uuid] = true
+	}
+	return uuids
+}
+
+// recordUserJob adds a submitted job to the history of the logged in
+// user, if any.
+func (h *Server) recordUserJob(r *http.Request, created *Created) {
+	if user := h.osmUser(r); user != nil {
+		h.addUserJob(*user, created)
+	}
+}
+
+// addUserJob adds a submitted job to the history of the user.
+func (h *Server) addUserJob(user OSMUser, created *Created) {
+	job := UserJob{Uuid: created.Uuid, SubmittedAt: time.Now().UTC().Format(time.RFC3339)}
+	if err := h.userJobs.Add(user.Id, job); err != nil {
+		fmt.Println("recording job", created.Uuid, "of user", user.Id, err)
+	}
+}
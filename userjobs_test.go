@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserJobStore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := LoadUserJobs(dir)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Add(42, UserJob{Uuid: "a", SubmittedAt: "2024-01-01T00:00:00Z"}))
+	assert.Nil(t, s.Add(42, UserJob{Uuid: "b", SubmittedAt: "2024-01-02T00:00:00Z"}))
+	assert.Nil(t, s.Add(7, UserJob{Uuid: "c", SubmittedAt: "2024-01-02T00:00:00Z"}))
+	// a job handed out again moves to the end.
+	assert.Nil(t, s.Add(42, UserJob{Uuid: "a", SubmittedAt: "2024-01-03T00:00:00Z"}))
+
+	reloaded, err := LoadUserJobs(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, []UserJob{{"b", "2024-01-02T00:00:00Z"}, {"a", "2024-01-03T00:00:00Z"}}, reloaded.jobs["42"])
+
+	for i := 0; i < maxUserJobs+5; i++ {
+		s.Add(1, UserJob{Uuid: string(rune('a'+i%26)) + string(rune(i))})
+	}
+	assert.Len(t, s.jobs["1"], maxUserJobs)
+}
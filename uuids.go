@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// a queued task whose uuid already has a completed result, which can
+// happen when filesDir is restored from a backup.
+var errUuidConflict = errors.New("uuid conflict")
+
+// uuidInUse reports whether anything exists for id: a job in memory, a
+// reservation, or a record, region, result or batch in filesDir.
+func (h *Server) uuidInUse(id string) bool {
+	if h.hasProgress(id) {
+		return true
+	}
+	if _, ok := h.reservations.awaiting(id, time.Now()); ok {
+		return true
+	}
+	for _, name := range []string{id, id + "_region.json", id + ".osm.pbf", id + ".osm.pbf.enc", id + "_batch.json"} {
+		if _, err := os.Lstat(filepath.Join(h.filesDir, name)); err == nil || !os.IsNotExist(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// newUuid draws uuids from generate until one isn't in use.
+func (h *Server) newUuid(generate func() string) string {
+	for {
+		if id := generate(); !h.uuidInUse(id) {
+			return id
+		}
+	}
+}
+
+// hasResult reports whether id already has a completed result that
+// running it again would overwrite.
+func (h *Server) hasResult(id string) bool {
+	for _, name := range []string{id + ".osm.pbf", id + ".osm.pbf.enc"} {
+		if _, err := os.Lstat(filepath.Join(h.filesDir, name)); err == nil {
+			return true
+		}
+	}
+	b, err := os.ReadFile(filepath.Join(h.filesDir, id))
+	if err != nil {
+		return false
+	}
+	var record Progress
+	return json.Unmarshal(b, &record) != nil || record.Complete
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewUuidSkipsUuidsInUse(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.StartWorkers()
+	os.WriteFile(filepath.Join(h.filesDir, "record"), []byte(`{"Complete":true}`), 0644)
+	os.WriteFile(filepath.Join(h.filesDir, "region_region.json"), []byte(`{}`), 0644)
+	os.WriteFile(filepath.Join(h.filesDir, "pbf.osm.pbf"), []byte("old"), 0644)
+	h.setProgress("queued", Progress{})
+	h.reservations.reserve("reserved", nil, nil, time.Now())
+
+	ids := []string{"record", "region", "pbf", "queued", "reserved", "fresh"}
+	generate := func() string {
+		id := ids[0]
+		ids = ids[1:]
+		return id
+	}
+	assert.Equal(t, "fresh", h.newUuid(generate))
+	assert.Empty(t, ids)
+}
+
+func TestRunTaskRefusesToOverwriteResult(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.StartWorkers()
+	id := "00000000-0000-0000-0000-000000000001"
+	record := []byte(`{"Complete":true,"SizeBytes":3}`)
+	os.WriteFile(filepath.Join(h.filesDir, id), record, 0644)
+	os.WriteFile(filepath.Join(h.filesDir, id+".osm.pbf"), []byte("old"), 0644)
+
+	h.setProgress(id, Progress{})
+	assert.True(t, h.queue.Push(Task{Uuid: id, SanitizedRegionType: "bbox", SanitizedRegionData: []byte("[37.5272,-77.4571,37.5530,-77.4133]")}, 1))
+	waitFor(t, func() bool {
+		failures, _ := h.failures.Window(time.Now())
+		return failures[failureConflict] == 1
+	})
+
+	b, _ := os.ReadFile(filepath.Join(h.filesDir, id))
+	assert.Equal(t, record, b)
+	b, _ = os.ReadFile(filepath.Join(h.filesDir, id+".osm.pbf"))
+	assert.Equal(t, "old", string(b))
+	_, err := os.Stat(filepath.Join(h.filesDir, id+"_region.json"))
+	assert.True(t, os.IsNotExist(err))
+	code, progress := getProgress(h, id)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, int64(3), progress.SizeBytes)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+// the body of a request whose region is not a valid polygon, locating
+// the first problem found: the index of the polygon of a MultiPolygon,
+// of the ring in it, 0 for its shell, and of the vertex in the ring, as
+// submitted.
+type GeometryError struct {
+	Error    string
+	Polygon  int
+	Ring     int
+	Vertex   int
+	Location orb.Point
+}
+
+// invalidGeometry carries a GeometryError through the error returns of
+// the parsers.
+type invalidGeometry struct {
+	body GeometryError
+}
+
+func (e *invalidGeometry) Error() string {
+	return e.body.Error
+}
+
+func geometryError(polygon, ring, vertex int, location orb.Point, format string, args ...any) error {
+	prefix := fmt.Sprintf("polygon %d ring %d ", polygon, ring)
+	return &invalidGeometry{GeometryError{
+		Error:    prefix + fmt.Sprintf(format, args...) + `; submit it with "Repair": true to repair it`,
+		Polygon:  polygon,
+		Ring:     ring,
+		Vertex:   vertex,
+		Location: location,
+	}}
+}
+
+// validRegion checks the polygons of a parsed geojson region, which
+// osmx extracts confusingly when their rings are open or cross. An
+// invalid region is rejected, or with repair rebuilt from the edges of
+// its rings like buffer(0): crossings become vertices, duplicate points
+// and spikes are dropped and rings are closed.
+func validRegion(geom orb.Geometry, data json.RawMessage, repair bool) (orb.Geometry, json.RawMessage, error) {
+	var mp orb.MultiPolygon
+	switch v := geom.(type) {
+	case orb.Polygon:
+		mp = orb.MultiPolygon{v}
+	case orb.MultiPolygon:
+		mp = v
+	default:
+		return geom, data, nil
+	}
+	err := validatePolygons(mp)
+	if err == nil || !repair {
+		return geom, data, err
+	}
+	repaired := unionPolygons(mp)
+	if len(repaired) == 0 {
+		return nil, nil, errors.New("the region has no area once repaired")
+	}
+	var result orb.Geometry = repaired
+	if len(repaired) == 1 {
+		result = repaired[0]
+	}
+	data, _ = geojson.NewGeometry(result).MarshalJSON()
+	return result, data, nil
+}
+
+// a ringSegment is the edge of a ring from its vertex to the next
+// distinct one. pos counts the segments of the ring and last is the pos
+// of its final segment, which is followed by the first.
+type ringSegment struct {
+	segment
+	ring, vertex int
+	pos, last    int
+}
+
+// validatePolygons returns a GeometryError for the first unclosed ring,
+// ring that goes back through one of its vertices, or ring that crosses
+// itself or another ring of its polygon. Rings may touch at a vertex.
+// Repeated consecutive vertices are dropped when the region is rounded,
+// and so are rings with fewer than 3 distinct vertices.
+func validatePolygons(mp orb.MultiPolygon) error {
+	for pi, polygon := range mp {
+		var segs []ringSegment
+		for ri, ring := range polygon {
+			last := len(ring) - 1
+			if ring[0] != ring[last] {
+				return geometryError(pi, ri, last, ring[last], "is not closed, its last vertex is not its first")
+			}
+			var points []orb.Point
+			var vertices []int
+			seen := make(map[orb.Point]int)
+			for vi, p := range ring {
+				if len(points) > 0 && points[len(points)-1] == p {
+					continue
+				}
+				if first, ok := seen[p]; ok && vi != last {
+					return geometryError(pi, ri, vi, p, "goes back through vertex %d at vertex %d", first, vi)
+				}
+				seen[p] = vi
+				points = append(points, p)
+				vertices = append(vertices, vi)
+			}
+			if len(points) < 4 {
+				continue
+			}
+			for k := 0; k < len(points)-1; k++ {
+				segs = append(segs, ringSegment{segment{points[k], points[k+1]}, ri, vertices[k], k, len(points) - 2})
+			}
+		}
+		if err := checkCrossings(pi, segs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkCrossings finds two segments of a polygon that cross, or overlap
+// along a line, such as a spike doubling back on itself. Consecutive
+// segments of a ring only meet at their shared vertex.
+func checkCrossings(pi int, segs []ringSegment) error {
+	bounds := make([]orb.Bound, len(segs))
+	for i, s := range segs {
+		bounds[i] = s.bound()
+	}
+	grid := newGridIndex(bounds)
+
+	var err error
+	for i, s := range segs {
+		grid.query(bounds[i], func(j int) {
+			if err != nil || j <= i || !bounds[i].Intersects(bounds[j]) {
+				return
+			}
+			t := segs[j]
+			at, ok := segmentsMeet(s, t)
+			if !ok {
+				return
+			}
+			if s.ring == t.ring {
+				err = geometryError(pi, s.ring, t.vertex, at, "crosses itself between vertices %d and %d at [%g, %g]", s.vertex, t.vertex, at[0], at[1])
+			} else {
+				err = geometryError(pi, t.ring, t.vertex, at, "crosses ring %d at [%g, %g]", s.ring, at[0], at[1])
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// segmentsMeet returns where s and the later segment t cross or start to
+// overlap.
+func segmentsMeet(s, t ringSegment) (orb.Point, bool) {
+	d1 := cross(s.a, s.b, t.a)
+	d2 := cross(s.a, s.b, t.b)
+	if s.ring == t.ring && (t.pos == s.pos+1 || (s.pos == 0 && t.pos == s.last)) {
+		// consecutive segments only overlap by turning straight back.
+		shared, p, q := s.b, s.a, t.b
+		if t.pos != s.pos+1 {
+			shared, p, q = s.a, s.b, t.a
+		}
+		if d1 == 0 && d2 == 0 && (p[0]-shared[0])*(q[0]-shared[0])+(p[1]-shared[1])*(q[1]-shared[1]) > 0 {
+			return shared, true
+		}
+		return orb.Point{}, false
+	}
+	d3 := cross(t.a, t.b, s.a)
+	d4 := cross(t.a, t.b, s.b)
+	if ((d1 > 0 && d2 < 0) || (d1 < 0 && d2 > 0)) && ((d3 > 0 && d4 < 0) || (d3 < 0 && d4 > 0)) {
+		f := d3 / (d3 - d4)
+		return orb.Point{s.a[0] + (s.b[0]-s.a[0])*f, s.a[1] + (s.b[1]-s.a[1])*f}, true
+	}
+	if d1 != 0 || d2 != 0 {
+		return orb.Point{}, false
+	}
+	for _, p := range []orb.Point{t.a, t.b} {
+		if onSegment(s.segment, p) {
+			return p, true
+		}
+	}
+	for _, p := range []orb.Point{s.a, s.b} {
+		if onSegment(t.segment, p) {
+			return p, true
+		}
+	}
+	if (s.a == t.a && s.b == t.b) || (s.a == t.b && s.b == t.a) {
+		return s.a, true
+	}
+	return orb.Point{}, false
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/planar"
+	"github.com/stretchr/testify/assert"
+)
+
+func polygonInput(coordinates string, repair bool) string {
+	return `{"Name":"a_name", "RegionType":"geojson", "Repair":` + strconv.FormatBool(repair) + `, "RegionData":{"type":"Polygon","coordinates":` + coordinates + `}}`
+}
+
+func TestValidatePolygons(t *testing.T) {
+	cases := []struct {
+		coordinates string
+		err         string
+		vertex      int
+	}{
+		{`[[[0,0],[1,1],[1,0],[0,1],[0,0]]]`, "polygon 0 ring 0 crosses itself between vertices 0 and 2 at [0.5, 0.5]", 2},
+		{`[[[0,0],[2,0],[1,0],[1,1],[0,1],[0,0]]]`, "polygon 0 ring 0 crosses itself between vertices 0 and 1 at [2, 0]", 1},
+		{`[[[0,0],[1,0],[1,1],[0,0],[-1,0],[-1,-1],[0,0]]]`, "polygon 0 ring 0 goes back through vertex 0 at vertex 3", 3},
+		{`[[[0,0],[1,0],[1,1],[0,1]]]`, "polygon 0 ring 0 is not closed, its last vertex is not its first", 3},
+		{`[[[0,0],[4,0],[4,4],[0,4],[0,0]],[[1,1],[5,1],[5,2],[1,2],[1,1]]]`, "polygon 0 ring 1 crosses ring 0 at [4, 1]", 0},
+	}
+	for _, c := range cases {
+		_, _, _, _, err := parseInput(strings.NewReader(polygonInput(c.coordinates, false)))
+		var invalid *invalidGeometry
+		if assert.ErrorAs(t, err, &invalid, c.coordinates) {
+			assert.Equal(t, c.err+`; submit it with "Repair": true to repair it`, invalid.body.Error)
+			assert.Equal(t, c.vertex, invalid.body.Vertex, c.coordinates)
+		}
+	}
+
+	// repeated vertices are dropped, and rings may touch at a vertex.
+	for _, coordinates := range []string{
+		`[[[0,0],[1,0],[1,0],[1,1],[0,0]]]`,
+		`[[[0,0],[4,0],[4,4],[0,4],[0,0]],[[0,0],[1,2],[2,1],[0,0]]]`,
+	} {
+		_, _, _, _, err := parseInput(strings.NewReader(polygonInput(coordinates, false)))
+		assert.Nil(t, err, coordinates)
+	}
+}
+
+func TestRepairRegion(t *testing.T) {
+	geom, _, _, data, err := parseInput(strings.NewReader(polygonInput(`[[[0,0],[1,1],[1,0],[0,1],[0,0]]]`, true)))
+	assert.Nil(t, err)
+	mp, ok := geom.(orb.MultiPolygon)
+	if assert.True(t, ok) {
+		assert.Equal(t, 2, len(mp))
+		assert.Nil(t, validatePolygons(mp))
+	}
+	assert.InDelta(t, 0.5, planar.Area(geom), 1e-9)
+	assert.Contains(t, string(data), `"MultiPolygon"`)
+
+	geom, _, _, _, err = parseInput(strings.NewReader(polygonInput(`[[[0,0],[4,0],[4,4],[0,4],[0,0]],[[1,1],[5,1],[5,2],[1,2],[1,1]]]`, true)))
+	assert.Nil(t, err)
+	assert.Nil(t, validatePolygons(orb.MultiPolygon{geom.(orb.Polygon)}))
+}
+
+func TestInvalidGeometryResponse(t *testing.T) {
+	h := newTestServer(t, "osmx")
+	r := httptest.NewRequest("POST", "/api/estimate", bytes.NewReader([]byte(polygonInput(`[[[0,0],[1,1],[1,0],[0,1],[0,0]]]`, false))))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, 400, w.Code)
+	var body GeometryError
+	json.NewDecoder(w.Body).Decode(&body)
+	assert.Equal(t, 0, body.Polygon)
+	assert.Equal(t, 0, body.Ring)
+	assert.Equal(t, 2, body.Vertex)
+	assert.Equal(t, orb.Point{0.5, 0.5}, body.Location)
+}
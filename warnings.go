@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// codes of the warnings a job can carry.
+const (
+	warningExcludeDisjoint = "exclude_disjoint"   // the Exclude polygon missed the region
+	warningEmptyExtract    = "empty_extract"      // the extract has no nodes
+	warningEstimateOver    = "estimate_overshoot" // the extract has far more nodes than estimated
+	warningReconstructed   = "reconstructed"      // the completion record was rebuilt from the pbf
+	warningUploadFailed    = "upload_failed"      // the result isn't in the object store
+)
+
+// the extract overshoots when it has this many times the estimated nodes.
+const estimateOvershootFactor = 2
+
+// A problem with a job that didn't stop it from completing.
+type Warning struct {
+	Code    string
+	Message string
+}
+
+// completionWarnings checks a completed extract against its task.
+func completionWarnings(task Task, progress Progress) []Warning {
+	var warnings []Warning
+	if progress.NodesTotal == 0 {
+		warnings = append(warnings, Warning{warningEmptyExtract, "the region contains no OSM data"})
+	} else if task.EstimatedNodes > 0 && progress.NodesTotal > estimateOvershootFactor*task.EstimatedNodes {
+		warnings = append(warnings, Warning{warningEstimateOver, fmt.Sprintf("the extract has %d nodes, more than %d times the estimate of %d", progress.NodesTotal, estimateOvershootFactor, task.EstimatedNodes)})
+	}
+	return warnings
+}
+
+// Counts of the warnings of completed jobs by code since startup.
+type WarningCounts struct {
+	mutex  sync.Mutex
+	counts map[string]int64
+}
+
+func (c *WarningCounts) Add(warnings []Warning) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[string]int64)
+	}
+	for _, w := range warnings {
+		c.counts[w.Code]++
+	}
+}
+
+func (c *WarningCounts) Counts() map[string]int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	counts := make(map[string]int64, len(c.counts))
+	for code, n := range c.counts {
+		counts[code] = n
+	}
+	return counts
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompletionWarnings(t *testing.T) {
+	assert.Empty(t, completionWarnings(Task{EstimatedNodes: 100}, Progress{NodesTotal: 150}))
+	assert.Equal(t, warningEmptyExtract, completionWarnings(Task{EstimatedNodes: 100}, Progress{})[0].Code)
+	assert.Equal(t, warningEstimateOver, completionWarnings(Task{EstimatedNodes: 100}, Progress{NodesTotal: 201})[0].Code)
+	// nothing to compare with.
+	assert.Empty(t, completionWarnings(Task{}, Progress{NodesTotal: 201}))
+}
+
+func TestWarningsInCompletionRecord(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.StartWorkers()
+	_, uuid := submit(h, `{"Name":"richmond","RegionType":"bbox","RegionData":[37.5272,-77.4571,37.5530,-77.4133],"Exclude":{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1],[0,0]]]}}`)
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, uuid)
+		return progress.Complete
+	})
+	_, progress := getProgress(h, uuid)
+	assert.False(t, progress.Failed)
+	assert.Equal(t, []Warning{{warningExcludeDisjoint, "Exclude does not intersect the region"}}, progress.Warnings)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/"+uuid+"/download", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "1", w.Header().Get("X-SliceOSM-Warnings"))
+
+	_, other := submit(h, richmond)
+	waitFor(t, func() bool {
+		_, progress := getProgress(h, other)
+		return progress.Complete
+	})
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/"+other+"/download", nil))
+	assert.Equal(t, "0", w.Header().Get("X-SliceOSM-Warnings"))
+
+	assert.Equal(t, map[string]int64{warningExcludeDisjoint: 1}, h.stats().Warnings)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// a callback is given up after this many attempts, waiting the backoff
+// before the first retry and twice as long before each following one.
+const callbackAttempts = 6
+const defaultCallbackBackoff = 10 * time.Second
+
+// The body POSTed to a task's CallbackUrl once it finishes: its
+// completion or failure record as GET /api/{uuid} returns it.
+type Callback struct {
+	Uuid     string
+	Progress json.RawMessage
+}
+
+// Webhooks signs and delivers completion callbacks. Deliveries are only
+// held in memory, so those still retrying are lost on restart.
+type Webhooks struct {
+	secret  []byte
+	client  *http.Client
+	backoff time.Duration
+}
+
+func loadWebhooks(secretFile string) (*Webhooks, error) {
+	b, err := os.ReadFile(secretFile)
+	if err != nil {
+		return nil, err
+	}
+	secret := bytes.TrimSpace(b)
+	if len(secret) == 0 {
+		return nil, errors.New("the secret is empty")
+	}
+	return &Webhooks{
+		secret:  secret,
+		client:  callbackClient(),
+		backoff: defaultCallbackBackoff,
+	}, nil
+}
+
+// callbackClient only connects to public addresses, also when
+// redirected, so a CallbackUrl can't make the server POST into its own
+// network.
+func callbackClient() *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, Control: publicAddressOnly}
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			// no proxy from the environment, which would be dialed
+			// instead of the host.
+			Proxy:       nil,
+			DialContext: dialer.DialContext,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			return checkCallbackUrl(req.URL)
+		},
+	}
+}
+
+func parseCallbackUrl(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return errors.New("CallbackUrl must be an absolute http or https URL")
+	}
+	return checkCallbackUrl(u)
+}
+
+// checkCallbackUrl refuses URLs that aren't http or https, and those of
+// addresses that aren't public. Hosts are checked again once resolved.
+func checkCallbackUrl(u *url.URL) error {
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errors.New("CallbackUrl must be an absolute http or https URL")
+	}
+	host := u.Hostname()
+	if strings.EqualFold(host, "localhost") {
+		return errors.New("CallbackUrl must be a public address")
+	}
+	if net.ParseIP(host) != nil {
+		if err := publicAddressOnly("tcp", net.JoinHostPort(host, "0"), nil); err != nil {
+			return fmt.Errorf("CallbackUrl must be a public address: %w", err)
+		}
+	}
+	return nil
+}
+
+// sign is the hex HMAC-SHA256 of the timestamp, a dot and the body, so a
+// captured callback can't be replayed with a new timestamp.
+func (wh *Webhooks) sign(timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, wh.secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (wh *Webhooks) post(callbackUrl string, body []byte) error {
+	req, err := http.NewRequest("POST", callbackUrl, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-SliceOSM-Timestamp", timestamp)
+	req.Header.Set("X-SliceOSM-Signature", "sha256="+wh.sign(timestamp, body))
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		// without the URL, which may hold a token.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// deliver POSTs the body until the receiver answers with a 2xx status.
+func (wh *Webhooks) deliver(callbackUrl string, body []byte) error {
+	wait := wh.backoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = wh.post(callbackUrl, body); err == nil || attempt == callbackAttempts {
+			return err
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// notifyCallback sends a finished task's record to its CallbackUrl in
+// the background.
+func (h *Server) notifyCallback(task Task) {
+	if task.CallbackUrl == "" || h.webhooks == nil {
+		return
+	}
+	record, err := os.ReadFile(filepath.Join(h.filesDir, task.Uuid))
+	if err != nil {
+		fmt.Println("callback for", task.Uuid, err)
+		return
+	}
+	var progress Progress
+	json.Unmarshal(record, &progress)
+	record = h.withDownloadUrl(record, task.Uuid, progress)
+	body, err := json.Marshal(Callback{Uuid: task.Uuid, Progress: bytes.TrimSpace(record)})
+	if err != nil {
+		fmt.Println("callback for", task.Uuid, err)
+		return
+	}
+	go func() {
+		if err := h.webhooks.deliver(task.CallbackUrl, body); err != nil {
+			// only the host is logged: the URL may hold a token.
+			u, _ := url.Parse(task.CallbackUrl)
+			fmt.Println("giving up on callback for", task.Uuid, "to", u.Host, "-", err)
+		}
+	}()
+}
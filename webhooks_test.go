@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const richmondWithCallback = `{"Name":"richmond","RegionType":"bbox","RegionData":[37.5272,-77.4571,37.5530,-77.4133],"CallbackUrl":"%s"}`
+
+func TestCallback(t *testing.T) {
+	var mutex sync.Mutex
+	attempts := 0
+	var body []byte
+	var header http.Header
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(503)
+			return
+		}
+		body, _ = io.ReadAll(r.Body)
+		header = r.Header
+	}))
+	defer receiver.Close()
+
+	// the receiver stands in for a public host.
+	client := &http.Client{Transport: &http.Transport{DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, receiver.Listener.Addr().String())
+	}}}
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.webhooks = &Webhooks{secret: []byte("shared"), client: client, backoff: time.Millisecond}
+	h.StartWorkers()
+	code, uuid := submit(h, fmt.Sprintf(richmondWithCallback, "http://callback.example/done?token=x"))
+	assert.Equal(t, 201, code)
+	waitFor(t, func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return body != nil
+	})
+
+	assert.Equal(t, 2, attempts)
+	assert.Equal(t, "sha256="+h.webhooks.sign(header.Get("X-SliceOSM-Timestamp"), body), header.Get("X-SliceOSM-Signature"))
+	var callback Callback
+	assert.Nil(t, json.Unmarshal(body, &callback))
+	assert.Equal(t, uuid, callback.Uuid)
+	var progress Progress
+	json.Unmarshal(callback.Progress, &progress)
+	assert.True(t, progress.Complete)
+
+	// the URL is kept out of the public region.json.
+	b, _ := os.ReadFile(filepath.Join(h.filesDir, uuid+"_region.json"))
+	assert.NotContains(t, string(b), "token")
+}
+
+func TestCallbackGivesUp(t *testing.T) {
+	var mutex sync.Mutex
+	attempts := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		attempts++
+		mutex.Unlock()
+		w.WriteHeader(500)
+	}))
+	defer receiver.Close()
+	wh := &Webhooks{secret: []byte("shared"), client: http.DefaultClient, backoff: time.Millisecond}
+	assert.NotNil(t, wh.deliver(receiver.URL, []byte("{}")))
+	assert.Equal(t, callbackAttempts, attempts)
+}
+
+func TestCallbackValidation(t *testing.T) {
+	h := newTestServer(t, fakeOsmx(t, ""))
+	h.StartWorkers()
+	code, _ := submit(h, fmt.Sprintf(richmondWithCallback, "https://example.com/done"))
+	assert.Equal(t, 400, code)
+
+	h.webhooks = &Webhooks{secret: []byte("shared")}
+	for _, callbackUrl := range []string{"ftp://example.com/done", "/done", "https://", "http://localhost:8080/done", "http://127.0.0.1/done", "http://169.254.169.254/latest/meta-data/", "http://[::1]/done", "http://10.0.0.1/done"} {
+		code, _ := submit(h, fmt.Sprintf(richmondWithCallback, callbackUrl))
+		assert.Equal(t, 400, code, callbackUrl)
+	}
+}
+
+func TestCallbackPublicAddressOnly(t *testing.T) {
+	received := false
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = true
+	}))
+	defer receiver.Close()
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	os.WriteFile(secretFile, []byte("shared"), 0600)
+	wh, err := loadWebhooks(secretFile)
+	assert.Nil(t, err)
+	err = wh.post(receiver.URL, []byte("{}"))
+	assert.Contains(t, err.Error(), "127.0.0.1 is not a public address")
+	assert.False(t, received)
+
+	// nor can a redirect lead there.
+	redirect, _ := http.NewRequest("GET", receiver.URL, nil)
+	assert.NotNil(t, wh.client.CheckRedirect(redirect, nil))
+}